@@ -3,51 +3,418 @@
 
 locals {
   # Naming convention following Microsoft CAF
-  name_prefix = var.name_prefix != "" ? var.name_prefix : "kv-${var.environment}-${var.location_short}"
-  kv_name     = var.custom_name != "" ? var.custom_name : "${local.name_prefix}${var.name_suffix}"
+  name_prefix  = var.name_prefix != "" ? var.name_prefix : "kv-${var.environment}-${var.location_short}"
+  kv_base_name = var.key_vault_name != null ? var.key_vault_name : var.custom_name != "" ? var.custom_name : var.workload != null ? local.workload_name : "${local.name_prefix}${var.name_suffix}"
 
-  # Default tags
+  # <name_prefix>-kv-<workload>-<environment>[-<name_suffix>], cutting the
+  # workload segment so the name fits in 24 characters, or in 17 before a
+  # random suffix.
+  workload_name_budget = (var.use_random_suffix ? 17 : 24) - length(join("-", compact([var.name_prefix, "kv", var.environment, var.name_suffix]))) - 1
+  workload_segment     = trimsuffix(substr(coalesce(var.workload, "-"), 0, max(local.workload_name_budget, 1)), "-")
+  workload_name        = join("-", compact([var.name_prefix, "kv", local.workload_segment, var.environment, var.name_suffix]))
+
+  # With use_random_suffix the base name is cut to 17 characters so that
+  # "<base>-<suffix>" stays within the 24 character limit.
+  kv_name = length(random_string.vault_suffix) > 0 ? "${trimsuffix(substr(local.kv_base_name, 0, 17), "-")}-${random_string.vault_suffix[0].result}" : local.kv_base_name
+
+  # Key Vault DNS suffix of each cloud_environment, unless vault_dns_suffix
+  # names the one of an Azure Stack Hub or air-gapped cloud
+  vault_dns_suffix = var.vault_dns_suffix != null ? var.vault_dns_suffix : {
+    public       = "vault.azure.net"
+    usgovernment = "vault.usgovcloudapi.net"
+    china        = "vault.azure.cn"
+  }[var.cloud_environment]
+  vault_dns_suffix_source = var.vault_dns_suffix != null ? "vault_dns_suffix" : "cloud_environment = \"${var.cloud_environment}\""
+
+  # Data-plane URI of the single vault, built from vault_dns_suffix when set
+  vault_uri = !local.create_vault ? null : var.vault_dns_suffix != null ? "https://${local.vault.name}.${var.vault_dns_suffix}/" : local.vault.vault_uri
+
+  # Module-managed tags. Caller tags win on key collisions, except ManagedBy,
+  # Purpose and the provenance tags.
+  managed_by = "Terraform"
   default_tags = {
     Environment = var.environment
     Project     = var.project_name
-    ManagedBy   = "Terraform"
+    ManagedBy   = local.managed_by
     Module      = "key-vault"
     CreatedDate = formatdate("YYYY-MM-DD", timestamp())
     CreatedBy   = var.created_by
   }
 
-  # Merge tags
-  tags = merge(local.default_tags, var.additional_tags)
+  # Tenant of the vault: tenant_id, or the provider's when it is unset or
+  # use_current_tenant is true
+  tenant_id = coalesce(var.use_current_tenant, var.tenant_id == null) ? data.azurerm_client_config.current.tenant_id : var.tenant_id
+
+  # Public access of the single vault, opened while bootstrap_public_access
+  # lets a runner seed it
+  public_network_access_enabled = var.public_network_access_enabled || var.bootstrap_public_access
+
+  # Provenance for audit: the principal running Terraform and the pipeline run
+  provenance_tags = var.enable_provenance_tags ? merge(
+    { CreatedByObjectId = data.azurerm_client_config.current.object_id },
+    var.pipeline_run_id != null ? { PipelineRunId = var.pipeline_run_id } : {}
+  ) : {}
+
+  # Tags applied to the vault and all child resources
+  common_tags  = merge(local.default_tags, var.additional_tags, var.tags, local.provenance_tags, local.purpose_tags, local.break_glass_tags, { ManagedBy = local.managed_by })
+  purpose_tags = var.purpose != null ? { Purpose = var.purpose } : {}
+
+  # Break-glass access is granted only while explicitly enabled, and the vault
+  # carries a tag saying so for as long as it is
+  break_glass_enabled = var.break_glass_enabled && var.break_glass_object_id != null
+  break_glass_tags    = local.break_glass_enabled ? { BreakGlass = "enabled" } : {}
 
-  # Network ACLs configuration
+  # Keys of the single vault that are not HSM-backed, which a vault with
+  # purpose = "encryption" must not hold
+  software_key_names = [for k, v in local.key_metadata : k if !endswith(v.key_type, "-HSM")]
+
+  # Tags of keys, secrets and certificates, marking them for janitor jobs
+  data_plane_tags = merge(local.common_tags, { lifecycle = var.data_lifecycle })
+
+  # Network ACLs configuration. Azure stores single addresses as /32 (IPv4) or
+  # /128 (IPv6) ranges and IPv6 in lowercase, compressed form, so rules are
+  # sent in that form to avoid diffs. IP rules firewall public access, so with
+  # IP rules the default action is always Deny.
+  network_acls_ip_rules = [
+    for rule in var.network_acls_ip_rules : cidrsubnet(strcontains(rule, "/") ? rule : "${rule}/${strcontains(rule, ":") ? 128 : 32}", 0, 0)
+  ]
   network_acls = var.enable_network_acls ? {
     bypass                     = var.network_acls_bypass
-    default_action             = var.network_acls_default_action
-    ip_rules                   = var.network_acls_ip_rules
+    default_action             = length(local.network_acls_ip_rules) > 0 ? "Deny" : local.create_vault && var.bootstrap_public_access ? "Allow" : var.network_acls_default_action
+    ip_rules                   = local.network_acls_ip_rules
     virtual_network_subnet_ids = var.network_acls_subnet_ids
   } : null
 
+  # Resource group: created by the module or read from an existing one
+  resource_group_name     = !var.enabled ? null : var.create_resource_group ? azurerm_resource_group.this[0].name : data.azurerm_resource_group.this[0].name
+  resource_group_location = !var.enabled ? null : var.create_resource_group ? azurerm_resource_group.this[0].location : data.azurerm_resource_group.this[0].location
+  resource_group_id       = !var.enabled ? null : var.create_resource_group ? azurerm_resource_group.this[0].id : data.azurerm_resource_group.this[0].id
+
+  # Backend: a standard vault, or a Managed HSM in its place, or neither when
+  # the module is disabled. Vault-scoped children (keys, secrets, certificates,
+  # access) only exist for a vault. Setting var.vaults deploys those vaults
+  # instead of the single one, without children.
+  is_managed_hsm = var.enabled && var.backend_type == "managed_hsm"
+  create_vault   = var.enabled && var.backend_type == "vault" && length(var.vaults) == 0
+  multi_vault    = var.enabled && var.backend_type == "vault" && length(var.vaults) > 0
+  has_backend    = local.is_managed_hsm || local.create_vault
+  backend_id     = local.is_managed_hsm ? azurerm_key_vault_managed_hardware_security_module.this[0].id : local.create_vault ? local.vault.id : null
+
+  # The single vault, from whichever of its two resources is in use. Lifecycle
+  # settings cannot be set from variables, so create_before_destroy needs a
+  # resource of its own.
+  vault_create_before_destroy = var.lifecycle_create_before_destroy && var.use_random_suffix
+  vault                       = one(concat(azurerm_key_vault.this, azurerm_key_vault.this_create_before_destroy))
+
+  managed_hsm_admin_object_ids = length(var.managed_hsm_admin_object_ids) > 0 ? var.managed_hsm_admin_object_ids : [data.azurerm_client_config.current.object_id]
+
   # RBAC configuration
   rbac_enabled = var.enable_rbac_authorization
+
+  # Purge protection is permanent, so it is off by default outside
+  # production; production vaults always get it.
+  is_production            = contains(["prod", "production"], lower(var.environment))
+  purge_protection_enabled = local.is_production || coalesce(var.purge_protection_enabled, false)
+
+  # Soft delete retention: soft_delete_retention_days, or the default of the
+  # environment, clamped into the 7-90 days Azure accepts when
+  # clamp_soft_delete_retention is set
+  soft_delete_retention_defaults = {
+    prod       = 90
+    production = 90
+  }
+  requested_soft_delete_retention_days = coalesce(var.soft_delete_retention_days, lookup(local.soft_delete_retention_defaults, lower(var.environment), 7))
+  soft_delete_retention_days           = var.clamp_soft_delete_retention ? min(max(local.requested_soft_delete_retention_days, 7), 90) : local.requested_soft_delete_retention_days
+  soft_delete_retention_error          = "Soft delete retention days must be a whole number between 7 and 90; Azure rejects values outside this range. Set clamp_soft_delete_retention = true to clamp soft_delete_retention_days = ${local.requested_soft_delete_retention_days} into range instead."
+
+  vault_soft_delete_retention_days = {
+    for k, v in var.vaults : k => var.clamp_soft_delete_retention ? min(max(coalesce(v.soft_delete_retention_days, local.requested_soft_delete_retention_days), 7), 90) : coalesce(v.soft_delete_retention_days, local.requested_soft_delete_retention_days)
+  }
+
+  # Purge protection of the deployed vault, if one already exists, for the
+  # guard against disabling it
+  existing_vault_purge_protected = try(data.azurerm_key_vault.existing[0].purge_protection_enabled, false)
+
+  # Private endpoint naming
+  private_endpoint_enabled = local.has_backend && var.enable_private_endpoint
+  private_endpoint_name    = coalesce(var.private_endpoint_name, "${local.kv_name}-pe")
+  private_dns_zone_ids     = concat(var.private_dns_zone_ids, data.azurerm_private_dns_zone.this[*].id)
+
+  # Diagnostic settings: the diagnostic_settings object takes precedence over
+  # the standalone variables. Without a destination nothing is created.
+  diagnostic_workspace_id = var.diagnostic_settings.log_analytics_workspace_id != null ? var.diagnostic_settings.log_analytics_workspace_id : var.log_analytics_workspace_id
+  diagnostics_enabled     = local.has_backend && var.enable_diagnostic_settings && (local.diagnostic_workspace_id != null || var.diagnostic_settings.eventhub_authorization_rule_id != null || local.diagnostic_storage_enabled)
+
+  # Storage account names are global and only take lowercase letters and numbers
+  diagnostic_storage_enabled = local.has_backend && var.enable_diagnostic_settings && var.create_diagnostic_storage
+  diagnostic_storage_name    = coalesce(var.diagnostic_storage.name, "${substr(replace(lower(local.kv_name), "/[^a-z0-9]/", ""), 0, 20)}diag")
+
+  # Lifecycle rules deleting archived logs: one per log category, which lands
+  # in its own insights-logs-<category> container, plus one for all metrics.
+  # Rule names may only hold letters and digits.
+  diagnostic_retention_rules = merge(
+    {
+      for c in local.diagnostic_log_categories : "logs${replace(lower(c), "/[^a-z0-9]/", "")}" => {
+        prefix = "insights-logs-${lower(c)}/"
+        days   = lookup(var.diagnostic_settings.diagnostic_retention_days, c, var.diagnostic_storage.retention_days)
+      } if lookup(var.diagnostic_settings.diagnostic_retention_days, c, var.diagnostic_storage.retention_days) > 0
+    },
+    length(var.diagnostic_metrics) > 0 ? { metrics = { prefix = "insights-metrics-", days = var.diagnostic_storage.retention_days } } : {}
+  )
+
+  # Managed HSM only emits AuditEvent logs
+  diagnostic_log_categories = [
+    for c in (var.diagnostic_settings.enabled_log_categories != null ? var.diagnostic_settings.enabled_log_categories : var.diagnostic_logs) : c
+    if !local.is_managed_hsm || c == "AuditEvent"
+  ]
+
+  # Network ACLs of each entry in var.vaults, falling back to the module-level
+  # network ACLs
+  vault_network_acls = {
+    for k, v in var.vaults : k => v.network_acls == null ? local.network_acls : {
+      bypass                     = v.network_acls.bypass
+      default_action             = length(v.network_acls.ip_rules) > 0 ? "Deny" : v.network_acls.default_action
+      ip_rules                   = [for rule in v.network_acls.ip_rules : strcontains(rule, "/") ? rule : "${rule}/${strcontains(rule, ":") ? 128 : 32}"]
+      virtual_network_subnet_ids = v.network_acls.virtual_network_subnet_ids
+    }
+  }
+
+  # Keys, secrets and certificates live on the vault data plane. Turning them
+  # off lets a first apply build only the vault and its access, before role
+  # assignments have propagated.
+  manage_data_plane = local.create_vault && var.manage_data_plane
+
+  # Event Grid notifications are only available for a vault
+  event_grid_enabled = local.create_vault && var.event_grid_enabled
+
+  # Availability alert, like Event Grid, only watches the single vault. It
+  # notifies the caller's action group, or one the module creates.
+  availability_alert_enabled         = local.create_vault && var.create_availability_alert
+  action_group_enabled               = local.create_vault && var.action_group != null
+  availability_alert_action_group_id = var.availability_alert_action_group_id != null ? var.availability_alert_action_group_id : one(azurerm_monitor_action_group.this[*].id)
+
+  # Disaster recovery vault, in the Azure region pair of location unless
+  # dr_location is set. Region names are compared in their short form.
+  dr_vault_enabled = local.create_vault && var.create_dr_vault
+  dr_vault_name    = coalesce(var.dr_vault_name, "${trimsuffix(substr(local.kv_name, 0, 21), "-")}-dr")
+  dr_location      = var.dr_location != null ? var.dr_location : lookup(local.region_pairs, lower(replace(var.location, " ", "")), null)
+  region_pairs = {
+    eastus             = "westus"
+    westus             = "eastus"
+    eastus2            = "centralus"
+    centralus          = "eastus2"
+    northcentralus     = "southcentralus"
+    southcentralus     = "northcentralus"
+    westus2            = "westcentralus"
+    westcentralus      = "westus2"
+    westus3            = "eastus"
+    canadacentral      = "canadaeast"
+    canadaeast         = "canadacentral"
+    brazilsouth        = "southcentralus"
+    northeurope        = "westeurope"
+    westeurope         = "northeurope"
+    uksouth            = "ukwest"
+    ukwest             = "uksouth"
+    francecentral      = "francesouth"
+    francesouth        = "francecentral"
+    germanywestcentral = "germanynorth"
+    germanynorth       = "germanywestcentral"
+    switzerlandnorth   = "switzerlandwest"
+    switzerlandwest    = "switzerlandnorth"
+    norwayeast         = "norwaywest"
+    norwaywest         = "norwayeast"
+    swedencentral      = "swedensouth"
+    swedensouth        = "swedencentral"
+    eastasia           = "southeastasia"
+    southeastasia      = "eastasia"
+    japaneast          = "japanwest"
+    japanwest          = "japaneast"
+    koreacentral       = "koreasouth"
+    koreasouth         = "koreacentral"
+    australiaeast      = "australiasoutheast"
+    australiasoutheast = "australiaeast"
+    australiacentral   = "australiacentral2"
+    australiacentral2  = "australiacentral"
+    centralindia       = "southindia"
+    southindia         = "centralindia"
+    westindia          = "southindia"
+    uaenorth           = "uaecentral"
+    uaecentral         = "uaenorth"
+    southafricanorth   = "southafricawest"
+    southafricawest    = "southafricanorth"
+    chinanorth         = "chinaeast"
+    chinaeast          = "chinanorth"
+    chinanorth2        = "chinaeast2"
+    chinaeast2         = "chinanorth2"
+    usgovvirginia      = "usgovtexas"
+    usgovtexas         = "usgovvirginia"
+    usgovarizona       = "usgovtexas"
+  }
+
+  # Data residency: the regions the module deploys to that allowed_regions,
+  # when set, does not list.
+  allowed_regions = [for r in var.allowed_regions : lower(replace(r, " ", ""))]
+  disallowed_regions = length(local.allowed_regions) == 0 || !var.enabled ? [] : [
+    for r in compact([var.location, local.dr_vault_enabled ? local.dr_location : null]) : r
+    if !contains(local.allowed_regions, lower(replace(r, " ", "")))
+  ]
+
+  # Next automatic key rotation, derivable when a key has an expiration date
+  # and rotates a whole number of days before it (ISO 8601 "P<n>D")
+  key_next_rotation_dates = {
+    for k, v in (local.manage_data_plane ? local.key_metadata : {}) : k => try(
+      timeadd(lookup(local.key_expiration_dates, k, v.expiration_date), "-${tonumber(regex("^P(\\d+)D$", v.rotation_policy.time_before_expiry)[0]) * 24}h"),
+      null
+    )
+  }
+
+  # Key settings without key_material, so keys can drive for_each while the
+  # imported material stays sensitive. Imported keys are stored as
+  # certificates, whose backing key shares the certificate's name and version.
+  key_metadata = {
+    for k, v in nonsensitive(var.keys) : k => merge(v, {
+      key_material = null
+      imported     = v.key_material != null
+      imported_pem = v.key_material != null && startswith(trimspace(try(v.key_material.contents, "")), "-----BEGIN")
+    })
+  }
+  generated_keys = { for k, v in local.key_metadata : k => v if !v.imported }
+  imported_keys  = { for k, v in local.key_metadata : k => v if v.imported }
+
+  imported_key_ids = {
+    for k, v in azurerm_key_vault_certificate.imported_key : k => {
+      id             = replace(v.id, "/certificates/", "/keys/")
+      versionless_id = replace(v.versionless_id, "/certificates/", "/keys/")
+      version        = v.version
+    }
+  }
+
+  # Least-privilege roles an application needs to use what the module creates
+  # on the data plane. Informational only; nothing is assigned.
+  recommended_roles = local.create_vault ? concat(
+    length(local.key_metadata) > 0 ? ["Key Vault Crypto User"] : [],
+    length(local.secret_metadata) > 0 ? ["Key Vault Secrets User"] : [],
+    length(var.certificates) > 0 ? ["Key Vault Certificate User"] : []
+  ) : []
+
+  # Secret metadata without values, so secrets can drive for_each while the
+  # values themselves stay sensitive
+  secret_metadata = {
+    for k, v in nonsensitive(var.secrets) : k => {
+      name            = coalesce(v.name, k)
+      content_type    = v.content_type
+      not_before_date = v.not_before_date
+      expiration_date = v.expiration_date
+      enabled         = v.enabled
+      tags            = v.tags
+    }
+  }
+
+  # Certificate issuers without their passwords, so issuers can drive for_each
+  # while the passwords stay sensitive
+  certificate_issuer_metadata = {
+    for k, v in nonsensitive(var.certificate_issuers) : k => merge(v, {
+      password = null
+    })
+  }
+
+  # Keys and secrets without an expiration_date expire default_expiration_days
+  # after the creation time recorded for them in time_static.created
+  default_expiration_entries = var.default_expiration_days == null ? {} : merge(
+    { for k, v in local.generated_keys : "key:${k}" => k if v.expiration_date == null },
+    { for k, v in local.secret_metadata : "secret:${k}" => k if v.expiration_date == null },
+  )
+  default_expiration_dates = {
+    for k, v in time_static.created : k => timeadd(v.rfc3339, "${var.default_expiration_days * 24}h")
+  }
+  key_expiration_dates = {
+    for k, v in local.generated_keys : k => v.expiration_date != null ? v.expiration_date : lookup(local.default_expiration_dates, "key:${k}", null)
+  }
+  secret_expiration_dates = {
+    for k, v in local.secret_metadata : k => v.expiration_date != null ? v.expiration_date : lookup(local.default_expiration_dates, "secret:${k}", null)
+  }
 }
 
 # Data sources
-data "azurerm_client_config" "current" {}
+data "azurerm_client_config" "current" {
+  lifecycle {
+    postcondition {
+      condition     = var.use_current_tenant == null || var.use_current_tenant == (var.tenant_id == null)
+      error_message = var.use_current_tenant == true ? "tenant_id is set while use_current_tenant = true. Remove tenant_id to use the current tenant, ${self.tenant_id}, or set use_current_tenant = false." : "use_current_tenant = false needs tenant_id. Set tenant_id, or use_current_tenant = true to use the current tenant, ${self.tenant_id}."
+    }
+    postcondition {
+      condition     = length(local.disallowed_regions) == 0
+      error_message = "The Key Vault would be deployed to ${join(", ", local.disallowed_regions)}, outside allowed_regions (${join(", ", var.allowed_regions)}). Deploy to an approved region, or add the region to allowed_regions if data-residency policy permits it."
+    }
+  }
+}
+
+data "azurerm_resource_group" "this" {
+  count = var.enabled && !var.create_resource_group ? 1 : 0
+  name  = var.resource_group_name
+}
+
+resource "random_string" "vault_suffix" {
+  count   = var.enabled && var.use_random_suffix ? 1 : 0
+  length  = 6
+  lower   = true
+  upper   = false
+  numeric = true
+  special = false
+
+  keepers = {
+    base_name = local.kv_base_name
+  }
+}
+
+# An already deployed vault of the same name. Vault names are globally unique,
+# so the lookup is subscription-wide and finds nothing for a new vault. It is
+# skipped with a random suffix, whose name is unknown until apply.
+data "azurerm_resources" "existing_vault" {
+  count = local.create_vault && !var.use_random_suffix ? 1 : 0
+  type  = "Microsoft.KeyVault/vaults"
+  name  = local.kv_name
+}
+
+# The private DNS zone given by name rather than ID. It is not read while
+# private_dns_zone_ids is also set, so the private endpoint's precondition
+# reports the conflict instead of a failed lookup.
+data "azurerm_private_dns_zone" "this" {
+  count               = local.private_endpoint_enabled && var.private_dns_zone_name != null && var.private_dns_zone_resource_group != null && length(var.private_dns_zone_ids) == 0 ? 1 : 0
+  name                = var.private_dns_zone_name
+  resource_group_name = var.private_dns_zone_resource_group
+}
+
+data "azurerm_key_vault" "existing" {
+  count               = length(data.azurerm_resources.existing_vault) > 0 ? length(data.azurerm_resources.existing_vault[0].resources) : 0
+  name                = local.kv_name
+  resource_group_name = split("/", data.azurerm_resources.existing_vault[0].resources[0].id)[4]
+}
+
+# Resource Group
+resource "azurerm_resource_group" "this" {
+  count    = var.enabled && var.create_resource_group ? 1 : 0
+  name     = var.resource_group_name
+  location = coalesce(var.resource_group_location, var.location)
+  tags     = local.common_tags
+}
 
 # Key Vault Resource
 resource "azurerm_key_vault" "this" {
+  count = local.create_vault && !local.vault_create_before_destroy ? 1 : 0
+
   name                            = local.kv_name
   location                        = var.location
-  resource_group_name             = var.resource_group_name
-  tenant_id                       = data.azurerm_client_config.current.tenant_id
+  resource_group_name             = local.resource_group_name
+  tenant_id                       = local.tenant_id
   sku_name                        = var.sku_name
   enabled_for_deployment          = var.enabled_for_deployment
   enabled_for_disk_encryption     = var.enabled_for_disk_encryption
   enabled_for_template_deployment = var.enabled_for_template_deployment
   enable_rbac_authorization       = local.rbac_enabled
-  purge_protection_enabled        = var.purge_protection_enabled
-  soft_delete_retention_days      = var.soft_delete_retention_days
-  public_network_access_enabled   = var.public_network_access_enabled
+  purge_protection_enabled        = local.purge_protection_enabled
+  soft_delete_retention_days      = local.soft_delete_retention_days
+  public_network_access_enabled   = local.public_network_access_enabled
 
   dynamic "network_acls" {
     for_each = local.network_acls != null ? [local.network_acls] : []
@@ -68,124 +435,815 @@ resource "azurerm_key_vault" "this" {
     }
   }
 
-  tags = local.tags
+  tags = local.common_tags
+
+  dynamic "timeouts" {
+    for_each = var.timeouts != null ? [var.timeouts] : []
+    content {
+      create = timeouts.value.create
+      read   = timeouts.value.read
+      update = timeouts.value.update
+      delete = timeouts.value.delete
+    }
+  }
+
+  # Destroyed before the purge hook, so the purge runs once the vault is gone.
+  depends_on = [terraform_data.purge_on_destroy]
+
+  lifecycle {
+    precondition {
+      condition     = can(regex("^[a-zA-Z][a-zA-Z0-9-]{1,22}[a-zA-Z0-9]$", local.kv_name)) && !strcontains(local.kv_name, "--")
+      error_message = "The Key Vault name '${local.kv_name}' is invalid: it must be 3-24 characters of letters, digits and single hyphens, start with a letter and end with a letter or digit. Set key_vault_name or shorten name_prefix/name_suffix."
+    }
+    precondition {
+      condition     = var.public_network_access_enabled || length(var.network_acls_ip_rules) == 0
+      error_message = "network_acls_ip_rules only filter public network access, which is disabled for Key Vault '${local.kv_name}'. Set public_network_access_enabled = true to allow the listed addresses through the firewall, or, for a private-only vault, remove the IP rules and reach it through network_acls_subnet_ids or a private endpoint (enable_private_endpoint)."
+    }
+    precondition {
+      condition     = local.soft_delete_retention_days >= 7 && local.soft_delete_retention_days <= 90
+      error_message = local.soft_delete_retention_error
+    }
+    precondition {
+      condition     = !local.rbac_enabled || length(var.access_policies) == 0
+      error_message = "access_policies are set while enable_rbac_authorization is true, and an RBAC vault ignores them: switching Key Vault '${local.kv_name}' to RBAC would cut off every principal they grant. To migrate, grant those principals the matching Key Vault roles with the rbac_* or role_assignments variables and remove access_policies in the same apply, or keep enable_rbac_authorization = false."
+    }
+    precondition {
+      condition     = local.rbac_enabled || length(var.access_policies) > 0
+      error_message = "enable_rbac_authorization is false but access_policies is empty, so no one could read or manage the contents of Key Vault '${local.kv_name}'. Add an access policy, for example for the deploying principal, or set enable_rbac_authorization = true."
+    }
+    precondition {
+      condition     = local.purge_protection_enabled || !local.existing_vault_purge_protected
+      error_message = "Purge protection is enabled on Key Vault '${local.kv_name}' and Azure does not allow disabling it. Keep purge_protection_enabled = true, or recreate the vault: deploy a new one under a different key_vault_name and move consumers to it."
+    }
+    postcondition {
+      condition     = endswith(trimsuffix(self.vault_uri, "/"), ".${local.vault_dns_suffix}")
+      error_message = "Key Vault '${local.kv_name}' was deployed with URI ${self.vault_uri}, which does not end in .${local.vault_dns_suffix} as expected for ${local.vault_dns_suffix_source}. Point the azurerm provider at that cloud (its environment setting or ARM_ENVIRONMENT), or set cloud_environment to the cloud it deploys to."
+    }
+  }
+}
+
+# Earlier versions created the vault without count. Vault names are global and
+# usually purge protected, so the vault must move rather than be replaced.
+moved {
+  from = azurerm_key_vault.this
+  to   = azurerm_key_vault.this[0]
+}
+
+# The same vault, replaced by creating the new one first. Keep the two in sync.
+resource "azurerm_key_vault" "this_create_before_destroy" {
+  count = local.create_vault && local.vault_create_before_destroy ? 1 : 0
+
+  name                            = local.kv_name
+  location                        = var.location
+  resource_group_name             = local.resource_group_name
+  tenant_id                       = local.tenant_id
+  sku_name                        = var.sku_name
+  enabled_for_deployment          = var.enabled_for_deployment
+  enabled_for_disk_encryption     = var.enabled_for_disk_encryption
+  enabled_for_template_deployment = var.enabled_for_template_deployment
+  enable_rbac_authorization       = local.rbac_enabled
+  purge_protection_enabled        = local.purge_protection_enabled
+  soft_delete_retention_days      = local.soft_delete_retention_days
+  public_network_access_enabled   = local.public_network_access_enabled
+
+  dynamic "network_acls" {
+    for_each = local.network_acls != null ? [local.network_acls] : []
+    content {
+      bypass                     = network_acls.value.bypass
+      default_action             = network_acls.value.default_action
+      ip_rules                   = network_acls.value.ip_rules
+      virtual_network_subnet_ids = network_acls.value.virtual_network_subnet_ids
+    }
+  }
+
+  dynamic "contact" {
+    for_each = var.contacts
+    content {
+      email = contact.value.email
+      name  = contact.value.name
+      phone = contact.value.phone
+    }
+  }
+
+  tags = local.common_tags
+
+  dynamic "timeouts" {
+    for_each = var.timeouts != null ? [var.timeouts] : []
+    content {
+      create = timeouts.value.create
+      read   = timeouts.value.read
+      update = timeouts.value.update
+      delete = timeouts.value.delete
+    }
+  }
+
+  # Destroyed before the purge hook, so the purge runs once the vault is gone.
+  depends_on = [terraform_data.purge_on_destroy]
+
+  lifecycle {
+    create_before_destroy = true
+
+    precondition {
+      condition     = can(regex("^[a-zA-Z][a-zA-Z0-9-]{1,22}[a-zA-Z0-9]$", local.kv_name)) && !strcontains(local.kv_name, "--")
+      error_message = "The Key Vault name '${local.kv_name}' is invalid: it must be 3-24 characters of letters, digits and single hyphens, start with a letter and end with a letter or digit. Set key_vault_name or shorten name_prefix/name_suffix."
+    }
+    precondition {
+      condition     = var.public_network_access_enabled || length(var.network_acls_ip_rules) == 0
+      error_message = "network_acls_ip_rules only filter public network access, which is disabled for Key Vault '${local.kv_name}'. Set public_network_access_enabled = true to allow the listed addresses through the firewall, or, for a private-only vault, remove the IP rules and reach it through network_acls_subnet_ids or a private endpoint (enable_private_endpoint)."
+    }
+    precondition {
+      condition     = local.soft_delete_retention_days >= 7 && local.soft_delete_retention_days <= 90
+      error_message = local.soft_delete_retention_error
+    }
+    precondition {
+      condition     = !local.rbac_enabled || length(var.access_policies) == 0
+      error_message = "access_policies are set while enable_rbac_authorization is true, and an RBAC vault ignores them: switching Key Vault '${local.kv_name}' to RBAC would cut off every principal they grant. To migrate, grant those principals the matching Key Vault roles with the rbac_* or role_assignments variables and remove access_policies in the same apply, or keep enable_rbac_authorization = false."
+    }
+    precondition {
+      condition     = local.rbac_enabled || length(var.access_policies) > 0
+      error_message = "enable_rbac_authorization is false but access_policies is empty, so no one could read or manage the contents of Key Vault '${local.kv_name}'. Add an access policy, for example for the deploying principal, or set enable_rbac_authorization = true."
+    }
+    precondition {
+      condition     = local.purge_protection_enabled || !local.existing_vault_purge_protected
+      error_message = "Purge protection is enabled on Key Vault '${local.kv_name}' and Azure does not allow disabling it. Keep purge_protection_enabled = true, or recreate the vault: deploy a new one under a different key_vault_name and move consumers to it."
+    }
+    postcondition {
+      condition     = endswith(trimsuffix(self.vault_uri, "/"), ".${local.vault_dns_suffix}")
+      error_message = "Key Vault '${local.kv_name}' was deployed with URI ${self.vault_uri}, which does not end in .${local.vault_dns_suffix} as expected for ${local.vault_dns_suffix_source}. Point the azurerm provider at that cloud (its environment setting or ARM_ENVIRONMENT), or set cloud_environment to the cloud it deploys to."
+    }
+  }
+}
+
+check "create_before_destroy_without_random_suffix" {
+  assert {
+    condition     = !var.lifecycle_create_before_destroy || var.use_random_suffix
+    error_message = "lifecycle_create_before_destroy is ignored without use_random_suffix: a replacement Key Vault under the same name would collide with the old one, which keeps the name until it is destroyed. Set use_random_suffix = true to stand up replacements first."
+  }
+}
+
+check "soft_delete_retention_clamped" {
+  assert {
+    condition     = !var.enabled || local.soft_delete_retention_days == local.requested_soft_delete_retention_days
+    error_message = "soft_delete_retention_days = ${local.requested_soft_delete_retention_days} is outside the 7-90 days Azure accepts and was clamped to ${local.soft_delete_retention_days}."
+  }
+}
+
+check "bootstrap_public_access_enabled" {
+  assert {
+    condition     = !local.create_vault || !var.bootstrap_public_access
+    error_message = "bootstrap_public_access is true, so Key Vault '${local.kv_name}' accepts traffic from the internet. Once it is seeded, apply again with bootstrap_public_access = false to lock it down."
+  }
+}
+
+# Org policy for the vault's purpose
+check "certificates_purpose_requirements" {
+  assert {
+    condition     = !local.create_vault || var.purpose != "certificates" || length(var.certificate_contacts) + length(var.contacts) > 0
+    error_message = "Key Vault '${local.kv_name}' has purpose = \"certificates\" but no certificate contacts, so nobody is told about expiring certificates. Set certificate_contacts."
+  }
+}
+
+check "encryption_purpose_requirements" {
+  assert {
+    condition     = !local.create_vault || var.purpose != "encryption" || (var.sku_name == "premium" && length(local.software_key_names) == 0)
+    error_message = "Key Vault '${local.kv_name}' has purpose = \"encryption\", which needs sku_name = \"premium\" (it is ${var.sku_name}) and only HSM-backed keys (RSA-HSM or EC-HSM)${length(local.software_key_names) > 0 ? ", but keys ${join(", ", local.software_key_names)} are not" : ""}."
+  }
+}
+
+# Template deployments can read secrets of a publicly reachable vault
+check "template_deployment_with_public_access" {
+  assert {
+    condition = !var.enabled_for_template_deployment || alltrue(concat(
+      local.create_vault ? [!var.public_network_access_enabled] : [],
+      [for v in var.vaults : !coalesce(v.public_network_access_enabled, var.public_network_access_enabled) if local.multi_vault],
+    ))
+    error_message = "enabled_for_template_deployment is set on a Key Vault with public network access, a combination security scanners commonly flag: ARM template deployments can read its secrets while it is reachable from the internet. Disable public_network_access_enabled, or enabled_for_template_deployment if no template deployment needs the vault."
+  }
+}
+
+# Azure Disk Encryption reaches the vault as a trusted service, not through a
+# private endpoint, so a locked-down vault needs the AzureServices bypass
+check "disk_encryption_without_trusted_services" {
+  assert {
+    condition = !var.enabled_for_disk_encryption || alltrue(concat(
+      local.create_vault ? [try(local.network_acls.bypass, "AzureServices") == "AzureServices" || local.public_network_access_enabled && try(local.network_acls.default_action, "Allow") == "Allow"] : [],
+      [for k, v in var.vaults : try(local.vault_network_acls[k].bypass, "AzureServices") == "AzureServices" || coalesce(v.public_network_access_enabled, var.public_network_access_enabled) && try(local.vault_network_acls[k].default_action, "Allow") == "Allow" if local.multi_vault],
+    ))
+    error_message = "enabled_for_disk_encryption is set, but network_acls_bypass is None on a Key Vault that denies network access, so Azure Disk Encryption, which reaches the vault as a trusted Azure service rather than through a private endpoint, would fail to read its keys and secrets. Set network_acls_bypass = \"AzureServices\", or enabled_for_disk_encryption = false if no VM encrypts its disks with the vault."
+  }
+}
+
+# Multiple vaults (when vaults is set, in place of the single vault). Unset
+# settings of an entry are inherited from the module-level variables.
+resource "azurerm_key_vault" "vaults" {
+  for_each = local.multi_vault ? var.vaults : {}
+
+  name                            = each.value.name
+  location                        = var.location
+  resource_group_name             = local.resource_group_name
+  tenant_id                       = local.tenant_id
+  sku_name                        = coalesce(each.value.sku_name, var.sku_name)
+  enabled_for_deployment          = var.enabled_for_deployment
+  enabled_for_disk_encryption     = var.enabled_for_disk_encryption
+  enabled_for_template_deployment = var.enabled_for_template_deployment
+  enable_rbac_authorization       = coalesce(each.value.enable_rbac_authorization, local.rbac_enabled)
+  purge_protection_enabled        = local.is_production || coalesce(each.value.purge_protection_enabled, local.purge_protection_enabled)
+  soft_delete_retention_days      = local.vault_soft_delete_retention_days[each.key]
+  public_network_access_enabled   = coalesce(each.value.public_network_access_enabled, var.public_network_access_enabled)
+
+  dynamic "network_acls" {
+    for_each = local.vault_network_acls[each.key] != null ? [local.vault_network_acls[each.key]] : []
+    content {
+      bypass                     = network_acls.value.bypass
+      default_action             = network_acls.value.default_action
+      ip_rules                   = network_acls.value.ip_rules
+      virtual_network_subnet_ids = network_acls.value.virtual_network_subnet_ids
+    }
+  }
+
+  tags = merge(local.common_tags, each.value.tags, { ManagedBy = local.managed_by })
+
+  dynamic "timeouts" {
+    for_each = var.timeouts != null ? [var.timeouts] : []
+    content {
+      create = timeouts.value.create
+      read   = timeouts.value.read
+      update = timeouts.value.update
+      delete = timeouts.value.delete
+    }
+  }
+
+  lifecycle {
+    precondition {
+      condition     = coalesce(each.value.public_network_access_enabled, var.public_network_access_enabled) || length(try(local.vault_network_acls[each.key].ip_rules, [])) == 0
+      error_message = "The IP rules of vaults entry '${each.key}' only filter public network access, which is disabled for it. Enable public_network_access_enabled for the entry, or remove its IP rules and reach it through virtual_network_subnet_ids or a private endpoint."
+    }
+    precondition {
+      condition     = local.vault_soft_delete_retention_days[each.key] >= 7 && local.vault_soft_delete_retention_days[each.key] <= 90
+      error_message = "The soft delete retention of vaults entry '${each.key}' must be a whole number between 7 and 90; Azure rejects values outside this range. Set clamp_soft_delete_retention = true to clamp it into range instead."
+    }
+    postcondition {
+      condition     = endswith(trimsuffix(self.vault_uri, "/"), ".${local.vault_dns_suffix}")
+      error_message = "Vaults entry '${each.key}' was deployed with URI ${self.vault_uri}, which does not end in .${local.vault_dns_suffix} as expected for ${local.vault_dns_suffix_source}. Point the azurerm provider at that cloud, or set cloud_environment to the cloud it deploys to."
+    }
+  }
+}
+
+check "vault_inputs_ignored_with_multiple_vaults" {
+  assert {
+    condition = !local.multi_vault || (
+      length(var.keys) + length(var.secrets) + length(var.certificates) + length(var.certificate_issuers) +
+      length(var.access_policies) + length(var.role_assignments) + length(var.deployment_principal_ids) + length(var.certificate_contacts) == 0 &&
+      var.disk_encryption_key == null && !var.event_grid_enabled && !var.create_availability_alert && !var.bootstrap_public_access && !var.create_dr_vault && !var.purge_on_destroy
+    )
+    error_message = "keys, secrets, certificates, certificate_issuers, access_policies, role_assignments, deployment_principal_ids, certificate_contacts, disk_encryption_key, event_grid_enabled, create_availability_alert, bootstrap_public_access, create_dr_vault and purge_on_destroy only apply to the single vault and are ignored when vaults is set."
+  }
+}
+
+# Disaster recovery vault: the single vault's settings in the paired region.
+# Only the control plane is mirrored; contents and access are managed by the
+# caller, for example by restoring backups into it.
+resource "azurerm_key_vault" "dr" {
+  count = local.dr_vault_enabled ? 1 : 0
+
+  name                            = local.dr_vault_name
+  location                        = local.dr_location
+  resource_group_name             = local.resource_group_name
+  tenant_id                       = local.tenant_id
+  sku_name                        = var.sku_name
+  enabled_for_deployment          = var.enabled_for_deployment
+  enabled_for_disk_encryption     = var.enabled_for_disk_encryption
+  enabled_for_template_deployment = var.enabled_for_template_deployment
+  enable_rbac_authorization       = local.rbac_enabled
+  purge_protection_enabled        = local.purge_protection_enabled
+  soft_delete_retention_days      = local.soft_delete_retention_days
+  public_network_access_enabled   = local.public_network_access_enabled
+
+  dynamic "network_acls" {
+    for_each = local.network_acls != null ? [local.network_acls] : []
+    content {
+      bypass                     = network_acls.value.bypass
+      default_action             = network_acls.value.default_action
+      ip_rules                   = network_acls.value.ip_rules
+      virtual_network_subnet_ids = network_acls.value.virtual_network_subnet_ids
+    }
+  }
+
+  dynamic "contact" {
+    for_each = var.contacts
+    content {
+      email = contact.value.email
+      name  = contact.value.name
+      phone = contact.value.phone
+    }
+  }
+
+  tags = local.common_tags
+
+  dynamic "timeouts" {
+    for_each = var.timeouts != null ? [var.timeouts] : []
+    content {
+      create = timeouts.value.create
+      read   = timeouts.value.read
+      update = timeouts.value.update
+      delete = timeouts.value.delete
+    }
+  }
+
+  lifecycle {
+    precondition {
+      condition     = local.dr_location != null
+      error_message = "No Azure region pair is known for location '${var.location}', so the disaster recovery vault has nowhere to go. Set dr_location to the region it should be deployed to."
+    }
+    precondition {
+      condition     = can(regex("^[a-zA-Z][a-zA-Z0-9-]{1,22}[a-zA-Z0-9]$", local.dr_vault_name)) && !strcontains(local.dr_vault_name, "--")
+      error_message = "The disaster recovery vault name '${local.dr_vault_name}' is invalid: it must be 3-24 characters of letters, digits and single hyphens, start with a letter and end with a letter or digit. Set dr_vault_name."
+    }
+  }
+}
+
+# Purge on destroy (ephemeral environments). Destroy provisioners can only
+# reference self, so the az arguments identifying the vault are passed as input.
+# A vault the provider already purged is skipped.
+resource "terraform_data" "purge_on_destroy" {
+  count = local.create_vault && var.purge_on_destroy && !local.purge_protection_enabled ? 1 : 0
+
+  input = {
+    cli_args = "--name '${local.kv_name}' --location '${var.location}' --subscription '${data.azurerm_client_config.current.subscription_id}'"
+  }
+
+  provisioner "local-exec" {
+    when    = destroy
+    command = "az keyvault show-deleted ${self.input.cli_args} > /dev/null 2>&1 || exit 0; az keyvault purge ${self.input.cli_args}"
+  }
+}
+
+check "purge_protection_forced_in_production" {
+  assert {
+    condition     = !local.is_production || (var.purge_protection_enabled != false && alltrue([for v in values(var.vaults) : v.purge_protection_enabled != false]))
+    error_message = "purge_protection_enabled = false is ignored in the ${var.environment} environment: production vaults always have purge protection."
+  }
+}
+
+check "purge_on_destroy_with_purge_protection" {
+  assert {
+    condition     = !var.purge_on_destroy || !local.purge_protection_enabled
+    error_message = "purge_on_destroy has no effect because purge protection is enabled: a purge-protected vault stays soft-deleted for soft_delete_retention_days."
+  }
+}
+
+# Managed HSM (when backend_type is managed_hsm)
+resource "azurerm_key_vault_managed_hardware_security_module" "this" {
+  count = local.is_managed_hsm ? 1 : 0
+
+  name                          = local.kv_name
+  location                      = var.location
+  resource_group_name           = local.resource_group_name
+  tenant_id                     = local.tenant_id
+  sku_name                      = var.managed_hsm_sku_name
+  admin_object_ids              = local.managed_hsm_admin_object_ids
+  purge_protection_enabled      = true
+  soft_delete_retention_days    = local.soft_delete_retention_days
+  public_network_access_enabled = var.public_network_access_enabled
+
+  dynamic "network_acls" {
+    for_each = local.network_acls != null ? [local.network_acls] : []
+    content {
+      bypass         = network_acls.value.bypass
+      default_action = network_acls.value.default_action
+    }
+  }
+
+  tags = local.common_tags
+
+  lifecycle {
+    precondition {
+      condition     = local.soft_delete_retention_days >= 7 && local.soft_delete_retention_days <= 90
+      error_message = local.soft_delete_retention_error
+    }
+  }
+}
+
+check "vault_inputs_ignored_with_managed_hsm" {
+  assert {
+    condition = !local.is_managed_hsm || (
+      length(var.keys) + length(var.secrets) + length(var.certificates) + length(var.certificate_issuers) +
+      length(var.access_policies) + length(var.role_assignments) == 0
+    )
+    error_message = "keys, secrets, certificates, certificate_issuers, access_policies and role_assignments are ignored when backend_type is managed_hsm. Manage HSM keys and local RBAC separately."
+  }
 }
 
 # Access Policies (when RBAC is not enabled)
 resource "azurerm_key_vault_access_policy" "this" {
-  for_each = local.rbac_enabled ? {} : var.access_policies
+  for_each = local.create_vault && !local.rbac_enabled ? var.access_policies : {}
 
-  key_vault_id = azurerm_key_vault.this.id
+  key_vault_id = local.vault.id
 
-  tenant_id = each.value.tenant_id
+  tenant_id = coalesce(each.value.tenant_id, local.tenant_id)
   object_id = each.value.object_id
 
   key_permissions         = each.value.key_permissions
   secret_permissions      = each.value.secret_permissions
   certificate_permissions = each.value.certificate_permissions
   storage_permissions     = each.value.storage_permissions
+
+  lifecycle {
+    precondition {
+      condition     = each.value.tenant_id == null || contains([for id in concat([local.tenant_id], var.additional_tenant_ids) : lower(id)], lower(coalesce(each.value.tenant_id, local.tenant_id)))
+      error_message = "Access policy '${each.key}' grants access to tenant ${each.value.tenant_id}, which is neither the vault's tenant nor listed in additional_tenant_ids. Add it to additional_tenant_ids for a guest tenant's principal, or drop tenant_id to use the vault's tenant."
+    }
+  }
 }
 
 # RBAC Role Assignments (when RBAC is enabled)
 resource "azurerm_role_assignment" "key_vault_administrator" {
-  for_each = local.rbac_enabled ? { for idx, principal_id in var.rbac_administrators : idx => principal_id } : {}
+  for_each = local.create_vault && local.rbac_enabled ? { for idx, principal_id in var.rbac_administrators : idx => principal_id } : {}
 
-  scope                = azurerm_key_vault.this.id
+  scope                = local.vault.id
   role_definition_name = "Key Vault Administrator"
   principal_id         = each.value
 }
 
 resource "azurerm_role_assignment" "key_vault_secrets_officer" {
-  for_each = local.rbac_enabled ? { for idx, principal_id in var.rbac_secrets_officers : idx => principal_id } : {}
+  for_each = local.create_vault && local.rbac_enabled ? { for idx, principal_id in var.rbac_secrets_officers : idx => principal_id } : {}
 
-  scope                = azurerm_key_vault.this.id
+  scope                = local.vault.id
   role_definition_name = "Key Vault Secrets Officer"
   principal_id         = each.value
 }
 
 resource "azurerm_role_assignment" "key_vault_secrets_user" {
-  for_each = local.rbac_enabled ? { for idx, principal_id in var.rbac_secrets_users : idx => principal_id } : {}
+  for_each = local.create_vault && local.rbac_enabled ? { for idx, principal_id in var.rbac_secrets_users : idx => principal_id } : {}
 
-  scope                = azurerm_key_vault.this.id
+  scope                = local.vault.id
   role_definition_name = "Key Vault Secrets User"
   principal_id         = each.value
 }
 
 resource "azurerm_role_assignment" "key_vault_crypto_officer" {
-  for_each = local.rbac_enabled ? { for idx, principal_id in var.rbac_crypto_officers : idx => principal_id } : {}
+  for_each = local.create_vault && local.rbac_enabled ? { for idx, principal_id in var.rbac_crypto_officers : idx => principal_id } : {}
 
-  scope                = azurerm_key_vault.this.id
+  scope                = local.vault.id
   role_definition_name = "Key Vault Crypto Officer"
   principal_id         = each.value
 }
 
 resource "azurerm_role_assignment" "key_vault_crypto_user" {
-  for_each = local.rbac_enabled ? { for idx, principal_id in var.rbac_crypto_users : idx => principal_id } : {}
+  for_each = local.create_vault && local.rbac_enabled ? { for idx, principal_id in var.rbac_crypto_users : idx => principal_id } : {}
 
-  scope                = azurerm_key_vault.this.id
+  scope                = local.vault.id
   role_definition_name = "Key Vault Crypto User"
   principal_id         = each.value
 }
 
 resource "azurerm_role_assignment" "key_vault_certificates_officer" {
-  for_each = local.rbac_enabled ? { for idx, principal_id in var.rbac_certificates_officers : idx => principal_id } : {}
+  for_each = local.create_vault && local.rbac_enabled ? { for idx, principal_id in var.rbac_certificates_officers : idx => principal_id } : {}
 
-  scope                = azurerm_key_vault.this.id
+  scope                = local.vault.id
   role_definition_name = "Key Vault Certificates Officer"
   principal_id         = each.value
 }
 
+# Emergency break-glass access, off unless break_glass_enabled
+resource "azurerm_role_assignment" "break_glass" {
+  count = local.create_vault && local.rbac_enabled && local.break_glass_enabled ? 1 : 0
+
+  scope                = local.vault.id
+  role_definition_name = "Key Vault Administrator"
+  principal_id         = var.break_glass_object_id
+  description          = "break-glass"
+}
+
+resource "azurerm_key_vault_access_policy" "break_glass" {
+  count = local.create_vault && !local.rbac_enabled && local.break_glass_enabled ? 1 : 0
+
+  key_vault_id = local.vault.id
+
+  tenant_id = local.tenant_id
+  object_id = var.break_glass_object_id
+
+  key_permissions         = ["Backup", "Create", "Decrypt", "Delete", "Encrypt", "Get", "Import", "List", "Purge", "Recover", "Restore", "Sign", "UnwrapKey", "Update", "Verify", "WrapKey", "Release", "Rotate", "GetRotationPolicy", "SetRotationPolicy"]
+  secret_permissions      = ["Backup", "Delete", "Get", "List", "Purge", "Recover", "Restore", "Set"]
+  certificate_permissions = ["Backup", "Create", "Delete", "DeleteIssuers", "Get", "GetIssuers", "Import", "List", "ListIssuers", "ManageContacts", "ManageIssuers", "Purge", "Recover", "Restore", "SetIssuers", "Update"]
+  storage_permissions     = ["Backup", "Delete", "DeleteSAS", "Get", "GetSAS", "List", "ListSAS", "Purge", "Recover", "RegenerateKey", "Restore", "Set", "SetSAS", "Update"]
+}
+
+check "break_glass_object_id_missing" {
+  assert {
+    condition     = !var.break_glass_enabled || var.break_glass_object_id != null
+    error_message = "break_glass_enabled is true but break_glass_object_id is not set, so no break-glass access is granted."
+  }
+}
+
+# Managed identities of VMs deployed with secrets from the vault
+resource "azurerm_role_assignment" "deployment_secrets_user" {
+  for_each = local.create_vault && local.rbac_enabled && var.enabled_for_deployment ? { for idx, principal_id in var.deployment_principal_ids : idx => principal_id } : {}
+
+  scope                = local.vault.id
+  role_definition_name = "Key Vault Secrets User"
+  principal_id         = each.value
+  principal_type       = "ServicePrincipal"
+}
+
+check "deployment_principal_ids_ignored" {
+  assert {
+    condition     = length(var.deployment_principal_ids) == 0 || local.rbac_enabled && var.enabled_for_deployment
+    error_message = "deployment_principal_ids are only granted Key Vault Secrets User with enabled_for_deployment = true and enable_rbac_authorization = true, and are ignored otherwise."
+  }
+}
+
+resource "azurerm_role_assignment" "this" {
+  for_each = local.create_vault && local.rbac_enabled ? var.role_assignments : {}
+
+  scope                = local.vault.id
+  role_definition_name = each.value.role_definition_name
+  principal_id         = each.value.principal_id
+  principal_type       = each.value.principal_type
+}
+
+# Creation time of each key and secret that takes its expiry from
+# default_expiration_days
+resource "time_static" "created" {
+  for_each = local.manage_data_plane ? local.default_expiration_entries : {}
+}
+
 # Keys
 resource "azurerm_key_vault_key" "this" {
-  for_each = var.keys
+  for_each = local.manage_data_plane ? local.generated_keys : {}
 
   name         = each.value.name
-  key_vault_id = azurerm_key_vault.this.id
+  key_vault_id = local.vault.id
 
   key_type        = each.value.key_type
   key_size        = each.value.key_size
   key_opts        = each.value.key_opts
   curve           = each.value.curve
   not_before_date = each.value.not_before_date
-  expiration_date = each.value.expiration_date
+  expiration_date = local.key_expiration_dates[each.key]
 
   dynamic "rotation_policy" {
     for_each = each.value.rotation_policy != null ? [each.value.rotation_policy] : []
     content {
-      automatic {
-        time_before_expiry = rotation_policy.value.time_before_expiry
+      dynamic "automatic" {
+        for_each = rotation_policy.value.time_after_creation != null || rotation_policy.value.time_before_expiry != null ? [1] : []
+        content {
+          time_after_creation = rotation_policy.value.time_after_creation
+          time_before_expiry  = rotation_policy.value.time_before_expiry
+        }
       }
       expire_after         = rotation_policy.value.expire_after
       notify_before_expiry = rotation_policy.value.notify_before_expiry
     }
   }
 
-  tags = merge(local.tags, each.value.tags)
+  tags = merge(local.data_plane_tags, each.value.tags, { ManagedBy = local.managed_by })
+
+  lifecycle {
+    precondition {
+      condition     = !endswith(each.value.key_type, "-HSM") || var.sku_name == "premium"
+      error_message = "Key '${each.key}' is HSM-backed (key_type = ${each.value.key_type}), which the ${var.sku_name} SKU does not support. Set sku_name = \"premium\", or use key_type ${trimsuffix(each.value.key_type, "-HSM")} for a software-protected key."
+    }
+    precondition {
+      condition     = !var.require_expiration || each.value.expiration_date != null || var.default_expiration_days != null
+      error_message = "Key '${each.key}' has no expiration_date and require_expiration is set. Set an expiration_date on the key, or default_expiration_days to expire keys without one."
+    }
+  }
+}
+
+# Keys imported from existing PFX or PEM material. Key Vault only accepts
+# private key material as part of a certificate.
+resource "azurerm_key_vault_certificate" "imported_key" {
+  for_each = local.manage_data_plane ? local.imported_keys : {}
+
+  name         = each.value.name
+  key_vault_id = local.vault.id
+
+  certificate {
+    contents = var.keys[each.key].key_material.contents
+    password = var.keys[each.key].key_material.password
+  }
+
+  certificate_policy {
+    issuer_parameters {
+      name = "Unknown"
+    }
+
+    key_properties {
+      exportable = true
+      key_type   = each.value.key_type
+      reuse_key  = false
+    }
+
+    secret_properties {
+      content_type = each.value.imported_pem ? "application/x-pem-file" : "application/x-pkcs12"
+    }
+  }
+
+  tags = merge(local.data_plane_tags, each.value.tags, { ManagedBy = local.managed_by })
+
+  depends_on = [azurerm_key_vault_access_policy.this]
+}
+
+# Disk encryption set key
+resource "azurerm_key_vault_key" "disk_encryption" {
+  count = local.manage_data_plane && var.disk_encryption_key != null ? 1 : 0
+
+  name         = var.disk_encryption_key.name
+  key_vault_id = local.vault.id
+
+  key_type        = var.disk_encryption_key.key_type
+  key_size        = 2048
+  key_opts        = var.disk_encryption_key.key_opts
+  expiration_date = var.disk_encryption_key.expiration_date
+
+  dynamic "rotation_policy" {
+    for_each = var.disk_encryption_key.rotation_policy != null ? [var.disk_encryption_key.rotation_policy] : []
+    content {
+      dynamic "automatic" {
+        for_each = rotation_policy.value.time_after_creation != null || rotation_policy.value.time_before_expiry != null ? [1] : []
+        content {
+          time_after_creation = rotation_policy.value.time_after_creation
+          time_before_expiry  = rotation_policy.value.time_before_expiry
+        }
+      }
+      expire_after         = rotation_policy.value.expire_after
+      notify_before_expiry = rotation_policy.value.notify_before_expiry
+    }
+  }
+
+  tags = merge(local.data_plane_tags, var.disk_encryption_key.tags, { ManagedBy = local.managed_by })
+
+  lifecycle {
+    precondition {
+      condition     = var.disk_encryption_key.key_type != "RSA-HSM" || var.sku_name == "premium"
+      error_message = "The disk encryption key is HSM-backed (key_type = RSA-HSM), which the ${var.sku_name} SKU does not support. Set sku_name = \"premium\", or use key_type RSA."
+    }
+  }
 }
 
 # Secrets
 resource "azurerm_key_vault_secret" "this" {
-  for_each = var.secrets
+  for_each = local.manage_data_plane ? local.secret_metadata : {}
 
   name         = each.value.name
-  value        = each.value.value
-  key_vault_id = azurerm_key_vault.this.id
+  value        = var.secrets[each.key].value_from_file != null ? file(var.secrets[each.key].value_from_file) : var.secrets[each.key].value
+  key_vault_id = local.vault.id
 
   content_type    = each.value.content_type
   not_before_date = each.value.not_before_date
-  expiration_date = each.value.expiration_date
+  expiration_date = local.secret_expiration_dates[each.key]
+
+  tags = merge(local.data_plane_tags, each.value.tags, { ManagedBy = local.managed_by })
+
+  depends_on = [azurerm_key_vault_access_policy.this]
+
+  lifecycle {
+    precondition {
+      condition     = !var.require_expiration || each.value.expiration_date != null || var.default_expiration_days != null
+      error_message = "Secret '${each.key}' has no expiration_date and require_expiration is set. Set an expiration_date on the secret, or default_expiration_days to expire secrets without one."
+    }
+  }
+}
+
+# Disabled keys and secrets. The azurerm provider cannot disable them, so
+# the az CLI sets them disabled after each new version, and enables them again
+# when they are switched back on. Destroy provisioners can only reference self,
+# so the az arguments are passed as input.
+resource "terraform_data" "disabled_objects" {
+  for_each = merge(
+    { for k, v in azurerm_key_vault_key.this : "key:${k}" => { type = "key", name = v.name, version = v.version } if !local.generated_keys[k].enabled },
+    { for k, v in azurerm_key_vault_secret.this : "secret:${k}" => { type = "secret", name = v.name, version = v.version } if !local.secret_metadata[k].enabled },
+  )
+
+  triggers_replace = each.value.version
+
+  input = {
+    cli_args = "${each.value.type} set-attributes --vault-name '${local.vault.name}' --name '${each.value.name}' --subscription '${data.azurerm_client_config.current.subscription_id}'"
+  }
+
+  provisioner "local-exec" {
+    command = "az keyvault ${self.input.cli_args} --enabled false"
+  }
+
+  # An object deleted along with its vault has nothing left to enable
+  provisioner "local-exec" {
+    when       = destroy
+    command    = "az keyvault ${self.input.cli_args} --enabled true"
+    on_failure = continue
+  }
+}
+
+# Kubernetes Secrets Store CSI driver manifest, written locally; no Azure
+# resources are involved
+resource "local_file" "csi_provider_class" {
+  count = local.create_vault && var.emit_csi_provider_class ? 1 : 0
+
+  filename        = var.csi_provider_class.path
+  file_permission = "0644"
+  content = templatefile("${path.module}/templates/secret_provider_class.yaml.tftpl", {
+    name           = coalesce(var.csi_provider_class.name, local.kv_name)
+    namespace      = var.csi_provider_class.namespace
+    client_id      = var.csi_provider_class.client_id
+    key_vault_name = local.vault.name
+    tenant_id      = local.vault.tenant_id
+    secret_names   = sort([for v in values(local.secret_metadata) : v.name])
+  })
+
+  lifecycle {
+    precondition {
+      condition     = var.csi_provider_class.path != null
+      error_message = "csi_provider_class.path must be set when emit_csi_provider_class is true."
+    }
+  }
+}
+
+# Markdown summary of the vault for self-service catalogs, written locally; no
+# Azure resources are involved. Secret values are never included.
+resource "local_file" "summary" {
+  count = local.create_vault && var.emit_summary ? 1 : 0
+
+  filename        = var.summary_path
+  file_permission = "0644"
+  content = templatefile("${path.module}/templates/vault_summary.md.tftpl", {
+    name                          = local.vault.name
+    uri                           = local.vault_uri
+    sku                           = var.sku_name
+    location                      = var.location
+    resource_group                = local.resource_group_name
+    rbac_enabled                  = local.rbac_enabled
+    purge_protection_enabled      = local.purge_protection_enabled
+    soft_delete_retention_days    = local.soft_delete_retention_days
+    public_network_access_enabled = local.public_network_access_enabled
+    network_default_action        = local.network_acls != null ? local.network_acls.default_action : "Allow"
+    private_endpoint_enabled      = local.private_endpoint_enabled
+    key_names                     = sort([for v in values(local.key_metadata) : v.name])
+    secret_names                  = sort([for v in values(local.secret_metadata) : v.name])
+  })
+
+  lifecycle {
+    precondition {
+      condition     = var.summary_path != null
+      error_message = "summary_path must be set when emit_summary is true."
+    }
+  }
+}
+
+# Certificate Issuers
+resource "azurerm_key_vault_certificate_issuer" "this" {
+  for_each = local.manage_data_plane ? local.certificate_issuer_metadata : {}
+
+  name          = each.key
+  key_vault_id  = local.vault.id
+  provider_name = each.value.provider_name
+  account_id    = each.value.account_id
+  org_id        = each.value.org_id
+  password      = var.certificate_issuers[each.key].password
+
+  dynamic "admin" {
+    for_each = each.value.admins
+    content {
+      email_address = admin.value.email_address
+      first_name    = admin.value.first_name
+      last_name     = admin.value.last_name
+      phone         = admin.value.phone
+    }
+  }
+
+  depends_on = [azurerm_key_vault_access_policy.this]
+}
 
-  tags = merge(local.tags, each.value.tags)
+# Certificate Contacts
+resource "azurerm_key_vault_certificate_contacts" "this" {
+  count = local.manage_data_plane && length(var.certificate_contacts) > 0 ? 1 : 0
+
+  key_vault_id = local.vault.id
+
+  dynamic "contact" {
+    for_each = var.certificate_contacts
+    content {
+      email = contact.value.email
+      name  = contact.value.name
+      phone = contact.value.phone
+    }
+  }
 
   depends_on = [azurerm_key_vault_access_policy.this]
 }
 
+check "certificate_contacts_conflict" {
+  assert {
+    condition     = length(var.contacts) == 0 || length(var.certificate_contacts) == 0
+    error_message = "contacts and certificate_contacts both manage the vault's certificate contacts and will overwrite each other. Move the entries from contacts to certificate_contacts."
+  }
+}
+
 # Certificates
 resource "azurerm_key_vault_certificate" "this" {
-  for_each = var.certificates
+  for_each = local.manage_data_plane ? var.certificates : {}
 
   name         = each.value.name
-  key_vault_id = azurerm_key_vault.this.id
+  key_vault_id = local.vault.id
 
   certificate_policy {
     issuer_parameters {
@@ -199,6 +1257,20 @@ resource "azurerm_key_vault_certificate" "this" {
       reuse_key  = each.value.key_properties.reuse_key
     }
 
+    dynamic "lifetime_action" {
+      for_each = each.value.lifetime_actions
+      content {
+        action {
+          action_type = lifetime_action.value.action_type
+        }
+
+        trigger {
+          days_before_expiry  = lifetime_action.value.days_before_expiry
+          lifetime_percentage = lifetime_action.value.lifetime_percentage
+        }
+      }
+    }
+
     secret_properties {
       content_type = each.value.secret_properties.content_type
     }
@@ -217,48 +1289,159 @@ resource "azurerm_key_vault_certificate" "this" {
     }
   }
 
-  tags = merge(local.tags, each.value.tags)
+  tags = merge(local.data_plane_tags, each.value.tags, { ManagedBy = local.managed_by })
 
-  depends_on = [azurerm_key_vault_access_policy.this]
+  depends_on = [
+    azurerm_key_vault_access_policy.this,
+    azurerm_key_vault_certificate_issuer.this
+  ]
+
+  lifecycle {
+    precondition {
+      condition     = !endswith(each.value.key_properties.key_type, "-HSM") || var.sku_name == "premium"
+      error_message = "Certificate '${each.key}' has an HSM-backed key (key_type = ${each.value.key_properties.key_type}), which the ${var.sku_name} SKU does not support. Set sku_name = \"premium\", or use key_type ${trimsuffix(each.value.key_properties.key_type, "-HSM")} for a software-protected key."
+    }
+  }
+}
+
+# Managed Storage Accounts. Key Vault regenerates the keys as its own service
+# principal, which needs the key operator role on each account.
+resource "azurerm_role_assignment" "managed_storage_key_operator" {
+  for_each = local.manage_data_plane ? var.managed_storage_accounts : {}
+
+  scope                = each.value.storage_account_id
+  role_definition_name = "Storage Account Key Operator Service Role"
+  principal_id         = var.key_vault_service_principal_object_id
+  principal_type       = "ServicePrincipal"
+
+  lifecycle {
+    precondition {
+      condition     = var.key_vault_service_principal_object_id != null
+      error_message = "Managed storage account '${each.key}' needs key_vault_service_principal_object_id, the object ID of the Azure Key Vault service principal in your tenant: az ad sp show --id cfa8b339-82a2-471a-a3c9-0fc0be7a4093 --query id"
+    }
+  }
+}
+
+resource "azurerm_key_vault_managed_storage_account" "this" {
+  for_each = local.manage_data_plane ? var.managed_storage_accounts : {}
+
+  name                         = each.value.name
+  key_vault_id                 = local.vault.id
+  storage_account_id           = each.value.storage_account_id
+  storage_account_key          = each.value.storage_account_key
+  regenerate_key_automatically = each.value.regenerate_key_automatically
+  regeneration_period          = each.value.regeneration_period
+
+  tags = merge(local.common_tags, each.value.tags, { ManagedBy = local.managed_by })
+
+  depends_on = [
+    azurerm_key_vault_access_policy.this,
+    azurerm_role_assignment.managed_storage_key_operator
+  ]
+
+  lifecycle {
+    precondition {
+      condition     = !local.rbac_enabled
+      error_message = "Managed storage account '${each.key}' needs a vault using access policies: Key Vault does not support managed storage account keys under the RBAC permission model. Set enable_rbac_authorization = false and grant the deployer storage permissions through access_policies."
+    }
+  }
 }
 
 # Private Endpoint
 resource "azurerm_private_endpoint" "this" {
-  count = var.enable_private_endpoint ? 1 : 0
+  count = local.private_endpoint_enabled ? 1 : 0
 
-  name                = "${local.kv_name}-pe"
+  name                = local.private_endpoint_name
   location            = var.location
-  resource_group_name = var.resource_group_name
+  resource_group_name = local.resource_group_name
   subnet_id           = var.private_endpoint_subnet_id
 
   private_service_connection {
-    name                           = "${local.kv_name}-pe-connection"
-    private_connection_resource_id = azurerm_key_vault.this.id
+    name                           = "${local.private_endpoint_name}-connection"
+    private_connection_resource_id = local.backend_id
     is_manual_connection           = false
-    subresource_names              = ["vault"]
+    subresource_names              = [local.is_managed_hsm ? "managedhsm" : "vault"]
   }
 
   dynamic "private_dns_zone_group" {
-    for_each = var.private_dns_zone_ids != null ? [1] : []
+    for_each = length(local.private_dns_zone_ids) > 0 ? [1] : []
     content {
       name                 = "default"
-      private_dns_zone_ids = var.private_dns_zone_ids
+      private_dns_zone_ids = local.private_dns_zone_ids
     }
   }
 
-  tags = local.tags
+  tags = local.common_tags
+
+  lifecycle {
+    precondition {
+      condition     = var.private_endpoint_subnet_id != null
+      error_message = "private_endpoint_subnet_id must be set when enable_private_endpoint is true."
+    }
+    precondition {
+      condition     = var.private_dns_zone_name == null || length(var.private_dns_zone_ids) == 0
+      error_message = "Set either private_dns_zone_ids or private_dns_zone_name, not both."
+    }
+    precondition {
+      condition     = (var.private_dns_zone_name == null) == (var.private_dns_zone_resource_group == null)
+      error_message = "private_dns_zone_name and private_dns_zone_resource_group must be set together to look up the private DNS zone by name."
+    }
+  }
 }
 
 # Diagnostic Settings
+resource "azurerm_storage_account" "diagnostics" {
+  count = local.diagnostic_storage_enabled ? 1 : 0
+
+  name                            = local.diagnostic_storage_name
+  resource_group_name             = local.resource_group_name
+  location                        = var.location
+  account_tier                    = "Standard"
+  account_replication_type        = var.diagnostic_storage.account_replication_type
+  https_traffic_only_enabled      = true
+  min_tls_version                 = "TLS1_2"
+  allow_nested_items_to_be_public = false
+
+  tags = local.common_tags
+}
+
+resource "azurerm_storage_management_policy" "diagnostics" {
+  count = local.diagnostic_storage_enabled && length(local.diagnostic_retention_rules) > 0 ? 1 : 0
+
+  storage_account_id = azurerm_storage_account.diagnostics[0].id
+
+  dynamic "rule" {
+    for_each = local.diagnostic_retention_rules
+    content {
+      name    = rule.key
+      enabled = true
+
+      filters {
+        blob_types   = ["appendBlob", "blockBlob"]
+        prefix_match = [rule.value.prefix]
+      }
+
+      actions {
+        base_blob {
+          delete_after_days_since_modification_greater_than = rule.value.days
+        }
+      }
+    }
+  }
+}
+
 resource "azurerm_monitor_diagnostic_setting" "this" {
-  count = var.enable_diagnostic_settings ? 1 : 0
+  count = local.diagnostics_enabled ? 1 : 0
 
-  name                       = "${local.kv_name}-diagnostics"
-  target_resource_id         = azurerm_key_vault.this.id
-  log_analytics_workspace_id = var.log_analytics_workspace_id
+  name                           = "${local.kv_name}-diagnostics"
+  target_resource_id             = local.backend_id
+  log_analytics_workspace_id     = local.diagnostic_workspace_id
+  storage_account_id             = local.diagnostic_storage_enabled ? azurerm_storage_account.diagnostics[0].id : null
+  eventhub_authorization_rule_id = var.diagnostic_settings.eventhub_authorization_rule_id
+  eventhub_name                  = var.diagnostic_settings.eventhub_name
 
   dynamic "enabled_log" {
-    for_each = var.diagnostic_logs
+    for_each = local.diagnostic_log_categories
     content {
       category = enabled_log.value
     }
@@ -273,12 +1456,177 @@ resource "azurerm_monitor_diagnostic_setting" "this" {
   }
 }
 
+# Event Grid Notifications
+resource "azurerm_eventgrid_system_topic" "this" {
+  count = local.event_grid_enabled ? 1 : 0
+
+  name                   = "${local.kv_name}-events"
+  location               = var.location
+  resource_group_name    = local.resource_group_name
+  source_arm_resource_id = local.vault.id
+  topic_type             = "Microsoft.KeyVault.vaults"
+
+  dynamic "identity" {
+    for_each = var.identity != null ? [var.identity] : []
+    content {
+      type         = identity.value.type
+      identity_ids = length(identity.value.identity_ids) > 0 ? identity.value.identity_ids : null
+    }
+  }
+
+  tags = local.common_tags
+}
+
+resource "azurerm_eventgrid_system_topic_event_subscription" "this" {
+  count = local.event_grid_enabled ? 1 : 0
+
+  name                 = "${local.kv_name}-near-expiry"
+  system_topic         = azurerm_eventgrid_system_topic.this[0].name
+  resource_group_name  = local.resource_group_name
+  included_event_types = var.event_grid_event_types
+
+  dynamic "webhook_endpoint" {
+    for_each = var.event_grid_webhook_url != null ? [1] : []
+    content {
+      url = var.event_grid_webhook_url
+    }
+  }
+
+  dynamic "storage_queue_endpoint" {
+    for_each = var.event_grid_storage_queue != null ? [var.event_grid_storage_queue] : []
+    content {
+      storage_account_id = storage_queue_endpoint.value.storage_account_id
+      queue_name         = storage_queue_endpoint.value.queue_name
+    }
+  }
+
+  # Webhooks cannot be delivered to with a managed identity
+  dynamic "delivery_identity" {
+    for_each = var.identity != null && var.event_grid_storage_queue != null ? [var.identity] : []
+    content {
+      type                   = length(delivery_identity.value.identity_ids) > 0 ? "UserAssigned" : "SystemAssigned"
+      user_assigned_identity = length(delivery_identity.value.identity_ids) > 0 ? delivery_identity.value.identity_ids[0] : null
+    }
+  }
+
+  lifecycle {
+    precondition {
+      condition     = (var.event_grid_webhook_url != null) != (var.event_grid_storage_queue != null)
+      error_message = "event_grid_enabled needs exactly one destination: set either event_grid_webhook_url or event_grid_storage_queue."
+    }
+  }
+}
+
+# Action group for the availability alert, for callers without one
+resource "azurerm_monitor_action_group" "this" {
+  count = local.action_group_enabled ? 1 : 0
+
+  name                = coalesce(var.action_group.name, "${local.kv_name}-alerts")
+  resource_group_name = local.resource_group_name
+  short_name          = coalesce(var.action_group.short_name, substr(local.kv_name, 0, 12))
+
+  dynamic "email_receiver" {
+    for_each = var.action_group.email_receivers
+    content {
+      name                    = email_receiver.key
+      email_address           = email_receiver.value.email_address
+      use_common_alert_schema = email_receiver.value.use_common_alert_schema
+    }
+  }
+
+  dynamic "webhook_receiver" {
+    for_each = var.action_group.webhook_receivers
+    content {
+      name                    = webhook_receiver.key
+      service_uri             = webhook_receiver.value.service_uri
+      use_common_alert_schema = webhook_receiver.value.use_common_alert_schema
+    }
+  }
+
+  tags = local.common_tags
+
+  lifecycle {
+    precondition {
+      condition     = var.availability_alert_action_group_id == null
+      error_message = "Set either availability_alert_action_group_id or action_group, not both."
+    }
+  }
+}
+
+# Availability Alert. ServiceApiResult counts every API result, so only
+# throttled (429) requests are alerted on.
+resource "azurerm_monitor_metric_alert" "availability" {
+  count = local.availability_alert_enabled ? 1 : 0
+
+  name                = "${local.kv_name}-availability"
+  resource_group_name = local.resource_group_name
+  scopes              = [local.vault.id]
+  description         = "Key Vault '${local.kv_name}' is ${var.availability_alert_metric == "SaturationShoebox" ? "saturated" : "throttling requests"}"
+  severity            = 2
+  frequency           = "PT1M"
+  window_size         = "PT5M"
+
+  criteria {
+    metric_namespace = "Microsoft.KeyVault/vaults"
+    metric_name      = var.availability_alert_metric
+    aggregation      = var.availability_alert_metric == "SaturationShoebox" ? "Average" : "Count"
+    operator         = "GreaterThan"
+    threshold        = var.availability_alert_threshold
+
+    dynamic "dimension" {
+      for_each = var.availability_alert_metric == "ServiceApiResult" ? [1] : []
+      content {
+        name     = "StatusCode"
+        operator = "Include"
+        values   = ["429"]
+      }
+    }
+  }
+
+  action {
+    action_group_id = local.availability_alert_action_group_id
+  }
+
+  tags = local.common_tags
+
+  lifecycle {
+    precondition {
+      condition     = var.availability_alert_action_group_id != null || var.action_group != null
+      error_message = "create_availability_alert needs an action group to notify: set availability_alert_action_group_id, or action_group to have the module create one."
+    }
+  }
+}
+
 # Management Lock
 resource "azurerm_management_lock" "this" {
-  count = var.enable_resource_lock ? 1 : 0
+  count = local.has_backend && var.enable_resource_lock ? 1 : 0
 
-  name       = "${local.kv_name}-lock"
-  scope      = azurerm_key_vault.this.id
+  name       = coalesce(var.resource_lock_name, "${local.kv_name}-lock")
+  scope      = local.backend_id
   lock_level = var.resource_lock_level
   notes      = "Key Vault resource lock to prevent accidental deletion"
+
+  # A lock on the vault also blocks deleting role assignments and diagnostic
+  # settings scoped to it. Depending on them makes the lock the last resource
+  # created and the first one removed on destroy.
+  depends_on = [
+    azurerm_key_vault_access_policy.this,
+    azurerm_key_vault_access_policy.break_glass,
+    azurerm_role_assignment.this,
+    azurerm_role_assignment.key_vault_administrator,
+    azurerm_role_assignment.key_vault_secrets_officer,
+    azurerm_role_assignment.key_vault_secrets_user,
+    azurerm_role_assignment.key_vault_crypto_officer,
+    azurerm_role_assignment.key_vault_crypto_user,
+    azurerm_role_assignment.key_vault_certificates_officer,
+    azurerm_role_assignment.deployment_secrets_user,
+    azurerm_role_assignment.break_glass,
+    azurerm_key_vault_key.this,
+    azurerm_key_vault_certificate.imported_key,
+    azurerm_key_vault_key.disk_encryption,
+    azurerm_key_vault_secret.this,
+    azurerm_key_vault_certificate.this,
+    azurerm_private_endpoint.this,
+    azurerm_monitor_diagnostic_setting.this
+  ]
 }
\ No newline at end of file
@@ -2,51 +2,125 @@
 
 output "key_vault_id" {
   description = "The ID of the Key Vault"
-  value       = azurerm_key_vault.this.id
+  value       = local.create_vault ? local.vault.id : null
 }
 
 output "key_vault_name" {
-  description = "The name of the Key Vault"
-  value       = azurerm_key_vault.this.name
+  description = "The name of the Key Vault, including the random suffix when use_random_suffix is set"
+  value       = local.create_vault ? local.vault.name : null
 }
 
 output "key_vault_uri" {
-  description = "The URI of the Key Vault"
-  value       = azurerm_key_vault.this.vault_uri
+  description = "The data-plane URI of the Key Vault (https://<name>.vault.azure.net/, or under vault_dns_suffix), for configuring SDK clients"
+  value       = local.vault_uri
 }
 
 output "key_vault_resource_group_name" {
   description = "The resource group name of the Key Vault"
-  value       = azurerm_key_vault.this.resource_group_name
+  value       = local.create_vault ? local.vault.resource_group_name : null
 }
 
 output "key_vault_location" {
   description = "The location of the Key Vault"
-  value       = azurerm_key_vault.this.location
+  value       = local.create_vault ? local.vault.location : null
+}
+
+output "key_vault_ids" {
+  description = "Map of vaults keys to Key Vault IDs. Without vaults, holds the single vault under the key \"default\""
+  value = merge(
+    { for v in local.vault[*] : "default" => v.id },
+    { for k, v in azurerm_key_vault.vaults : k => v.id }
+  )
+}
+
+output "key_vault_names" {
+  description = "Map of vaults keys to Key Vault names. Without vaults, holds the single vault under the key \"default\""
+  value = merge(
+    { for v in local.vault[*] : "default" => v.name },
+    { for k, v in azurerm_key_vault.vaults : k => v.name }
+  )
+}
+
+output "key_vault_uris" {
+  description = "Map of vaults keys to Key Vault data-plane URIs. Without vaults, holds the single vault under the key \"default\""
+  value = merge(
+    { for v in local.vault[*] : "default" => local.vault_uri },
+    { for k, v in azurerm_key_vault.vaults : k => var.vault_dns_suffix != null ? "https://${v.name}.${var.vault_dns_suffix}/" : v.vault_uri }
+  )
+}
+
+output "managed_hsm_id" {
+  description = "The ID of the Managed HSM, when backend_type is managed_hsm"
+  value       = local.is_managed_hsm ? azurerm_key_vault_managed_hardware_security_module.this[0].id : null
+}
+
+output "managed_hsm_name" {
+  description = "The name of the Managed HSM, when backend_type is managed_hsm"
+  value       = local.is_managed_hsm ? azurerm_key_vault_managed_hardware_security_module.this[0].name : null
+}
+
+output "hsm_uri" {
+  description = "The URI of the Managed HSM, when backend_type is managed_hsm"
+  value       = local.is_managed_hsm ? azurerm_key_vault_managed_hardware_security_module.this[0].hsm_uri : null
+}
+
+output "vault_uri" {
+  description = "The data-plane URI of the provisioned backend: the Key Vault URI or the Managed HSM URI"
+  value       = local.is_managed_hsm ? azurerm_key_vault_managed_hardware_security_module.this[0].hsm_uri : local.vault_uri
+}
+
+output "resource_group_name" {
+  description = "The name of the resource group containing the Key Vault"
+  value       = local.resource_group_name
+}
+
+output "resource_group_location" {
+  description = "The location of the resource group containing the Key Vault"
+  value       = local.resource_group_location
 }
 
 output "key_vault_tenant_id" {
   description = "The tenant ID of the Key Vault"
-  value       = azurerm_key_vault.this.tenant_id
+  value       = local.create_vault ? local.vault.tenant_id : null
 }
 
 # Keys outputs
 output "key_ids" {
-  description = "Map of key names to key IDs"
-  value = {
-    for k, v in azurerm_key_vault_key.this : k => v.id
-  }
+  description = "Map of key names to key IDs, including keys imported from key_material"
+  value = merge(
+    { for k, v in azurerm_key_vault_key.this : k => v.id },
+    { for k, v in local.imported_key_ids : k => v.id }
+  )
 }
 
 output "key_versions" {
   description = "Map of key names to key versions"
-  value = {
-    for k, v in azurerm_key_vault_key.this : k => v.version
-  }
+  value = merge(
+    { for k, v in azurerm_key_vault_key.this : k => v.version },
+    { for k, v in local.imported_key_ids : k => v.version }
+  )
+}
+
+output "key_vault_key_versionless_ids" {
+  description = "Map of key names to versionless key IDs, for consumers that track the latest version (e.g. disk encryption sets)"
+  value = merge(
+    { for k, v in azurerm_key_vault_key.this : k => v.versionless_id },
+    { for k, v in local.imported_key_ids : k => v.versionless_id }
+  )
+}
+
+output "disk_encryption_key_id" {
+  description = "Versionless ID of the disk encryption set key, so the disk encryption set picks up rotated versions"
+  value       = local.manage_data_plane && var.disk_encryption_key != null ? azurerm_key_vault_key.disk_encryption[0].versionless_id : null
+}
+
+output "key_next_rotation_dates" {
+  description = "Map of key names to the next automatic rotation timestamp, or null when it cannot be derived from the key's expiration date and rotation policy"
+  value       = local.key_next_rotation_dates
 }
 
 output "key_public_keys" {
-  description = "Map of key names to public key PEMs"
+  description = "Map of key names to public key PEMs. Keys imported from key_material are not included"
   value = {
     for k, v in azurerm_key_vault_key.this : k => v.public_key_pem
   }
@@ -55,9 +129,9 @@ output "key_public_keys" {
 
 # Secrets outputs
 output "secret_ids" {
-  description = "Map of secret names to secret IDs"
+  description = "Map of secret names to versionless secret IDs"
   value = {
-    for k, v in azurerm_key_vault_secret.this : k => v.id
+    for k, v in azurerm_key_vault_secret.this : k => v.versionless_id
   }
 }
 
@@ -68,6 +142,16 @@ output "secret_versions" {
   }
 }
 
+output "csi_provider_class_path" {
+  description = "Path of the SecretProviderClass manifest written by emit_csi_provider_class"
+  value       = local.create_vault && var.emit_csi_provider_class ? local_file.csi_provider_class[0].filename : null
+}
+
+output "summary_path" {
+  description = "Path of the markdown vault summary written by emit_summary"
+  value       = local.create_vault && var.emit_summary ? local_file.summary[0].filename : null
+}
+
 # Certificates outputs
 output "certificate_ids" {
   description = "Map of certificate names to certificate IDs"
@@ -90,15 +174,54 @@ output "certificate_thumbprints" {
   }
 }
 
+output "certificate_secret_ids" {
+  description = "Map of certificate names to the IDs of the secrets holding their certificate and private key, for exportable certificates only"
+  value = {
+    for k, v in azurerm_key_vault_certificate.this : k => v.secret_id if var.certificates[k].key_properties.exportable
+  }
+}
+
+output "certificate_contact_emails" {
+  description = "Email addresses of the certificate contacts"
+  value       = local.manage_data_plane && length(var.certificate_contacts) > 0 ? [for c in azurerm_key_vault_certificate_contacts.this[0].contact : c.email] : []
+}
+
+output "certificate_issuer_ids" {
+  description = "Map of certificate issuer names to issuer IDs"
+  value = {
+    for k, v in azurerm_key_vault_certificate_issuer.this : k => v.id
+  }
+}
+
+# Managed Storage Accounts outputs
+output "managed_storage_account_ids" {
+  description = "Map of managed storage account names to their IDs in the Key Vault"
+  value       = { for k, v in azurerm_key_vault_managed_storage_account.this : k => v.id }
+}
+
 # Private Endpoint outputs
 output "private_endpoint_id" {
   description = "The ID of the private endpoint"
-  value       = var.enable_private_endpoint ? azurerm_private_endpoint.this[0].id : null
+  value       = local.private_endpoint_enabled ? azurerm_private_endpoint.this[0].id : null
 }
 
 output "private_endpoint_ip_address" {
   description = "The private IP address of the private endpoint"
-  value       = var.enable_private_endpoint ? azurerm_private_endpoint.this[0].private_service_connection[0].private_ip_address : null
+  value       = local.private_endpoint_enabled ? azurerm_private_endpoint.this[0].private_service_connection[0].private_ip_address : null
+}
+
+output "private_endpoint_fqdn" {
+  description = "The FQDN registered for the private endpoint"
+  value = local.private_endpoint_enabled ? try(
+    azurerm_private_endpoint.this[0].private_dns_zone_configs[0].record_sets[0].fqdn,
+    azurerm_private_endpoint.this[0].custom_dns_configs[0].fqdn,
+    null
+  ) : null
+}
+
+output "private_dns_zone_configs" {
+  description = "Map of the private endpoint's DNS zone config names to the IDs of their private DNS zones. Empty without a private endpoint or a private DNS zone"
+  value       = local.private_endpoint_enabled ? { for c in azurerm_private_endpoint.this[0].private_dns_zone_configs : c.name => c.private_dns_zone_id } : {}
 }
 
 # RBAC outputs
@@ -110,10 +233,18 @@ output "rbac_role_assignments" {
     { for k, v in azurerm_role_assignment.key_vault_secrets_user : "secrets_user_${k}" => v.id },
     { for k, v in azurerm_role_assignment.key_vault_crypto_officer : "crypto_officer_${k}" => v.id },
     { for k, v in azurerm_role_assignment.key_vault_crypto_user : "crypto_user_${k}" => v.id },
-    { for k, v in azurerm_role_assignment.key_vault_certificates_officer : "certificates_officer_${k}" => v.id }
+    { for k, v in azurerm_role_assignment.key_vault_certificates_officer : "certificates_officer_${k}" => v.id },
+    { for k, v in azurerm_role_assignment.deployment_secrets_user : "deployment_secrets_user_${k}" => v.id }
   )
 }
 
+output "role_assignment_ids" {
+  description = "Map of role_assignments keys to role assignment IDs"
+  value = {
+    for k, v in azurerm_role_assignment.this : k => v.id
+  }
+}
+
 # Access Policies outputs
 output "access_policy_ids" {
   description = "Map of access policy keys to policy IDs"
@@ -125,43 +256,145 @@ output "access_policy_ids" {
 # Diagnostic Settings outputs
 output "diagnostic_setting_id" {
   description = "The ID of the diagnostic setting"
-  value       = var.enable_diagnostic_settings ? azurerm_monitor_diagnostic_setting.this[0].id : null
+  value       = local.diagnostics_enabled ? azurerm_monitor_diagnostic_setting.this[0].id : null
+}
+
+output "diagnostic_storage_account_id" {
+  description = "The ID of the storage account archiving the Key Vault's logs and metrics, if create_diagnostic_storage is set"
+  value       = local.diagnostic_storage_enabled ? azurerm_storage_account.diagnostics[0].id : null
+}
+
+# Event Grid outputs
+output "event_grid_system_topic_id" {
+  description = "The ID of the Event Grid system topic on the Key Vault"
+  value       = local.event_grid_enabled ? azurerm_eventgrid_system_topic.this[0].id : null
+}
+
+output "event_grid_subscription_id" {
+  description = "The ID of the Event Grid subscription delivering near-expiry events"
+  value       = local.event_grid_enabled ? azurerm_eventgrid_system_topic_event_subscription.this[0].id : null
+}
+
+output "event_grid_system_topic_principal_id" {
+  description = "The principal ID of the Event Grid system topic's system-assigned identity, for granting it access to the event destination"
+  value       = local.event_grid_enabled && strcontains(try(var.identity.type, ""), "SystemAssigned") ? azurerm_eventgrid_system_topic.this[0].identity[0].principal_id : null
+}
+
+# Availability Alert outputs
+output "action_group_id" {
+  description = "The ID of the action group the module created from action_group, when set"
+  value       = local.action_group_enabled ? azurerm_monitor_action_group.this[0].id : null
+}
+
+output "availability_alert_id" {
+  description = "The ID of the metric alert on the Key Vault's saturation or throttling, when create_availability_alert is set"
+  value       = local.availability_alert_enabled ? azurerm_monitor_metric_alert.availability[0].id : null
+}
+
+# Disaster Recovery outputs
+output "dr_vault_id" {
+  description = "The ID of the disaster recovery vault in the paired region, when create_dr_vault is set"
+  value       = local.dr_vault_enabled ? azurerm_key_vault.dr[0].id : null
+}
+
+output "dr_vault_uri" {
+  description = "The data-plane URI of the disaster recovery vault, when create_dr_vault is set"
+  value       = !local.dr_vault_enabled ? null : var.vault_dns_suffix != null ? "https://${local.dr_vault_name}.${var.vault_dns_suffix}/" : azurerm_key_vault.dr[0].vault_uri
+}
+
+output "dr_vault_location" {
+  description = "The region of the disaster recovery vault, when create_dr_vault is set"
+  value       = local.dr_vault_enabled ? azurerm_key_vault.dr[0].location : null
 }
 
 # Resource Lock outputs
 output "resource_lock_id" {
   description = "The ID of the resource lock"
-  value       = var.enable_resource_lock ? azurerm_management_lock.this[0].id : null
+  value       = var.enabled && var.enable_resource_lock ? azurerm_management_lock.this[0].id : null
+}
+
+# Azure Policy outputs
+output "rotation_policy_assignment_id" {
+  description = "The ID of the key rotation policy assignment on the resource group, when assign_rotation_policy is set"
+  value       = var.enabled && var.assign_rotation_policy ? azurerm_resource_group_policy_assignment.key_vault_key_rotation[0].id : null
+}
+
+output "policy_exemption_ids" {
+  description = "Map of policy exemption names to their IDs"
+  value       = { for k, v in azurerm_resource_group_policy_exemption.this : k => v.id }
 }
 
 # Resource information
 output "resource_tags" {
-  description = "Tags applied to the Key Vault"
-  value       = azurerm_key_vault.this.tags
+  description = "Tags applied to the Key Vault or Managed HSM"
+  value       = local.is_managed_hsm ? azurerm_key_vault_managed_hardware_security_module.this[0].tags : local.create_vault ? local.vault.tags : null
+}
+
+output "common_tags" {
+  description = "The merged tag map applied to the Key Vault and its child resources"
+  value       = var.enabled ? local.common_tags : {}
 }
 
 # Security information
 output "purge_protection_enabled" {
   description = "Whether purge protection is enabled"
-  value       = azurerm_key_vault.this.purge_protection_enabled
+  value       = local.is_managed_hsm ? azurerm_key_vault_managed_hardware_security_module.this[0].purge_protection_enabled : local.create_vault ? local.vault.purge_protection_enabled : null
 }
 
 output "soft_delete_enabled" {
   description = "Whether soft delete is enabled"
-  value       = azurerm_key_vault.this.soft_delete_retention_days > 0
+  value       = local.is_managed_hsm ? azurerm_key_vault_managed_hardware_security_module.this[0].soft_delete_retention_days > 0 : local.create_vault ? local.vault.soft_delete_retention_days > 0 : null
 }
 
 output "network_acls_enabled" {
   description = "Whether network ACLs are enabled"
-  value       = var.enable_network_acls
+  value       = var.enabled ? var.enable_network_acls : null
 }
 
 output "private_endpoint_enabled" {
   description = "Whether private endpoint is enabled"
-  value       = var.enable_private_endpoint
+  value       = var.enabled ? var.enable_private_endpoint : null
+}
+
+output "network_posture" {
+  description = "Summary of the Key Vault's network exposure, as deployed: public network access, the firewall default action and bypass, the number of IP and subnet rules, and whether a private endpoint is attached"
+  value = local.create_vault ? {
+    public_network_access    = local.vault.public_network_access_enabled
+    default_action           = try(local.vault.network_acls[0].default_action, null)
+    bypass                   = try(local.vault.network_acls[0].bypass, null)
+    ip_rule_count            = length(try(local.vault.network_acls[0].ip_rules, []))
+    subnet_rule_count        = length(try(local.vault.network_acls[0].virtual_network_subnet_ids, []))
+    private_endpoint_enabled = local.private_endpoint_enabled
+  } : null
 }
 
 output "rbac_enabled" {
   description = "Whether RBAC authorization is enabled"
-  value       = var.enable_rbac_authorization
-}
\ No newline at end of file
+  value       = var.enabled ? var.enable_rbac_authorization : null
+}
+
+output "recommended_roles" {
+  description = "Least-privilege roles an application identity needs for the vault's contents: Key Vault Crypto User with keys, Key Vault Secrets User with secrets and Key Vault Certificate User with certificates. Informational; nothing is assigned"
+  value       = local.recommended_roles
+}
+# Composite outputs
+output "resources" {
+  description = "IDs of every child resource by logical name, grouped by type. Each group is an empty map when nothing of that type is created"
+  value = {
+    keys                     = merge({ for k, v in azurerm_key_vault_key.this : k => v.id }, { for k, v in local.imported_key_ids : k => v.id })
+    secrets                  = { for k, v in azurerm_key_vault_secret.this : k => v.versionless_id }
+    certificates             = { for k, v in azurerm_key_vault_certificate.this : k => v.id }
+    managed_storage_accounts = { for k, v in azurerm_key_vault_managed_storage_account.this : k => v.id }
+    role_assignments = merge(
+      { for k, v in azurerm_role_assignment.key_vault_administrator : "administrator_${k}" => v.id },
+      { for k, v in azurerm_role_assignment.key_vault_secrets_officer : "secrets_officer_${k}" => v.id },
+      { for k, v in azurerm_role_assignment.key_vault_secrets_user : "secrets_user_${k}" => v.id },
+      { for k, v in azurerm_role_assignment.key_vault_crypto_officer : "crypto_officer_${k}" => v.id },
+      { for k, v in azurerm_role_assignment.key_vault_crypto_user : "crypto_user_${k}" => v.id },
+      { for k, v in azurerm_role_assignment.key_vault_certificates_officer : "certificates_officer_${k}" => v.id },
+      { for k, v in azurerm_role_assignment.deployment_secrets_user : "deployment_secrets_user_${k}" => v.id },
+      { for k, v in azurerm_role_assignment.this : k => v.id }
+    )
+    private_endpoint = { for v in azurerm_private_endpoint.this : v.name => v.id }
+  }
+}
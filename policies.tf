@@ -26,9 +26,15 @@ data "azurerm_policy_definition" "key_vault_private_endpoint" {
   display_name = "Key Vault should use private link"
 }
 
+data "azurerm_policy_definition" "key_vault_key_rotation" {
+  count = var.enabled && var.assign_rotation_policy ? 1 : 0
+
+  display_name = "Keys should have a rotation policy ensuring that their rotation is scheduled within the specified number of days after creation."
+}
+
 # Policy Assignments
 resource "azurerm_resource_group_policy_assignment" "key_vault_purge_protection" {
-  count = var.enable_policy_assignments ? 1 : 0
+  count = var.enabled && var.enable_policy_assignments ? 1 : 0
 
   name                 = "kv-purge-protection"
   resource_group_id    = var.resource_group_id
@@ -42,7 +48,7 @@ resource "azurerm_resource_group_policy_assignment" "key_vault_purge_protection"
 }
 
 resource "azurerm_resource_group_policy_assignment" "key_vault_soft_delete" {
-  count = var.enable_policy_assignments ? 1 : 0
+  count = var.enabled && var.enable_policy_assignments ? 1 : 0
 
   name                 = "kv-soft-delete"
   resource_group_id    = var.resource_group_id
@@ -56,7 +62,7 @@ resource "azurerm_resource_group_policy_assignment" "key_vault_soft_delete" {
 }
 
 resource "azurerm_resource_group_policy_assignment" "key_vault_firewall" {
-  count = var.enable_policy_assignments ? 1 : 0
+  count = var.enabled && var.enable_policy_assignments ? 1 : 0
 
   name                 = "kv-firewall"
   resource_group_id    = var.resource_group_id
@@ -70,7 +76,7 @@ resource "azurerm_resource_group_policy_assignment" "key_vault_firewall" {
 }
 
 resource "azurerm_resource_group_policy_assignment" "key_vault_private_network" {
-  count = var.enable_policy_assignments ? 1 : 0
+  count = var.enabled && var.enable_policy_assignments ? 1 : 0
 
   name                 = "kv-private-network"
   resource_group_id    = var.resource_group_id
@@ -84,7 +90,7 @@ resource "azurerm_resource_group_policy_assignment" "key_vault_private_network"
 }
 
 resource "azurerm_resource_group_policy_assignment" "key_vault_logging" {
-  count = var.enable_policy_assignments ? 1 : 0
+  count = var.enabled && var.enable_policy_assignments ? 1 : 0
 
   name                 = "kv-logging"
   resource_group_id    = var.resource_group_id
@@ -98,13 +104,13 @@ resource "azurerm_resource_group_policy_assignment" "key_vault_logging" {
       value = "setByPolicy"
     }
     logAnalyticsWorkspaceId = {
-      value = var.log_analytics_workspace_id
+      value = local.diagnostic_workspace_id
     }
   })
 }
 
 resource "azurerm_resource_group_policy_assignment" "key_vault_private_link" {
-  count = var.enable_policy_assignments ? 1 : 0
+  count = var.enabled && var.enable_policy_assignments ? 1 : 0
 
   name                 = "kv-private-link"
   resource_group_id    = var.resource_group_id
@@ -117,9 +123,27 @@ resource "azurerm_resource_group_policy_assignment" "key_vault_private_link" {
   })
 }
 
+# Key rotation across the resource group (opt-in)
+resource "azurerm_resource_group_policy_assignment" "key_vault_key_rotation" {
+  count = var.enabled && var.assign_rotation_policy ? 1 : 0
+
+  name                 = "kv-key-rotation"
+  resource_group_id    = local.resource_group_id
+  policy_definition_id = data.azurerm_policy_definition.key_vault_key_rotation[0].id
+
+  parameters = jsonencode({
+    effect = {
+      value = "Audit"
+    }
+    maximumDaysToRotate = {
+      value = var.rotation_policy_max_days
+    }
+  })
+}
+
 # Custom Policy Definitions for Key Vault
 resource "azurerm_policy_definition" "key_vault_key_rotation" {
-  count = var.enable_custom_policies ? 1 : 0
+  count = var.enabled && var.enable_custom_policies ? 1 : 0
 
   name         = "key-vault-key-rotation-policy"
   policy_type  = "Custom"
@@ -145,7 +169,7 @@ resource "azurerm_policy_definition" "key_vault_key_rotation" {
 }
 
 resource "azurerm_policy_definition" "key_vault_secret_expiration" {
-  count = var.enable_custom_policies ? 1 : 0
+  count = var.enabled && var.enable_custom_policies ? 1 : 0
 
   name         = "key-vault-secret-expiration-policy"
   policy_type  = "Custom"
@@ -171,7 +195,7 @@ resource "azurerm_policy_definition" "key_vault_secret_expiration" {
 }
 
 resource "azurerm_policy_definition" "key_vault_certificate_auto_renewal" {
-  count = var.enable_custom_policies ? 1 : 0
+  count = var.enabled && var.enable_custom_policies ? 1 : 0
 
   name         = "key-vault-certificate-auto-renewal-policy"
   policy_type  = "Custom"
@@ -198,7 +222,7 @@ resource "azurerm_policy_definition" "key_vault_certificate_auto_renewal" {
 
 # Initiative Definition for Key Vault Security
 resource "azurerm_policy_set_definition" "key_vault_security_initiative" {
-  count = var.enable_policy_initiative ? 1 : 0
+  count = var.enabled && var.enable_policy_initiative ? 1 : 0
 
   name         = "key-vault-security-initiative"
   policy_type  = "Custom"
@@ -241,7 +265,7 @@ resource "azurerm_policy_set_definition" "key_vault_security_initiative" {
         value = "setByPolicy"
       }
       logAnalyticsWorkspaceId = {
-        value = var.log_analytics_workspace_id
+        value = local.diagnostic_workspace_id
       }
     })
   }
@@ -249,7 +273,7 @@ resource "azurerm_policy_set_definition" "key_vault_security_initiative" {
 
 # Initiative Assignment
 resource "azurerm_resource_group_policy_assignment" "key_vault_security_initiative" {
-  count = var.enable_policy_initiative ? 1 : 0
+  count = var.enabled && var.enable_policy_initiative ? 1 : 0
 
   name                 = "kv-security-initiative"
   resource_group_id    = var.resource_group_id
@@ -257,7 +281,20 @@ resource "azurerm_resource_group_policy_assignment" "key_vault_security_initiati
 
   parameters = jsonencode({
     logAnalyticsWorkspaceId = {
-      value = var.log_analytics_workspace_id
+      value = local.diagnostic_workspace_id
     }
   })
-}
\ No newline at end of file
+}
+
+# Policy Exemptions
+resource "azurerm_resource_group_policy_exemption" "this" {
+  for_each = var.enabled ? { for e in var.policy_exemptions : e.name => e } : {}
+
+  name                            = each.key
+  resource_group_id               = local.resource_group_id
+  policy_assignment_id            = each.value.policy_assignment_id
+  exemption_category              = each.value.exemption_category
+  expires_on                      = each.value.expires_on
+  description                     = each.value.description
+  policy_definition_reference_ids = each.value.policy_definition_reference_ids
+}
@@ -0,0 +1,156 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/keyvault/armkeyvault"
+	"github.com/gruntwork-io/terratest/modules/logger"
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	terratesting "github.com/gruntwork-io/terratest/modules/testing"
+)
+
+// artifactsDirEnv names the environment variable holding the directory
+// failure artifacts are written to, one subdirectory per test and tenant.
+const artifactsDirEnv = "KV_TEST_ARTIFACTS_DIR"
+
+// capturingLogger forwards Terratest log lines to the default logger while
+// recording them, so tests can assert sensitive values never reach the output
+// and failed runs can keep their Terraform logs.
+type capturingLogger struct {
+	mu  sync.Mutex
+	buf strings.Builder
+}
+
+func (l *capturingLogger) Logf(t terratesting.TestingT, format string, args ...interface{}) {
+	l.mu.Lock()
+	fmt.Fprintf(&l.buf, format+"\n", args...)
+	l.mu.Unlock()
+	logger.Default.Logf(t, format, args...)
+}
+
+func (l *capturingLogger) String() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.buf.String()
+}
+
+// artifact is one file written when a test fails.
+type artifact struct {
+	name    string
+	collect func() ([]byte, error)
+}
+
+// artifactT is the subset of *testing.T used to capture artifacts.
+type artifactT interface {
+	Name() string
+	Failed() bool
+	Cleanup(func())
+	Logf(format string, args ...interface{})
+}
+
+// CaptureArtifactsOnFailure records the Terraform logs of terraformOptions
+// and, if t fails, writes them to KV_TEST_ARTIFACTS_DIR together with the
+// output of terraform show -json and the live vault behind the key_vault_id
+// output. Nothing is captured when the variable is unset. BuildTerraformOptions
+// calls it, so every tenant of every test is covered.
+//
+// The files are written from a t.Cleanup, which runs after deferred calls: a
+// test that destroys with defer leaves an empty state and no vault to read by
+// then, but the log still holds every command up to and including the
+// destroy. Register the destroy with t.Cleanup before building the options to
+// keep the state and vault as well.
+func CaptureArtifactsOnFailure(t *testing.T, terraformOptions *terraform.Options) {
+	t.Helper()
+
+	dir := os.Getenv(artifactsDirEnv)
+	if dir == "" {
+		return
+	}
+
+	log := &capturingLogger{}
+	terraformOptions.Logger = logger.New(log)
+	captureArtifactsOnFailure(t, filepath.Join(dir, artifactDirName(t.Name())), []artifact{
+		{name: "terraform.log", collect: func() ([]byte, error) {
+			return []byte(log.String()), nil
+		}},
+		{name: "terraform-show.json", collect: func() ([]byte, error) {
+			out, err := terraform.ShowE(t, terraformOptions)
+			return []byte(out), err
+		}},
+		{name: "vault.json", collect: func() ([]byte, error) {
+			return liveVaultJSON(t, terraformOptions)
+		}},
+	})
+}
+
+// captureArtifactsOnFailure registers a cleanup that writes every artifact
+// into dir if t has failed. An artifact that cannot be collected is written
+// with the error instead, so the file is always there to look at.
+func captureArtifactsOnFailure(t artifactT, dir string, artifacts []artifact) {
+	t.Cleanup(func() {
+		if !t.Failed() {
+			return
+		}
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Logf("failed to create artifacts directory %s: %v", dir, err)
+			return
+		}
+		for _, a := range artifacts {
+			data, err := a.collect()
+			if err != nil {
+				data = []byte(fmt.Sprintf("failed to collect %s: %v\n", a.name, err))
+			}
+			if err := os.WriteFile(filepath.Join(dir, a.name), data, 0o644); err != nil {
+				t.Logf("failed to write artifact %s: %v", a.name, err)
+			}
+		}
+		t.Logf("wrote failure artifacts to %s", dir)
+	})
+}
+
+var unsafeArtifactChars = regexp.MustCompile(`[^A-Za-z0-9._=/-]`)
+
+// artifactDirName turns a test name such as "TestKeyVaultKeys/tenant=a b"
+// into a relative path with one directory per subtest level that cannot
+// escape the artifacts directory.
+func artifactDirName(testName string) string {
+	name := strings.ReplaceAll(unsafeArtifactChars.ReplaceAllString(testName, "_"), "..", "__")
+	return filepath.FromSlash(name)
+}
+
+// liveVaultJSON reads the vault behind the key_vault_id output from ARM. Errors
+// are returned rather than failing t, since it runs during cleanup.
+func liveVaultJSON(t *testing.T, terraformOptions *terraform.Options) ([]byte, error) {
+	vaultID, err := terraform.OutputE(t, terraformOptions, "key_vault_id")
+	if err != nil {
+		return nil, err
+	}
+	id, err := arm.ParseResourceID(vaultID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key_vault_id %q: %w", vaultID, err)
+	}
+
+	cred, err := azidentity.NewDefaultAzureCredential(&azidentity.DefaultAzureCredentialOptions{ClientOptions: clientOptions()})
+	if err != nil {
+		return nil, err
+	}
+	client, err := armkeyvault.NewVaultsClient(id.SubscriptionID, cred, armClientOptions())
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Get(context.Background(), id.ResourceGroupName, id.Name, nil)
+	if err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(resp.Vault, "", "  ")
+}
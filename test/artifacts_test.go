@@ -0,0 +1,79 @@
+package test
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeTestT stands in for a test that can be marked failed without
+// failing the real one. Cleanups run when finish is called.
+type fakeTestT struct {
+	failed   bool
+	cleanups []func()
+	logs     []string
+}
+
+func (f *fakeTestT) Name() string { return "TestFake/tenant=a" }
+func (f *fakeTestT) Failed() bool { return f.failed }
+func (f *fakeTestT) Cleanup(fn func()) {
+	f.cleanups = append(f.cleanups, fn)
+}
+func (f *fakeTestT) Logf(format string, args ...interface{}) {
+	f.logs = append(f.logs, fmt.Sprintf(format, args...))
+}
+
+func (f *fakeTestT) finish() {
+	for i := len(f.cleanups) - 1; i >= 0; i-- {
+		f.cleanups[i]()
+	}
+}
+
+func testArtifacts() []artifact {
+	return []artifact{
+		{name: "terraform.log", collect: func() ([]byte, error) { return []byte("apply failed\n"), nil }},
+		{name: "vault.json", collect: func() ([]byte, error) { return nil, errors.New("vault not found") }},
+	}
+}
+
+func TestCaptureArtifactsOnFailure(t *testing.T) {
+	t.Parallel()
+
+	dir := filepath.Join(t.TempDir(), artifactDirName("TestFake/tenant=a"))
+	fake := &fakeTestT{}
+	captureArtifactsOnFailure(fake, dir, testArtifacts())
+	fake.failed = true
+	fake.finish()
+
+	log, err := os.ReadFile(filepath.Join(dir, "terraform.log"))
+	require.NoError(t, err)
+	assert.Equal(t, "apply failed\n", string(log))
+
+	vault, err := os.ReadFile(filepath.Join(dir, "vault.json"))
+	require.NoError(t, err)
+	assert.Equal(t, "failed to collect vault.json: vault not found\n", string(vault))
+	assert.Contains(t, fake.logs, "wrote failure artifacts to "+dir)
+}
+
+func TestCaptureArtifactsOnFailureSkipsPassingTests(t *testing.T) {
+	t.Parallel()
+
+	dir := filepath.Join(t.TempDir(), "artifacts")
+	fake := &fakeTestT{}
+	captureArtifactsOnFailure(fake, dir, testArtifacts())
+	fake.finish()
+
+	assert.NoDirExists(t, dir)
+}
+
+func TestArtifactDirName(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, filepath.Join("TestKeyVaultKeys", "tenant=prod_eu"), artifactDirName("TestKeyVaultKeys/tenant=prod eu"))
+	assert.Equal(t, filepath.Join("TestKeyVaultKeys", "tenant=__", "etc"), artifactDirName("TestKeyVaultKeys/tenant=../etc"))
+}
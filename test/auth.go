@@ -0,0 +1,169 @@
+package test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/stretchr/testify/require"
+)
+
+// Auth modes selectable through TestConfig.AuthMode. An empty mode uses the
+// azidentity default chain (environment, workload identity, managed identity,
+// Azure CLI).
+const (
+	AuthModeServicePrincipal = "sp"
+	AuthModeManagedIdentity  = "msi"
+	AuthModeCLI              = "cli"
+)
+
+// credentialFactory builds the azidentity credential for each auth mode. It is
+// a variable so tests can check mode selection without real identities.
+type credentialFactory struct {
+	servicePrincipal func(tenantID, clientID, clientSecret string) (azcore.TokenCredential, error)
+	managedIdentity  func(clientID string) (azcore.TokenCredential, error)
+	cli              func(tenantID string) (azcore.TokenCredential, error)
+	defaultChain     func(tenantID string) (azcore.TokenCredential, error)
+}
+
+var azureCredentials = credentialFactory{
+	servicePrincipal: func(tenantID, clientID, clientSecret string) (azcore.TokenCredential, error) {
+		return azidentity.NewClientSecretCredential(tenantID, clientID, clientSecret, &azidentity.ClientSecretCredentialOptions{ClientOptions: clientOptions()})
+	},
+	managedIdentity: func(clientID string) (azcore.TokenCredential, error) {
+		options := &azidentity.ManagedIdentityCredentialOptions{ClientOptions: clientOptions()}
+		if clientID != "" {
+			options.ID = azidentity.ClientID(clientID)
+		}
+		return azidentity.NewManagedIdentityCredential(options)
+	},
+	cli: func(tenantID string) (azcore.TokenCredential, error) {
+		return azidentity.NewAzureCLICredential(&azidentity.AzureCLICredentialOptions{TenantID: tenantID})
+	},
+	defaultChain: func(tenantID string) (azcore.TokenCredential, error) {
+		return azidentity.NewDefaultAzureCredential(&azidentity.DefaultAzureCredentialOptions{ClientOptions: clientOptions(), TenantID: tenantID})
+	},
+}
+
+// newCredential returns the credential for config.AuthMode. The service
+// principal secret is only ever read from ARM_CLIENT_SECRET, never from the
+// tenants file.
+func newCredential(config TestConfig, factory credentialFactory) (azcore.TokenCredential, error) {
+	switch config.AuthMode {
+	case AuthModeServicePrincipal:
+		clientID := clientIDFor(config)
+		secret := os.Getenv("ARM_CLIENT_SECRET")
+		if clientID == "" || secret == "" {
+			return nil, fmt.Errorf("auth mode %q needs a client ID and ARM_CLIENT_SECRET", config.AuthMode)
+		}
+		return factory.servicePrincipal(config.TenantID, clientID, secret)
+	case AuthModeManagedIdentity:
+		return factory.managedIdentity(clientIDFor(config))
+	case AuthModeCLI:
+		return factory.cli(config.TenantID)
+	case "":
+		return factory.defaultChain(config.TenantID)
+	}
+	return nil, fmt.Errorf("unknown auth mode %q; use %q, %q or %q", config.AuthMode, AuthModeServicePrincipal, AuthModeManagedIdentity, AuthModeCLI)
+}
+
+// credentialCache holds the credential SetupAzureAuth resolved for each test,
+// keyed by test name, so SDK calls authenticate as the tenant's auth mode.
+// Tenants of one run can have different auth modes and run in parallel.
+type credentialCache struct {
+	mu    sync.Mutex
+	creds map[string]azcore.TokenCredential
+}
+
+var resolvedCredentials = &credentialCache{creds: map[string]azcore.TokenCredential{}}
+
+func (c *credentialCache) put(testName string, cred azcore.TokenCredential) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.creds[testName] = cred
+}
+
+// get returns the credential of testName or of the closest parent test that
+// resolved one, so subtests share their tenant's credential.
+func (c *credentialCache) get(testName string) (azcore.TokenCredential, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for name := testName; ; {
+		if cred, ok := c.creds[name]; ok {
+			return cred, true
+		}
+		i := strings.LastIndex(name, "/")
+		if i < 0 {
+			return nil, false
+		}
+		name = name[:i]
+	}
+}
+
+// TerraformEnvVars returns the ARM_* variables that make the azurerm provider
+// authenticate the same way as config.AuthMode, in config.Environment.
+func TerraformEnvVars(config TestConfig) map[string]string {
+	env := map[string]string{
+		"ARM_SUBSCRIPTION_ID": config.SubscriptionID,
+		"ARM_TENANT_ID":       config.TenantID,
+	}
+	if config.Environment != "" {
+		env["ARM_ENVIRONMENT"] = strings.ToLower(config.Environment)
+	}
+
+	switch config.AuthMode {
+	case AuthModeServicePrincipal:
+		env["ARM_CLIENT_ID"] = clientIDFor(config)
+		env["ARM_CLIENT_SECRET"] = os.Getenv("ARM_CLIENT_SECRET")
+	case AuthModeManagedIdentity:
+		env["ARM_USE_MSI"] = "true"
+		if clientID := clientIDFor(config); clientID != "" {
+			env["ARM_CLIENT_ID"] = clientID
+		}
+	case AuthModeCLI:
+		env["ARM_USE_CLI"] = "true"
+	}
+	return env
+}
+
+// SetupAzureAuth points the SDK clients and terratest helpers at
+// config.Environment, then resolves credentials for config.AuthMode and checks
+// they can get an ARM token before any resources are created. The test is
+// skipped when no credentials resolve; otherwise azureCredential returns the
+// same credential for the test and its subtests.
+func SetupAzureAuth(t *testing.T, config TestConfig) {
+	t.Helper()
+
+	env, err := cloudEnvironmentFor(config.Environment)
+	require.NoError(t, err, "invalid environment for tenant %s", config.TenantID)
+	require.NoError(t, activeCloud.use(env))
+
+	cred, err := newCredential(config, azureCredentials)
+	if err != nil {
+		t.Skipf("no Azure credentials for tenant %s: %v", config.TenantID, err)
+	}
+
+	_, err = cred.GetToken(context.Background(), policy.TokenRequestOptions{
+		Scopes:   []string{env.armScope()},
+		TenantID: config.TenantID,
+	})
+	if err != nil {
+		t.Skipf("no Azure credentials resolved for tenant %s (auth mode %q); run 'az login' or set ARM_CLIENT_ID/ARM_CLIENT_SECRET: %v", config.TenantID, config.AuthMode, err)
+	}
+	resolvedCredentials.put(t.Name(), cred)
+}
+
+func clientIDFor(config TestConfig) string {
+	if config.ClientID != "" {
+		return config.ClientID
+	}
+	return os.Getenv("ARM_CLIENT_ID")
+}
@@ -0,0 +1,133 @@
+package test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type namedCredential string
+
+func (c namedCredential) GetToken(ctx context.Context, options policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	return azcore.AccessToken{Token: string(c)}, nil
+}
+
+// recordingCredentials returns a factory whose credentials are named after the
+// constructor and arguments that built them.
+func recordingCredentials() credentialFactory {
+	return credentialFactory{
+		servicePrincipal: func(tenantID, clientID, clientSecret string) (azcore.TokenCredential, error) {
+			return namedCredential("sp:" + tenantID + ":" + clientID + ":" + clientSecret), nil
+		},
+		managedIdentity: func(clientID string) (azcore.TokenCredential, error) {
+			return namedCredential("msi:" + clientID), nil
+		},
+		cli: func(tenantID string) (azcore.TokenCredential, error) {
+			return namedCredential("cli:" + tenantID), nil
+		},
+		defaultChain: func(tenantID string) (azcore.TokenCredential, error) {
+			return namedCredential("default:" + tenantID), nil
+		},
+	}
+}
+
+func TestNewCredentialSelectsAuthMode(t *testing.T) {
+	t.Setenv("ARM_CLIENT_ID", "env-client")
+	t.Setenv("ARM_CLIENT_SECRET", "s3cret")
+
+	testCases := []struct {
+		config   TestConfig
+		expected namedCredential
+	}{
+		{TestConfig{TenantID: "tenant", AuthMode: AuthModeServicePrincipal}, "sp:tenant:env-client:s3cret"},
+		{TestConfig{TenantID: "tenant", AuthMode: AuthModeServicePrincipal, ClientID: "file-client"}, "sp:tenant:file-client:s3cret"},
+		{TestConfig{TenantID: "tenant", AuthMode: AuthModeManagedIdentity, ClientID: "uami"}, "msi:uami"},
+		{TestConfig{TenantID: "tenant", AuthMode: AuthModeCLI}, "cli:tenant"},
+		{TestConfig{TenantID: "tenant"}, "default:tenant"},
+	}
+
+	for _, tc := range testCases {
+		cred, err := newCredential(tc.config, recordingCredentials())
+		require.NoError(t, err, "auth mode %q", tc.config.AuthMode)
+		assert.Equal(t, tc.expected, cred, "auth mode %q", tc.config.AuthMode)
+	}
+}
+
+func TestNewCredentialErrors(t *testing.T) {
+	t.Setenv("ARM_CLIENT_ID", "")
+	t.Setenv("ARM_CLIENT_SECRET", "")
+
+	_, err := newCredential(TestConfig{AuthMode: AuthModeServicePrincipal}, recordingCredentials())
+	assert.ErrorContains(t, err, "needs a client ID and ARM_CLIENT_SECRET")
+
+	_, err = newCredential(TestConfig{AuthMode: "kerberos"}, recordingCredentials())
+	assert.ErrorContains(t, err, `unknown auth mode "kerberos"`)
+}
+
+func TestCredentialCacheFallsBackToParentTest(t *testing.T) {
+	cache := &credentialCache{creds: map[string]azcore.TokenCredential{}}
+	cache.put("TestVault/tenant-a", namedCredential("sp"))
+	cache.put("TestVault/tenant-b", namedCredential("cli"))
+
+	cred, ok := cache.get("TestVault/tenant-a/rotation/step")
+	require.True(t, ok)
+	assert.Equal(t, namedCredential("sp"), cred)
+
+	cred, ok = cache.get("TestVault/tenant-b")
+	require.True(t, ok)
+	assert.Equal(t, namedCredential("cli"), cred)
+
+	_, ok = cache.get("TestVault/tenant-c")
+	assert.False(t, ok, "a tenant that never resolved credentials should not borrow another's")
+}
+
+func TestTerraformEnvVars(t *testing.T) {
+	t.Setenv("ARM_CLIENT_ID", "env-client")
+	t.Setenv("ARM_CLIENT_SECRET", "s3cret")
+
+	base := TestConfig{TenantID: "tenant", SubscriptionID: "sub"}
+
+	sp := base
+	sp.AuthMode = AuthModeServicePrincipal
+	assert.Equal(t, map[string]string{
+		"ARM_SUBSCRIPTION_ID": "sub",
+		"ARM_TENANT_ID":       "tenant",
+		"ARM_CLIENT_ID":       "env-client",
+		"ARM_CLIENT_SECRET":   "s3cret",
+	}, TerraformEnvVars(sp))
+
+	msi := base
+	msi.AuthMode = AuthModeManagedIdentity
+	msi.ClientID = "uami"
+	assert.Equal(t, map[string]string{
+		"ARM_SUBSCRIPTION_ID": "sub",
+		"ARM_TENANT_ID":       "tenant",
+		"ARM_USE_MSI":         "true",
+		"ARM_CLIENT_ID":       "uami",
+	}, TerraformEnvVars(msi))
+
+	cli := base
+	cli.AuthMode = AuthModeCLI
+	assert.Equal(t, map[string]string{
+		"ARM_SUBSCRIPTION_ID": "sub",
+		"ARM_TENANT_ID":       "tenant",
+		"ARM_USE_CLI":         "true",
+	}, TerraformEnvVars(cli))
+
+	assert.Equal(t, map[string]string{
+		"ARM_SUBSCRIPTION_ID": "sub",
+		"ARM_TENANT_ID":       "tenant",
+	}, TerraformEnvVars(base))
+
+	gov := base
+	gov.Environment = "USGovernment"
+	assert.Equal(t, map[string]string{
+		"ARM_SUBSCRIPTION_ID": "sub",
+		"ARM_TENANT_ID":       "tenant",
+		"ARM_ENVIRONMENT":     "usgovernment",
+	}, TerraformEnvVars(gov))
+}
@@ -0,0 +1,124 @@
+package test
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/authorization/armauthorization/v2"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armlocks"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azcertificates"
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azkeys"
+	"github.com/stretchr/testify/require"
+)
+
+// azureCredential returns the credential for direct Azure SDK calls made by
+// the tests: the one SetupAzureAuth resolved for t or a parent test, so SDK
+// calls and Terraform authenticate alike. Tests that never called
+// SetupAzureAuth get the azidentity default chain.
+func azureCredential(t *testing.T) azcore.TokenCredential {
+	t.Helper()
+
+	if cred, ok := resolvedCredentials.get(t.Name()); ok {
+		return cred
+	}
+	cred, err := azidentity.NewDefaultAzureCredential(&azidentity.DefaultAzureCredentialOptions{ClientOptions: clientOptions()})
+	require.NoError(t, err, "failed to create Azure credential")
+	return cred
+}
+
+// currentPrincipalObjectID returns the object ID of the identity the tests run
+// as, read from the "oid" claim of an ARM access token.
+func currentPrincipalObjectID(t *testing.T, cred azcore.TokenCredential) string {
+	t.Helper()
+
+	token, err := cred.GetToken(context.Background(), policy.TokenRequestOptions{Scopes: []string{activeCloud.get().armScope()}})
+	require.NoError(t, err, "failed to acquire ARM token")
+
+	parts := strings.Split(token.Token, ".")
+	require.Len(t, parts, 3, "ARM token is not a JWT")
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	require.NoError(t, err, "failed to decode ARM token payload")
+
+	var claims struct {
+		ObjectID string `json:"oid"`
+	}
+	require.NoError(t, json.Unmarshal(payload, &claims), "failed to parse ARM token claims")
+	require.NotEmpty(t, claims.ObjectID, "ARM token has no oid claim")
+	return claims.ObjectID
+}
+
+// getRoleAssignment reads a role assignment from ARM by ID and returns it
+// together with the name of its role definition.
+func getRoleAssignment(t *testing.T, config TestConfig, roleAssignmentID string) (armauthorization.RoleAssignment, string) {
+	t.Helper()
+
+	cred := azureCredential(t)
+	ctx := context.Background()
+
+	assignments, err := armauthorization.NewRoleAssignmentsClient(config.SubscriptionID, cred, armClientOptions())
+	require.NoError(t, err)
+	assignment, err := assignments.GetByID(ctx, roleAssignmentID, nil)
+	require.NoError(t, err, "failed to get role assignment %s", roleAssignmentID)
+
+	definitions, err := armauthorization.NewRoleDefinitionsClient(cred, armClientOptions())
+	require.NoError(t, err)
+	definition, err := definitions.GetByID(ctx, *assignment.Properties.RoleDefinitionID, nil)
+	require.NoError(t, err, "failed to get role definition %s", *assignment.Properties.RoleDefinitionID)
+
+	return assignment.RoleAssignment, *definition.Properties.RoleName
+}
+
+// getManagementLock reads a management lock on the given scope from ARM.
+func getManagementLock(t *testing.T, config TestConfig, scope string, lockName string) armlocks.ManagementLockObject {
+	t.Helper()
+
+	client, err := armlocks.NewManagementLocksClient(config.SubscriptionID, azureCredential(t), armClientOptions())
+	require.NoError(t, err)
+	lock, err := client.GetByScope(context.Background(), scope, lockName, nil)
+	require.NoError(t, err, "failed to get management lock %s on %s", lockName, scope)
+	return lock.ManagementLockObject
+}
+
+// getKeyRotationPolicy reads the rotation policy of a key from the vault data
+// plane.
+func getKeyRotationPolicy(t *testing.T, vaultURI string, keyName string) azkeys.KeyRotationPolicy {
+	t.Helper()
+
+	client, err := azkeys.NewClient(vaultURI, azureCredential(t), nil)
+	require.NoError(t, err)
+	policy, err := client.GetKeyRotationPolicy(context.Background(), keyName, nil)
+	require.NoError(t, err, "failed to get rotation policy of key %s", keyName)
+	return policy.KeyRotationPolicy
+}
+
+// getCertificateContacts reads the certificate contacts of a vault from the
+// vault data plane.
+func getCertificateContacts(t *testing.T, vaultURI string) azcertificates.Contacts {
+	t.Helper()
+
+	client, err := azcertificates.NewClient(vaultURI, azureCredential(t), nil)
+	require.NoError(t, err)
+	contacts, err := client.GetContacts(context.Background(), nil)
+	require.NoError(t, err, "failed to get certificate contacts of %s", vaultURI)
+	return contacts.Contacts
+}
+
+// getResourceByID reads any ARM resource by ID with the given API version,
+// for resource types the tests have no dedicated SDK client for.
+func getResourceByID(t *testing.T, config TestConfig, resourceID string, apiVersion string) armresources.GenericResource {
+	t.Helper()
+
+	client, err := armresources.NewClient(config.SubscriptionID, azureCredential(t), armClientOptions())
+	require.NoError(t, err)
+	resource, err := client.GetByID(context.Background(), resourceID, apiVersion, nil)
+	require.NoError(t, err, "failed to get resource %s", resourceID)
+	return resource.GenericResource
+}
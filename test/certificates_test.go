@@ -0,0 +1,124 @@
+package test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/gruntwork-io/terratest/modules/azure"
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyVaultSelfSignedCertificate(t *testing.T) {
+	t.Parallel()
+
+	MultiTenantTestRunner(t, func(t *testing.T, config TestConfig) {
+		SetupAzureAuth(t, config)
+		CreateResourceGroup(t, &config)
+
+		keyVaultName := fmt.Sprintf("kv-cert-%s", config.UniqueID)
+		keyVaultName = PurgeSoftDeletedVault(t, config, keyVaultName)
+
+		vars := baseModuleVars(config, keyVaultName)
+		vars["certificates"] = map[string]interface{}{
+			"internal-tls": map[string]interface{}{
+				"name": "internal-tls",
+				"x509_certificate_properties": map[string]interface{}{
+					"subject": "CN=internal.example.com",
+					"subject_alternative_names": map[string]interface{}{
+						"dns_names": []string{"internal.example.com"},
+					},
+				},
+			},
+		}
+
+		terraformOptions := BuildTerraformOptions(t, config, vars)
+
+		defer terraform.Destroy(t, terraformOptions)
+		terraform.InitAndApply(t, terraformOptions)
+
+		certificateIDs := terraform.OutputMap(t, terraformOptions, "certificate_ids")
+		thumbprints := terraform.OutputMap(t, terraformOptions, "certificate_thumbprints")
+		assert.NotEmpty(t, certificateIDs["internal-tls"])
+		assert.NotEmpty(t, thumbprints["internal-tls"])
+		assert.True(t, azure.KeyVaultCertificateExists(t, keyVaultName, "internal-tls"))
+	})
+}
+
+func TestKeyVaultCertificateSecretIDs(t *testing.T) {
+	t.Parallel()
+
+	MultiTenantTestRunner(t, func(t *testing.T, config TestConfig) {
+		SetupAzureAuth(t, config)
+		CreateResourceGroup(t, &config)
+
+		keyVaultName := fmt.Sprintf("kv-csid-%s", config.UniqueID)
+		keyVaultName = PurgeSoftDeletedVault(t, config, keyVaultName)
+
+		vars := baseModuleVars(config, keyVaultName)
+		vars["certificates"] = map[string]interface{}{
+			"exportable-tls": map[string]interface{}{
+				"name": "exportable-tls",
+				"x509_certificate_properties": map[string]interface{}{
+					"subject": "CN=exportable.example.com",
+				},
+			},
+			"pinned-tls": map[string]interface{}{
+				"name": "pinned-tls",
+				"key_properties": map[string]interface{}{
+					"exportable": false,
+				},
+				"x509_certificate_properties": map[string]interface{}{
+					"subject": "CN=pinned.example.com",
+				},
+			},
+		}
+
+		terraformOptions := BuildTerraformOptions(t, config, vars)
+
+		defer terraform.Destroy(t, terraformOptions)
+		terraform.InitAndApply(t, terraformOptions)
+
+		secretIDs := terraform.OutputMap(t, terraformOptions, "certificate_secret_ids")
+		vaultURI := terraform.Output(t, terraformOptions, "key_vault_uri")
+		assert.True(t, strings.HasPrefix(secretIDs["exportable-tls"], strings.TrimSuffix(vaultURI, "/")+"/secrets/exportable-tls/"),
+			"the secret ID of exportable-tls should point at its backing secret, got %q", secretIDs["exportable-tls"])
+		assert.NotContains(t, secretIDs, "pinned-tls", "non-exportable certificates should have no secret ID")
+	})
+}
+
+func TestKeyVaultCertificateContacts(t *testing.T) {
+	t.Parallel()
+
+	MultiTenantTestRunner(t, func(t *testing.T, config TestConfig) {
+		SetupAzureAuth(t, config)
+		CreateResourceGroup(t, &config)
+
+		keyVaultName := fmt.Sprintf("kv-ctc-%s", config.UniqueID)
+		keyVaultName = PurgeSoftDeletedVault(t, config, keyVaultName)
+		emails := []string{"pki-team@example.com", "security@example.com"}
+
+		vars := baseModuleVars(config, keyVaultName)
+		vars["certificate_contacts"] = []map[string]interface{}{
+			{"email": emails[0], "name": "PKI Team"},
+			{"email": emails[1], "name": "Security", "phone": "+48 22 000 00 00"},
+		}
+
+		terraformOptions := BuildTerraformOptions(t, config, vars)
+
+		defer terraform.Destroy(t, terraformOptions)
+		terraform.InitAndApply(t, terraformOptions)
+
+		assert.ElementsMatch(t, emails, terraform.OutputList(t, terraformOptions, "certificate_contact_emails"))
+
+		contacts := getCertificateContacts(t, terraform.Output(t, terraformOptions, "key_vault_uri"))
+		actual := []string{}
+		for _, contact := range contacts.ContactList {
+			require.NotNil(t, contact.Email)
+			actual = append(actual, *contact.Email)
+		}
+		assert.ElementsMatch(t, emails, actual)
+	})
+}
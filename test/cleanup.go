@@ -0,0 +1,140 @@
+package test
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
+	"github.com/stretchr/testify/require"
+)
+
+// Tags CreateResourceGroup puts on the groups it creates, which
+// CleanupLeakedResourceGroups selects leaked groups by. CreatedAt holds the
+// creation time in RFC 3339, as ARM does not report when a group was created.
+const (
+	purposeTag                 = "Purpose"
+	managedByTag               = "ManagedBy"
+	createdAtTag               = "CreatedAt"
+	testResourceGroupPurpose   = "kv-module-test"
+	testResourceGroupManagedBy = "terraform"
+)
+
+// cleanupOlderThanEnv names the environment variable holding the age, as a
+// Go duration such as 24h, past which TestCleanupLeakedResourceGroups treats a
+// test resource group as leaked.
+const cleanupOlderThanEnv = "KV_TEST_CLEANUP_OLDER_THAN"
+
+var confirmCleanup = flag.Bool("confirm-cleanup", false, "delete the leaked resource groups CleanupLeakedResourceGroups finds instead of only listing them")
+
+// resourceGroupCleaner is the subset of the ARM resource groups API used to
+// clean up leaked test resource groups.
+type resourceGroupCleaner interface {
+	// listByTag returns the resource groups with the given tag value.
+	listByTag(ctx context.Context, name string, value string) ([]*armresources.ResourceGroup, error)
+	delete(ctx context.Context, name string) error
+}
+
+// logT is the subset of *testing.T used to report progress.
+type logT interface {
+	Logf(format string, args ...interface{})
+}
+
+func (a armResourceGroups) listByTag(ctx context.Context, name string, value string) ([]*armresources.ResourceGroup, error) {
+	var result []*armresources.ResourceGroup
+	pager := a.client.NewListPager(&armresources.ResourceGroupsClientListOptions{
+		Filter: to.Ptr(fmt.Sprintf("tagName eq '%s' and tagValue eq '%s'", name, value)),
+	})
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, page.Value...)
+	}
+	return result, nil
+}
+
+// CleanupLeakedResourceGroups finds the resource groups of the subscription
+// that CreateResourceGroup created more than olderThan ago, by their Purpose,
+// ManagedBy and CreatedAt tags, and returns their names. They are only
+// logged unless confirm is set, in which case they are deleted with
+// everything inside; deletion failures are reported in a single failure once
+// every group has been tried.
+func CleanupLeakedResourceGroups(t *testing.T, config TestConfig, olderThan time.Duration, confirm bool) []string {
+	t.Helper()
+
+	client, err := armresources.NewResourceGroupsClient(config.SubscriptionID, azureCredential(t), armClientOptions())
+	require.NoError(t, err)
+	leaked, failures, err := cleanupLeakedResourceGroups(context.Background(), t, armResourceGroups{client}, time.Now(), olderThan, confirm)
+	require.NoError(t, err, "failed to list resource groups of subscription %s", config.SubscriptionID)
+	if len(failures) > 0 {
+		t.Errorf("failed to delete %d leaked resource group(s):\n  %s", len(failures), strings.Join(failures, "\n  "))
+	}
+	return leaked
+}
+
+// cleanupLeakedResourceGroups selects the leaked groups and, with confirm,
+// deletes them, returning the selected names and one line per failed delete.
+func cleanupLeakedResourceGroups(ctx context.Context, t logT, groups resourceGroupCleaner, now time.Time, olderThan time.Duration, confirm bool) ([]string, []string, error) {
+	candidates, err := groups.listByTag(ctx, purposeTag, testResourceGroupPurpose)
+	if err != nil {
+		return nil, nil, err
+	}
+	leaked := selectLeakedResourceGroups(candidates, now, olderThan)
+
+	var failures []string
+	for _, name := range leaked {
+		if !confirm {
+			t.Logf("would delete leaked resource group %s; rerun with -confirm-cleanup to delete it", name)
+			continue
+		}
+		t.Logf("deleting leaked resource group %s", name)
+		if err := groups.delete(ctx, name); err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", name, err))
+		}
+	}
+	return leaked, failures, nil
+}
+
+// selectLeakedResourceGroups returns the sorted names of the groups tagged
+// as test resource groups whose CreatedAt is more than olderThan before now.
+// Groups without a parseable CreatedAt are left alone, since their age is
+// unknown, and so are groups already being deleted.
+func selectLeakedResourceGroups(groups []*armresources.ResourceGroup, now time.Time, olderThan time.Duration) []string {
+	var leaked []string
+	for _, group := range groups {
+		if group == nil || group.Name == nil {
+			continue
+		}
+		if !strings.EqualFold(tagValue(group.Tags, purposeTag), testResourceGroupPurpose) || !strings.EqualFold(tagValue(group.Tags, managedByTag), testResourceGroupManagedBy) {
+			continue
+		}
+		if group.Properties != nil && group.Properties.ProvisioningState != nil && strings.EqualFold(*group.Properties.ProvisioningState, "Deleting") {
+			continue
+		}
+		createdAt, err := time.Parse(time.RFC3339, tagValue(group.Tags, createdAtTag))
+		if err != nil || now.Sub(createdAt) <= olderThan {
+			continue
+		}
+		leaked = append(leaked, *group.Name)
+	}
+	sort.Strings(leaked)
+	return leaked
+}
+
+// tagValue returns the value of the named tag, matching the name
+// case-insensitively as ARM does, or "" when the tag is not set.
+func tagValue(tags map[string]*string, name string) string {
+	for k, v := range tags {
+		if strings.EqualFold(k, name) && v != nil {
+			return *v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,192 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
+	"github.com/gruntwork-io/terratest/modules/random"
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var cleanupNow = time.Date(2024, 6, 10, 12, 0, 0, 0, time.UTC)
+
+type fakeResourceGroupCleaner struct {
+	groups     []*armresources.ResourceGroup
+	deleted    []string
+	deleteErrs map[string]error
+	listErr    error
+}
+
+func (f *fakeResourceGroupCleaner) listByTag(ctx context.Context, name string, value string) ([]*armresources.ResourceGroup, error) {
+	return f.groups, f.listErr
+}
+
+func (f *fakeResourceGroupCleaner) delete(ctx context.Context, name string) error {
+	if err := f.deleteErrs[name]; err != nil {
+		return err
+	}
+	f.deleted = append(f.deleted, name)
+	return nil
+}
+
+func testResourceGroup(name string, tags map[string]string) *armresources.ResourceGroup {
+	group := &armresources.ResourceGroup{Name: to.Ptr(name), Tags: map[string]*string{}}
+	for k, v := range tags {
+		group.Tags[k] = to.Ptr(v)
+	}
+	return group
+}
+
+func leakedGroupTags(age time.Duration) map[string]string {
+	return map[string]string{
+		"Purpose":   "kv-module-test",
+		"ManagedBy": "terraform",
+		"CreatedAt": cleanupNow.Add(-age).Format(time.RFC3339),
+	}
+}
+
+func TestSelectLeakedResourceGroups(t *testing.T) {
+	t.Parallel()
+
+	deleting := testResourceGroup("kv-test-deleting", leakedGroupTags(72*time.Hour))
+	deleting.Properties = &armresources.ResourceGroupProperties{ProvisioningState: to.Ptr("Deleting")}
+
+	groups := []*armresources.ResourceGroup{
+		testResourceGroup("kv-test-old", leakedGroupTags(48*time.Hour)),
+		testResourceGroup("kv-test-fresh", leakedGroupTags(time.Hour)),
+		testResourceGroup("kv-test-case", map[string]string{
+			"purpose":   "KV-Module-Test",
+			"managedby": "Terraform",
+			"createdat": cleanupNow.Add(-25 * time.Hour).Format(time.RFC3339),
+		}),
+		testResourceGroup("kv-test-other-owner", map[string]string{
+			"Purpose":   "kv-module-test",
+			"ManagedBy": "someone-else",
+			"CreatedAt": cleanupNow.Add(-48 * time.Hour).Format(time.RFC3339),
+		}),
+		testResourceGroup("kv-test-no-date", map[string]string{"Purpose": "kv-module-test", "ManagedBy": "terraform"}),
+		testResourceGroup("kv-test-bad-date", map[string]string{"Purpose": "kv-module-test", "ManagedBy": "terraform", "CreatedAt": "yesterday"}),
+		testResourceGroup("rg-prod", map[string]string{"ManagedBy": "terraform", "CreatedAt": cleanupNow.Add(-48 * time.Hour).Format(time.RFC3339)}),
+		deleting,
+		{Tags: map[string]*string{"Purpose": to.Ptr("kv-module-test")}},
+	}
+
+	assert.Equal(t, []string{"kv-test-case", "kv-test-old"}, selectLeakedResourceGroups(groups, cleanupNow, 24*time.Hour))
+	assert.Empty(t, selectLeakedResourceGroups(groups, cleanupNow, 72*time.Hour))
+}
+
+func TestCleanupLeakedResourceGroupsDryRun(t *testing.T) {
+	t.Parallel()
+
+	groups := &fakeResourceGroupCleaner{groups: []*armresources.ResourceGroup{
+		testResourceGroup("kv-test-old", leakedGroupTags(48*time.Hour)),
+	}}
+	fake := &fakeTestT{}
+
+	leaked, failures, err := cleanupLeakedResourceGroups(context.Background(), fake, groups, cleanupNow, 24*time.Hour, false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"kv-test-old"}, leaked)
+	assert.Empty(t, failures)
+	assert.Empty(t, groups.deleted, "a dry run must not delete anything")
+	assert.Equal(t, []string{"would delete leaked resource group kv-test-old; rerun with -confirm-cleanup to delete it"}, fake.logs)
+}
+
+func TestCleanupLeakedResourceGroupsConfirm(t *testing.T) {
+	t.Parallel()
+
+	groups := &fakeResourceGroupCleaner{
+		groups: []*armresources.ResourceGroup{
+			testResourceGroup("kv-test-a", leakedGroupTags(48*time.Hour)),
+			testResourceGroup("kv-test-b", leakedGroupTags(48*time.Hour)),
+			testResourceGroup("kv-test-c", leakedGroupTags(48*time.Hour)),
+		},
+		deleteErrs: map[string]error{"kv-test-b": errors.New("ScopeLocked")},
+	}
+
+	leaked, failures, err := cleanupLeakedResourceGroups(context.Background(), &fakeTestT{}, groups, cleanupNow, 24*time.Hour, true)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"kv-test-a", "kv-test-b", "kv-test-c"}, leaked)
+	assert.Equal(t, []string{"kv-test-a", "kv-test-c"}, groups.deleted, "a failed delete should not stop the others")
+	assert.Equal(t, []string{"kv-test-b: ScopeLocked"}, failures)
+}
+
+func TestCleanupLeakedResourceGroupsListError(t *testing.T) {
+	t.Parallel()
+
+	groups := &fakeResourceGroupCleaner{listErr: errors.New("AuthorizationFailed")}
+
+	_, _, err := cleanupLeakedResourceGroups(context.Background(), &fakeTestT{}, groups, cleanupNow, 24*time.Hour, true)
+	assert.EqualError(t, err, "AuthorizationFailed")
+}
+
+func TestKeyVaultCleanupTestDataPlaneObjects(t *testing.T) {
+	t.Parallel()
+
+	MultiTenantTestRunner(t, func(t *testing.T, config TestConfig) {
+		SetupAzureAuth(t, config)
+		CreateResourceGroup(t, &config)
+
+		keyVaultName := PurgeSoftDeletedVault(t, config, fmt.Sprintf("kv-tclean-%s", config.UniqueID))
+		vars := baseModuleVars(config, keyVaultName)
+		vars["rbac_secrets_officers"] = []string{currentPrincipalObjectID(t, azureCredential(t))}
+
+		terraformOptions := BuildTerraformOptions(t, config, vars)
+
+		defer terraform.Destroy(t, terraformOptions)
+		terraform.InitAndApply(t, terraformOptions)
+
+		WaitForVaultReady(t, config, keyVaultName, 5*time.Minute)
+
+		// Stands in for what a long-lived vault already holds
+		SeedSecrets(t, config, keyVaultName, map[string]string{"pre-existing": random.UniqueId()}, 1)
+
+		var created []string
+		seeded := map[string]string{}
+		for _, name := range []string{"test-created-a", "test-created-b"} {
+			seeded[name] = random.UniqueId()
+			created = append(created, name)
+		}
+		SeedSecrets(t, config, keyVaultName, seeded, 2)
+
+		CleanupTestDataPlaneObjects(t, config, keyVaultName, created)
+
+		listed, err := azureSecretLister{secretsClient(t, keyVaultName)}.listSecrets(context.Background())
+		require.NoError(t, err)
+		names := map[string]bool{}
+		for _, secret := range listed {
+			if secret.ID != nil {
+				names[secret.ID.Name()] = true
+			}
+		}
+		for _, name := range created {
+			assert.False(t, names[name], "secret %s should have been removed", name)
+		}
+		assert.True(t, names["pre-existing"], "the unrelated secret should be left intact")
+	})
+}
+
+func TestCleanupLeakedResourceGroups(t *testing.T) {
+	t.Parallel()
+
+	olderThan := os.Getenv(cleanupOlderThanEnv)
+	if olderThan == "" {
+		t.Skip("KV_TEST_CLEANUP_OLDER_THAN is not set; skipping the leaked resource group cleanup")
+	}
+	age, err := time.ParseDuration(olderThan)
+	require.NoError(t, err, "KV_TEST_CLEANUP_OLDER_THAN must be a duration such as 24h")
+
+	MultiTenantTestRunner(t, func(t *testing.T, config TestConfig) {
+		SetupAzureAuth(t, config)
+
+		leaked := CleanupLeakedResourceGroups(t, config, age, *confirmCleanup)
+		t.Logf("found %d leaked resource group(s) older than %s in subscription %s", len(leaked), age, config.SubscriptionID)
+	})
+}
@@ -0,0 +1,111 @@
+package test
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
+)
+
+// Cloud environments selectable through TestConfig.Environment, named as the
+// azurerm provider's ARM_ENVIRONMENT. An empty environment is public.
+const (
+	EnvironmentPublic       = "public"
+	EnvironmentUSGovernment = "usgovernment"
+	EnvironmentChina        = "china"
+)
+
+// terratestEnvironmentEnv is read by terratest's azure module to pick the
+// endpoints of helpers such as azure.GetKeyVault.
+const terratestEnvironmentEnv = "AZURE_ENVIRONMENT"
+
+// cloudEnvironment is one Azure cloud as seen by each client the tests use.
+type cloudEnvironment struct {
+	name      string
+	sdk       cloud.Configuration
+	terratest string
+}
+
+var cloudEnvironments = map[string]cloudEnvironment{
+	EnvironmentPublic:       {name: EnvironmentPublic, sdk: cloud.AzurePublic, terratest: "AzurePublicCloud"},
+	EnvironmentUSGovernment: {name: EnvironmentUSGovernment, sdk: cloud.AzureGovernment, terratest: "AzureUSGovernmentCloud"},
+	EnvironmentChina:        {name: EnvironmentChina, sdk: cloud.AzureChina, terratest: "AzureChinaCloud"},
+}
+
+// cloudEnvironmentFor returns the cloud named by a TestConfig.Environment.
+func cloudEnvironmentFor(name string) (cloudEnvironment, error) {
+	if name == "" {
+		name = EnvironmentPublic
+	}
+	env, ok := cloudEnvironments[strings.ToLower(name)]
+	if !ok {
+		names := make([]string, 0, len(cloudEnvironments))
+		for n := range cloudEnvironments {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return cloudEnvironment{}, fmt.Errorf("unknown environment %q; use one of %s", name, strings.Join(names, ", "))
+	}
+	return env, nil
+}
+
+// armEndpoint returns the Azure Resource Manager endpoint of the cloud.
+func (c cloudEnvironment) armEndpoint() string {
+	return c.sdk.Services[cloud.ResourceManager].Endpoint
+}
+
+// armScope returns the OAuth scope of ARM access tokens in the cloud.
+func (c cloudEnvironment) armScope() string {
+	return strings.TrimSuffix(c.armEndpoint(), "/") + "/.default"
+}
+
+// cloudSelection holds the cloud the SDK clients of this test run target.
+// terratest reads its cloud from the process environment, so a run can only
+// target one cloud; SetupAzureAuth rejects tenants from a different one.
+type cloudSelection struct {
+	mu      sync.Mutex
+	current *cloudEnvironment
+}
+
+var activeCloud = &cloudSelection{}
+
+// use makes env the cloud of the run and exports it for terratest.
+func (s *cloudSelection) use(env cloudEnvironment) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.current != nil && s.current.name != env.name {
+		return fmt.Errorf("environment %q cannot run alongside %q in one test run; split the tenants by cloud", env.name, s.current.name)
+	}
+	if err := os.Setenv(terratestEnvironmentEnv, env.terratest); err != nil {
+		return err
+	}
+	s.current = &env
+	return nil
+}
+
+// get returns the cloud of the run, public until use is called.
+func (s *cloudSelection) get() cloudEnvironment {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.current == nil {
+		return cloudEnvironments[EnvironmentPublic]
+	}
+	return *s.current
+}
+
+// clientOptions returns the SDK client options for the cloud of the run.
+func clientOptions() azcore.ClientOptions {
+	return azcore.ClientOptions{Cloud: activeCloud.get().sdk}
+}
+
+// armClientOptions returns the ARM client options for the cloud of the run.
+func armClientOptions() *arm.ClientOptions {
+	return &arm.ClientOptions{ClientOptions: clientOptions()}
+}
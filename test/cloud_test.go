@@ -0,0 +1,55 @@
+package test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCloudEnvironmentFor(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name        string
+		armEndpoint string
+		armScope    string
+	}{
+		{"", "https://management.azure.com", "https://management.azure.com/.default"},
+		{EnvironmentPublic, "https://management.azure.com", "https://management.azure.com/.default"},
+		{"USGovernment", "https://management.usgovcloudapi.net", "https://management.usgovcloudapi.net/.default"},
+		{EnvironmentChina, "https://management.chinacloudapi.cn", "https://management.chinacloudapi.cn/.default"},
+	}
+
+	for _, tc := range testCases {
+		env, err := cloudEnvironmentFor(tc.name)
+		require.NoError(t, err, "environment %q", tc.name)
+		assert.Equal(t, tc.armEndpoint, env.armEndpoint(), "environment %q", tc.name)
+		assert.Equal(t, tc.armScope, env.armScope(), "environment %q", tc.name)
+	}
+
+	_, err := cloudEnvironmentFor("germany")
+	assert.ErrorContains(t, err, `unknown environment "germany"; use one of china, public, usgovernment`)
+}
+
+func TestCloudSelectionGovernment(t *testing.T) {
+	t.Setenv(terratestEnvironmentEnv, "")
+
+	gov, err := cloudEnvironmentFor(EnvironmentUSGovernment)
+	require.NoError(t, err)
+
+	selection := &cloudSelection{}
+	assert.Equal(t, EnvironmentPublic, selection.get().name)
+
+	require.NoError(t, selection.use(gov))
+	assert.Equal(t, "AzureUSGovernmentCloud", os.Getenv(terratestEnvironmentEnv), "terratest helpers should target Azure Government")
+	assert.Equal(t, "https://management.usgovcloudapi.net", selection.get().armEndpoint())
+	assert.Equal(t, "https://login.microsoftonline.us/", selection.get().sdk.ActiveDirectoryAuthorityHost)
+
+	require.NoError(t, selection.use(gov), "reusing the same cloud is allowed")
+
+	public, err := cloudEnvironmentFor(EnvironmentPublic)
+	require.NoError(t, err)
+	assert.ErrorContains(t, selection.use(public), `environment "public" cannot run alongside "usgovernment"`)
+}
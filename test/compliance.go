@@ -0,0 +1,628 @@
+package test
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/keyvault/armkeyvault"
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Severity ranks how serious a failed compliance rule is.
+type Severity int
+
+const (
+	SeverityLow Severity = iota
+	SeverityMedium
+	SeverityHigh
+	SeverityCritical
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityLow:
+		return "LOW"
+	case SeverityMedium:
+		return "MEDIUM"
+	case SeverityHigh:
+		return "HIGH"
+	case SeverityCritical:
+		return "CRITICAL"
+	}
+	return fmt.Sprintf("Severity(%d)", int(s))
+}
+
+// ComplianceRule is a single check against a deployed vault. Check returns
+// whether the vault passes and, when it does not, why.
+type ComplianceRule struct {
+	Name     string
+	Check    func(kv *armkeyvault.Vault) (bool, string)
+	Severity Severity
+}
+
+// ComplianceFailure records a rule a vault did not pass.
+type ComplianceFailure struct {
+	Rule     string
+	Severity Severity
+	Reason   string
+}
+
+func (f ComplianceFailure) String() string {
+	return fmt.Sprintf("[%s] %s: %s", f.Severity, f.Rule, f.Reason)
+}
+
+// DefaultComplianceRules returns the module's security baseline. Callers can
+// append their own rules to the returned slice.
+func DefaultComplianceRules() []ComplianceRule {
+	return []ComplianceRule{
+		{
+			Name:     "purge-protection",
+			Severity: SeverityCritical,
+			Check: func(kv *armkeyvault.Vault) (bool, string) {
+				return isTrue(kv.Properties.EnablePurgeProtection), "purge protection is disabled"
+			},
+		},
+		{
+			Name:     "soft-delete-retention",
+			Severity: SeverityHigh,
+			Check: func(kv *armkeyvault.Vault) (bool, string) {
+				if kv.Properties.EnableSoftDelete != nil && !*kv.Properties.EnableSoftDelete {
+					return false, "soft delete is disabled"
+				}
+				days := kv.Properties.SoftDeleteRetentionInDays
+				if days == nil || *days < 90 {
+					return false, fmt.Sprintf("soft delete retention is %s days, want at least 90", formatInt32(days))
+				}
+				return true, ""
+			},
+		},
+		{
+			Name:     "rbac-authorization",
+			Severity: SeverityHigh,
+			Check: func(kv *armkeyvault.Vault) (bool, string) {
+				return isTrue(kv.Properties.EnableRbacAuthorization), "RBAC authorization is disabled; access policies are in use"
+			},
+		},
+		{
+			Name:     "public-network-access",
+			Severity: SeverityHigh,
+			Check: func(kv *armkeyvault.Vault) (bool, string) {
+				if kv.Properties.PublicNetworkAccess == nil || !strings.EqualFold(*kv.Properties.PublicNetworkAccess, "Disabled") {
+					return false, "public network access is enabled"
+				}
+				return true, ""
+			},
+		},
+	}
+}
+
+// ComplianceMode selects what ValidateSecurityCompliance does with the
+// violations it finds.
+type ComplianceMode string
+
+const (
+	// ComplianceModeEnforce fails the test on any violation.
+	ComplianceModeEnforce ComplianceMode = "enforce"
+	// ComplianceModeReport only logs violations, for a grace period while a
+	// new rule rolls out.
+	ComplianceModeReport ComplianceMode = "report"
+)
+
+// Violation is a compliance rule a vault did not pass, as returned by
+// ValidateSecurityCompliance.
+type Violation = ComplianceFailure
+
+// complianceT is the subset of *testing.T used to report violations.
+type complianceT interface {
+	Errorf(format string, args ...interface{})
+	Logf(format string, args ...interface{})
+}
+
+// ValidateSecurityCompliance checks the vault deployed by terraformOptions
+// against rules, or DefaultComplianceRules when none are given, and returns
+// the violations, most severe first. In ComplianceModeEnforce they are
+// reported together as a single failure; in ComplianceModeReport they are
+// only logged and the test keeps passing.
+func ValidateSecurityCompliance(t *testing.T, terraformOptions *terraform.Options, mode ComplianceMode, rules ...ComplianceRule) []Violation {
+	t.Helper()
+
+	if mode != ComplianceModeEnforce && mode != ComplianceModeReport {
+		t.Fatalf("unknown compliance mode %q; use %q or %q", mode, ComplianceModeEnforce, ComplianceModeReport)
+	}
+	if len(rules) == 0 {
+		rules = DefaultComplianceRules()
+	}
+
+	kv := getDeployedVault(t, terraformOptions)
+	violations := EvaluateCompliance(kv, rules)
+	reportViolations(t, *kv.Name, violations, mode)
+	return violations
+}
+
+// reportViolations fails t with every violation of vaultName in enforce mode
+// and logs them in report mode.
+func reportViolations(t complianceT, vaultName string, violations []Violation, mode ComplianceMode) {
+	if len(violations) == 0 {
+		return
+	}
+	lines := make([]string, len(violations))
+	for i, v := range violations {
+		lines[i] = v.String()
+	}
+	if mode == ComplianceModeReport {
+		t.Logf("Key Vault %s failed %d compliance rule(s), reported only:\n  %s", vaultName, len(violations), strings.Join(lines, "\n  "))
+		return
+	}
+	t.Errorf("Key Vault %s failed %d compliance rule(s):\n  %s", vaultName, len(violations), strings.Join(lines, "\n  "))
+}
+
+// EvaluateCompliance runs every rule against kv and returns the failures
+// ordered by descending severity, then by rule order.
+func EvaluateCompliance(kv *armkeyvault.Vault, rules []ComplianceRule) []ComplianceFailure {
+	var failures []ComplianceFailure
+	for _, rule := range rules {
+		if kv.Properties == nil {
+			failures = append(failures, ComplianceFailure{Rule: rule.Name, Severity: rule.Severity, Reason: "vault has no properties"})
+			continue
+		}
+		if ok, reason := rule.Check(kv); !ok {
+			failures = append(failures, ComplianceFailure{Rule: rule.Name, Severity: rule.Severity, Reason: reason})
+		}
+	}
+	sort.SliceStable(failures, func(i, j int) bool {
+		return failures[i].Severity > failures[j].Severity
+	})
+	return failures
+}
+
+// ValidateNoPublicAccess checks that kv is unreachable from public networks:
+// public network access is disabled, the network ACLs deny by default, and
+// trusted Azure services cannot bypass them unless allowAzureServicesBypass is
+// set. All violations are reported in one failure, and it returns whether kv
+// passed.
+func ValidateNoPublicAccess(t assert.TestingT, kv *armkeyvault.Vault, allowAzureServicesBypass bool) bool {
+	if h, ok := t.(interface{ Helper() }); ok {
+		h.Helper()
+	}
+
+	violations := publicAccessViolations(kv, allowAzureServicesBypass)
+	if len(violations) == 0 {
+		return true
+	}
+	name := "<unnamed>"
+	if kv.Name != nil {
+		name = *kv.Name
+	}
+	return assert.Fail(t, fmt.Sprintf("Key Vault %s is reachable from public networks:\n  %s", name, strings.Join(violations, "\n  ")))
+}
+
+func publicAccessViolations(kv *armkeyvault.Vault, allowAzureServicesBypass bool) []string {
+	if kv.Properties == nil {
+		return []string{"vault has no properties"}
+	}
+
+	var violations []string
+	if kv.Properties.PublicNetworkAccess == nil || !strings.EqualFold(*kv.Properties.PublicNetworkAccess, "Disabled") {
+		violations = append(violations, "public network access is enabled")
+	}
+
+	acls := kv.Properties.NetworkACLs
+	if acls == nil {
+		return append(violations, "network ACLs are not configured")
+	}
+	if acls.DefaultAction == nil || *acls.DefaultAction != armkeyvault.NetworkRuleActionDeny {
+		violations = append(violations, "network ACLs do not deny by default")
+	}
+	if !allowAzureServicesBypass && (acls.Bypass == nil || *acls.Bypass == armkeyvault.NetworkRuleBypassOptionsAzureServices) {
+		violations = append(violations, "network ACLs let trusted Azure services bypass them")
+	}
+	return violations
+}
+
+// AssertNoAccessPoliciesWhenRbac fails t if kv uses RBAC authorization but
+// still carries access policies, which Azure keeps but ignores, naming the
+// object ID of each. It returns whether kv passed; a vault in access policy
+// mode always does.
+func AssertNoAccessPoliciesWhenRbac(t assert.TestingT, kv *armkeyvault.Vault) bool {
+	if h, ok := t.(interface{ Helper() }); ok {
+		h.Helper()
+	}
+
+	if kv.Properties == nil || !isTrue(kv.Properties.EnableRbacAuthorization) || len(kv.Properties.AccessPolicies) == 0 {
+		return true
+	}
+	objectIDs := make([]string, 0, len(kv.Properties.AccessPolicies))
+	for _, policy := range kv.Properties.AccessPolicies {
+		if policy != nil && policy.ObjectID != nil {
+			objectIDs = append(objectIDs, *policy.ObjectID)
+		} else {
+			objectIDs = append(objectIDs, "<unknown>")
+		}
+	}
+	name := "<unnamed>"
+	if kv.Name != nil {
+		name = *kv.Name
+	}
+	return assert.Fail(t, fmt.Sprintf("Key Vault %s uses RBAC authorization but still has %d access policy(ies), which it ignores: %s",
+		name, len(objectIDs), strings.Join(objectIDs, ", ")))
+}
+
+// TagOption adds a constraint to ValidateRequiredTags.
+type TagOption func(patterns map[string]*regexp.Regexp)
+
+// TagValueMatches requires the value of tag key, when present, to match
+// pattern, such as `^[0-9]{4}$` for a CostCenter. Key is matched
+// case-insensitively, as Azure treats tag names.
+func TagValueMatches(key string, pattern string) TagOption {
+	re := regexp.MustCompile(pattern)
+	return func(patterns map[string]*regexp.Regexp) {
+		patterns[key] = re
+	}
+}
+
+// ValidateRequiredTags reads vaultName in config's resource group and checks
+// it carries every tag in required, with a non-empty value matching any
+// TagValueMatches pattern. All problems are reported in a single failure.
+func ValidateRequiredTags(t *testing.T, config TestConfig, vaultName string, required []string, opts ...TagOption) {
+	t.Helper()
+
+	patterns := map[string]*regexp.Regexp{}
+	for _, opt := range opts {
+		opt(patterns)
+	}
+	tags := GetVaultView(t, config, config.ResourceGroupName(), vaultName).Tags
+	if problems := tagProblems(tags, required, patterns); len(problems) > 0 {
+		t.Errorf("Key Vault %s does not meet the tagging policy, %d problem(s):\n  %s", vaultName, len(problems), strings.Join(problems, "\n  "))
+	}
+}
+
+// tagProblems returns one line per required tag that is missing or empty,
+// and per tag whose value does not match its pattern, in a stable order.
+func tagProblems(tags map[string]string, required []string, patterns map[string]*regexp.Regexp) []string {
+	values := make(map[string]string, len(tags))
+	for key, value := range tags {
+		values[strings.ToLower(key)] = value
+	}
+
+	var problems []string
+	for _, key := range required {
+		value, ok := values[strings.ToLower(key)]
+		switch {
+		case !ok:
+			problems = append(problems, fmt.Sprintf("%s: missing", key))
+		case strings.TrimSpace(value) == "":
+			problems = append(problems, fmt.Sprintf("%s: empty", key))
+		}
+	}
+
+	keys := make([]string, 0, len(patterns))
+	for key := range patterns {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if value, ok := values[strings.ToLower(key)]; ok && !patterns[key].MatchString(value) {
+			problems = append(problems, fmt.Sprintf("%s: %q does not match %s", key, value, patterns[key]))
+		}
+	}
+	return problems
+}
+
+// BaselineSecureVaultRules returns DefaultComplianceRules plus the network
+// rules of a vault only reachable privately: the network ACLs deny by default
+// and trusted Azure services cannot bypass them.
+func BaselineSecureVaultRules() []ComplianceRule {
+	return append(DefaultComplianceRules(),
+		ComplianceRule{
+			Name:     "network-default-deny",
+			Severity: SeverityHigh,
+			Check: func(kv *armkeyvault.Vault) (bool, string) {
+				acls := kv.Properties.NetworkACLs
+				if acls == nil {
+					return false, "network ACLs are not configured"
+				}
+				return acls.DefaultAction != nil && *acls.DefaultAction == armkeyvault.NetworkRuleActionDeny, "network ACLs do not deny by default"
+			},
+		},
+		ComplianceRule{
+			Name:     "network-bypass",
+			Severity: SeverityMedium,
+			Check: func(kv *armkeyvault.Vault) (bool, string) {
+				acls := kv.Properties.NetworkACLs
+				if acls == nil {
+					return false, "network ACLs are not configured"
+				}
+				return acls.Bypass != nil && *acls.Bypass != armkeyvault.NetworkRuleBypassOptionsAzureServices, "network ACLs let trusted Azure services bypass them"
+			},
+		},
+	)
+}
+
+// AssertBaselineSecureVault reads vaultName in config's resource group from
+// ARM and checks it against BaselineSecureVaultRules: purge protection, soft
+// delete retention of at least 90 days, RBAC authorization, no public network
+// access, network ACLs denying by default and no trusted services bypass.
+// Every violation is reported in a single failure, and the violations are
+// returned, most severe first; none means the vault passed.
+func AssertBaselineSecureVault(t *testing.T, config TestConfig, vaultName string) []Violation {
+	t.Helper()
+
+	client, err := armkeyvault.NewVaultsClient(config.SubscriptionID, azureCredential(t), armClientOptions())
+	require.NoError(t, err)
+	resp, err := client.Get(context.Background(), config.ResourceGroupName(), vaultName, nil)
+	require.NoError(t, err, "failed to get Key Vault %s", vaultName)
+
+	violations := EvaluateCompliance(&resp.Vault, BaselineSecureVaultRules())
+	reportViolations(t, vaultName, violations, ComplianceModeEnforce)
+	return violations
+}
+
+// SecurityExpectations is the security posture a vault is expected to keep.
+type SecurityExpectations struct {
+	PurgeProtection         bool
+	SoftDeleteRetentionDays int32
+	RBACAuthorization       bool
+	PublicNetworkAccess     bool
+}
+
+// SecurityDrift is a setting whose live value differs from the expected one.
+type SecurityDrift struct {
+	Field    string
+	Expected string
+	Actual   string
+}
+
+func (d SecurityDrift) String() string {
+	return fmt.Sprintf("%s: expected %s, got %s", d.Field, d.Expected, d.Actual)
+}
+
+// DetectSecurityDrift reads vaultName in config's resource group straight from
+// ARM and returns every setting in expected it no longer matches. It does not
+// use Terraform state, so it can run in a scheduled job against vaults that
+// were changed by hand.
+func DetectSecurityDrift(t *testing.T, config TestConfig, vaultName string, expected SecurityExpectations) []SecurityDrift {
+	t.Helper()
+
+	client, err := armkeyvault.NewVaultsClient(config.SubscriptionID, azureCredential(t), armClientOptions())
+	require.NoError(t, err)
+	resp, err := client.Get(context.Background(), config.ResourceGroupName(), vaultName, nil)
+	require.NoError(t, err, "failed to get Key Vault %s", vaultName)
+	return securityDrift(&resp.Vault, expected)
+}
+
+// securityDrift compares kv against expected in a fixed field order. Unset
+// properties take the ARM defaults: purge protection and RBAC off, public
+// network access enabled.
+func securityDrift(kv *armkeyvault.Vault, expected SecurityExpectations) []SecurityDrift {
+	props := kv.Properties
+	if props == nil {
+		props = &armkeyvault.VaultProperties{}
+	}
+
+	retention := formatInt32(props.SoftDeleteRetentionInDays)
+	if props.EnableSoftDelete != nil && !*props.EnableSoftDelete {
+		retention = "disabled"
+	}
+	publicAccess := props.PublicNetworkAccess == nil || !strings.EqualFold(*props.PublicNetworkAccess, "Disabled")
+
+	fields := []SecurityDrift{
+		{Field: "purge_protection", Expected: fmt.Sprint(expected.PurgeProtection), Actual: fmt.Sprint(isTrue(props.EnablePurgeProtection))},
+		{Field: "soft_delete_retention_days", Expected: fmt.Sprint(expected.SoftDeleteRetentionDays), Actual: retention},
+		{Field: "rbac_authorization", Expected: fmt.Sprint(expected.RBACAuthorization), Actual: fmt.Sprint(isTrue(props.EnableRbacAuthorization))},
+		{Field: "public_network_access", Expected: fmt.Sprint(expected.PublicNetworkAccess), Actual: fmt.Sprint(publicAccess)},
+	}
+
+	var drift []SecurityDrift
+	for _, f := range fields {
+		if f.Expected != f.Actual {
+			drift = append(drift, f)
+		}
+	}
+	return drift
+}
+
+// AssertDisasterRecoveryReady checks kv can be signed off for disaster
+// recovery: purge protection and soft delete are on, and deleted objects are
+// kept for at least minRetention days. Every failing condition is named in
+// the returned error, which is also reported as a test failure; nil means the
+// vault is ready.
+func AssertDisasterRecoveryReady(t *testing.T, kv *armkeyvault.Vault, minRetention int32) error {
+	t.Helper()
+
+	err := disasterRecoveryReadiness(kv, minRetention)
+	assert.NoError(t, err)
+	return err
+}
+
+// disasterRecoveryReadiness returns an error naming each disaster recovery
+// condition kv fails. Unset soft delete counts as on, as ARM enables it by
+// default, but an unset retention fails.
+func disasterRecoveryReadiness(kv *armkeyvault.Vault, minRetention int32) error {
+	props := kv.Properties
+	if props == nil {
+		props = &armkeyvault.VaultProperties{}
+	}
+
+	var problems []string
+	if !isTrue(props.EnablePurgeProtection) {
+		problems = append(problems, "purge protection is disabled")
+	}
+	if props.EnableSoftDelete != nil && !*props.EnableSoftDelete {
+		problems = append(problems, "soft delete is disabled")
+	}
+	if days := props.SoftDeleteRetentionInDays; days == nil || *days < minRetention {
+		problems = append(problems, fmt.Sprintf("soft delete retention is %s days, want at least %d", formatInt32(days), minRetention))
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	return fmt.Errorf("Key Vault %s is not disaster recovery ready: %s", formatString(kv.Name), strings.Join(problems, "; "))
+}
+
+// AssertVaultParity reads vaultA and vaultB from config's resource group and
+// checks they are configured alike: name, location, SKU, purge protection,
+// soft delete retention, RBAC, public network access, network ACLs and tags.
+// ignoreFields names fields expected to differ, such as "name" and
+// "location"; a prefix such as "tags" or "network_acls" ignores every field
+// under it. All mismatches are reported in a single failure, and it returns
+// whether the vaults match.
+func AssertVaultParity(t *testing.T, config TestConfig, vaultA string, vaultB string, ignoreFields []string) bool {
+	t.Helper()
+
+	client, err := armkeyvault.NewVaultsClient(config.SubscriptionID, azureCredential(t), armClientOptions())
+	require.NoError(t, err)
+	a, err := client.Get(context.Background(), config.ResourceGroupName(), vaultA, nil)
+	require.NoError(t, err, "failed to get Key Vault %s", vaultA)
+	b, err := client.Get(context.Background(), config.ResourceGroupName(), vaultB, nil)
+	require.NoError(t, err, "failed to get Key Vault %s", vaultB)
+
+	if mismatches := vaultParityMismatches(&a.Vault, &b.Vault, ignoreFields); len(mismatches) > 0 {
+		t.Errorf("Key Vaults %s and %s differ in %d field(s):\n  %s", vaultA, vaultB, len(mismatches), strings.Join(mismatches, "\n  "))
+		return false
+	}
+	return true
+}
+
+// vaultParityMismatches returns one "field: a vs b" line per compared field
+// that differs between a and b, sorted by field.
+func vaultParityMismatches(a *armkeyvault.Vault, b *armkeyvault.Vault, ignoreFields []string) []string {
+	fieldsA, fieldsB := vaultParityFields(a), vaultParityFields(b)
+
+	names := make([]string, 0, len(fieldsA)+len(fieldsB))
+	for name := range fieldsA {
+		names = append(names, name)
+	}
+	for name := range fieldsB {
+		if _, ok := fieldsA[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var mismatches []string
+	for _, name := range names {
+		if parityFieldIgnored(name, ignoreFields) {
+			continue
+		}
+		valueA, ok := fieldsA[name]
+		if !ok {
+			valueA = "unset"
+		}
+		valueB, ok := fieldsB[name]
+		if !ok {
+			valueB = "unset"
+		}
+		if valueA != valueB {
+			mismatches = append(mismatches, fmt.Sprintf("%s: %s vs %s", name, valueA, valueB))
+		}
+	}
+	return mismatches
+}
+
+func parityFieldIgnored(name string, ignoreFields []string) bool {
+	for _, ignored := range ignoreFields {
+		if name == ignored || strings.HasPrefix(name, ignored+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// vaultParityFields flattens the compared settings of kv into strings. Lists
+// are sorted so their order does not count, and each tag is its own field.
+func vaultParityFields(kv *armkeyvault.Vault) map[string]string {
+	props := kv.Properties
+	if props == nil {
+		props = &armkeyvault.VaultProperties{}
+	}
+
+	fields := map[string]string{
+		"name":                       formatString(kv.Name),
+		"location":                   "unset",
+		"sku":                        "unset",
+		"purge_protection":           fmt.Sprint(isTrue(props.EnablePurgeProtection)),
+		"soft_delete_retention_days": formatInt32(props.SoftDeleteRetentionInDays),
+		"rbac_authorization":         fmt.Sprint(isTrue(props.EnableRbacAuthorization)),
+		"public_network_access":      fmt.Sprint(props.PublicNetworkAccess == nil || !strings.EqualFold(*props.PublicNetworkAccess, "Disabled")),
+	}
+	if kv.Location != nil {
+		fields["location"] = normalizeLocation(*kv.Location)
+	}
+	if props.SKU != nil && props.SKU.Name != nil {
+		fields["sku"] = strings.ToLower(string(*props.SKU.Name))
+	}
+	if props.EnableSoftDelete != nil && !*props.EnableSoftDelete {
+		fields["soft_delete_retention_days"] = "disabled"
+	}
+
+	if acls := props.NetworkACLs; acls != nil {
+		fields["network_acls.bypass"] = "unset"
+		if acls.Bypass != nil {
+			fields["network_acls.bypass"] = string(*acls.Bypass)
+		}
+		fields["network_acls.default_action"] = "unset"
+		if acls.DefaultAction != nil {
+			fields["network_acls.default_action"] = string(*acls.DefaultAction)
+		}
+		var ipRules, subnets []string
+		for _, rule := range acls.IPRules {
+			if rule != nil && rule.Value != nil {
+				ipRules = append(ipRules, *rule.Value)
+			}
+		}
+		for _, rule := range acls.VirtualNetworkRules {
+			if rule != nil && rule.ID != nil {
+				subnets = append(subnets, strings.ToLower(*rule.ID))
+			}
+		}
+		sort.Strings(ipRules)
+		sort.Strings(subnets)
+		fields["network_acls.ip_rules"] = "[" + strings.Join(ipRules, ", ") + "]"
+		fields["network_acls.virtual_network_rules"] = "[" + strings.Join(subnets, ", ") + "]"
+	}
+
+	for key, value := range kv.Tags {
+		fields["tags."+key] = formatString(value)
+	}
+	return fields
+}
+
+// getDeployedVault reads the vault behind the key_vault_id output from ARM.
+func getDeployedVault(t *testing.T, terraformOptions *terraform.Options) *armkeyvault.Vault {
+	t.Helper()
+
+	vaultID := terraform.Output(t, terraformOptions, "key_vault_id")
+	id, err := arm.ParseResourceID(vaultID)
+	require.NoError(t, err, "invalid key_vault_id %q", vaultID)
+
+	client, err := armkeyvault.NewVaultsClient(id.SubscriptionID, azureCredential(t), armClientOptions())
+	require.NoError(t, err)
+	resp, err := client.Get(context.Background(), id.ResourceGroupName, id.Name, nil)
+	require.NoError(t, err, "failed to get Key Vault %s", vaultID)
+	return &resp.Vault
+}
+
+func isTrue(b *bool) bool {
+	return b != nil && *b
+}
+
+func formatString(v *string) string {
+	if v == nil {
+		return "unset"
+	}
+	return *v
+}
+
+func formatInt32(v *int32) string {
+	if v == nil {
+		return "unset"
+	}
+	return fmt.Sprintf("%d", *v)
+}
@@ -0,0 +1,356 @@
+package test
+
+import (
+	"fmt"
+	"regexp"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/keyvault/armkeyvault"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func compliantVault() *armkeyvault.Vault {
+	return &armkeyvault.Vault{
+		Name: to.Ptr("kv-compliant"),
+		Properties: &armkeyvault.VaultProperties{
+			EnablePurgeProtection:     to.Ptr(true),
+			EnableSoftDelete:          to.Ptr(true),
+			SoftDeleteRetentionInDays: to.Ptr[int32](90),
+			EnableRbacAuthorization:   to.Ptr(true),
+			PublicNetworkAccess:       to.Ptr("Disabled"),
+		},
+	}
+}
+
+func TestEvaluateComplianceDefaultRules(t *testing.T) {
+	t.Parallel()
+
+	assert.Empty(t, EvaluateCompliance(compliantVault(), DefaultComplianceRules()))
+
+	kv := compliantVault()
+	kv.Properties.EnablePurgeProtection = to.Ptr(false)
+	kv.Properties.SoftDeleteRetentionInDays = to.Ptr[int32](7)
+	kv.Properties.PublicNetworkAccess = to.Ptr("Enabled")
+
+	assert.Equal(t, []ComplianceFailure{
+		{Rule: "purge-protection", Severity: SeverityCritical, Reason: "purge protection is disabled"},
+		{Rule: "soft-delete-retention", Severity: SeverityHigh, Reason: "soft delete retention is 7 days, want at least 90"},
+		{Rule: "public-network-access", Severity: SeverityHigh, Reason: "public network access is enabled"},
+	}, EvaluateCompliance(kv, DefaultComplianceRules()))
+}
+
+func TestEvaluateComplianceCustomRules(t *testing.T) {
+	t.Parallel()
+
+	rules := append(DefaultComplianceRules(), ComplianceRule{
+		Name:     "premium-sku",
+		Severity: SeverityLow,
+		Check: func(kv *armkeyvault.Vault) (bool, string) {
+			return kv.Properties.SKU != nil && *kv.Properties.SKU.Name == armkeyvault.SKUNamePremium, "vault is not premium"
+		},
+	})
+
+	kv := compliantVault()
+	kv.Properties.EnableRbacAuthorization = nil
+
+	failures := EvaluateCompliance(kv, rules)
+	assert.Equal(t, []ComplianceFailure{
+		{Rule: "rbac-authorization", Severity: SeverityHigh, Reason: "RBAC authorization is disabled; access policies are in use"},
+		{Rule: "premium-sku", Severity: SeverityLow, Reason: "vault is not premium"},
+	}, failures)
+	assert.Equal(t, "[HIGH] rbac-authorization: RBAC authorization is disabled; access policies are in use", failures[0].String())
+}
+
+func TestEvaluateComplianceMissingProperties(t *testing.T) {
+	t.Parallel()
+
+	failures := EvaluateCompliance(&armkeyvault.Vault{Name: to.Ptr("kv-empty")}, DefaultComplianceRules())
+	assert.Len(t, failures, len(DefaultComplianceRules()))
+	for _, f := range failures {
+		assert.Equal(t, "vault has no properties", f.Reason)
+	}
+}
+
+func TestBaselineSecureVaultRules(t *testing.T) {
+	t.Parallel()
+
+	assert.Empty(t, EvaluateCompliance(privateVault(armkeyvault.NetworkRuleBypassOptionsNone), BaselineSecureVaultRules()))
+
+	testCases := []struct {
+		name     string
+		relax    func(kv *armkeyvault.Vault)
+		expected ComplianceFailure
+	}{
+		{"purge protection off", func(kv *armkeyvault.Vault) { kv.Properties.EnablePurgeProtection = to.Ptr(false) }, ComplianceFailure{Rule: "purge-protection", Severity: SeverityCritical, Reason: "purge protection is disabled"}},
+		{"short retention", func(kv *armkeyvault.Vault) { kv.Properties.SoftDeleteRetentionInDays = to.Ptr[int32](30) }, ComplianceFailure{Rule: "soft-delete-retention", Severity: SeverityHigh, Reason: "soft delete retention is 30 days, want at least 90"}},
+		{"access policies", func(kv *armkeyvault.Vault) { kv.Properties.EnableRbacAuthorization = to.Ptr(false) }, ComplianceFailure{Rule: "rbac-authorization", Severity: SeverityHigh, Reason: "RBAC authorization is disabled; access policies are in use"}},
+		{"public access", func(kv *armkeyvault.Vault) { kv.Properties.PublicNetworkAccess = to.Ptr("Enabled") }, ComplianceFailure{Rule: "public-network-access", Severity: SeverityHigh, Reason: "public network access is enabled"}},
+		{"default allow", func(kv *armkeyvault.Vault) {
+			kv.Properties.NetworkACLs.DefaultAction = to.Ptr(armkeyvault.NetworkRuleActionAllow)
+		}, ComplianceFailure{Rule: "network-default-deny", Severity: SeverityHigh, Reason: "network ACLs do not deny by default"}},
+		{"azure services bypass", func(kv *armkeyvault.Vault) {
+			kv.Properties.NetworkACLs.Bypass = to.Ptr(armkeyvault.NetworkRuleBypassOptionsAzureServices)
+		}, ComplianceFailure{Rule: "network-bypass", Severity: SeverityMedium, Reason: "network ACLs let trusted Azure services bypass them"}},
+	}
+
+	for _, tc := range testCases {
+		kv := privateVault(armkeyvault.NetworkRuleBypassOptionsNone)
+		tc.relax(kv)
+		assert.Equal(t, []ComplianceFailure{tc.expected}, EvaluateCompliance(kv, BaselineSecureVaultRules()), tc.name)
+	}
+
+	// Without network ACLs both network rules fail
+	failures := EvaluateCompliance(compliantVault(), BaselineSecureVaultRules())
+	assert.Len(t, failures, 2)
+}
+
+func TestSecurityDrift(t *testing.T) {
+	t.Parallel()
+
+	expected := SecurityExpectations{
+		PurgeProtection:         true,
+		SoftDeleteRetentionDays: 90,
+		RBACAuthorization:       true,
+		PublicNetworkAccess:     false,
+	}
+	assert.Empty(t, securityDrift(compliantVault(), expected))
+
+	kv := compliantVault()
+	kv.Properties.EnablePurgeProtection = nil
+	kv.Properties.SoftDeleteRetentionInDays = to.Ptr[int32](7)
+	kv.Properties.PublicNetworkAccess = to.Ptr("Enabled")
+
+	drift := securityDrift(kv, expected)
+	assert.Equal(t, []SecurityDrift{
+		{Field: "purge_protection", Expected: "true", Actual: "false"},
+		{Field: "soft_delete_retention_days", Expected: "90", Actual: "7"},
+		{Field: "public_network_access", Expected: "false", Actual: "true"},
+	}, drift)
+	assert.Equal(t, "purge_protection: expected true, got false", drift[0].String())
+}
+
+func TestSecurityDriftSoftDeleteDisabled(t *testing.T) {
+	t.Parallel()
+
+	kv := compliantVault()
+	kv.Properties.EnableSoftDelete = to.Ptr(false)
+	kv.Properties.EnableRbacAuthorization = to.Ptr(false)
+
+	assert.Equal(t, []SecurityDrift{
+		{Field: "soft_delete_retention_days", Expected: "90", Actual: "disabled"},
+		{Field: "rbac_authorization", Expected: "true", Actual: "false"},
+	}, securityDrift(kv, SecurityExpectations{PurgeProtection: true, SoftDeleteRetentionDays: 90, RBACAuthorization: true}))
+}
+
+func vaultWithNetwork(name string, location string) *armkeyvault.Vault {
+	kv := compliantVault()
+	kv.Name = to.Ptr(name)
+	kv.Location = to.Ptr(location)
+	kv.Tags = map[string]*string{"Environment": to.Ptr("prod"), "Region": to.Ptr(location)}
+	kv.Properties.SKU = &armkeyvault.SKU{Name: to.Ptr(armkeyvault.SKUNamePremium)}
+	kv.Properties.NetworkACLs = &armkeyvault.NetworkRuleSet{
+		DefaultAction: to.Ptr(armkeyvault.NetworkRuleActionDeny),
+		Bypass:        to.Ptr(armkeyvault.NetworkRuleBypassOptionsNone),
+		IPRules:       []*armkeyvault.IPRule{{Value: to.Ptr("203.0.113.0/24")}, {Value: to.Ptr("198.51.100.7/32")}},
+	}
+	return kv
+}
+
+func TestDisasterRecoveryReadiness(t *testing.T) {
+	t.Parallel()
+
+	assert.NoError(t, AssertDisasterRecoveryReady(t, compliantVault(), 90))
+
+	kv := compliantVault()
+	kv.Properties.SoftDeleteRetentionInDays = to.Ptr[int32](30)
+	err := disasterRecoveryReadiness(kv, 90)
+	require.Error(t, err)
+	assert.Equal(t, "Key Vault kv-compliant is not disaster recovery ready: soft delete retention is 30 days, want at least 90", err.Error())
+	assert.NoError(t, disasterRecoveryReadiness(kv, 30), "30 days should meet a 30 day minimum")
+
+	kv.Properties.EnablePurgeProtection = nil
+	kv.Properties.EnableSoftDelete = to.Ptr(false)
+	kv.Properties.SoftDeleteRetentionInDays = nil
+	err = disasterRecoveryReadiness(kv, 90)
+	require.Error(t, err)
+	assert.Equal(t, "Key Vault kv-compliant is not disaster recovery ready: purge protection is disabled; soft delete is disabled; soft delete retention is unset days, want at least 90", err.Error())
+}
+
+func TestTagProblems(t *testing.T) {
+	t.Parallel()
+
+	patterns := map[string]*regexp.Regexp{}
+	TagValueMatches("CostCenter", `^[0-9]{4}$`)(patterns)
+	required := []string{"CostCenter", "Owner", "Environment"}
+
+	assert.Empty(t, tagProblems(map[string]string{"costcenter": "4711", "Owner": "platform-team", "Environment": "prod"}, required, patterns),
+		"tag names should match case-insensitively")
+
+	assert.Equal(t, []string{"Owner: missing"},
+		tagProblems(map[string]string{"CostCenter": "4711", "Environment": "prod"}, required, patterns))
+
+	assert.Equal(t, []string{"Owner: empty", "Environment: missing", `CostCenter: "marketing" does not match ^[0-9]{4}$`},
+		tagProblems(map[string]string{"CostCenter": "marketing", "Owner": " "}, required, patterns))
+}
+
+func TestVaultParityMismatches(t *testing.T) {
+	t.Parallel()
+
+	a := vaultWithNetwork("kv-weu", "West Europe")
+	b := vaultWithNetwork("kv-neu", "northeurope")
+	// List order does not count.
+	b.Properties.NetworkACLs.IPRules[0], b.Properties.NetworkACLs.IPRules[1] = b.Properties.NetworkACLs.IPRules[1], b.Properties.NetworkACLs.IPRules[0]
+
+	assert.Empty(t, vaultParityMismatches(a, b, []string{"name", "location", "tags.Region"}))
+	assert.Equal(t, []string{
+		"location: westeurope vs northeurope",
+		"name: kv-weu vs kv-neu",
+		"tags.Region: West Europe vs northeurope",
+	}, vaultParityMismatches(a, b, nil))
+}
+
+func TestVaultParityMismatchesReportsEveryField(t *testing.T) {
+	t.Parallel()
+
+	a := vaultWithNetwork("kv-a", "westeurope")
+	b := vaultWithNetwork("kv-b", "westeurope")
+	b.Properties.SKU.Name = to.Ptr(armkeyvault.SKUNameStandard)
+	b.Properties.SoftDeleteRetentionInDays = to.Ptr[int32](30)
+	b.Properties.NetworkACLs.DefaultAction = to.Ptr(armkeyvault.NetworkRuleActionAllow)
+	b.Properties.NetworkACLs.IPRules = nil
+	delete(b.Tags, "Environment")
+	b.Tags["Owner"] = to.Ptr("platform")
+
+	assert.Equal(t, []string{
+		"network_acls.default_action: Deny vs Allow",
+		"network_acls.ip_rules: [198.51.100.7/32, 203.0.113.0/24] vs []",
+		"sku: premium vs standard",
+		"soft_delete_retention_days: 90 vs 30",
+		"tags.Environment: prod vs unset",
+		"tags.Owner: unset vs platform",
+	}, vaultParityMismatches(a, b, []string{"name", "tags.Region"}))
+	assert.Empty(t, vaultParityMismatches(a, b, []string{"name", "sku", "soft_delete_retention_days", "network_acls", "tags"}))
+}
+
+// recordingT captures assertion failures so tests can check what a validation
+// helper reports without failing themselves.
+type recordingT struct {
+	errors []string
+	logs   []string
+}
+
+func (r *recordingT) Errorf(format string, args ...interface{}) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func (r *recordingT) Logf(format string, args ...interface{}) {
+	r.logs = append(r.logs, fmt.Sprintf(format, args...))
+}
+
+func privateVault(bypass armkeyvault.NetworkRuleBypassOptions) *armkeyvault.Vault {
+	kv := compliantVault()
+	kv.Properties.NetworkACLs = &armkeyvault.NetworkRuleSet{
+		DefaultAction: to.Ptr(armkeyvault.NetworkRuleActionDeny),
+		Bypass:        to.Ptr(bypass),
+	}
+	return kv
+}
+
+func TestValidateNoPublicAccess(t *testing.T) {
+	t.Parallel()
+
+	rec := &recordingT{}
+	assert.True(t, ValidateNoPublicAccess(rec, privateVault(armkeyvault.NetworkRuleBypassOptionsNone), false))
+	assert.Empty(t, rec.errors)
+}
+
+func TestValidateNoPublicAccessFlagsAzureServicesBypass(t *testing.T) {
+	t.Parallel()
+
+	kv := privateVault(armkeyvault.NetworkRuleBypassOptionsAzureServices)
+
+	rec := &recordingT{}
+	assert.False(t, ValidateNoPublicAccess(rec, kv, false))
+	require.Len(t, rec.errors, 1)
+	assert.Contains(t, rec.errors[0], "Key Vault kv-compliant is reachable from public networks")
+	assert.Contains(t, rec.errors[0], "network ACLs let trusted Azure services bypass them")
+	assert.NotContains(t, rec.errors[0], "public network access is enabled")
+
+	rec = &recordingT{}
+	assert.True(t, ValidateNoPublicAccess(rec, kv, true), "bypass should pass when explicitly allowed")
+	assert.Empty(t, rec.errors)
+}
+
+func TestAssertNoAccessPoliciesWhenRbac(t *testing.T) {
+	t.Parallel()
+
+	rec := &recordingT{}
+	assert.True(t, AssertNoAccessPoliciesWhenRbac(rec, compliantVault()), "a clean RBAC vault should pass")
+	assert.Empty(t, rec.errors)
+
+	kv := compliantVault()
+	kv.Properties.AccessPolicies = []*armkeyvault.AccessPolicyEntry{
+		{ObjectID: to.Ptr("00000000-0000-0000-0000-000000000001")},
+		{ObjectID: to.Ptr("00000000-0000-0000-0000-000000000002")},
+	}
+	rec = &recordingT{}
+	assert.False(t, AssertNoAccessPoliciesWhenRbac(rec, kv))
+	require.Len(t, rec.errors, 1)
+	assert.Contains(t, rec.errors[0], "Key Vault kv-compliant uses RBAC authorization but still has 2 access policy(ies), which it ignores: 00000000-0000-0000-0000-000000000001, 00000000-0000-0000-0000-000000000002")
+
+	kv.Properties.EnableRbacAuthorization = to.Ptr(false)
+	rec = &recordingT{}
+	assert.True(t, AssertNoAccessPoliciesWhenRbac(rec, kv), "access policy mode vaults should pass")
+	assert.Empty(t, rec.errors)
+}
+
+func TestValidateNoPublicAccessReportsEveryViolation(t *testing.T) {
+	t.Parallel()
+
+	kv := privateVault(armkeyvault.NetworkRuleBypassOptionsAzureServices)
+	kv.Properties.PublicNetworkAccess = to.Ptr("Enabled")
+	kv.Properties.NetworkACLs.DefaultAction = to.Ptr(armkeyvault.NetworkRuleActionAllow)
+
+	assert.Equal(t, []string{
+		"public network access is enabled",
+		"network ACLs do not deny by default",
+		"network ACLs let trusted Azure services bypass them",
+	}, publicAccessViolations(kv, false))
+
+	kv.Properties.NetworkACLs = nil
+	assert.Equal(t, []string{
+		"public network access is enabled",
+		"network ACLs are not configured",
+	}, publicAccessViolations(kv, true))
+}
+
+func TestReportViolations(t *testing.T) {
+	t.Parallel()
+
+	kv := compliantVault()
+	kv.Properties.EnablePurgeProtection = to.Ptr(false)
+	kv.Properties.PublicNetworkAccess = to.Ptr("Enabled")
+	violations := EvaluateCompliance(kv, DefaultComplianceRules())
+	require.Len(t, violations, 2)
+
+	rec := &recordingT{}
+	reportViolations(rec, "kv-compliant", violations, ComplianceModeReport)
+	assert.Empty(t, rec.errors, "report mode must not fail the test")
+	require.Len(t, rec.logs, 1)
+	assert.Equal(t, "Key Vault kv-compliant failed 2 compliance rule(s), reported only:\n"+
+		"  [CRITICAL] purge-protection: purge protection is disabled\n"+
+		"  [HIGH] public-network-access: public network access is enabled", rec.logs[0])
+
+	rec = &recordingT{}
+	reportViolations(rec, "kv-compliant", violations, ComplianceModeEnforce)
+	assert.Empty(t, rec.logs)
+	require.Len(t, rec.errors, 1)
+	assert.Contains(t, rec.errors[0], "Key Vault kv-compliant failed 2 compliance rule(s):\n  [CRITICAL] purge-protection")
+
+	rec = &recordingT{}
+	reportViolations(rec, "kv-compliant", nil, ComplianceModeEnforce)
+	assert.Empty(t, rec.errors)
+	assert.Empty(t, rec.logs)
+}
@@ -0,0 +1,78 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/gruntwork-io/terratest/modules/shell"
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// costToolBinary is the cost estimation CLI EstimateMonthlyCost shells out
+// to. It reads a Terraform plan JSON and prices it (infracost).
+const costToolBinary = "infracost"
+
+// EstimateMonthlyCost plans terraformOptions and returns the cost tool's
+// estimated monthly cost of the result. The test is skipped when the tool is
+// not installed.
+func EstimateMonthlyCost(t *testing.T, terraformOptions *terraform.Options) float64 {
+	t.Helper()
+
+	if _, err := exec.LookPath(costToolBinary); err != nil {
+		t.Skipf("%s is not installed; skipping cost estimate", costToolBinary)
+	}
+
+	planOptions, err := terraformOptions.Clone()
+	require.NoError(t, err)
+	planOptions.PlanFilePath = filepath.Join(t.TempDir(), "plan.out")
+
+	planJSONPath := filepath.Join(t.TempDir(), "plan.json")
+	require.NoError(t, os.WriteFile(planJSONPath, []byte(terraform.InitAndPlanAndShow(t, planOptions)), 0o600))
+
+	output, err := shell.RunCommandAndGetStdOutE(t, shell.Command{
+		Command: costToolBinary,
+		Args:    []string{"breakdown", "--path", planJSONPath, "--format", "json", "--no-color"},
+		Env:     terraformOptions.EnvVars,
+	})
+	require.NoError(t, err, "%s breakdown failed", costToolBinary)
+
+	cost, err := parseMonthlyCost([]byte(output))
+	require.NoError(t, err)
+	return cost
+}
+
+// AssertCostUnder fails the test when the estimated monthly cost of
+// terraformOptions exceeds max.
+func AssertCostUnder(t *testing.T, terraformOptions *terraform.Options, max float64) {
+	t.Helper()
+
+	cost := EstimateMonthlyCost(t, terraformOptions)
+	assert.LessOrEqual(t, cost, max, "estimated monthly cost %.2f exceeds the %.2f budget", cost, max)
+}
+
+// parseMonthlyCost extracts totalMonthlyCost from cost tool JSON output. The
+// tool reports it as a decimal string, or null when nothing is priced.
+func parseMonthlyCost(output []byte) (float64, error) {
+	var breakdown struct {
+		TotalMonthlyCost *string `json:"totalMonthlyCost"`
+	}
+	if err := json.Unmarshal(output, &breakdown); err != nil {
+		return 0, fmt.Errorf("failed to parse %s output: %w", costToolBinary, err)
+	}
+	if breakdown.TotalMonthlyCost == nil {
+		return 0, nil
+	}
+
+	cost, err := strconv.ParseFloat(*breakdown.TotalMonthlyCost, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid totalMonthlyCost %q: %w", *breakdown.TotalMonthlyCost, err)
+	}
+	return cost, nil
+}
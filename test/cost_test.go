@@ -0,0 +1,64 @@
+package test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	test_structure "github.com/gruntwork-io/terratest/modules/test-structure"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMonthlyCost(t *testing.T) {
+	t.Parallel()
+
+	recorded, err := os.ReadFile(filepath.Join("fixtures", "cost", "infracost_breakdown.json"))
+	require.NoError(t, err)
+
+	cost, err := parseMonthlyCost(recorded)
+	require.NoError(t, err)
+	assert.InDelta(t, 5.0, cost, 0.001)
+
+	cost, err = parseMonthlyCost([]byte(`{"totalMonthlyCost": null}`))
+	require.NoError(t, err)
+	assert.Zero(t, cost)
+
+	_, err = parseMonthlyCost([]byte(`{"totalMonthlyCost": "n/a"}`))
+	assert.ErrorContains(t, err, `invalid totalMonthlyCost "n/a"`)
+
+	_, err = parseMonthlyCost([]byte(`not json`))
+	assert.ErrorContains(t, err, "failed to parse infracost output")
+}
+
+func TestKeyVaultCostBudget(t *testing.T) {
+	t.Parallel()
+
+	MultiTenantTestRunner(t, func(t *testing.T, config TestConfig) {
+		SetupAzureAuth(t, config)
+		CreateResourceGroup(t, &config)
+
+		vars := baseModuleVars(config, fmt.Sprintf("kv-cost-%s", config.UniqueID))
+		vars["keys"] = map[string]interface{}{
+			"cmk": map[string]interface{}{
+				"name":     "cmk",
+				"key_type": "RSA",
+				"key_size": 2048,
+				"key_opts": []string{"wrapKey", "unwrapKey"},
+			},
+		}
+
+		terraformOptions := &terraform.Options{
+			TerraformDir:    test_structure.CopyTerraformFolderToTemp(t, "..", "."),
+			TerraformBinary: TerraformBinary(),
+			Vars:            vars,
+			EnvVars:         TerraformEnvVars(config),
+		}
+
+		// A standard vault with software keys is priced per operation; a
+		// jump past this budget usually means premium HSM keys slipped in.
+		AssertCostUnder(t, terraformOptions, 10)
+	})
+}
@@ -0,0 +1,177 @@
+package test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/monitor/azquery"
+	"github.com/gruntwork-io/terratest/modules/azure"
+	"github.com/stretchr/testify/require"
+)
+
+// auditEventQuery counts the AuditEvent entries of one vault since a point in
+// time. Diagnostic settings write either to AzureDiagnostics or to the
+// resource-specific AZKVAuditLogs table; isfuzzy tolerates whichever of the two
+// does not exist in the workspace.
+const auditEventQuery = `union isfuzzy=true
+    (AzureDiagnostics | where ResourceProvider == "MICROSOFT.KEYVAULT" and Category == "AuditEvent"),
+    (AZKVAuditLogs)
+| where TimeGenerated >= datetime(%s) and _ResourceId endswith "/vaults/%s"
+| count`
+
+const (
+	logsPollInitialWait = 15 * time.Second
+	logsPollMaxWait     = 2 * time.Minute
+)
+
+// ValidateDiagnosticLogsFlowing proves that AuditEvent logs of vaultName reach
+// the Log Analytics workspace: it makes a data-plane request against the vault
+// and polls the workspace, with backoff, until the request shows up or timeout
+// elapses. workspaceID is the workspace (customer) ID GUID, the workspace_id
+// attribute of azurerm_log_analytics_workspace, not its ARM resource ID.
+// Ingestion usually takes 5-10 minutes, so allow at least 15.
+func ValidateDiagnosticLogsFlowing(t *testing.T, config TestConfig, workspaceID string, vaultName string, timeout time.Duration) {
+	t.Helper()
+
+	ctx := context.Background()
+	start := time.Now().UTC()
+
+	// Every data-plane request is audited, including a denied one, so the
+	// outcome of listing keys does not matter.
+	if _, err := keysClient(t, vaultName).NewListKeyPropertiesPager(nil).NextPage(ctx); err != nil {
+		t.Logf("listing keys in Key Vault %s failed (the request is audited regardless): %v", vaultName, err)
+	}
+
+	client, err := azquery.NewLogsClient(azureCredential(t), nil)
+	require.NoError(t, err)
+	query := fmt.Sprintf(auditEventQuery, start.Format(time.RFC3339), strings.ToLower(vaultName))
+
+	err = pollWithBackoff(ctx, timeout, logsPollInitialWait, logsPollMaxWait, time.Now, time.Sleep, func(ctx context.Context) (bool, error) {
+		resp, err := client.QueryWorkspace(ctx, workspaceID, azquery.Body{Query: to.Ptr(query)}, nil)
+		if err != nil {
+			return false, err
+		}
+		if resp.Error != nil {
+			return false, resp.Error
+		}
+		return countResult(resp.Tables) > 0, nil
+	})
+	require.NoError(t, err, "no AuditEvent log of Key Vault %s reached workspace %s; check the vault's diagnostic setting, or raise the timeout if ingestion is slow", vaultName, workspaceID)
+}
+
+// ValidateDiagnosticCategories reads the diagnostic setting the module
+// creates on vaultName, named "<vaultName>-diagnostics", and fails t if any
+// of the required log categories is missing from it or disabled.
+func ValidateDiagnosticCategories(t *testing.T, config TestConfig, vaultName string, required []string) {
+	t.Helper()
+
+	vaultID := GetVaultView(t, config, config.ResourceGroupName(), vaultName).ID
+	setting := azure.GetDiagnosticsSettingsResource(t, fmt.Sprintf("%s-diagnostics", vaultName), vaultID, config.SubscriptionID)
+
+	enabled := map[string]bool{}
+	if setting.Logs != nil {
+		for _, log := range *setting.Logs {
+			if log.Category != nil {
+				enabled[*log.Category] = log.Enabled != nil && *log.Enabled
+			}
+		}
+	}
+	if missing := missingDiagnosticCategories(enabled, required); len(missing) > 0 {
+		t.Errorf("diagnostic setting of Key Vault %s does not enable required log categories %s", vaultName, strings.Join(missing, ", "))
+	}
+}
+
+// missingDiagnosticCategories returns the required categories that enabled
+// does not map to true, in the order given. Categories are compared
+// case-insensitively, as Azure returns them in whatever case they were set.
+func missingDiagnosticCategories(enabled map[string]bool, required []string) []string {
+	var missing []string
+	for _, category := range required {
+		found := false
+		for name, on := range enabled {
+			if on && strings.EqualFold(name, category) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			missing = append(missing, category)
+		}
+	}
+	return missing
+}
+
+// countResult reads the single value returned by a KQL `count`.
+func countResult(tables []*azquery.Table) int {
+	if len(tables) == 0 || len(tables[0].Rows) == 0 || len(tables[0].Rows[0]) == 0 {
+		return 0
+	}
+	switch n := tables[0].Rows[0][0].(type) {
+	case float64:
+		return int(n)
+	case int64:
+		return int(n)
+	case int:
+		return n
+	}
+	return 0
+}
+
+// AssertStorageRequiresHTTPS sends a plain HTTP request to the blob endpoint
+// of storageAccountName and fails t unless the account turns it away for
+// requiring HTTPS.
+func AssertStorageRequiresHTTPS(t *testing.T, storageAccountName string) {
+	t.Helper()
+
+	suffix, err := azure.GetStorageURISuffixE()
+	require.NoError(t, err)
+	if problem := httpsOnlyProblem(http.DefaultClient, fmt.Sprintf("http://%s.blob.%s/?comp=list", storageAccountName, suffix)); problem != "" {
+		t.Error(problem)
+	}
+}
+
+// httpsOnlyProblem describes how a plain HTTP request to url was not rejected
+// with the AccountRequiresHttps error of an HTTPS-only storage account, or
+// returns "" if it was.
+func httpsOnlyProblem(client *http.Client, url string) string {
+	resp, err := client.Get(url)
+	if err != nil {
+		return fmt.Sprintf("plain HTTP request to %s failed: %v", url, err)
+	}
+	defer resp.Body.Close()
+
+	if code := resp.Header.Get("x-ms-error-code"); code != "AccountRequiresHttps" {
+		return fmt.Sprintf("plain HTTP request to %s returned %s with error code %q, want it rejected with AccountRequiresHttps", url, resp.Status, code)
+	}
+	return ""
+}
+
+// pollWithBackoff calls found until it reports true. Between calls it sleeps,
+// starting at initialWait and doubling up to maxWait, and it gives up once
+// timeout has elapsed. Errors from found are retried; the last one is
+// returned on timeout.
+func pollWithBackoff(ctx context.Context, timeout, initialWait, maxWait time.Duration, now func() time.Time, sleep func(time.Duration), found func(ctx context.Context) (bool, error)) error {
+	deadline := now().Add(timeout)
+	wait := initialWait
+	for attempt := 1; ; attempt++ {
+		ok, err := found(ctx)
+		if err == nil && ok {
+			return nil
+		}
+
+		remaining := deadline.Sub(now())
+		if remaining <= 0 {
+			if err != nil {
+				return fmt.Errorf("timed out after %s and %d attempts: %w", timeout, attempt, err)
+			}
+			return fmt.Errorf("timed out after %s and %d attempts", timeout, attempt)
+		}
+		sleep(min(wait, remaining))
+		wait = min(2*wait, maxWait)
+	}
+}
@@ -0,0 +1,251 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/monitor/azquery"
+	"github.com/gruntwork-io/terratest/modules/azure"
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	test_structure "github.com/gruntwork-io/terratest/modules/test-structure"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeClock is a clock whose sleeps advance time instantly.
+type fakeClock struct {
+	now    time.Time
+	sleeps []time.Duration
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func (c *fakeClock) Sleep(d time.Duration) {
+	c.sleeps = append(c.sleeps, d)
+	c.now = c.now.Add(d)
+}
+
+func TestPollWithBackoffSucceeds(t *testing.T) {
+	t.Parallel()
+
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	calls := 0
+	err := pollWithBackoff(context.Background(), time.Hour, 10*time.Second, 30*time.Second, clock.Now, clock.Sleep, func(ctx context.Context) (bool, error) {
+		calls++
+		if calls == 2 {
+			return false, errors.New("transient")
+		}
+		return calls == 5, nil
+	})
+
+	require.NoError(t, err)
+	assert.Equal(t, 5, calls)
+	assert.Equal(t, []time.Duration{10 * time.Second, 20 * time.Second, 30 * time.Second, 30 * time.Second}, clock.sleeps)
+}
+
+func TestPollWithBackoffTimesOut(t *testing.T) {
+	t.Parallel()
+
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	queryErr := errors.New("workspace not found")
+	err := pollWithBackoff(context.Background(), time.Minute, 10*time.Second, time.Minute, clock.Now, clock.Sleep, func(ctx context.Context) (bool, error) {
+		return false, queryErr
+	})
+
+	require.Error(t, err)
+	assert.ErrorIs(t, err, queryErr)
+	assert.Contains(t, err.Error(), "timed out after 1m0s and 4 attempts")
+	// The last wait is cut short so polling stops at the deadline.
+	assert.Equal(t, []time.Duration{10 * time.Second, 20 * time.Second, 30 * time.Second}, clock.sleeps)
+}
+
+func TestCountResult(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, 0, countResult(nil))
+	assert.Equal(t, 0, countResult([]*azquery.Table{{}}))
+	assert.Equal(t, 3, countResult([]*azquery.Table{{Rows: []azquery.Row{{float64(3)}}}}))
+}
+
+func TestMissingDiagnosticCategories(t *testing.T) {
+	t.Parallel()
+
+	required := []string{"AuditEvent", "AzurePolicyEvaluationDetails"}
+
+	assert.Empty(t, missingDiagnosticCategories(map[string]bool{"AuditEvent": true, "AzurePolicyEvaluationDetails": true}, required))
+	assert.Empty(t, missingDiagnosticCategories(map[string]bool{"auditevent": true, "azurepolicyevaluationdetails": true}, required), "categories match case-insensitively")
+	assert.Equal(t, []string{"AzurePolicyEvaluationDetails"}, missingDiagnosticCategories(map[string]bool{"AuditEvent": true}, required))
+	assert.Equal(t, []string{"AzurePolicyEvaluationDetails"}, missingDiagnosticCategories(map[string]bool{"AuditEvent": true, "AzurePolicyEvaluationDetails": false}, required), "a disabled category counts as missing")
+	assert.Equal(t, required, missingDiagnosticCategories(nil, required))
+}
+
+func TestHTTPSOnlyProblem(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("account") == "https-only" {
+			w.Header().Set("x-ms-error-code", "AccountRequiresHttps")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	assert.Empty(t, httpsOnlyProblem(server.Client(), server.URL+"/?account=https-only"))
+	assert.Contains(t, httpsOnlyProblem(server.Client(), server.URL+"/?account=open"), "200 OK")
+	assert.Contains(t, httpsOnlyProblem(server.Client(), "http://127.0.0.1:0/"), "failed")
+}
+
+func TestKeyVaultDiagnosticSettings(t *testing.T) {
+	t.Parallel()
+
+	MultiTenantTestRunner(t, func(t *testing.T, config TestConfig) {
+		SetupAzureAuth(t, config)
+		CreateResourceGroup(t, &config)
+
+		fixtureDir := test_structure.CopyTerraformFolderToTemp(t, "..", "test/fixtures/diagnostics")
+		keyVaultName := PurgeSoftDeletedVault(t, config, fmt.Sprintf("kv-diag-%s", config.UniqueID))
+
+		terraformOptions := BuildTerraformOptions(t, config, map[string]interface{}{
+			"key_vault_name":      keyVaultName,
+			"location":            config.Region,
+			"resource_group_name": fmt.Sprintf("%s-%s", config.ResourceGroup, config.UniqueID),
+		}, WithTerraformDir(fixtureDir))
+
+		defer terraform.Destroy(t, terraformOptions)
+		terraform.InitAndApply(t, terraformOptions)
+
+		require.NotEmpty(t, terraform.Output(t, terraformOptions, "diagnostic_setting_id"))
+
+		keyVaultID := terraform.Output(t, terraformOptions, "key_vault_id")
+		workspaceID := terraform.Output(t, terraformOptions, "log_analytics_workspace_id")
+		settingName := fmt.Sprintf("%s-diagnostics", keyVaultName)
+
+		diagnosticSetting := azure.GetDiagnosticsSettingsResource(t, settingName, keyVaultID, config.SubscriptionID)
+		require.NotNil(t, diagnosticSetting.WorkspaceID)
+		assert.True(t, strings.EqualFold(workspaceID, *diagnosticSetting.WorkspaceID), "diagnostic setting should target the test workspace")
+
+		enabledCategories := []string{}
+		for _, log := range *diagnosticSetting.Logs {
+			if log.Category != nil && log.Enabled != nil && *log.Enabled {
+				enabledCategories = append(enabledCategories, *log.Category)
+			}
+		}
+		assert.ElementsMatch(t, []string{"AuditEvent", "AzurePolicyEvaluationDetails"}, enabledCategories)
+
+		ValidateDiagnosticCategories(t, config, keyVaultName, []string{"AuditEvent", "AzurePolicyEvaluationDetails"})
+	})
+}
+
+func TestKeyVaultDiagnosticStorage(t *testing.T) {
+	t.Parallel()
+
+	MultiTenantTestRunner(t, func(t *testing.T, config TestConfig) {
+		SetupAzureAuth(t, config)
+		CreateResourceGroup(t, &config)
+
+		keyVaultName := PurgeSoftDeletedVault(t, config, fmt.Sprintf("kv-dst-%s", config.UniqueID))
+		vars := baseModuleVars(config, keyVaultName)
+		vars["enable_diagnostic_settings"] = true
+		vars["create_diagnostic_storage"] = true
+		vars["diagnostic_storage"] = map[string]interface{}{
+			"retention_days": 30,
+		}
+
+		terraformOptions := BuildTerraformOptions(t, config, vars)
+
+		defer terraform.Destroy(t, terraformOptions)
+		terraform.InitAndApply(t, terraformOptions)
+
+		storageAccountID := terraform.Output(t, terraformOptions, "diagnostic_storage_account_id")
+		require.NotEmpty(t, storageAccountID)
+
+		diagnosticSetting := azure.GetDiagnosticsSettingsResource(t, fmt.Sprintf("%s-diagnostics", keyVaultName), terraform.Output(t, terraformOptions, "key_vault_id"), config.SubscriptionID)
+		require.NotNil(t, diagnosticSetting.StorageAccountID)
+		assert.True(t, strings.EqualFold(storageAccountID, *diagnosticSetting.StorageAccountID), "diagnostic setting should archive to the created storage account")
+
+		id, err := arm.ParseResourceID(storageAccountID)
+		require.NoError(t, err)
+		AssertStorageRequiresHTTPS(t, id.Name)
+	})
+}
+
+func TestKeyVaultDiagnosticRetention(t *testing.T) {
+	t.Parallel()
+
+	retention := func(auditEvent interface{}) map[string]interface{} {
+		vars := map[string]interface{}{
+			"enable_diagnostic_settings": true,
+			"create_diagnostic_storage":  true,
+			"diagnostic_storage":         map[string]interface{}{"retention_days": 30},
+		}
+		if auditEvent != nil {
+			vars["diagnostic_settings"] = map[string]interface{}{"diagnostic_retention_days": map[string]interface{}{"AuditEvent": auditEvent}}
+		}
+		return vars
+	}
+	rules := func(expected map[string]float64) func(t *testing.T, plan *terraform.PlanStruct) {
+		return func(t *testing.T, plan *terraform.PlanStruct) {
+			policy, ok := plan.ResourcePlannedValuesMap["azurerm_storage_management_policy.diagnostics[0]"]
+			require.True(t, ok, "plan should create the lifecycle policy of the diagnostic storage account")
+
+			rules := map[string]float64{}
+			ruleList, _ := policy.AttributeValues["rule"].([]interface{})
+			for _, r := range ruleList {
+				rule := r.(map[string]interface{})
+				actions := rule["actions"].([]interface{})[0].(map[string]interface{})
+				baseBlob := actions["base_blob"].([]interface{})[0].(map[string]interface{})
+				rules[rule["name"].(string)] = baseBlob["delete_after_days_since_modification_greater_than"].(float64)
+			}
+			assert.Equal(t, expected, rules)
+		}
+	}
+
+	testCases := []planCase{
+		{name: "default", vars: retention(nil), check: rules(map[string]float64{"logsauditevent": 365, "logsazurepolicyevaluationdetails": 30, "metrics": 30})},
+		{name: "custom AuditEvent", vars: retention(90), check: rules(map[string]float64{"logsauditevent": 90, "logsazurepolicyevaluationdetails": 30, "metrics": 30})},
+		{name: "AuditEvent kept forever", vars: retention(0), check: rules(map[string]float64{"logsazurepolicyevaluationdetails": 30, "metrics": 30})},
+	}
+
+	MultiTenantTestRunner(t, func(t *testing.T, config TestConfig) {
+		SetupAzureAuth(t, config)
+		runPlanCases(t, config, "kv-drt", testCases)
+	})
+}
+
+func TestKeyVaultDiagnosticLogsFlowing(t *testing.T) {
+	t.Parallel()
+
+	if os.Getenv("KV_TEST_DIAGNOSTIC_LOGS") == "" {
+		t.Skip("KV_TEST_DIAGNOSTIC_LOGS is not set; skipping the Log Analytics ingestion check")
+	}
+
+	MultiTenantTestRunner(t, func(t *testing.T, config TestConfig) {
+		SetupAzureAuth(t, config)
+		CreateResourceGroup(t, &config)
+
+		fixtureDir := test_structure.CopyTerraformFolderToTemp(t, "..", "test/fixtures/diagnostics")
+		keyVaultName := PurgeSoftDeletedVault(t, config, fmt.Sprintf("kv-logs-%s", config.UniqueID))
+
+		terraformOptions := BuildTerraformOptions(t, config, map[string]interface{}{
+			"key_vault_name":      keyVaultName,
+			"location":            config.Region,
+			"resource_group_name": config.ResourceGroupName(),
+		}, WithTerraformDir(fixtureDir))
+
+		defer terraform.Destroy(t, terraformOptions)
+		terraform.InitAndApply(t, terraformOptions)
+
+		workspaceID := terraform.Output(t, terraformOptions, "log_analytics_workspace_customer_id")
+		ValidateDiagnosticLogsFlowing(t, config, workspaceID, keyVaultName, 20*time.Minute)
+	})
+}
@@ -0,0 +1,166 @@
+package test
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// runnerVNetEnv names the environment variable holding the ID of the virtual
+// network the test runner resolves DNS through. The private endpoint fixture
+// links its private DNS zone to it, so the runner sees private records.
+const runnerVNetEnv = "KV_TEST_RUNNER_VNET_ID"
+
+// ResolveVaultDNS resolves the data-plane host name of vaultName in the
+// current cloud with the runner's resolver and returns every address.
+func ResolveVaultDNS(t *testing.T, vaultName string) []net.IP {
+	t.Helper()
+
+	uri, err := url.Parse(keyVaultURI(t, vaultName))
+	require.NoError(t, err)
+	ips, err := net.DefaultResolver.LookupIP(context.Background(), "ip", uri.Hostname())
+	require.NoError(t, err, "failed to resolve %s", uri.Hostname())
+	return ips
+}
+
+// AssertVaultResolvesPrivately checks that vaultName only resolves to
+// addresses inside privateCIDR, the private endpoint subnet, and returns
+// whether it does. A public address means the privatelink zone is missing,
+// lacks the vault's record or is not linked to the runner's network.
+func AssertVaultResolvesPrivately(t *testing.T, vaultName string, privateCIDR string) bool {
+	t.Helper()
+
+	_, subnet, err := net.ParseCIDR(privateCIDR)
+	require.NoError(t, err, "invalid private CIDR %q", privateCIDR)
+	if outside := addressesOutside(ResolveVaultDNS(t, vaultName), subnet); len(outside) > 0 {
+		t.Errorf("Key Vault %s resolves to %s outside the private endpoint subnet %s", vaultName, strings.Join(outside, ", "), subnet)
+		return false
+	}
+	return true
+}
+
+// addressesOutside returns the addresses in ips that subnet does not contain.
+func addressesOutside(ips []net.IP, subnet *net.IPNet) []string {
+	var outside []string
+	for _, ip := range ips {
+		if !subnet.Contains(ip) {
+			outside = append(outside, ip.String())
+		}
+	}
+	return outside
+}
+
+// endpointProbe resolves and contacts a vault's data-plane endpoint.
+type endpointProbe interface {
+	lookupIP(ctx context.Context, host string) ([]net.IP, error)
+	// handshake completes a verified TLS handshake with host on port 443.
+	handshake(ctx context.Context, host string) error
+	// get sends an unauthenticated GET to target and returns the status code.
+	get(ctx context.Context, target string) (int, error)
+}
+
+type netEndpointProbe struct {
+	dialer *net.Dialer
+	client *http.Client
+}
+
+func (p netEndpointProbe) lookupIP(ctx context.Context, host string) ([]net.IP, error) {
+	return net.DefaultResolver.LookupIP(ctx, "ip", host)
+}
+
+func (p netEndpointProbe) handshake(ctx context.Context, host string) error {
+	_, err := tlsHandshake(p.dialer, net.JoinHostPort(host, "443"), &tls.Config{ServerName: host, MinVersion: tls.VersionTLS12})
+	return err
+}
+
+func (p netEndpointProbe) get(ctx context.Context, target string) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+// AssertDRVaultReachable checks that the primary vault at primaryURI and the
+// disaster recovery vault at drURI, such as the key_vault_uri and
+// dr_vault_uri outputs, each resolve, complete a verified TLS handshake and
+// answer a request on their own, so a failover to the disaster recovery vault
+// does not depend on the primary region. It returns whether both did. The
+// check is skipped for private-only setups, when an endpoint resolves only to
+// private addresses the runner cannot reach.
+func AssertDRVaultReachable(t *testing.T, primaryURI string, drURI string) bool {
+	t.Helper()
+
+	dialer := &net.Dialer{Timeout: tlsDialTimeout}
+	probe := netEndpointProbe{dialer: dialer, client: &http.Client{Timeout: tlsDialTimeout}}
+	problems, skip := drReachabilityProblems(context.Background(), probe, primaryURI, drURI)
+	if skip != "" {
+		t.Skipf("skipping the disaster recovery reachability check: %s", skip)
+	}
+	for _, problem := range problems {
+		t.Error(problem)
+	}
+	return len(problems) == 0
+}
+
+// drReachabilityProblems describes each way the primary and disaster
+// recovery endpoints fail to resolve, handshake or respond, or returns a
+// reason to skip the check when one of them is private-only and out of
+// reach.
+func drReachabilityProblems(ctx context.Context, probe endpointProbe, primaryURI string, drURI string) ([]string, string) {
+	var problems []string
+	for _, endpoint := range []struct{ role, uri string }{{"primary", primaryURI}, {"disaster recovery", drURI}} {
+		u, err := url.Parse(endpoint.uri)
+		if err != nil || u.Hostname() == "" {
+			problems = append(problems, fmt.Sprintf("%s vault URI %q is not a valid URL", endpoint.role, endpoint.uri))
+			continue
+		}
+		host := u.Hostname()
+
+		ips, err := probe.lookupIP(ctx, host)
+		if err != nil || len(ips) == 0 {
+			problems = append(problems, fmt.Sprintf("%s vault %s does not resolve: %v", endpoint.role, host, err))
+			continue
+		}
+		if err := probe.handshake(ctx, host); err != nil {
+			if privateOnly(ips) {
+				return nil, fmt.Sprintf("%s vault %s only resolves to private addresses and is not reachable from the runner: %v", endpoint.role, host, err)
+			}
+			problems = append(problems, fmt.Sprintf("%s vault %s failed the TLS handshake: %v", endpoint.role, host, err))
+			continue
+		}
+		// Any answer short of a server error shows the vault is up, even the
+		// 401 an unauthenticated request gets
+		status, err := probe.get(ctx, endpoint.uri)
+		switch {
+		case err != nil:
+			problems = append(problems, fmt.Sprintf("%s vault %s did not respond: %v", endpoint.role, host, err))
+		case status >= http.StatusInternalServerError:
+			problems = append(problems, fmt.Sprintf("%s vault %s responded with status %d", endpoint.role, host, status))
+		}
+	}
+	return problems, ""
+}
+
+// privateOnly reports whether every address in ips is private or loopback,
+// as when a vault is only reachable through a private endpoint.
+func privateOnly(ips []net.IP) bool {
+	for _, ip := range ips {
+		if !ip.IsPrivate() && !ip.IsLoopback() {
+			return false
+		}
+	}
+	return true
+}
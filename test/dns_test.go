@@ -0,0 +1,173 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	test_structure "github.com/gruntwork-io/terratest/modules/test-structure"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAddressesOutside(t *testing.T) {
+	t.Parallel()
+
+	_, subnet, err := net.ParseCIDR("10.42.1.0/24")
+	require.NoError(t, err)
+
+	assert.Empty(t, addressesOutside([]net.IP{net.ParseIP("10.42.1.4")}, subnet))
+	assert.Equal(t, []string{"20.61.15.49", "10.42.2.4"}, addressesOutside([]net.IP{
+		net.ParseIP("20.61.15.49"),
+		net.ParseIP("10.42.1.5"),
+		net.ParseIP("10.42.2.4"),
+	}, subnet))
+}
+
+// fakeEndpointProbe resolves hosts to addresses and fails handshakes and
+// requests for the hosts listed in handshakeErrs and statuses.
+type fakeEndpointProbe struct {
+	addresses     map[string][]net.IP
+	handshakeErrs map[string]error
+	statuses      map[string]int
+}
+
+func (f fakeEndpointProbe) lookupIP(ctx context.Context, host string) ([]net.IP, error) {
+	ips, ok := f.addresses[host]
+	if !ok {
+		return nil, errors.New("no such host")
+	}
+	return ips, nil
+}
+
+func (f fakeEndpointProbe) handshake(ctx context.Context, host string) error {
+	return f.handshakeErrs[host]
+}
+
+func (f fakeEndpointProbe) get(ctx context.Context, target string) (int, error) {
+	if status, ok := f.statuses[target]; ok {
+		return status, nil
+	}
+	return http.StatusUnauthorized, nil
+}
+
+func TestDRReachabilityProblems(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	const (
+		primary = "https://kv-app.vault.azure.net/"
+		dr      = "https://kv-app-dr.vault.azure.net/"
+	)
+	public := map[string][]net.IP{
+		"kv-app.vault.azure.net":    {net.ParseIP("20.61.15.49")},
+		"kv-app-dr.vault.azure.net": {net.ParseIP("52.155.92.12")},
+	}
+
+	problems, skip := drReachabilityProblems(ctx, fakeEndpointProbe{addresses: public}, primary, dr)
+	assert.Empty(t, problems)
+	assert.Empty(t, skip)
+
+	t.Run("unresolvable and failing endpoints", func(t *testing.T) {
+		probe := fakeEndpointProbe{
+			addresses:     map[string][]net.IP{"kv-app.vault.azure.net": public["kv-app.vault.azure.net"]},
+			handshakeErrs: map[string]error{},
+			statuses:      map[string]int{primary: http.StatusServiceUnavailable},
+		}
+		problems, skip := drReachabilityProblems(ctx, probe, primary, dr)
+		assert.Empty(t, skip)
+		require.Len(t, problems, 2)
+		assert.Equal(t, "primary vault kv-app.vault.azure.net responded with status 503", problems[0])
+		assert.Contains(t, problems[1], "disaster recovery vault kv-app-dr.vault.azure.net does not resolve")
+	})
+
+	t.Run("failed handshake on a public endpoint", func(t *testing.T) {
+		probe := fakeEndpointProbe{addresses: public, handshakeErrs: map[string]error{"kv-app-dr.vault.azure.net": errors.New("certificate signed by unknown authority")}}
+		problems, skip := drReachabilityProblems(ctx, probe, primary, dr)
+		assert.Empty(t, skip)
+		assert.Equal(t, []string{"disaster recovery vault kv-app-dr.vault.azure.net failed the TLS handshake: certificate signed by unknown authority"}, problems)
+	})
+
+	t.Run("private-only endpoint out of reach", func(t *testing.T) {
+		probe := fakeEndpointProbe{
+			addresses:     map[string][]net.IP{"kv-app.vault.azure.net": {net.ParseIP("10.42.1.4")}, "kv-app-dr.vault.azure.net": {net.ParseIP("10.43.1.4")}},
+			handshakeErrs: map[string]error{"kv-app.vault.azure.net": errors.New("i/o timeout")},
+		}
+		problems, skip := drReachabilityProblems(ctx, probe, primary, dr)
+		assert.Empty(t, problems)
+		assert.Contains(t, skip, "only resolves to private addresses")
+	})
+}
+
+func TestKeyVaultPrivateDNSResolution(t *testing.T) {
+	t.Parallel()
+
+	runnerVNetID := os.Getenv(runnerVNetEnv)
+	if runnerVNetID == "" {
+		t.Skip(runnerVNetEnv + " is not set; skipping the private DNS resolution check")
+	}
+
+	MultiTenantTestRunner(t, func(t *testing.T, config TestConfig) {
+		SetupAzureAuth(t, config)
+		CreateResourceGroup(t, &config)
+
+		fixtureDir := test_structure.CopyTerraformFolderToTemp(t, "..", "test/fixtures/private_endpoint")
+		keyVaultName := PurgeSoftDeletedVault(t, config, fmt.Sprintf("kv-dns-%s", config.UniqueID))
+
+		terraformOptions := BuildTerraformOptions(t, config, map[string]interface{}{
+			"key_vault_name":            keyVaultName,
+			"location":                  config.Region,
+			"resource_group_name":       fmt.Sprintf("%s-%s", config.ResourceGroup, config.UniqueID),
+			"runner_virtual_network_id": runnerVNetID,
+		}, WithTerraformDir(fixtureDir))
+
+		defer terraform.Destroy(t, terraformOptions)
+		terraform.InitAndApply(t, terraformOptions)
+
+		AssertVaultResolvesPrivately(t, keyVaultName, terraform.Output(t, terraformOptions, "private_endpoint_subnet_cidr"))
+	})
+}
+
+func TestKeyVaultDRVaultReachable(t *testing.T) {
+	t.Parallel()
+
+	if os.Getenv("KV_TEST_DR_REACHABILITY") == "" {
+		t.Skip("KV_TEST_DR_REACHABILITY is not set; skipping the disaster recovery reachability probe")
+	}
+
+	MultiTenantTestRunner(t, func(t *testing.T, config TestConfig) {
+		SetupAzureAuth(t, config)
+		CreateResourceGroup(t, &config)
+
+		// Any other region will do, preferably one the tenant is already
+		// configured for
+		drRegion := "northeurope"
+		for _, region := range append(append([]string(nil), config.RegionList...), "northeurope", "westeurope") {
+			if !strings.EqualFold(region, config.Region) {
+				drRegion = region
+				break
+			}
+		}
+
+		keyVaultName := PurgeSoftDeletedVault(t, config, fmt.Sprintf("kv-drr-%s", config.UniqueID))
+		vars := baseModuleVars(config, keyVaultName)
+		vars["create_dr_vault"] = true
+		vars["dr_location"] = drRegion
+
+		terraformOptions := BuildTerraformOptions(t, config, vars)
+
+		defer terraform.Destroy(t, terraformOptions)
+		terraform.InitAndApply(t, terraformOptions)
+
+		primaryURI := terraform.Output(t, terraformOptions, "key_vault_uri")
+		drURI := terraform.Output(t, terraformOptions, "dr_vault_uri")
+		require.NotEqual(t, primaryURI, drURI, "the disaster recovery vault needs its own endpoint")
+		AssertDRVaultReachable(t, primaryURI, drURI)
+	})
+}
@@ -0,0 +1,140 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azkeys"
+	"github.com/stretchr/testify/require"
+)
+
+// runnerIPEnv names the environment variable holding the test runner's
+// public IP address, for runners whose egress address the echo service
+// cannot see, such as those behind a proxy.
+const runnerIPEnv = "KV_TEST_RUNNER_IP"
+
+// runnerIPEchoURL answers with the caller's public IP address as plain text.
+const runnerIPEchoURL = "https://api.ipify.org"
+
+// Network rule changes take a few minutes to reach every Key Vault front end,
+// so firewall outcomes are polled with backoff.
+const (
+	firewallPropagationTimeout = 5 * time.Minute
+	firewallInitialWait        = 5 * time.Second
+	firewallMaxWait            = 30 * time.Second
+)
+
+// RunnerPublicIP returns the public IP address the test runner reaches Azure
+// from, to allowlist it in network_acls_ip_rules. KV_TEST_RUNNER_IP overrides
+// the lookup.
+func RunnerPublicIP(t *testing.T) string {
+	t.Helper()
+
+	if ip := os.Getenv(runnerIPEnv); ip != "" {
+		require.NotNil(t, net.ParseIP(ip), "%s must be an IP address, got %q", runnerIPEnv, ip)
+		return ip
+	}
+	ip, err := runnerPublicIP(&http.Client{Timeout: 10 * time.Second}, runnerIPEchoURL)
+	require.NoError(t, err, "failed to look up the runner's public IP; set %s", runnerIPEnv)
+	return ip
+}
+
+func runnerPublicIP(client *http.Client, url string) (string, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64))
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s answered %s", url, resp.Status)
+	}
+	ip := strings.TrimSpace(string(body))
+	if net.ParseIP(ip) == nil {
+		return "", fmt.Errorf("%s answered %q, not an IP address", url, ip)
+	}
+	return ip, nil
+}
+
+// AssertVaultAccessDeniedFromCurrentIP lists keys on the data plane of
+// vaultURI and fails t unless the vault's firewall rejects the runner with
+// 403 Forbidden, for a vault that denies by default and does not allowlist
+// the runner's IP. A 403 from RBAC does not count as a firewall denial.
+func AssertVaultAccessDeniedFromCurrentIP(t *testing.T, vaultURI string) {
+	t.Helper()
+
+	err := waitForFirewallOutcome(context.Background(), dataPlaneLister(t, vaultURI), true, firewallPropagationTimeout, time.Now, time.Sleep)
+	if err != nil {
+		t.Errorf("Key Vault %s did not deny the runner's IP: %v", vaultURI, err)
+	}
+}
+
+// AssertVaultAccessAllowed lists keys on the data plane of vaultURI and fails
+// t unless the call succeeds, for a vault whose network rules admit the
+// runner.
+func AssertVaultAccessAllowed(t *testing.T, vaultURI string) {
+	t.Helper()
+
+	err := waitForFirewallOutcome(context.Background(), dataPlaneLister(t, vaultURI), false, firewallPropagationTimeout, time.Now, time.Sleep)
+	if err != nil {
+		t.Errorf("Key Vault %s did not allow the runner's IP: %v", vaultURI, err)
+	}
+}
+
+func dataPlaneLister(t *testing.T, vaultURI string) func(ctx context.Context) error {
+	t.Helper()
+
+	client, err := azkeys.NewClient(vaultURI, azureCredential(t), nil)
+	require.NoError(t, err)
+	return func(ctx context.Context) error {
+		_, err := client.NewListKeyPropertiesPager(nil).NextPage(ctx)
+		return err
+	}
+}
+
+// waitForFirewallOutcome calls list until it is denied by the firewall, when
+// wantDenied is set, or until it succeeds otherwise. Anything else, such as
+// a call still allowed before a new rule has propagated, is retried until
+// timeout.
+func waitForFirewallOutcome(ctx context.Context, list func(ctx context.Context) error, wantDenied bool, timeout time.Duration, now func() time.Time, sleep func(time.Duration)) error {
+	return pollWithBackoff(ctx, timeout, firewallInitialWait, firewallMaxWait, now, sleep, func(ctx context.Context) (bool, error) {
+		err := list(ctx)
+		if !wantDenied {
+			return err == nil, err
+		}
+		switch {
+		case isFirewallDenied(err):
+			return true, nil
+		case err == nil:
+			return false, errors.New("data-plane list succeeded")
+		default:
+			return false, err
+		}
+	})
+}
+
+// isFirewallDenied reports whether err is a 403 from the Key Vault firewall
+// rather than from RBAC, whose denials carry the ForbiddenByRbac inner code.
+func isFirewallDenied(err error) bool {
+	if err == nil || strings.Contains(err.Error(), "ForbiddenByRbac") {
+		return false
+	}
+	var respErr *azcore.ResponseError
+	if errors.As(err, &respErr) {
+		return respErr.StatusCode == http.StatusForbidden
+	}
+	return strings.Contains(err.Error(), "ForbiddenByFirewall")
+}
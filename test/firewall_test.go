@@ -0,0 +1,109 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunnerPublicIP(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "203.0.113.7")
+	}))
+	defer server.Close()
+
+	ip, err := runnerPublicIP(server.Client(), server.URL)
+	require.NoError(t, err)
+	assert.Equal(t, "203.0.113.7", ip)
+}
+
+func TestRunnerPublicIPRejectsOtherAnswers(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "<html>rate limited</html>")
+	}))
+	defer server.Close()
+
+	_, err := runnerPublicIP(server.Client(), server.URL)
+	assert.ErrorContains(t, err, "not an IP address")
+}
+
+func TestIsFirewallDenied(t *testing.T) {
+	t.Parallel()
+
+	assert.True(t, isFirewallDenied(&azcore.ResponseError{StatusCode: http.StatusForbidden, ErrorCode: "Forbidden"}))
+	assert.True(t, isFirewallDenied(errors.New(`Status=403 Code="Forbidden" InnerError={"code":"ForbiddenByFirewall"}`)))
+	assert.False(t, isFirewallDenied(errors.New(`Status=403 Code="Forbidden" InnerError={"code":"ForbiddenByRbac"}`)))
+	assert.False(t, isFirewallDenied(&azcore.ResponseError{StatusCode: http.StatusNotFound}))
+	assert.False(t, isFirewallDenied(nil))
+}
+
+func TestWaitForFirewallOutcomeDenied(t *testing.T) {
+	t.Parallel()
+
+	// The first call lands before the new rule has propagated
+	results := []error{nil, &azcore.ResponseError{StatusCode: http.StatusForbidden, ErrorCode: "Forbidden"}}
+	calls := 0
+	list := func(ctx context.Context) error {
+		calls++
+		return results[calls-1]
+	}
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+
+	require.NoError(t, waitForFirewallOutcome(context.Background(), list, true, time.Minute, clock.Now, clock.Sleep))
+	assert.Equal(t, 2, calls)
+}
+
+func TestWaitForFirewallOutcomeAllowedTimesOut(t *testing.T) {
+	t.Parallel()
+
+	denied := errors.New(`Status=403 Code="Forbidden" InnerError={"code":"ForbiddenByFirewall"}`)
+	list := func(ctx context.Context) error { return denied }
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+
+	err := waitForFirewallOutcome(context.Background(), list, false, time.Minute, clock.Now, clock.Sleep)
+	assert.ErrorIs(t, err, denied)
+}
+
+func TestKeyVaultFirewallEnforcement(t *testing.T) {
+	t.Parallel()
+
+	MultiTenantTestRunner(t, func(t *testing.T, config TestConfig) {
+		SetupAzureAuth(t, config)
+		CreateResourceGroup(t, &config)
+
+		keyVaultName := PurgeSoftDeletedVault(t, config, fmt.Sprintf("kv-fw-%s", config.UniqueID))
+		vars := baseModuleVars(config, keyVaultName)
+		vars["network_acls_default_action"] = "Deny"
+		vars["network_acls_bypass"] = "None"
+
+		terraformOptions := BuildTerraformOptions(t, config, vars)
+
+		defer terraform.Destroy(t, terraformOptions)
+		terraform.InitAndApply(t, terraformOptions)
+		vaultURI := terraform.Output(t, terraformOptions, "key_vault_uri")
+
+		view := GetVaultView(t, config, config.ResourceGroupName(), keyVaultName)
+		assert.Equal(t, "None", view.NetworkBypass, "trusted Azure services should not bypass the firewall")
+		assert.Equal(t, "Deny", view.NetworkDefaultAction)
+
+		AssertVaultAccessDeniedFromCurrentIP(t, vaultURI)
+
+		vars["network_acls_ip_rules"] = []string{RunnerPublicIP(t)}
+		terraform.Apply(t, terraformOptions)
+
+		AssertVaultAccessAllowed(t, vaultURI)
+	})
+}
@@ -0,0 +1,43 @@
+# Test fixture: Key Vault paging an action group on saturation
+
+terraform {
+  required_providers {
+    azurerm = {
+      source  = "hashicorp/azurerm"
+      version = "~> 4.0"
+    }
+  }
+}
+
+provider "azurerm" {
+  features {}
+}
+
+resource "azurerm_monitor_action_group" "test" {
+  name                = "ag-${var.key_vault_name}"
+  resource_group_name = var.resource_group_name
+  short_name          = "kvtest"
+}
+
+module "key_vault" {
+  source = "../../.."
+
+  custom_name         = var.key_vault_name
+  location            = var.location
+  location_short      = "test"
+  environment         = "test"
+  resource_group_name = var.resource_group_name
+
+  purge_protection_enabled      = false
+  public_network_access_enabled = true
+  network_acls_default_action   = "Allow"
+
+  create_availability_alert          = true
+  availability_alert_action_group_id = azurerm_monitor_action_group.test.id
+
+  enable_private_endpoint    = false
+  enable_diagnostic_settings = false
+  enable_resource_lock       = false
+  enable_policy_assignments  = false
+  enable_policy_initiative   = false
+}
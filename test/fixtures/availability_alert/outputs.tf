@@ -0,0 +1,16 @@
+# Test fixture outputs
+
+output "key_vault_id" {
+  description = "The ID of the Key Vault"
+  value       = module.key_vault.key_vault_id
+}
+
+output "action_group_id" {
+  description = "The ID of the action group the alert notifies"
+  value       = azurerm_monitor_action_group.test.id
+}
+
+output "availability_alert_id" {
+  description = "The ID of the availability alert on the Key Vault"
+  value       = module.key_vault.availability_alert_id
+}
@@ -0,0 +1,45 @@
+# Test fixture: Key Vault shipping diagnostics to a dedicated Log Analytics workspace
+
+terraform {
+  required_providers {
+    azurerm = {
+      source  = "hashicorp/azurerm"
+      version = "~> 4.0"
+    }
+  }
+}
+
+provider "azurerm" {
+  features {}
+}
+
+resource "azurerm_log_analytics_workspace" "test" {
+  name                = "log-${var.key_vault_name}"
+  location            = var.location
+  resource_group_name = var.resource_group_name
+  sku                 = "PerGB2018"
+  retention_in_days   = 30
+}
+
+module "key_vault" {
+  source = "../../.."
+
+  custom_name         = var.key_vault_name
+  location            = var.location
+  location_short      = "test"
+  environment         = "test"
+  resource_group_name = var.resource_group_name
+
+  purge_protection_enabled      = false
+  public_network_access_enabled = true
+  network_acls_default_action   = "Allow"
+
+  diagnostic_settings = {
+    log_analytics_workspace_id = azurerm_log_analytics_workspace.test.id
+  }
+
+  enable_private_endpoint   = false
+  enable_resource_lock      = false
+  enable_policy_assignments = false
+  enable_policy_initiative  = false
+}
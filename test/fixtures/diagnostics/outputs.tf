@@ -0,0 +1,26 @@
+# Test fixture outputs
+
+output "key_vault_id" {
+  description = "The ID of the Key Vault"
+  value       = module.key_vault.key_vault_id
+}
+
+output "key_vault_name" {
+  description = "The name of the Key Vault"
+  value       = module.key_vault.key_vault_name
+}
+
+output "diagnostic_setting_id" {
+  description = "The ID of the diagnostic setting"
+  value       = module.key_vault.diagnostic_setting_id
+}
+
+output "log_analytics_workspace_id" {
+  description = "The ID of the test Log Analytics workspace"
+  value       = azurerm_log_analytics_workspace.test.id
+}
+
+output "log_analytics_workspace_customer_id" {
+  description = "The workspace (customer) ID of the test Log Analytics workspace, used to query its logs"
+  value       = azurerm_log_analytics_workspace.test.workspace_id
+}
@@ -0,0 +1,73 @@
+# Test fixture: Key Vault publishing near-expiry events to a storage queue,
+# optionally delivered as a user-assigned identity
+
+terraform {
+  required_providers {
+    azurerm = {
+      source  = "hashicorp/azurerm"
+      version = "~> 4.0"
+    }
+  }
+}
+
+provider "azurerm" {
+  features {}
+}
+
+resource "azurerm_storage_account" "test" {
+  name                     = substr(replace("st${var.key_vault_name}", "-", ""), 0, 24)
+  location                 = var.location
+  resource_group_name      = var.resource_group_name
+  account_tier             = "Standard"
+  account_replication_type = "LRS"
+}
+
+resource "azurerm_storage_queue" "test" {
+  name                 = "key-vault-events"
+  storage_account_name = azurerm_storage_account.test.name
+}
+
+resource "azurerm_user_assigned_identity" "test" {
+  count               = var.use_managed_identity ? 1 : 0
+  name                = "id-${var.key_vault_name}"
+  location            = var.location
+  resource_group_name = var.resource_group_name
+}
+
+resource "azurerm_role_assignment" "queue_sender" {
+  count                = var.use_managed_identity ? 1 : 0
+  scope                = azurerm_storage_account.test.id
+  role_definition_name = "Storage Queue Data Message Sender"
+  principal_id         = azurerm_user_assigned_identity.test[0].principal_id
+  principal_type       = "ServicePrincipal"
+}
+
+module "key_vault" {
+  source = "../../.."
+
+  custom_name         = var.key_vault_name
+  location            = var.location
+  location_short      = "test"
+  environment         = "test"
+  resource_group_name = var.resource_group_name
+
+  purge_protection_enabled      = false
+  public_network_access_enabled = true
+  network_acls_default_action   = "Allow"
+
+  event_grid_enabled = true
+  event_grid_storage_queue = {
+    storage_account_id = azurerm_storage_account.test.id
+    queue_name         = azurerm_storage_queue.test.name
+  }
+  identity = var.use_managed_identity ? {
+    type         = "UserAssigned"
+    identity_ids = [azurerm_user_assigned_identity.test[0].id]
+  } : null
+
+  enable_private_endpoint    = false
+  enable_diagnostic_settings = false
+  enable_resource_lock       = false
+  enable_policy_assignments  = false
+  enable_policy_initiative   = false
+}
@@ -0,0 +1,21 @@
+# Test fixture outputs
+
+output "key_vault_id" {
+  description = "The ID of the Key Vault"
+  value       = module.key_vault.key_vault_id
+}
+
+output "event_grid_system_topic_id" {
+  description = "The ID of the Event Grid system topic on the Key Vault"
+  value       = module.key_vault.event_grid_system_topic_id
+}
+
+output "event_grid_subscription_id" {
+  description = "The ID of the Event Grid subscription delivering near-expiry events"
+  value       = module.key_vault.event_grid_subscription_id
+}
+
+output "user_assigned_identity_id" {
+  description = "The ID of the user-assigned identity delivering the events, when use_managed_identity is set"
+  value       = var.use_managed_identity ? azurerm_user_assigned_identity.test[0].id : null
+}
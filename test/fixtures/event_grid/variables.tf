@@ -0,0 +1,22 @@
+# Test fixture variables
+
+variable "key_vault_name" {
+  description = "Name of the Key Vault under test"
+  type        = string
+}
+
+variable "location" {
+  description = "Azure region for the test resources"
+  type        = string
+}
+
+variable "resource_group_name" {
+  description = "Name of the pre-created test resource group"
+  type        = string
+}
+
+variable "use_managed_identity" {
+  description = "Deliver the events as a user-assigned identity allowed to send to the queue"
+  type        = bool
+  default     = false
+}
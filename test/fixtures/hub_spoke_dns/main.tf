@@ -0,0 +1,55 @@
+# Test fixture: Key Vault in a spoke subscription registering its private
+# endpoint in a private DNS zone the hub subscription owns. Only validated,
+# never deployed.
+
+terraform {
+  required_providers {
+    azurerm = {
+      source  = "hashicorp/azurerm"
+      version = "~> 4.0"
+    }
+  }
+}
+
+provider "azurerm" {
+  features {}
+}
+
+provider "azurerm" {
+  alias           = "hub"
+  subscription_id = var.hub_subscription_id
+  features {}
+}
+
+resource "azurerm_private_dns_zone" "hub" {
+  provider = azurerm.hub
+
+  name                = "privatelink.vaultcore.azure.net"
+  resource_group_name = var.hub_resource_group_name
+}
+
+module "key_vault" {
+  source = "../../.."
+
+  providers = {
+    azurerm = azurerm
+  }
+
+  custom_name         = var.key_vault_name
+  location            = var.location
+  location_short      = "test"
+  environment         = "test"
+  resource_group_name = var.resource_group_name
+
+  purge_protection_enabled      = false
+  public_network_access_enabled = false
+
+  enable_private_endpoint    = true
+  private_endpoint_subnet_id = var.private_endpoint_subnet_id
+  private_dns_zone_ids       = [azurerm_private_dns_zone.hub.id]
+
+  enable_diagnostic_settings = false
+  enable_resource_lock       = false
+  enable_policy_assignments  = false
+  enable_policy_initiative   = false
+}
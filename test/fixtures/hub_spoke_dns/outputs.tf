@@ -0,0 +1,11 @@
+# Test fixture outputs
+
+output "key_vault_id" {
+  description = "The ID of the Key Vault"
+  value       = module.key_vault.key_vault_id
+}
+
+output "private_dns_zone_id" {
+  description = "The ID of the hub's private DNS zone"
+  value       = azurerm_private_dns_zone.hub.id
+}
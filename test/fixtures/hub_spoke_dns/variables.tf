@@ -0,0 +1,31 @@
+# Test fixture variables
+
+variable "key_vault_name" {
+  description = "Name of the Key Vault under test"
+  type        = string
+}
+
+variable "location" {
+  description = "Azure region for the test resources"
+  type        = string
+}
+
+variable "resource_group_name" {
+  description = "Name of the spoke resource group holding the Key Vault"
+  type        = string
+}
+
+variable "private_endpoint_subnet_id" {
+  description = "ID of the spoke subnet for the private endpoint"
+  type        = string
+}
+
+variable "hub_subscription_id" {
+  description = "ID of the hub subscription owning the private DNS zone"
+  type        = string
+}
+
+variable "hub_resource_group_name" {
+  description = "Name of the hub resource group holding the private DNS zone"
+  type        = string
+}
@@ -0,0 +1,65 @@
+# Test fixture: Key Vault managing and regenerating the keys of a storage
+# account
+
+terraform {
+  required_providers {
+    azurerm = {
+      source  = "hashicorp/azurerm"
+      version = "~> 4.0"
+    }
+  }
+}
+
+provider "azurerm" {
+  features {}
+}
+
+data "azurerm_client_config" "current" {}
+
+resource "azurerm_storage_account" "test" {
+  name                     = substr(replace("st${var.key_vault_name}", "-", ""), 0, 24)
+  location                 = var.location
+  resource_group_name      = var.resource_group_name
+  account_tier             = "Standard"
+  account_replication_type = "LRS"
+}
+
+module "key_vault" {
+  source = "../../.."
+
+  custom_name         = var.key_vault_name
+  location            = var.location
+  location_short      = "test"
+  environment         = "test"
+  resource_group_name = var.resource_group_name
+
+  purge_protection_enabled      = false
+  public_network_access_enabled = true
+  network_acls_default_action   = "Allow"
+
+  # Managed storage account keys are only available with access policies
+  enable_rbac_authorization = false
+  access_policies = {
+    deployer = {
+      object_id           = data.azurerm_client_config.current.object_id
+      secret_permissions  = ["Get", "List", "Set", "Delete", "Purge"]
+      storage_permissions = ["Get", "List", "Set", "Update", "Delete", "Purge", "RegenerateKey", "GetSAS", "ListSAS", "SetSAS", "DeleteSAS"]
+    }
+  }
+
+  key_vault_service_principal_object_id = var.key_vault_service_principal_object_id
+  managed_storage_accounts = {
+    test = {
+      name                = "sttest"
+      storage_account_id  = azurerm_storage_account.test.id
+      storage_account_key = "key1"
+      regeneration_period = "P30D"
+    }
+  }
+
+  enable_private_endpoint    = false
+  enable_diagnostic_settings = false
+  enable_resource_lock       = false
+  enable_policy_assignments  = false
+  enable_policy_initiative   = false
+}
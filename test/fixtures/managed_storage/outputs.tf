@@ -0,0 +1,16 @@
+# Test fixture outputs
+
+output "key_vault_id" {
+  description = "The ID of the Key Vault"
+  value       = module.key_vault.key_vault_id
+}
+
+output "storage_account_id" {
+  description = "The ID of the managed storage account"
+  value       = azurerm_storage_account.test.id
+}
+
+output "managed_storage_account_ids" {
+  description = "Map of managed storage account names to their IDs in the Key Vault"
+  value       = module.key_vault.managed_storage_account_ids
+}
@@ -0,0 +1,21 @@
+# Test fixture variables
+
+variable "key_vault_name" {
+  description = "Name of the Key Vault under test"
+  type        = string
+}
+
+variable "location" {
+  description = "Azure region for the test resources"
+  type        = string
+}
+
+variable "resource_group_name" {
+  description = "Name of the pre-created test resource group"
+  type        = string
+}
+
+variable "key_vault_service_principal_object_id" {
+  description = "Object ID of the Azure Key Vault service principal in the test tenant"
+  type        = string
+}
@@ -0,0 +1,6 @@
+# Test fixture: a configuration with nothing to provision, for timing the
+# Terraform workflow itself
+
+resource "terraform_data" "noop" {
+  input = "noop"
+}
@@ -0,0 +1,6 @@
+# Test fixture outputs
+
+output "value" {
+  description = "Value stored in the no-op resource"
+  value       = terraform_data.noop.output
+}
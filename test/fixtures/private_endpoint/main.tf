@@ -0,0 +1,89 @@
+# Test fixture: Key Vault behind a private endpoint in a dedicated test VNet
+
+terraform {
+  required_providers {
+    azurerm = {
+      source  = "hashicorp/azurerm"
+      version = "~> 4.0"
+    }
+  }
+}
+
+provider "azurerm" {
+  features {}
+}
+
+resource "azurerm_virtual_network" "test" {
+  name                = "vnet-${var.key_vault_name}"
+  location            = var.location
+  resource_group_name = var.resource_group_name
+  address_space       = ["10.42.0.0/16"]
+}
+
+resource "azurerm_subnet" "private_endpoints" {
+  name                 = "snet-private-endpoints"
+  resource_group_name  = var.resource_group_name
+  virtual_network_name = azurerm_virtual_network.test.name
+  address_prefixes     = [var.private_endpoint_subnet_cidr]
+  service_endpoints    = var.allow_subnet_through_firewall ? ["Microsoft.KeyVault"] : []
+}
+
+resource "azurerm_private_dns_zone" "key_vault" {
+  name                = "privatelink.vaultcore.azure.net"
+  resource_group_name = var.resource_group_name
+}
+
+resource "azurerm_private_dns_zone_virtual_network_link" "key_vault" {
+  name                  = "link-${var.key_vault_name}"
+  resource_group_name   = var.resource_group_name
+  private_dns_zone_name = azurerm_private_dns_zone.key_vault.name
+  virtual_network_id    = azurerm_virtual_network.test.id
+}
+
+# Lets a runner outside the test VNet resolve the private records
+resource "azurerm_private_dns_zone_virtual_network_link" "runner" {
+  count = var.runner_virtual_network_id != null ? 1 : 0
+
+  name                  = "link-runner-${var.key_vault_name}"
+  resource_group_name   = var.resource_group_name
+  private_dns_zone_name = azurerm_private_dns_zone.key_vault.name
+  virtual_network_id    = var.runner_virtual_network_id
+}
+
+resource "azurerm_private_dns_zone" "secondary" {
+  count = var.secondary_private_dns_zone_name != null ? 1 : 0
+
+  name                = var.secondary_private_dns_zone_name
+  resource_group_name = var.resource_group_name
+}
+
+module "key_vault" {
+  source = "../../.."
+
+  custom_name         = var.key_vault_name
+  location            = var.location
+  location_short      = "test"
+  environment         = "test"
+  resource_group_name = var.resource_group_name
+
+  purge_protection_enabled      = false
+  public_network_access_enabled = false
+
+  enable_private_endpoint    = true
+  private_endpoint_subnet_id = azurerm_subnet.private_endpoints.id
+  private_dns_zone_ids       = var.lookup_private_dns_zone_by_name ? [] : concat([azurerm_private_dns_zone.key_vault.id], azurerm_private_dns_zone.secondary[*].id)
+
+  # The resource group is taken from the zone's ID, unknown until the zone
+  # exists, so the module's lookup waits for it instead of running at plan.
+  private_dns_zone_name           = var.lookup_private_dns_zone_by_name ? azurerm_private_dns_zone.key_vault.name : null
+  private_dns_zone_resource_group = var.lookup_private_dns_zone_by_name ? split("/", azurerm_private_dns_zone.key_vault.id)[4] : null
+
+  network_acls_subnet_ids = var.allow_subnet_through_firewall ? [azurerm_subnet.private_endpoints.id] : []
+
+  role_assignments = var.role_assignments
+
+  enable_diagnostic_settings = false
+  enable_resource_lock       = false
+  enable_policy_assignments  = false
+  enable_policy_initiative   = false
+}
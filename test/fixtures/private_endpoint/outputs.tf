@@ -0,0 +1,51 @@
+# Test fixture outputs
+
+output "key_vault_id" {
+  description = "The ID of the Key Vault"
+  value       = module.key_vault.key_vault_id
+}
+
+output "key_vault_name" {
+  description = "The name of the Key Vault"
+  value       = module.key_vault.key_vault_name
+}
+
+output "private_endpoint_id" {
+  description = "The ID of the private endpoint"
+  value       = module.key_vault.private_endpoint_id
+}
+
+output "private_endpoint_ip_address" {
+  description = "The private IP address of the private endpoint"
+  value       = module.key_vault.private_endpoint_ip_address
+}
+
+output "private_endpoint_fqdn" {
+  description = "The FQDN registered for the private endpoint"
+  value       = module.key_vault.private_endpoint_fqdn
+}
+
+output "private_endpoint_subnet_cidr" {
+  description = "Address prefix of the private endpoint subnet"
+  value       = var.private_endpoint_subnet_cidr
+}
+
+output "role_assignment_ids" {
+  description = "Map of role assignment keys to their IDs"
+  value       = module.key_vault.role_assignment_ids
+}
+
+output "network_posture" {
+  description = "Summary of the Key Vault's network exposure"
+  value       = module.key_vault.network_posture
+}
+
+output "private_dns_zone_ids" {
+  description = "IDs of the private DNS zones created for the private endpoint"
+  value       = concat([azurerm_private_dns_zone.key_vault.id], azurerm_private_dns_zone.secondary[*].id)
+}
+
+output "private_dns_zone_configs" {
+  description = "Map of the private endpoint's DNS zone config names to their zone IDs"
+  value       = module.key_vault.private_dns_zone_configs
+}
@@ -0,0 +1,56 @@
+# Test fixture variables
+
+variable "key_vault_name" {
+  description = "Name of the Key Vault under test"
+  type        = string
+}
+
+variable "location" {
+  description = "Azure region for the test resources"
+  type        = string
+}
+
+variable "resource_group_name" {
+  description = "Name of the pre-created test resource group"
+  type        = string
+}
+
+variable "private_endpoint_subnet_cidr" {
+  description = "Address prefix of the private endpoint subnet"
+  type        = string
+  default     = "10.42.1.0/24"
+}
+
+variable "runner_virtual_network_id" {
+  description = "ID of the virtual network the test runner resolves DNS through, linked to the private DNS zone when set"
+  type        = string
+  default     = null
+}
+
+variable "allow_subnet_through_firewall" {
+  description = "Enable the Key Vault service endpoint on the private endpoint subnet and allow the subnet through the vault firewall"
+  type        = bool
+  default     = false
+}
+
+variable "secondary_private_dns_zone_name" {
+  description = "Name of a second private DNS zone, standing in for another hub's zone, added to the private endpoint's DNS zone group when set"
+  type        = string
+  default     = null
+}
+
+variable "lookup_private_dns_zone_by_name" {
+  description = "Pass the private DNS zone to the module by name and resource group instead of by ID"
+  type        = bool
+  default     = false
+}
+
+variable "role_assignments" {
+  description = "Role assignments passed through to the module"
+  type = map(object({
+    principal_id         = string
+    role_definition_name = string
+    principal_type       = optional(string)
+  }))
+  default = {}
+}
@@ -0,0 +1,40 @@
+# Test fixture: ephemeral Key Vault purged by the module on destroy
+
+terraform {
+  required_providers {
+    azurerm = {
+      source  = "hashicorp/azurerm"
+      version = "~> 4.0"
+    }
+  }
+}
+
+# The provider purge is turned off so only purge_on_destroy can purge the vault.
+provider "azurerm" {
+  features {
+    key_vault {
+      purge_soft_delete_on_destroy = false
+    }
+  }
+}
+
+module "key_vault" {
+  source = "../../.."
+
+  custom_name         = var.key_vault_name
+  location            = var.location
+  location_short      = "test"
+  environment         = "test"
+  resource_group_name = var.resource_group_name
+
+  purge_protection_enabled      = false
+  purge_on_destroy              = true
+  public_network_access_enabled = true
+  network_acls_default_action   = "Allow"
+
+  enable_private_endpoint    = false
+  enable_diagnostic_settings = false
+  enable_resource_lock       = false
+  enable_policy_assignments  = false
+  enable_policy_initiative   = false
+}
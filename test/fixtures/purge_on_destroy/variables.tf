@@ -0,0 +1,16 @@
+# Test fixture variables
+
+variable "key_vault_name" {
+  description = "Name of the Key Vault under test"
+  type        = string
+}
+
+variable "location" {
+  description = "Azure region for the test resources"
+  type        = string
+}
+
+variable "resource_group_name" {
+  description = "Name of the pre-created test resource group"
+  type        = string
+}
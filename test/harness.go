@@ -0,0 +1,473 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
+	"github.com/gruntwork-io/terratest/modules/random"
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	defaultTestRegion        = "westeurope"
+	defaultTestResourceGroup = "rg-kv-test"
+)
+
+// TestConfig describes one tenant and subscription the module tests run
+// against. UniqueID is generated per run, after UniqueIDPrefix, so parallel
+// tests never share resource names. When RegionList is set,
+// CreateResourceGroup tries its regions in order and sets Region to the first
+// one with capacity. Environment selects the Azure cloud (see
+// EnvironmentPublic and friends).
+type TestConfig struct {
+	Name           string   `json:"name" yaml:"name"`
+	TenantID       string   `json:"tenant_id" yaml:"tenant_id"`
+	SubscriptionID string   `json:"subscription_id" yaml:"subscription_id"`
+	Region         string   `json:"region" yaml:"region"`
+	RegionList     []string `json:"region_list" yaml:"region_list"`
+	ResourceGroup  string   `json:"resource_group" yaml:"resource_group"`
+	UniqueIDPrefix string   `json:"unique_id_prefix" yaml:"unique_id_prefix"`
+	AuthMode       string   `json:"auth_mode" yaml:"auth_mode"`
+	ClientID       string   `json:"client_id" yaml:"client_id"`
+	Environment    string   `json:"environment" yaml:"environment"`
+	UniqueID       string   `json:"-" yaml:"-"`
+}
+
+// ResourceGroupName returns the name of the resource group created for this
+// run by CreateResourceGroup.
+func (c TestConfig) ResourceGroupName() string {
+	return fmt.Sprintf("%s-%s", c.ResourceGroup, c.UniqueID)
+}
+
+// Clone returns a copy of c with UniqueID set to uniqueID, and so its own
+// ResourceGroupName and resource names, for running one tenant under several
+// scenarios in parallel subtests. Nothing is shared with c: changing the
+// copy's RegionList, or a Region set by CreateResourceGroup, leaves c as it
+// was. Keep uniqueID as short as the generated ones, since vault names are
+// built from it.
+func (c TestConfig) Clone(uniqueID string) TestConfig {
+	clone := c
+	clone.RegionList = append([]string(nil), c.RegionList...)
+	clone.UniqueID = uniqueID
+	return clone
+}
+
+var (
+	guidPattern          = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+	regionPattern        = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9 ]*$`)
+	resourceGroupPattern = regexp.MustCompile(`^[-\w.()]*[-\w()]$`)
+)
+
+// ValidateTestConfig checks the fields every tenant needs before anything is
+// deployed: TenantID and SubscriptionID must be GUIDs, ClientID too when set,
+// Region a region name and ResourceGroup a valid resource group name prefix.
+// All problems are returned in one error naming the tenant and the field to
+// fix, rather than an Azure error from deep inside an apply.
+func ValidateTestConfig(config TestConfig) error {
+	var problems []string
+	checkGUID := func(field string, value string, required bool) {
+		switch {
+		case value == "" && required:
+			problems = append(problems, fmt.Sprintf("%s is empty", field))
+		case value != "" && !guidPattern.MatchString(value):
+			problems = append(problems, fmt.Sprintf("%s %q is not a GUID", field, value))
+		}
+	}
+	checkGUID("tenant_id", config.TenantID, true)
+	checkGUID("subscription_id", config.SubscriptionID, true)
+	checkGUID("client_id", config.ClientID, false)
+
+	switch {
+	case strings.TrimSpace(config.Region) == "":
+		problems = append(problems, "region is empty")
+	case !regionPattern.MatchString(config.Region):
+		problems = append(problems, fmt.Sprintf("region %q is not an Azure region name such as westeurope", config.Region))
+	}
+
+	// CreateResourceGroup appends "-<UniqueID>", so the prefix leaves room
+	// for it within the 90 character limit.
+	switch {
+	case config.ResourceGroup == "":
+		problems = append(problems, "resource_group is empty")
+	case !resourceGroupPattern.MatchString(config.ResourceGroup):
+		problems = append(problems, fmt.Sprintf("resource_group %q may only hold letters, digits, '-', '_', '.', '(' and ')' and must not end with '.'", config.ResourceGroup))
+	case len(config.ResourceGroup) > 70:
+		problems = append(problems, fmt.Sprintf("resource_group %q is longer than 70 characters", config.ResourceGroup))
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	name := config.Name
+	if name == "" {
+		name = config.TenantID
+	}
+	if name == "" {
+		return fmt.Errorf("invalid test config: %s", strings.Join(problems, "; "))
+	}
+	return fmt.Errorf("invalid test config for tenant %q: %s", name, strings.Join(problems, "; "))
+}
+
+// loadTestConfigs returns the tenants to test against: the entries of the
+// file named by KV_TEST_TENANTS_FILE when set, otherwise a single tenant read
+// from the standard ARM_* environment variables. Tests are skipped when no
+// subscription is configured.
+func loadTestConfigs(t *testing.T) []TestConfig {
+	t.Helper()
+
+	if path := os.Getenv(tenantsFileEnv); path != "" {
+		configs, err := loadTenantsFile(path)
+		require.NoError(t, err, "failed to load tenants from %s=%s", tenantsFileEnv, path)
+		return configs
+	}
+
+	subscriptionID := os.Getenv("ARM_SUBSCRIPTION_ID")
+	if subscriptionID == "" {
+		t.Skip("ARM_SUBSCRIPTION_ID is not set; skipping Azure integration test")
+	}
+
+	return []TestConfig{{
+		TenantID:       os.Getenv("ARM_TENANT_ID"),
+		SubscriptionID: subscriptionID,
+		AuthMode:       os.Getenv("KV_TEST_AUTH_MODE"),
+		Environment:    os.Getenv("ARM_ENVIRONMENT"),
+		Region:         envOrDefault("AZURE_TEST_REGION", defaultTestRegion),
+		RegionList:     splitList(os.Getenv("AZURE_TEST_REGIONS")),
+		ResourceGroup:  envOrDefault("AZURE_TEST_RESOURCE_GROUP", defaultTestResourceGroup),
+	}}
+}
+
+// parallelismEnv names the environment variable that sets the default number
+// of tenants MultiTenantTestRunner tests at once.
+const parallelismEnv = "KV_TEST_PARALLELISM"
+
+// planOnlyEnv names the environment variable that, when set, makes
+// MultiTenantTestRunner only init and plan each tenant instead of running
+// testFunc, so nothing is applied or destroyed.
+const planOnlyEnv = "KV_TEST_PLAN_ONLY"
+
+type runnerOptions struct {
+	maxParallelTenants int
+	junitPath          string
+	beforeTenant       func(t *testing.T, config TestConfig)
+	afterTenant        func(t *testing.T, config TestConfig)
+	planOnly           bool
+	planOptions        func(t *testing.T, config TestConfig) *terraform.Options
+	initAndPlan        func(t *testing.T, options *terraform.Options) (string, error)
+}
+
+// RunnerOption configures MultiTenantTestRunner.
+type RunnerOption func(*runnerOptions)
+
+// MaxParallelTenants bounds how many tenant subtests run at the same time.
+// Values below 1 are treated as 1.
+func MaxParallelTenants(n int) RunnerOption {
+	return func(o *runnerOptions) {
+		o.maxParallelTenants = n
+	}
+}
+
+// BeforeTenant runs setup, for example registering a resource provider, in
+// each tenant's subtest before testFunc.
+func BeforeTenant(setup func(t *testing.T, config TestConfig)) RunnerOption {
+	return func(o *runnerOptions) {
+		o.beforeTenant = setup
+	}
+}
+
+// AfterTenant runs teardown in each tenant's subtest once testFunc returns,
+// and also when it or the BeforeTenant setup fails, skips or panics.
+func AfterTenant(teardown func(t *testing.T, config TestConfig)) RunnerOption {
+	return func(o *runnerOptions) {
+		o.afterTenant = teardown
+	}
+}
+
+// PlanOnlyOptions gives the Terraform options MultiTenantTestRunner inits and
+// plans for each tenant when KV_TEST_PLAN_ONLY is set. Tests without it are
+// skipped in plan-only mode.
+func PlanOnlyOptions(build func(t *testing.T, config TestConfig) *terraform.Options) RunnerOption {
+	return func(o *runnerOptions) {
+		o.planOptions = build
+	}
+}
+
+// MultiTenantTestRunner runs testFunc once per configured tenant as a subtest,
+// each with its own UniqueID. A tenant whose config fails ValidateTestConfig
+// fails without running testFunc. Tenants run one at a time unless
+// MaxParallelTenants or KV_TEST_PARALLELISM allows more. When
+// KV_TEST_JUNIT_OUT or JUnitReport names a file, every tenant is recorded in
+// it as a JUnit testcase. BeforeTenant and AfterTenant add per-tenant setup
+// and teardown around testFunc. When KV_TEST_PLAN_ONLY is set, testFunc is
+// not run: each tenant inits and plans the PlanOnlyOptions configuration
+// instead and fails if the plan does, catching tenant-specific provider and
+// auth problems without deploying anything.
+func MultiTenantTestRunner(t *testing.T, testFunc func(t *testing.T, config TestConfig), opts ...RunnerOption) {
+	t.Helper()
+
+	options := runnerOptions{
+		maxParallelTenants: defaultParallelism(t),
+		junitPath:          os.Getenv(junitOutEnv),
+		planOnly:           os.Getenv(planOnlyEnv) != "",
+		initAndPlan: func(t *testing.T, options *terraform.Options) (string, error) {
+			return terraform.InitAndPlanE(t, options)
+		},
+	}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	if options.maxParallelTenants < 1 {
+		options.maxParallelTenants = 1
+	}
+	if options.planOnly && options.planOptions == nil {
+		t.Skipf("%s is set and this test has no PlanOnlyOptions; skipping", planOnlyEnv)
+	}
+
+	configs := loadTestConfigs(t)
+	assignUniqueIDs(configs)
+
+	var report *junitReport
+	if options.junitPath != "" {
+		report = junitReportFor(options.junitPath)
+	}
+	suite := t.Name()
+
+	sem := make(chan struct{}, options.maxParallelTenants)
+	for _, config := range configs {
+		config := config
+
+		name := config.Name
+		if name == "" {
+			name = fmt.Sprintf("tenant=%s", config.TenantID)
+		}
+		t.Run(name, func(t *testing.T) {
+			if options.maxParallelTenants > 1 {
+				t.Parallel()
+			}
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			if report != nil {
+				start := time.Now()
+				defer func() {
+					report.recordTenant(t, suite, name, start, recover())
+				}()
+			}
+			if err := ValidateTestConfig(config); err != nil {
+				t.Fatal(err)
+			}
+			if options.afterTenant != nil {
+				defer options.afterTenant(t, config)
+			}
+			if options.beforeTenant != nil {
+				options.beforeTenant(t, config)
+			}
+			if options.planOnly {
+				_, err := options.initAndPlan(t, options.planOptions(t, config))
+				require.NoError(t, err, "terraform plan failed for tenant %s", name)
+				return
+			}
+			testFunc(t, config)
+		})
+	}
+}
+
+// assignUniqueIDs gives every config a UniqueID no other config in the run
+// shares, so tenants running concurrently never collide on resource names.
+func assignUniqueIDs(configs []TestConfig) {
+	seen := make(map[string]bool, len(configs))
+	for i := range configs {
+		for {
+			id := configs[i].UniqueIDPrefix + strings.ToLower(random.UniqueId())
+			if !seen[id] {
+				seen[id] = true
+				configs[i].UniqueID = id
+				break
+			}
+		}
+	}
+}
+
+func defaultParallelism(t *testing.T) int {
+	t.Helper()
+
+	value := os.Getenv(parallelismEnv)
+	if value == "" {
+		return 1
+	}
+	n, err := strconv.Atoi(value)
+	require.NoError(t, err, "%s must be an integer, got %q", parallelismEnv, value)
+	return n
+}
+
+// regionUnavailableCodes are the ARM error codes meaning a region cannot take
+// the deployment right now or at all, so the next region in
+// TestConfig.RegionList is worth trying. Auth, validation and naming errors
+// are not in the list and fail immediately.
+var regionUnavailableCodes = map[string]bool{
+	"SkuNotAvailable":                       true,
+	"LocationNotAvailableForResourceGroup":  true,
+	"LocationNotAvailableForResourceType":   true,
+	"RegionDoesNotAllowProvisioning":        true,
+	"AllocationFailed":                      true,
+	"ZonalAllocationFailed":                 true,
+	"OverconstrainedAllocationRequest":      true,
+	"ResourceTypeNotSupportedInTheLocation": true,
+}
+
+// resourceGroups is the subset of the ARM resource groups API used to set up
+// the run's resource group.
+type resourceGroups interface {
+	// get returns the resource group with the given name, or nil when there
+	// is none.
+	get(ctx context.Context, name string) (*armresources.ResourceGroup, error)
+	create(ctx context.Context, name string, region string) error
+	delete(ctx context.Context, name string) error
+}
+
+type armResourceGroups struct {
+	client *armresources.ResourceGroupsClient
+}
+
+func (a armResourceGroups) get(ctx context.Context, name string) (*armresources.ResourceGroup, error) {
+	resp, err := a.client.Get(ctx, name, nil)
+	var respErr *azcore.ResponseError
+	if errors.As(err, &respErr) && respErr.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &resp.ResourceGroup, nil
+}
+
+func (a armResourceGroups) create(ctx context.Context, name string, region string) error {
+	_, err := a.client.CreateOrUpdate(ctx, name, armresources.ResourceGroup{
+		Location: to.Ptr(region),
+		Tags: map[string]*string{
+			purposeTag:   to.Ptr(testResourceGroupPurpose),
+			managedByTag: to.Ptr(testResourceGroupManagedBy),
+			createdAtTag: to.Ptr(time.Now().UTC().Format(time.RFC3339)),
+		},
+	}, nil)
+	return err
+}
+
+func (a armResourceGroups) delete(ctx context.Context, name string) error {
+	poller, err := a.client.BeginDelete(ctx, name, nil)
+	if err != nil {
+		return err
+	}
+	_, err = poller.PollUntilDone(ctx, nil)
+	return err
+}
+
+// cleanupT is the subset of *testing.T used to register teardown.
+type cleanupT interface {
+	Cleanup(func())
+	Logf(format string, args ...interface{})
+}
+
+// CreateResourceGroup creates the run's resource group and deletes it, with
+// everything still inside, when the test finishes. With a RegionList the
+// regions are tried in order and config.Region is set to the one used. A
+// group that already exists, for example one shared by the subscription's
+// users, is reused as is: config.Region is set to its location and it is not
+// deleted afterwards. The Microsoft.KeyVault resource provider is registered
+// first if the subscription does not have it yet.
+func CreateResourceGroup(t *testing.T, config *TestConfig) {
+	t.Helper()
+
+	EnsureProviderRegistered(t, *config, "Microsoft.KeyVault")
+
+	client, err := armresources.NewResourceGroupsClient(config.SubscriptionID, azureCredential(t), armClientOptions())
+	require.NoError(t, err)
+	err = createResourceGroup(context.Background(), t, armResourceGroups{client}, config)
+	require.NoError(t, err, "failed to create resource group %s", config.ResourceGroupName())
+}
+
+func createResourceGroup(ctx context.Context, t cleanupT, groups resourceGroups, config *TestConfig) error {
+	name := config.ResourceGroupName()
+	existing, err := groups.get(ctx, name)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		if existing.Location != nil {
+			config.Region = *existing.Location
+		}
+		t.Logf("reusing existing resource group %s in %s", name, config.Region)
+		return nil
+	}
+
+	regions := config.RegionList
+	if len(regions) == 0 {
+		regions = []string{config.Region}
+	}
+	region, err := firstAvailableRegion(regions, func(region string) error {
+		return groups.create(ctx, name, region)
+	})
+	if err != nil {
+		return err
+	}
+	if region != config.Region {
+		t.Logf("using region %s for resource group %s", region, name)
+	}
+	config.Region = region
+
+	t.Cleanup(func() {
+		if err := groups.delete(context.Background(), name); err != nil {
+			t.Logf("failed to delete resource group %s: %v", name, err)
+		}
+	})
+	return nil
+}
+
+// firstAvailableRegion calls create for each region in order and returns the
+// first region it succeeds in. Only regionUnavailableCodes move on to the next
+// region; any other error is returned as is.
+func firstAvailableRegion(regions []string, create func(region string) error) (string, error) {
+	var unavailable []string
+	for _, region := range regions {
+		err := create(region)
+		if err == nil {
+			return region, nil
+		}
+		var respErr *azcore.ResponseError
+		if !errors.As(err, &respErr) || !regionUnavailableCodes[respErr.ErrorCode] {
+			return "", fmt.Errorf("region %s: %w", region, err)
+		}
+		unavailable = append(unavailable, fmt.Sprintf("%s (%s)", region, respErr.ErrorCode))
+	}
+	return "", fmt.Errorf("no region available; tried %s", strings.Join(unavailable, ", "))
+}
+
+func envOrDefault(key string, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// splitList splits a comma-separated list, dropping blank entries.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
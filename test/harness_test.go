@@ -0,0 +1,198 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFirstAvailableRegion(t *testing.T) {
+	t.Parallel()
+
+	t.Run("falls back when a region lacks capacity", func(t *testing.T) {
+		var tried []string
+		region, err := firstAvailableRegion([]string{"westeurope", "northeurope", "swedencentral"}, func(region string) error {
+			tried = append(tried, region)
+			if region == "westeurope" {
+				return &azcore.ResponseError{StatusCode: http.StatusConflict, ErrorCode: "SkuNotAvailable"}
+			}
+			return nil
+		})
+		require.NoError(t, err)
+		assert.Equal(t, "northeurope", region)
+		assert.Equal(t, []string{"westeurope", "northeurope"}, tried)
+	})
+
+	t.Run("does not fall back on other errors", func(t *testing.T) {
+		var tried []string
+		_, err := firstAvailableRegion([]string{"westeurope", "northeurope"}, func(region string) error {
+			tried = append(tried, region)
+			return &azcore.ResponseError{StatusCode: http.StatusForbidden, ErrorCode: "AuthorizationFailed"}
+		})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "region westeurope")
+		assert.Equal(t, []string{"westeurope"}, tried)
+
+		_, err = firstAvailableRegion([]string{"westeurope", "northeurope"}, func(region string) error {
+			return errors.New("connection refused")
+		})
+		assert.Contains(t, err.Error(), "connection refused")
+	})
+
+	t.Run("reports every unavailable region", func(t *testing.T) {
+		_, err := firstAvailableRegion([]string{"westeurope", "northeurope"}, func(region string) error {
+			return &azcore.ResponseError{StatusCode: http.StatusConflict, ErrorCode: "LocationNotAvailableForResourceGroup"}
+		})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "westeurope (LocationNotAvailableForResourceGroup), northeurope (LocationNotAvailableForResourceGroup)")
+	})
+}
+
+// fakeResourceGroups keeps resource groups in memory by lowercased name, as
+// ARM treats group names case-insensitively.
+type fakeResourceGroups struct {
+	groups  map[string]string
+	creates int
+	deletes int
+}
+
+func (f *fakeResourceGroups) get(ctx context.Context, name string) (*armresources.ResourceGroup, error) {
+	location, ok := f.groups[strings.ToLower(name)]
+	if !ok {
+		return nil, nil
+	}
+	return &armresources.ResourceGroup{Name: to.Ptr(name), Location: to.Ptr(location)}, nil
+}
+
+func (f *fakeResourceGroups) create(ctx context.Context, name string, region string) error {
+	if _, ok := f.groups[strings.ToLower(name)]; ok {
+		return &azcore.ResponseError{StatusCode: http.StatusConflict, ErrorCode: "ResourceGroupExists"}
+	}
+	f.creates++
+	f.groups[strings.ToLower(name)] = region
+	return nil
+}
+
+func (f *fakeResourceGroups) delete(ctx context.Context, name string) error {
+	f.deletes++
+	delete(f.groups, strings.ToLower(name))
+	return nil
+}
+
+func TestCreateResourceGroupIsIdempotent(t *testing.T) {
+	t.Parallel()
+
+	groups := &fakeResourceGroups{groups: map[string]string{}}
+	config := TestConfig{Region: "westeurope", ResourceGroup: "rg-kv-test", UniqueID: "abc123"}
+	fake := &fakeTestT{}
+
+	require.NoError(t, createResourceGroup(context.Background(), fake, groups, &config))
+	require.NoError(t, createResourceGroup(context.Background(), fake, groups, &config), "a second call should reuse the group")
+	assert.Equal(t, 1, groups.creates)
+	assert.Len(t, fake.cleanups, 1, "only the call that created the group should delete it")
+	assert.Contains(t, fake.logs, "reusing existing resource group rg-kv-test-abc123 in westeurope")
+
+	fake.finish()
+	assert.Equal(t, 1, groups.deletes)
+}
+
+func TestCreateResourceGroupKeepsPreexistingGroup(t *testing.T) {
+	t.Parallel()
+
+	groups := &fakeResourceGroups{groups: map[string]string{"rg-shared-abc123": "northeurope"}}
+	config := TestConfig{Region: "westeurope", ResourceGroup: "rg-shared", UniqueID: "abc123"}
+	fake := &fakeTestT{}
+
+	require.NoError(t, createResourceGroup(context.Background(), fake, groups, &config))
+	assert.Equal(t, "northeurope", config.Region, "the region should follow the existing group")
+	fake.finish()
+	assert.Zero(t, groups.creates)
+	assert.Zero(t, groups.deletes, "a pre-existing group must not be deleted")
+}
+
+func TestTestConfigClone(t *testing.T) {
+	t.Parallel()
+
+	original := TestConfig{Name: "primary", Region: "westeurope", RegionList: []string{"westeurope", "northeurope"}, ResourceGroup: "rg-kv-test", UniqueID: "abc123"}
+	clone := original.Clone("def456")
+
+	assert.Equal(t, "rg-kv-test-def456", clone.ResourceGroupName())
+	assert.Equal(t, "rg-kv-test-abc123", original.ResourceGroupName())
+	assert.Equal(t, "primary", clone.Name)
+
+	clone.RegionList[0] = "swedencentral"
+	clone.Region = "swedencentral"
+	assert.Equal(t, []string{"westeurope", "northeurope"}, original.RegionList)
+	assert.Equal(t, "westeurope", original.Region)
+
+	assert.Nil(t, TestConfig{}.Clone("x").RegionList)
+}
+
+func TestSplitList(t *testing.T) {
+	t.Parallel()
+
+	assert.Nil(t, splitList(""))
+	assert.Equal(t, []string{"westeurope", "northeurope"}, splitList(" westeurope, ,northeurope "))
+}
+
+func TestValidateTestConfig(t *testing.T) {
+	t.Parallel()
+
+	valid := TestConfig{
+		Name:           "corp",
+		TenantID:       "00000000-0000-0000-0000-000000000001",
+		SubscriptionID: "00000000-0000-0000-0001-000000000001",
+		ClientID:       "00000000-0000-0000-0002-000000000001",
+		Region:         "westeurope",
+		ResourceGroup:  "rg-kv-test",
+	}
+	require.NoError(t, ValidateTestConfig(valid))
+
+	displayName := valid
+	displayName.Region = "West Europe"
+	assert.NoError(t, ValidateTestConfig(displayName), "region display names are accepted by ARM")
+
+	testCases := map[string]struct {
+		modify        func(c *TestConfig)
+		expectedError string
+	}{
+		"missing tenant":                  {func(c *TestConfig) { c.TenantID = "" }, "tenant_id is empty"},
+		"invalid subscription":            {func(c *TestConfig) { c.SubscriptionID = "sub-1" }, `subscription_id "sub-1" is not a GUID`},
+		"invalid client":                  {func(c *TestConfig) { c.ClientID = "app" }, `client_id "app" is not a GUID`},
+		"missing region":                  {func(c *TestConfig) { c.Region = " " }, "region is empty"},
+		"invalid region":                  {func(c *TestConfig) { c.Region = "west-europe" }, `region "west-europe" is not an Azure region name`},
+		"missing resource group":          {func(c *TestConfig) { c.ResourceGroup = "" }, "resource_group is empty"},
+		"invalid resource group":          {func(c *TestConfig) { c.ResourceGroup = "rg/kv" }, `resource_group "rg/kv" may only hold`},
+		"resource group ends with period": {func(c *TestConfig) { c.ResourceGroup = "rg-kv." }, "must not end with '.'"},
+		"resource group too long":         {func(c *TestConfig) { c.ResourceGroup = strings.Repeat("r", 71) }, "is longer than 70 characters"},
+	}
+
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			config := valid
+			tc.modify(&config)
+			err := ValidateTestConfig(config)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), `invalid test config for tenant "corp"`)
+			assert.Contains(t, err.Error(), tc.expectedError)
+		})
+	}
+}
+
+func TestValidateTestConfigReportsEveryProblem(t *testing.T) {
+	t.Parallel()
+
+	err := ValidateTestConfig(TestConfig{Region: "westeurope", ResourceGroup: "rg-kv-test"})
+	assert.EqualError(t, err, `invalid test config: tenant_id is empty; subscription_id is empty`)
+}
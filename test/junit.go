@@ -0,0 +1,161 @@
+package test
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"sync"
+	"testing"
+	"time"
+)
+
+// junitOutEnv names the environment variable holding the path of the JUnit
+// XML report MultiTenantTestRunner writes, one testsuite per test and one
+// testcase per tenant.
+const junitOutEnv = "KV_TEST_JUNIT_OUT"
+
+// JUnitReport makes MultiTenantTestRunner record each tenant in the JUnit XML
+// report at path, overriding KV_TEST_JUNIT_OUT. An empty path disables it.
+func JUnitReport(path string) RunnerOption {
+	return func(o *runnerOptions) {
+		o.junitPath = path
+	}
+}
+
+type junitTestSuites struct {
+	XMLName xml.Name          `xml:"testsuites"`
+	Suites  []*junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Skipped  int             `xml:"skipped,attr"`
+	Time     string          `xml:"time,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+
+	elapsed time.Duration
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Skipped   *junitSkipped `xml:"skipped,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Body    string `xml:",chardata"`
+}
+
+type junitSkipped struct{}
+
+// junitReport accumulates the testsuites of every runner writing to one path
+// and rewrites the file after each tenant, so the report is complete up to the
+// last tenant even if the test binary dies.
+type junitReport struct {
+	mu     sync.Mutex
+	path   string
+	suites []*junitTestSuite
+}
+
+var (
+	junitReportsMu sync.Mutex
+	junitReports   = map[string]*junitReport{}
+)
+
+func junitReportFor(path string) *junitReport {
+	junitReportsMu.Lock()
+	defer junitReportsMu.Unlock()
+
+	report, ok := junitReports[path]
+	if !ok {
+		report = &junitReport{path: path}
+		junitReports[path] = report
+	}
+	return report
+}
+
+// recordTenant adds the outcome of a tenant subtest and flushes the report.
+// It is deferred by the subtest with the subtest's recover() value: a panic is
+// recorded as a failure and re-raised after the report is written.
+func (r *junitReport) recordTenant(t *testing.T, suite string, tenant string, start time.Time, panicValue interface{}) {
+	elapsed := time.Since(start)
+	testCase := junitTestCase{
+		Name:      tenant,
+		Classname: suite,
+		Time:      formatSeconds(elapsed),
+	}
+	switch {
+	case panicValue != nil:
+		testCase.Failure = &junitFailure{Message: fmt.Sprintf("panic: %v", panicValue), Type: "panic", Body: string(debug.Stack())}
+	case t.Failed():
+		testCase.Failure = &junitFailure{Message: fmt.Sprintf("tenant %s failed; see the go test output of %s", tenant, t.Name()), Type: "failure"}
+	case t.Skipped():
+		testCase.Skipped = &junitSkipped{}
+	}
+
+	if err := r.add(suite, testCase, elapsed); err != nil {
+		t.Errorf("failed to write JUnit report %s: %v", r.path, err)
+	}
+	if panicValue != nil {
+		panic(panicValue)
+	}
+}
+
+func (r *junitReport) add(suiteName string, testCase junitTestCase, elapsed time.Duration) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var suite *junitTestSuite
+	for _, s := range r.suites {
+		if s.Name == suiteName {
+			suite = s
+		}
+	}
+	if suite == nil {
+		suite = &junitTestSuite{Name: suiteName}
+		r.suites = append(r.suites, suite)
+	}
+
+	suite.Cases = append(suite.Cases, testCase)
+	suite.Tests++
+	if testCase.Failure != nil {
+		suite.Failures++
+	}
+	if testCase.Skipped != nil {
+		suite.Skipped++
+	}
+	suite.elapsed += elapsed
+	suite.Time = formatSeconds(suite.elapsed)
+
+	return r.write()
+}
+
+// write replaces the report file atomically so readers never see a partial
+// document.
+func (r *junitReport) write() error {
+	data, err := xml.MarshalIndent(junitTestSuites{Suites: r.suites}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(r.path), 0o755); err != nil {
+		return err
+	}
+
+	tmp := r.path + ".tmp"
+	if err := os.WriteFile(tmp, append([]byte(xml.Header), data...), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, r.path)
+}
+
+func formatSeconds(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
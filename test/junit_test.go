@@ -0,0 +1,78 @@
+package test
+
+import (
+	"encoding/xml"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const junitHelperEnv = "KV_TEST_JUNIT_HELPER"
+
+// TestJUnitReportHelperProcess is run by TestMultiTenantTestRunnerJUnitReport
+// in a separate test binary, since its tenants fail and panic on purpose.
+func TestJUnitReportHelperProcess(t *testing.T) {
+	if os.Getenv(junitHelperEnv) == "" {
+		t.Skip("only runs as a helper process")
+	}
+
+	MultiTenantTestRunner(t, func(t *testing.T, config TestConfig) {
+		switch config.Name {
+		case "failing":
+			t.Error("tenant assertion failed")
+		case "panicking":
+			panic("tenant blew up")
+		}
+	}, MaxParallelTenants(1))
+}
+
+func TestMultiTenantTestRunnerJUnitReport(t *testing.T) {
+	reportPath := filepath.Join(t.TempDir(), "reports", "junit.xml")
+	tenantsFile := writeTenantsFile(t, "tenants.json", `[
+		{"name": "passing", "tenant_id": "00000000-0000-0000-0000-000000000001", "subscription_id": "00000000-0000-0000-0001-000000000001", "region": "westeurope"},
+		{"name": "failing", "tenant_id": "00000000-0000-0000-0000-000000000002", "subscription_id": "00000000-0000-0000-0001-000000000002", "region": "westeurope"},
+		{"name": "panicking", "tenant_id": "00000000-0000-0000-0000-000000000003", "subscription_id": "00000000-0000-0000-0001-000000000003", "region": "westeurope"}
+	]`)
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestJUnitReportHelperProcess$")
+	cmd.Env = append(os.Environ(),
+		junitHelperEnv+"=1",
+		tenantsFileEnv+"="+tenantsFile,
+		junitOutEnv+"="+reportPath,
+	)
+	output, err := cmd.CombinedOutput()
+	require.Error(t, err, "helper process should fail:\n%s", output)
+
+	data, err := os.ReadFile(reportPath)
+	require.NoError(t, err, "report should be written even though a tenant panicked:\n%s", output)
+
+	var report junitTestSuites
+	require.NoError(t, xml.Unmarshal(data, &report))
+	require.Len(t, report.Suites, 1)
+
+	suite := report.Suites[0]
+	assert.Equal(t, "TestJUnitReportHelperProcess", suite.Name)
+	assert.Equal(t, 3, suite.Tests)
+	assert.Equal(t, 2, suite.Failures)
+	require.Len(t, suite.Cases, 3)
+
+	cases := map[string]junitTestCase{}
+	for _, c := range suite.Cases {
+		cases[c.Name] = c
+		assert.Equal(t, "TestJUnitReportHelperProcess", c.Classname)
+		_, err := strconv.ParseFloat(c.Time, 64)
+		assert.NoError(t, err, "testcase %s should record its duration in seconds", c.Name)
+	}
+
+	assert.Nil(t, cases["passing"].Failure)
+	require.NotNil(t, cases["failing"].Failure)
+	assert.Equal(t, "failure", cases["failing"].Failure.Type)
+	require.NotNil(t, cases["panicking"].Failure)
+	assert.Equal(t, "panic", cases["panicking"].Failure.Type)
+	assert.Contains(t, cases["panicking"].Failure.Message, "tenant blew up")
+}
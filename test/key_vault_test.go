@@ -1,13 +1,21 @@
 package test
 
 import (
+	"encoding/json"
 	"fmt"
+	"net/url"
+	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
 	"github.com/gruntwork-io/terratest/modules/azure"
+	"github.com/gruntwork-io/terratest/modules/random"
 	"github.com/gruntwork-io/terratest/modules/terraform"
+	test_structure "github.com/gruntwork-io/terratest/modules/test-structure"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestAzureKeyVaultModule(t *testing.T) {
@@ -15,33 +23,26 @@ func TestAzureKeyVaultModule(t *testing.T) {
 
 	MultiTenantTestRunner(t, func(t *testing.T, config TestConfig) {
 		SetupAzureAuth(t, config)
-		CreateResourceGroup(t, config)
-		
-		uniqueID := config.UniqueID
-		expectedKeyVaultName := fmt.Sprintf("kv-test-%s", uniqueID)
-		
-		terraformDir := filepath.Join("..", "..", "modules", "azure-key-vault-module")
-		
-		terraformOptions := terraform.WithDefaultRetryableErrors(t, &terraform.Options{
-			TerraformDir: terraformDir,
-			Vars: map[string]interface{}{
-				"key_vault_name":                      expectedKeyVaultName,
-				"location":                           config.Region,
-				"resource_group_name":                fmt.Sprintf("%s-%s", config.ResourceGroup, uniqueID),
-				"tenant_id":                          config.TenantID,
-				"sku_name":                           "standard",
-				"enabled_for_disk_encryption":        true,
-				"enabled_for_deployment":             true,
-				"enabled_for_template_deployment":    true,
-				"purge_protection_enabled":           true,
-				"soft_delete_retention_days":         90,
-				"public_network_access_enabled":      false,
-			},
-			EnvVars: map[string]string{
-				"ARM_SUBSCRIPTION_ID": config.SubscriptionID,
-				"ARM_TENANT_ID":      config.TenantID,
-			},
-		})
+		CreateResourceGroup(t, &config)
+
+		expectedKeyVaultName := PurgeSoftDeletedVault(t, config, fmt.Sprintf("kv-test-%s", config.UniqueID))
+
+		vars := baseModuleVars(config, expectedKeyVaultName)
+		vars["key_vault_name"] = expectedKeyVaultName
+		vars["resource_group_name"] = config.ResourceGroupName()
+		vars["sku_name"] = "standard"
+		vars["enabled_for_disk_encryption"] = true
+		vars["enabled_for_deployment"] = true
+		vars["enabled_for_template_deployment"] = true
+		vars["purge_protection_enabled"] = true
+		vars["soft_delete_retention_days"] = 90
+		vars["public_network_access_enabled"] = false
+		vars["network_acls_default_action"] = "Deny"
+		if config.TenantID != "" {
+			vars["tenant_id"] = config.TenantID
+		}
+
+		terraformOptions := BuildTerraformOptions(t, config, vars)
 
 		defer terraform.Destroy(t, terraformOptions)
 		terraform.InitAndApply(t, terraformOptions)
@@ -50,25 +51,691 @@ func TestAzureKeyVaultModule(t *testing.T) {
 		keyVaultName := terraform.Output(t, terraformOptions, "key_vault_name")
 		assert.Equal(t, expectedKeyVaultName, keyVaultName)
 
+		// Validate the data-plane URI points at this vault
+		vaultURI := terraform.Output(t, terraformOptions, "key_vault_uri")
+		parsedURI, err := url.Parse(vaultURI)
+		require.NoError(t, err, "key_vault_uri %q is not a valid URL", vaultURI)
+		assert.Equal(t, "https", parsedURI.Scheme)
+		assert.Equal(t, "/", parsedURI.Path)
+		assert.Equal(t, keyVaultURI(t, keyVaultName), vaultURI)
+
+		// Validate tenant
+		if config.TenantID != "" {
+			assert.Equal(t, config.TenantID, terraform.Output(t, terraformOptions, "key_vault_tenant_id"))
+		} else {
+			assert.NotEmpty(t, terraform.Output(t, terraformOptions, "key_vault_tenant_id"))
+		}
+
 		// Validate purge protection
-		keyVault := azure.GetKeyVault(t, fmt.Sprintf("%s-%s", config.ResourceGroup, uniqueID), keyVaultName, config.SubscriptionID)
-		assert.True(t, *keyVault.Properties.EnablePurgeProtection)
+		keyVault := getDeployedVault(t, terraformOptions)
+		require.NotNil(t, keyVault.Properties)
+		assert.True(t, isTrue(keyVault.Properties.EnablePurgeProtection))
+
+		// Validate resource ID (ARM may return a different casing)
+		keyVaultID := terraform.Output(t, terraformOptions, "key_vault_id")
+		assert.True(t, strings.EqualFold(stringValue(keyVault.ID), keyVaultID), "key_vault_id %s does not match %s", keyVaultID, stringValue(keyVault.ID))
 
 		// Validate soft delete
-		assert.True(t, *keyVault.Properties.EnableSoftDelete)
-		assert.Equal(t, int32(90), *keyVault.Properties.SoftDeleteRetentionInDays)
+		assert.True(t, isTrue(keyVault.Properties.EnableSoftDelete))
+		assert.Equal(t, to.Ptr(int32(90)), keyVault.Properties.SoftDeleteRetentionInDays)
 
-		// Validate public network access is disabled
-		assert.Equal(t, "Disabled", string(keyVault.Properties.PublicNetworkAccess))
+		// Validate the vault is unreachable from public networks; the module
+		// keeps the default AzureServices bypass
+		ValidateNoPublicAccess(t, keyVault, true)
 
 		// Validate RBAC is enabled
-		assert.True(t, *keyVault.Properties.EnableRbacAuthorization)
+		assert.True(t, isTrue(keyVault.Properties.EnableRbacAuthorization))
 
 		// Security compliance validation
-		ValidateSecurityCompliance(t, terraformOptions)
-		
-		// Validate network ACLs
-		assert.NotNil(t, keyVault.Properties.NetworkAcls)
-		assert.Equal(t, "Deny", string(keyVault.Properties.NetworkAcls.DefaultAction))
+		ValidateSecurityCompliance(t, terraformOptions, ComplianceModeEnforce)
+	})
+}
+
+// baseModuleVars returns the minimal module inputs for a standalone vault in
+// the tenant's test resource group. Features that need pre-existing
+// infrastructure (private endpoint, diagnostics, policy) are switched off so
+// feature tests only opt in to what they exercise.
+func baseModuleVars(config TestConfig, vaultName string) map[string]interface{} {
+	return map[string]interface{}{
+		"custom_name":                   vaultName,
+		"location":                      config.Region,
+		"location_short":                "test",
+		"environment":                   "test",
+		"resource_group_name":           fmt.Sprintf("%s-%s", config.ResourceGroup, config.UniqueID),
+		"purge_protection_enabled":      false,
+		"public_network_access_enabled": true,
+		"network_acls_default_action":   "Allow",
+		"enable_private_endpoint":       false,
+		"enable_diagnostic_settings":    false,
+		"enable_resource_lock":          false,
+		"enable_policy_assignments":     false,
+		"enable_policy_initiative":      false,
+		"cloud_environment":             moduleCloudEnvironment(config),
+	}
+}
+
+// moduleCloudEnvironment returns the module's cloud_environment for the cloud
+// config deploys to.
+func moduleCloudEnvironment(config TestConfig) string {
+	if config.Environment == "" {
+		return EnvironmentPublic
+	}
+	return strings.ToLower(config.Environment)
+}
+
+// planFixture returns options for planning a fresh copy of the module with
+// vars against config's tenant. The plan is written to a temp file so callers
+// can read it back with terraform.ShowWithStruct.
+func planFixture(t *testing.T, config TestConfig, vars map[string]interface{}) *terraform.Options {
+	return &terraform.Options{
+		TerraformDir:    test_structure.CopyTerraformFolderToTemp(t, "..", "."),
+		TerraformBinary: TerraformBinary(),
+		Vars:            vars,
+		EnvVars:         TerraformEnvVars(config),
+		NoColor:         true,
+		PlanFilePath:    filepath.Join(t.TempDir(), "plan.out"),
+	}
+}
+
+// planCase is one plan-only variant of the module. Its vars are layered over
+// baseModuleVars after the unset inputs are removed. A case with
+// expectedError must fail to plan with that message; otherwise the plan must
+// succeed, warning must appear in its output when warned is true and be
+// absent when it is false, and check, if set, inspects the plan.
+type planCase struct {
+	name          string
+	vars          map[string]interface{}
+	unset         []string
+	expectedError string
+	warning       string
+	warned        bool
+	check         func(t *testing.T, plan *terraform.PlanStruct)
+}
+
+// runPlanCases plans each case as a subtest for a vault named after prefix,
+// creating its resource group in the plan since nothing is deployed.
+func runPlanCases(t *testing.T, config TestConfig, prefix string, cases []planCase) {
+	t.Helper()
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			vars := baseModuleVars(config, fmt.Sprintf("%s-%s", prefix, config.UniqueID))
+			vars["create_resource_group"] = true
+			for _, name := range tc.unset {
+				delete(vars, name)
+			}
+			for k, v := range tc.vars {
+				vars[k] = v
+			}
+
+			terraformOptions := planFixture(t, config, vars)
+			output, err := terraform.InitAndPlanE(t, terraformOptions)
+			if tc.expectedError != "" {
+				require.Error(t, err, "plan should fail with %q", tc.expectedError)
+				assert.Contains(t, flattenDiagnostics(err.Error()), tc.expectedError)
+				return
+			}
+			require.NoError(t, err)
+
+			if tc.warning != "" {
+				if tc.warned {
+					assert.Contains(t, flattenDiagnostics(output), tc.warning)
+				} else {
+					assert.NotContains(t, flattenDiagnostics(output), tc.warning)
+				}
+			}
+			if tc.check != nil {
+				tc.check(t, terraform.ShowWithStruct(t, terraformOptions))
+			}
+		})
+	}
+}
+
+// plannedVaultAttribute returns a planCase check that the plan creates the
+// vault with attribute set to expected.
+func plannedVaultAttribute(attribute string, expected interface{}) func(t *testing.T, plan *terraform.PlanStruct) {
+	return func(t *testing.T, plan *terraform.PlanStruct) {
+		vault, ok := plan.ResourcePlannedValuesMap["azurerm_key_vault.this[0]"]
+		require.True(t, ok, "plan should create the Key Vault")
+		assert.Equal(t, expected, vault.AttributeValues[attribute])
+	}
+}
+
+func TestKeyVaultInputValidation(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name          string
+		vars          map[string]interface{}
+		expectedError string
+	}{
+		{"name too short", map[string]interface{}{"key_vault_name": "kv"}, "Key Vault name must be 3-24 characters"},
+		{"name too long", map[string]interface{}{"key_vault_name": "kv-this-name-is-far-too-long"}, "Key Vault name must be 3-24 characters"},
+		{"name starts with digit", map[string]interface{}{"key_vault_name": "1kv-test"}, "Key Vault name must be 3-24 characters"},
+		{"name with underscore", map[string]interface{}{"key_vault_name": "kv_test"}, "Key Vault name must be 3-24 characters"},
+		{"name with consecutive hyphens", map[string]interface{}{"key_vault_name": "kv--test"}, "must not contain consecutive hyphens"},
+		{"invalid sku", map[string]interface{}{"sku_name": "Standard"}, "SKU name must be either 'standard' or 'premium'"},
+		{"invalid ip rule", map[string]interface{}{"network_acls_ip_rules": []string{"203.0.113.300"}}, "must be an IPv4 or IPv6 address or CIDR range"},
+		{"ip rule with host bits", map[string]interface{}{"network_acls_ip_rules": []string{"203.0.113.5/24"}}, "without host bits set"},
+		{"ipv6 rule with host bits", map[string]interface{}{"network_acls_ip_rules": []string{"2001:db8::1/48"}}, "without host bits set"},
+		{"invalid timeout", map[string]interface{}{"timeouts": map[string]interface{}{"create": "30 minutes"}}, "Each timeout must be a duration"},
+		{"user-assigned identity without ids", map[string]interface{}{"identity": map[string]interface{}{"type": "UserAssigned"}}, "identity_ids must list the user-assigned identities"},
+		{"key material with key size", map[string]interface{}{"keys": map[string]interface{}{"imported": map[string]interface{}{"name": "imported", "key_type": "RSA", "key_size": 2048, "key_opts": []string{}, "key_material": map[string]interface{}{"contents": "MIIC"}}}}, "take their size and curve from the material"},
+		{"secret not before malformed", map[string]interface{}{"secrets": map[string]interface{}{"app": map[string]interface{}{"value": "x", "not_before_date": "next monday"}}}, "Secrets not_before_date and expiration_date must be RFC 3339 timestamps"},
+		{"secret not before after expiry", map[string]interface{}{"secrets": map[string]interface{}{"app": map[string]interface{}{"value": "x", "not_before_date": "2031-01-01T00:00:00Z", "expiration_date": "2030-01-01T00:00:00Z"}}}, "Secrets not_before_date must be earlier than expiration_date"},
+		{"disabled imported key", map[string]interface{}{"keys": map[string]interface{}{"imported": map[string]interface{}{"name": "imported", "key_type": "RSA", "key_opts": []string{}, "key_material": map[string]interface{}{"contents": "MIIC"}, "enabled": false}}}, "Keys imported from key_material cannot set enabled = false"},
+		{"unknown purpose", map[string]interface{}{"purpose": "logging"}, "purpose must be 'certificates', 'encryption' or 'secrets'"},
+		{"invalid break glass object id", map[string]interface{}{"break_glass_object_id": "break-glass-admin"}, "break_glass_object_id must be an object ID (GUID)."},
+		{"action group short name too long", map[string]interface{}{"action_group": map[string]interface{}{"short_name": "keyvaultalerts", "email_receivers": map[string]interface{}{"oncall": map[string]interface{}{"email_address": "oncall@example.com"}}}}, "action_group.short_name must be at most 12 characters."},
+		{"action group without receivers", map[string]interface{}{"action_group": map[string]interface{}{}}, "action_group needs at least one email or webhook receiver."},
+		{"action group plaintext webhook", map[string]interface{}{"action_group": map[string]interface{}{"webhook_receivers": map[string]interface{}{"pager": map[string]interface{}{"service_uri": "http://pager.example.com/hook"}}}}, "action_group webhook receivers must use an https:// service_uri."},
+		{"unsupported bypass", map[string]interface{}{"network_acls_bypass": "Everything"}, "Network ACLs bypass must be 'None' or 'AzureServices'"},
+		{"unsupported vaults bypass", map[string]interface{}{"vaults": map[string]interface{}{"a": map[string]interface{}{"name": "kv-a", "network_acls": map[string]interface{}{"bypass": "Logging"}}}}, "vaults network_acls bypass must be 'None' or 'AzureServices'"},
+		{"unsupported key type", map[string]interface{}{"keys": map[string]interface{}{"oct": map[string]interface{}{"name": "oct", "key_type": "oct-HSM", "key_opts": []string{}}}}, "Keys key_type must be RSA, RSA-HSM, EC or EC-HSM"},
+		{"key not before malformed", map[string]interface{}{"keys": map[string]interface{}{"app": map[string]interface{}{"name": "app", "key_type": "EC", "key_opts": []string{"sign"}, "not_before_date": "2030-01-01"}}}, "Keys not_before_date and expiration_date must be RFC 3339 timestamps"},
+		{"key not before after expiry", map[string]interface{}{"keys": map[string]interface{}{"app": map[string]interface{}{"name": "app", "key_type": "EC", "key_opts": []string{"sign"}, "not_before_date": "2031-01-01T00:00:00Z", "expiration_date": "2030-01-01T00:00:00Z"}}}, "Keys not_before_date must be earlier than expiration_date"},
+		{"retention too long", map[string]interface{}{"soft_delete_retention_days": 365}, "Soft delete retention days must be a whole number between 7 and 90"},
+		{"invalid managed storage account key", map[string]interface{}{"managed_storage_accounts": map[string]interface{}{"logs": map[string]interface{}{"name": "logs", "storage_account_id": "/subscriptions/x", "storage_account_key": "primary"}}}, "storage_account_key must be 'key1' or 'key2'"},
+		{"invalid regeneration period", map[string]interface{}{"managed_storage_accounts": map[string]interface{}{"logs": map[string]interface{}{"name": "logs", "storage_account_id": "/subscriptions/x", "regeneration_period": "90d"}}}, "regeneration_period must be an ISO 8601 duration"},
+		{"unknown cloud environment", map[string]interface{}{"cloud_environment": "germany"}, "cloud_environment must be one of: public, usgovernment, china"},
+		{"disk encryption key without unwrapKey", map[string]interface{}{"disk_encryption_key": map[string]interface{}{"key_opts": []string{"wrapKey"}}}, "must allow the wrapKey and unwrapKey operations"},
+		{"invalid diagnostic storage name", map[string]interface{}{"diagnostic_storage": map[string]interface{}{"name": "st-kv-diag"}}, "Diagnostic storage account name must be 3-24 lowercase letters and numbers"},
+		{"diagnostic retention too long", map[string]interface{}{"diagnostic_settings": map[string]interface{}{"diagnostic_retention_days": map[string]interface{}{"AuditEvent": 730}}}, "must be a whole number of days between 0 (keep forever) and 365"},
+		{"waiver without expiry", map[string]interface{}{"policy_exemptions": []map[string]interface{}{{"name": "legacy", "policy_assignment_id": "/subscriptions/x/providers/Microsoft.Authorization/policyAssignments/a", "exemption_category": "Waiver"}}}, "Waiver category must set expires_on"},
+		{"duplicate private DNS zone names", map[string]interface{}{"private_dns_zone_ids": []string{"/subscriptions/x/resourceGroups/hub-weu/providers/Microsoft.Network/privateDnsZones/privatelink.vaultcore.azure.net", "/subscriptions/x/resourceGroups/hub-neu/providers/Microsoft.Network/privateDnsZones/privatelink.vaultcore.azure.net"}}, "must not list two zones with the same name"},
+		{"secret with value and file", map[string]interface{}{"secrets": map[string]interface{}{"db": map[string]interface{}{"value": "inline", "value_from_file": "secrets/db.txt"}}}, "must set exactly one of value or value_from_file"},
+		{"secret file missing", map[string]interface{}{"secrets": map[string]interface{}{"db": map[string]interface{}{"value_from_file": "secrets/missing.txt"}}}, "value_from_file must name an existing file"},
+		{"certificate without lifetime action", map[string]interface{}{"certificates": map[string]interface{}{"web": map[string]interface{}{"name": "web", "lifetime_actions": []interface{}{}, "x509_certificate_properties": map[string]interface{}{"subject": "CN=web"}}}}, "must have at least one lifetime_action"},
+		{"certificate lifetime action with both triggers", map[string]interface{}{"certificates": map[string]interface{}{"web": map[string]interface{}{"name": "web", "lifetime_actions": []map[string]interface{}{{"action_type": "AutoRenew", "days_before_expiry": 30, "lifetime_percentage": 80}}, "x509_certificate_properties": map[string]interface{}{"subject": "CN=web"}}}}, "must set exactly one of days_before_expiry or lifetime_percentage"},
+		{"certificate renewal after expiry", map[string]interface{}{"certificates": map[string]interface{}{"web": map[string]interface{}{"name": "web", "lifetime_actions": []map[string]interface{}{{"action_type": "AutoRenew", "days_before_expiry": 400}}, "x509_certificate_properties": map[string]interface{}{"subject": "CN=web"}}}}, "days_before_expiry must be between 1 and 27 times validity_in_months"},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			vars := map[string]interface{}{
+				"location":            "westeurope",
+				"location_short":      "weu",
+				"environment":         "test",
+				"resource_group_name": "rg-kv-validation",
+			}
+			for k, v := range tc.vars {
+				vars[k] = v
+			}
+
+			terraformOptions := &terraform.Options{
+				TerraformDir:    test_structure.CopyTerraformFolderToTemp(t, "..", "."),
+				TerraformBinary: TerraformBinary(),
+				Vars:            vars,
+				NoColor:         true,
+			}
+
+			_, err := terraform.InitAndPlanE(t, terraformOptions)
+			require.Error(t, err, "plan should fail input validation")
+			assert.Contains(t, flattenDiagnostics(err.Error()), tc.expectedError)
+		})
+	}
+}
+
+func TestKeyVaultRejectsShortRetention(t *testing.T) {
+	t.Parallel()
+
+	terraformOptions := &terraform.Options{
+		TerraformDir:    test_structure.CopyTerraformFolderToTemp(t, "..", "."),
+		TerraformBinary: TerraformBinary(),
+		Vars: map[string]interface{}{
+			"location":                   "westeurope",
+			"location_short":             "weu",
+			"environment":                "test",
+			"resource_group_name":        "rg-kv-validation",
+			"soft_delete_retention_days": 5,
+		},
+		NoColor: true,
+	}
+
+	AssertApplyFails(t, terraformOptions, "Soft delete retention days must be a whole number between 7 and 90")
+}
+
+func TestKeyVaultDisabled(t *testing.T) {
+	t.Parallel()
+
+	MultiTenantTestRunner(t, func(t *testing.T, config TestConfig) {
+		SetupAzureAuth(t, config)
+
+		vars := baseModuleVars(config, fmt.Sprintf("kv-off-%s", config.UniqueID))
+		vars["enabled"] = false
+		vars["enable_private_endpoint"] = true
+		vars["enable_resource_lock"] = true
+
+		terraformOptions := planFixture(t, config, vars)
+
+		planOutput := terraform.InitAndPlan(t, terraformOptions)
+		resourceCount := terraform.GetResourceCount(t, planOutput)
+		assert.Equal(t, 0, resourceCount.Add, "enabled = false should not create any resources")
+		assert.Equal(t, 0, resourceCount.Change)
+		assert.Equal(t, 0, resourceCount.Destroy)
+	})
+}
+
+func TestKeyVaultTimeouts(t *testing.T) {
+	t.Parallel()
+
+	MultiTenantTestRunner(t, func(t *testing.T, config TestConfig) {
+		SetupAzureAuth(t, config)
+
+		vars := baseModuleVars(config, fmt.Sprintf("kv-tmo-%s", config.UniqueID))
+		vars["timeouts"] = map[string]interface{}{"create": "45m"}
+
+		terraformOptions := planFixture(t, config, vars)
+
+		plan := terraform.InitAndPlanAndShowWithStruct(t, terraformOptions)
+		vault, ok := plan.ResourcePlannedValuesMap["azurerm_key_vault.this[0]"]
+		require.True(t, ok, "plan should create the Key Vault")
+
+		timeouts, _ := vault.AttributeValues["timeouts"].(map[string]interface{})
+		require.NotNil(t, timeouts, "plan should include the timeouts block")
+		assert.Equal(t, "45m", timeouts["create"])
+		assert.Equal(t, "30m", timeouts["delete"], "delete should keep its default when only create is set")
+	})
+}
+
+func TestKeyVaultWithoutDataPlane(t *testing.T) {
+	t.Parallel()
+
+	MultiTenantTestRunner(t, func(t *testing.T, config TestConfig) {
+		SetupAzureAuth(t, config)
+
+		vars := baseModuleVars(config, fmt.Sprintf("kv-cp-%s", config.UniqueID))
+		vars["create_resource_group"] = true
+		vars["manage_data_plane"] = false
+		vars["keys"] = map[string]interface{}{
+			"app": map[string]interface{}{
+				"name":     "app-key",
+				"key_type": "RSA",
+				"key_size": 2048,
+				"key_opts": []string{"wrapKey", "unwrapKey"},
+			},
+		}
+		vars["secrets"] = map[string]interface{}{
+			"app-secret": map[string]interface{}{"value": "not-created"},
+		}
+		vars["disk_encryption_key"] = map[string]interface{}{}
+		vars["certificate_contacts"] = []map[string]interface{}{{"email": "pki-team@example.com"}}
+
+		terraformOptions := planFixture(t, config, vars)
+
+		plan := terraform.InitAndPlanAndShowWithStruct(t, terraformOptions)
+		require.Contains(t, plan.ResourcePlannedValuesMap, "azurerm_key_vault.this[0]", "the vault itself should still be planned")
+
+		for address := range plan.ResourcePlannedValuesMap {
+			for _, dataPlaneType := range []string{
+				"azurerm_key_vault_key.",
+				"azurerm_key_vault_secret.",
+				"azurerm_key_vault_certificate.",
+				"azurerm_key_vault_certificate_issuer.",
+				"azurerm_key_vault_certificate_contacts.",
+			} {
+				assert.False(t, strings.HasPrefix(address, dataPlaneType), "%s should not be planned with manage_data_plane = false", address)
+			}
+		}
+	})
+}
+
+// TestKeyVaultPlanWarnings checks the module's plan-time warnings fire only
+// for the configurations they describe.
+func TestKeyVaultPlanWarnings(t *testing.T) {
+	t.Parallel()
+
+	const (
+		templateWarning     = "enabled_for_template_deployment is set on a Key Vault with public network access"
+		bypassWarning       = "enabled_for_disk_encryption is set, but network_acls_bypass is None on a Key Vault that denies network access"
+		certificatesWarning = "has purpose = \"certificates\" but no certificate contacts"
+		encryptionWarning   = "has purpose = \"encryption\", which needs sku_name = \"premium\""
+	)
+	hsmKey := map[string]interface{}{"name": "cmk", "key_type": "RSA-HSM", "key_size": 3072, "key_opts": []string{"wrapKey", "unwrapKey"}}
+	softwareKey := map[string]interface{}{"name": "cmk", "key_type": "RSA", "key_size": 3072, "key_opts": []string{"wrapKey", "unwrapKey"}}
+	diskEncryption := func(bypass string, publicAccess bool, defaultAction string, enabled bool) map[string]interface{} {
+		return map[string]interface{}{
+			"enabled_for_disk_encryption":   enabled,
+			"network_acls_bypass":           bypass,
+			"public_network_access_enabled": publicAccess,
+			"network_acls_default_action":   defaultAction,
+		}
+	}
+
+	testCases := []planCase{
+		{name: "template deployment with public access", vars: map[string]interface{}{"enabled_for_template_deployment": true, "public_network_access_enabled": true}, warning: templateWarning, warned: true},
+		{name: "template deployment with private access", vars: map[string]interface{}{"enabled_for_template_deployment": true, "public_network_access_enabled": false}, warning: templateWarning, warned: false},
+		{name: "private endpoint only without bypass", vars: diskEncryption("None", false, "Deny", true), warning: bypassWarning, warned: true},
+		{name: "firewalled without bypass", vars: diskEncryption("None", true, "Deny", true), warning: bypassWarning, warned: true},
+		{name: "trusted services bypass", vars: diskEncryption("AzureServices", false, "Deny", true), warning: bypassWarning, warned: false},
+		{name: "open firewall", vars: diskEncryption("None", true, "Allow", true), warning: bypassWarning, warned: false},
+		{name: "disk encryption off", vars: diskEncryption("None", false, "Deny", false), warning: bypassWarning, warned: false},
+		{name: "certificates without contacts", vars: map[string]interface{}{"purpose": "certificates"}, warning: certificatesWarning, warned: true},
+		{name: "certificates with contacts", vars: map[string]interface{}{"purpose": "certificates", "certificate_contacts": []map[string]interface{}{{"email": "pki@example.com"}}}, warning: certificatesWarning, warned: false},
+		{name: "encryption on standard", vars: map[string]interface{}{"purpose": "encryption", "sku_name": "standard", "keys": map[string]interface{}{"cmk": hsmKey}}, warning: encryptionWarning, warned: true},
+		{name: "encryption with software key", vars: map[string]interface{}{"purpose": "encryption", "sku_name": "premium", "keys": map[string]interface{}{"cmk": softwareKey}}, warning: "but keys cmk are not", warned: true},
+		{name: "encryption on premium with HSM key", vars: map[string]interface{}{"purpose": "encryption", "sku_name": "premium", "keys": map[string]interface{}{"cmk": hsmKey}}, warning: encryptionWarning, warned: false},
+		{name: "secrets", vars: map[string]interface{}{"purpose": "secrets", "sku_name": "standard"}, warning: "has purpose =", warned: false},
+	}
+
+	MultiTenantTestRunner(t, func(t *testing.T, config TestConfig) {
+		SetupAzureAuth(t, config)
+		runPlanCases(t, config, "kv-warn", testCases)
+	})
+}
+
+// TestModuleValidates type-checks the module without touching Azure, so it
+// needs no credentials or ARM_* variables and can run on every commit.
+// Validation does not configure providers or read variable values.
+func TestModuleValidates(t *testing.T) {
+	t.Parallel()
+
+	terraformOptions := &terraform.Options{
+		TerraformDir:    test_structure.CopyTerraformFolderToTemp(t, "..", "."),
+		TerraformBinary: TerraformBinary(),
+		NoColor:         true,
+	}
+
+	terraform.InitAndValidate(t, terraformOptions)
+}
+
+// TestModuleValidatesWithHubProvider validates the hub-spoke fixture, whose
+// caller passes the module its provider explicitly and manages the private
+// DNS zone through a second, aliased provider for the hub subscription.
+func TestModuleValidatesWithHubProvider(t *testing.T) {
+	t.Parallel()
+
+	// The fixture references the module by relative path, so copy the
+	// whole repository to keep that path valid in the temp folder.
+	fixtureDir := test_structure.CopyTerraformFolderToTemp(t, "..", "test/fixtures/hub_spoke_dns")
+	terraformOptions := &terraform.Options{
+		TerraformDir:    fixtureDir,
+		TerraformBinary: TerraformBinary(),
+		NoColor:         true,
+	}
+
+	terraform.InitAndValidate(t, terraformOptions)
+}
+
+// planOnlyModuleOptions returns PlanOnlyOptions for a vault named after
+// prefix with baseModuleVars, creating its resource group in the plan since
+// nothing is deployed beforehand in plan-only mode.
+func planOnlyModuleOptions(prefix string) func(t *testing.T, config TestConfig) *terraform.Options {
+	return func(t *testing.T, config TestConfig) *terraform.Options {
+		SetupAzureAuth(t, config)
+
+		vars := baseModuleVars(config, fmt.Sprintf("%s-%s", prefix, config.UniqueID))
+		vars["create_resource_group"] = true
+		return planFixture(t, config, vars)
+	}
+}
+
+func TestKeyVaultOutputsJSON(t *testing.T) {
+	t.Parallel()
+
+	MultiTenantTestRunner(t, func(t *testing.T, config TestConfig) {
+		SetupAzureAuth(t, config)
+		CreateResourceGroup(t, &config)
+
+		keyVaultName := PurgeSoftDeletedVault(t, config, fmt.Sprintf("kv-json-%s", config.UniqueID))
+		vars := baseModuleVars(config, keyVaultName)
+		vars["keys"] = map[string]interface{}{
+			"app": map[string]interface{}{
+				"name":     "app-key",
+				"key_type": "RSA",
+				"key_size": 2048,
+				"key_opts": []string{"sign", "verify"},
+			},
+		}
+
+		terraformOptions := BuildTerraformOptions(t, config, vars)
+
+		defer terraform.Destroy(t, terraformOptions)
+		terraform.InitAndApply(t, terraformOptions)
+
+		path := filepath.Join(t.TempDir(), "outputs", "key-vault.json")
+		WriteOutputsJSON(t, terraformOptions, path)
+
+		content, err := os.ReadFile(path)
+		require.NoError(t, err, "outputs file should be written")
+		var outputs map[string]interface{}
+		require.NoError(t, json.Unmarshal(content, &outputs))
+
+		assert.Equal(t, keyVaultName, outputs["key_vault_name"])
+		assert.Contains(t, outputs["key_ids"], "app")
+		assert.Equal(t, "***", outputs["key_public_keys"], "sensitive outputs should be redacted")
+		assert.NotContains(t, string(content), "BEGIN PUBLIC KEY")
+
+		AssertOutputSchema(t, terraformOptions, map[string]string{
+			"key_vault_name": "string",
+			"key_ids":        "object",
+		})
+	})
+}
+
+func TestKeyVaultResourcesOutput(t *testing.T) {
+	t.Parallel()
+
+	MultiTenantTestRunner(t, func(t *testing.T, config TestConfig) {
+		SetupAzureAuth(t, config)
+		CreateResourceGroup(t, &config)
+
+		keyVaultName := fmt.Sprintf("kv-res-%s", config.UniqueID)
+		keyVaultName = PurgeSoftDeletedVault(t, config, keyVaultName)
+
+		content, err := json.Marshal(map[string]interface{}{
+			"secrets": map[string]interface{}{
+				"app-secret": map[string]interface{}{"value": random.UniqueId()},
+			},
+		})
+		require.NoError(t, err)
+		varFile := filepath.Join(t.TempDir(), "secrets.tfvars.json")
+		require.NoError(t, os.WriteFile(varFile, content, 0o600))
+
+		vars := baseModuleVars(config, keyVaultName)
+		vars["keys"] = map[string]interface{}{
+			"app": map[string]interface{}{
+				"name":     "app-key",
+				"key_type": "RSA",
+				"key_opts": []string{"wrapKey", "unwrapKey"},
+			},
+		}
+
+		terraformOptions := BuildTerraformOptions(t, config, vars)
+		terraformOptions.VarFiles = []string{varFile}
+
+		defer terraform.Destroy(t, terraformOptions)
+		terraform.InitAndApply(t, terraformOptions)
+
+		resources := terraform.OutputMapOfObjects(t, terraformOptions, "resources")
+		for _, group := range []string{"keys", "secrets", "certificates", "role_assignments", "private_endpoint"} {
+			require.Contains(t, resources, group)
+		}
+
+		keys, _ := resources["keys"].(map[string]interface{})
+		assert.Equal(t, terraform.OutputMap(t, terraformOptions, "key_ids")["app"], keys["app"])
+		secrets, _ := resources["secrets"].(map[string]interface{})
+		assert.Equal(t, terraform.OutputMap(t, terraformOptions, "secret_ids")["app-secret"], secrets["app-secret"])
+
+		assert.Empty(t, resources["certificates"])
+		assert.Empty(t, resources["private_endpoint"])
+	})
+}
+
+func TestKeyVaultMultipleVaults(t *testing.T) {
+	t.Parallel()
+
+	MultiTenantTestRunner(t, func(t *testing.T, config TestConfig) {
+		SetupAzureAuth(t, config)
+		CreateResourceGroup(t, &config)
+
+		expectedNames := map[string]string{
+			"secrets":      PurgeSoftDeletedVault(t, config, fmt.Sprintf("kv-msec-%s", config.UniqueID)),
+			"certificates": PurgeSoftDeletedVault(t, config, fmt.Sprintf("kv-mcrt-%s", config.UniqueID)),
+		}
+
+		vars := baseModuleVars(config, expectedNames["secrets"])
+		vars["vaults"] = map[string]interface{}{
+			"secrets": map[string]interface{}{
+				"name": expectedNames["secrets"],
+			},
+			"certificates": map[string]interface{}{
+				"name":     expectedNames["certificates"],
+				"sku_name": "premium",
+			},
+		}
+
+		terraformOptions := BuildTerraformOptions(t, config, vars)
+
+		defer terraform.Destroy(t, terraformOptions)
+		terraform.InitAndApply(t, terraformOptions)
+
+		assert.Equal(t, expectedNames, terraform.OutputMap(t, terraformOptions, "key_vault_names"))
+		keyVaultIDs := terraform.OutputMap(t, terraformOptions, "key_vault_ids")
+		assert.Len(t, keyVaultIDs, 2)
+		assert.NotContains(t, keyVaultIDs, "default", "the single vault should not be created when vaults is set")
+
+		for _, name := range expectedNames {
+			keyVault := azure.GetKeyVault(t, config.ResourceGroupName(), name, config.SubscriptionID)
+			assert.Equal(t, name, *keyVault.Name)
+		}
+	})
+}
+
+func TestKeyVaultManagedHSM(t *testing.T) {
+	t.Parallel()
+
+	if os.Getenv("KV_TEST_MANAGED_HSM") == "" {
+		t.Skip("KV_TEST_MANAGED_HSM is not set; skipping Managed HSM test (billed hourly while it exists)")
+	}
+
+	MultiTenantTestRunner(t, func(t *testing.T, config TestConfig) {
+		SetupAzureAuth(t, config)
+		CreateResourceGroup(t, &config)
+
+		hsmName := fmt.Sprintf("hsm-test-%s", config.UniqueID)
+
+		vars := baseModuleVars(config, hsmName)
+		vars["backend_type"] = "managed_hsm"
+		vars["soft_delete_retention_days"] = 7
+
+		terraformOptions := BuildTerraformOptions(t, config, vars)
+
+		defer terraform.Destroy(t, terraformOptions)
+		terraform.InitAndApply(t, terraformOptions)
+
+		assert.Equal(t, hsmName, terraform.Output(t, terraformOptions, "managed_hsm_name"))
+		assert.NotEmpty(t, terraform.Output(t, terraformOptions, "managed_hsm_id"))
+
+		hsmURI := terraform.Output(t, terraformOptions, "hsm_uri")
+		assert.True(t, strings.HasPrefix(hsmURI, fmt.Sprintf("https://%s.", hsmName)), "unexpected HSM URI %s", hsmURI)
+		assert.Equal(t, hsmURI, terraform.Output(t, terraformOptions, "vault_uri"))
+		assert.Empty(t, terraform.Output(t, terraformOptions, "key_vault_id"), "no standard vault should exist alongside the HSM")
+		assert.Equal(t, "true", terraform.Output(t, terraformOptions, "purge_protection_enabled"))
+	})
+}
+
+func TestKeyVaultSummary(t *testing.T) {
+	t.Parallel()
+
+	MultiTenantTestRunner(t, func(t *testing.T, config TestConfig) {
+		SetupAzureAuth(t, config)
+
+		keyVaultName := fmt.Sprintf("kv-sum-%s", config.UniqueID)
+		secretValue := random.UniqueId()
+		vars := baseModuleVars(config, keyVaultName)
+		vars["create_resource_group"] = true
+		vars["soft_delete_retention_days"] = 30
+		vars["keys"] = map[string]interface{}{
+			"rsa": map[string]interface{}{
+				"name":     "rsa-key",
+				"key_type": "RSA",
+				"key_size": 3072,
+				"key_opts": []string{"encrypt", "decrypt"},
+			},
+		}
+		vars["secrets"] = map[string]interface{}{
+			"db":  map[string]interface{}{"name": "db-password", "value": secretValue},
+			"api": map[string]interface{}{"value": random.UniqueId()},
+		}
+		vars["emit_summary"] = true
+		vars["summary_path"] = filepath.Join(t.TempDir(), "SUMMARY.md")
+
+		terraformOptions := planFixture(t, config, vars)
+
+		plan := terraform.InitAndPlanAndShowWithStruct(t, terraformOptions)
+		summary, ok := plan.ResourcePlannedValuesMap["local_file.summary[0]"]
+		require.True(t, ok, "plan should write the vault summary")
+
+		content, _ := summary.AttributeValues["content"].(string)
+		assert.Contains(t, content, "# Key Vault "+keyVaultName+"\n")
+		assert.Contains(t, content, "| URI | "+keyVaultURI(t, keyVaultName)+" |")
+		assert.Contains(t, content, "| SKU | standard |")
+		assert.Contains(t, content, "| RBAC authorization | true |")
+		assert.Contains(t, content, "| Purge protection | false |")
+		assert.Contains(t, content, "| Soft delete retention | 30 days |")
+		assert.Contains(t, content, "| Public network access | true |")
+		assert.Contains(t, content, "| Network default action | Allow |")
+		assert.Contains(t, content, "| Private endpoint | false |")
+		assert.Contains(t, content, "- `rsa-key`\n")
+		assert.Contains(t, content, "- `api`\n- `db-password`\n", "secrets should be listed by name, sorted")
+		assert.NotContains(t, content, secretValue, "the summary must not include secret values")
+	})
+}
+
+func TestKeyVaultBaseResourceCount(t *testing.T) {
+	t.Parallel()
+
+	MultiTenantTestRunner(t, func(t *testing.T, config TestConfig) {
+		SetupAzureAuth(t, config)
+		CreateResourceGroup(t, &config)
+
+		keyVaultName := PurgeSoftDeletedVault(t, config, fmt.Sprintf("kv-cnt-%s", config.UniqueID))
+		terraformOptions := BuildTerraformOptions(t, config, baseModuleVars(config, keyVaultName))
+
+		defer terraform.Destroy(t, terraformOptions)
+		terraform.InitAndApply(t, terraformOptions)
+
+		// The base configuration deploys the vault alone into an existing
+		// resource group
+		AssertResourceCount(t, terraformOptions, 1)
+	})
+}
+
+func TestKeyVaultManagedStorageAccount(t *testing.T) {
+	t.Parallel()
+
+	keyVaultPrincipalID := os.Getenv("KV_TEST_KEY_VAULT_SP_OBJECT_ID")
+	if keyVaultPrincipalID == "" {
+		t.Skip("KV_TEST_KEY_VAULT_SP_OBJECT_ID is not set; skipping the managed storage account test")
+	}
+
+	MultiTenantTestRunner(t, func(t *testing.T, config TestConfig) {
+		SetupAzureAuth(t, config)
+		CreateResourceGroup(t, &config)
+
+		fixtureDir := test_structure.CopyTerraformFolderToTemp(t, "..", "test/fixtures/managed_storage")
+		keyVaultName := PurgeSoftDeletedVault(t, config, fmt.Sprintf("kv-msa-%s", config.UniqueID))
+
+		terraformOptions := BuildTerraformOptions(t, config, map[string]interface{}{
+			"key_vault_name":                        keyVaultName,
+			"location":                              config.Region,
+			"resource_group_name":                   config.ResourceGroupName(),
+			"key_vault_service_principal_object_id": keyVaultPrincipalID,
+		}, WithTerraformDir(fixtureDir))
+
+		defer terraform.Destroy(t, terraformOptions)
+		terraform.InitAndApply(t, terraformOptions)
+
+		id := terraform.OutputMap(t, terraformOptions, "managed_storage_account_ids")["test"]
+		assert.Equal(t, keyVaultURI(t, keyVaultName)+"storage/sttest", id)
+
+		// A refresh reads the managed storage account back from the vault, so
+		// an empty plan shows it exists as configured.
+		assert.Equal(t, 0, terraform.PlanExitCode(t, terraformOptions), "the managed storage account should match its configuration")
 	})
-}
\ No newline at end of file
+}
@@ -0,0 +1,594 @@
+package test
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azkeys"
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azsecrets"
+	"github.com/gruntwork-io/terratest/modules/azure"
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyVaultKeys(t *testing.T) {
+	t.Parallel()
+
+	MultiTenantTestRunner(t, func(t *testing.T, config TestConfig) {
+		SetupAzureAuth(t, config)
+		CreateResourceGroup(t, &config)
+
+		keyVaultName := fmt.Sprintf("kv-keys-%s", config.UniqueID)
+		keyVaultName = PurgeSoftDeletedVault(t, config, keyVaultName)
+
+		vars := baseModuleVars(config, keyVaultName)
+		vars["keys"] = map[string]interface{}{
+			"rsa": map[string]interface{}{
+				"name":     "rsa-key",
+				"key_type": "RSA",
+				"key_size": 3072,
+				"key_opts": []string{"encrypt", "decrypt", "wrapKey", "unwrapKey"},
+			},
+			"ec": map[string]interface{}{
+				"name":     "ec-key",
+				"key_type": "EC",
+				"curve":    "P-256",
+				"key_opts": []string{"sign", "verify"},
+			},
+		}
+
+		terraformOptions := BuildTerraformOptions(t, config, vars)
+
+		defer terraform.Destroy(t, terraformOptions)
+		terraform.InitAndApply(t, terraformOptions)
+
+		WaitForVaultReady(t, config, keyVaultName, 5*time.Minute)
+
+		keyIDs := terraform.OutputMap(t, terraformOptions, "key_ids")
+		versionlessIDs := terraform.OutputMap(t, terraformOptions, "key_vault_key_versionless_ids")
+		assert.Len(t, keyIDs, 2)
+		assert.Len(t, versionlessIDs, 2)
+
+		for name, keyName := range map[string]string{"rsa": "rsa-key", "ec": "ec-key"} {
+			assert.True(t, azure.KeyVaultKeyExists(t, keyVaultName, keyName), "key %s should exist", keyName)
+			assert.True(t, strings.HasPrefix(keyIDs[name], versionlessIDs[name]+"/"), "key_ids should hold the versioned form of %s", name)
+		}
+
+		ValidateKeyVaultKeys(t, config, keyVaultName, []string{"rsa-key", "ec-key"})
+	}, PlanOnlyOptions(planOnlyModuleOptions("kv-keys")))
+}
+
+func TestKeyVaultKeyNotBeforeDate(t *testing.T) {
+	t.Parallel()
+
+	MultiTenantTestRunner(t, func(t *testing.T, config TestConfig) {
+		SetupAzureAuth(t, config)
+		CreateResourceGroup(t, &config)
+
+		keyVaultName := PurgeSoftDeletedVault(t, config, fmt.Sprintf("kv-nbf-%s", config.UniqueID))
+		notBefore := time.Now().UTC().AddDate(0, 0, 30).Truncate(time.Second)
+		vars := baseModuleVars(config, keyVaultName)
+		vars["keys"] = map[string]interface{}{
+			"scheduled": map[string]interface{}{
+				"name":            "scheduled-key",
+				"key_type":        "RSA",
+				"key_size":        2048,
+				"key_opts":        []string{"wrapKey", "unwrapKey"},
+				"not_before_date": notBefore.Format(time.RFC3339),
+				"expiration_date": notBefore.AddDate(1, 0, 0).Format(time.RFC3339),
+			},
+		}
+
+		terraformOptions := BuildTerraformOptions(t, config, vars)
+
+		defer terraform.Destroy(t, terraformOptions)
+		terraform.InitAndApply(t, terraformOptions)
+
+		WaitForVaultReady(t, config, keyVaultName, 5*time.Minute)
+
+		resp, err := keysClient(t, keyVaultName).GetKey(context.Background(), "scheduled-key", "", nil)
+		require.NoError(t, err)
+		require.NotNil(t, resp.Attributes)
+		require.NotNil(t, resp.Attributes.NotBefore, "the key should carry its activation date")
+		assert.True(t, notBefore.Equal(*resp.Attributes.NotBefore), "not before should be %s, got %s", notBefore, resp.Attributes.NotBefore)
+	})
+}
+
+func TestKeyVaultDisabledObjects(t *testing.T) {
+	t.Parallel()
+
+	MultiTenantTestRunner(t, func(t *testing.T, config TestConfig) {
+		SetupAzureAuth(t, config)
+		CreateResourceGroup(t, &config)
+
+		keyVaultName := PurgeSoftDeletedVault(t, config, fmt.Sprintf("kv-dis-%s", config.UniqueID))
+		vars := baseModuleVars(config, keyVaultName)
+		vars["keys"] = map[string]interface{}{
+			"retired": map[string]interface{}{
+				"name":     "retired-key",
+				"key_type": "RSA",
+				"key_size": 2048,
+				"key_opts": []string{"wrapKey", "unwrapKey"},
+				"enabled":  false,
+			},
+		}
+		vars["secrets"] = map[string]interface{}{
+			"paused":  map[string]interface{}{"name": "paused-secret", "value": "not-in-use", "enabled": false},
+			"current": map[string]interface{}{"name": "current-secret", "value": "in-use"},
+		}
+
+		terraformOptions := BuildTerraformOptions(t, config, vars)
+
+		defer terraform.Destroy(t, terraformOptions)
+		// The provider cannot refresh a disabled secret, so it is enabled
+		// again before destroy.
+		defer func() {
+			_, err := secretsClient(t, keyVaultName).UpdateSecretProperties(context.Background(), "paused-secret", "", azsecrets.UpdateSecretPropertiesParameters{
+				SecretAttributes: &azsecrets.SecretAttributes{Enabled: to.Ptr(true)},
+			}, nil)
+			assert.NoError(t, err, "failed to enable paused-secret before destroy")
+		}()
+		terraform.InitAndApply(t, terraformOptions)
+
+		WaitForVaultReady(t, config, keyVaultName, 5*time.Minute)
+
+		ValidateObjectEnabled(t, config, keyVaultName, "paused-secret", false)
+		ValidateObjectEnabled(t, config, keyVaultName, "retired-key", false)
+		ValidateObjectEnabled(t, config, keyVaultName, "current-secret", true)
+	})
+}
+
+func TestKeyVaultKeyOperations(t *testing.T) {
+	t.Parallel()
+
+	MultiTenantTestRunner(t, func(t *testing.T, config TestConfig) {
+		SetupAzureAuth(t, config)
+		CreateResourceGroup(t, &config)
+
+		keyVaultName := PurgeSoftDeletedVault(t, config, fmt.Sprintf("kv-kops-%s", config.UniqueID))
+		vars := baseModuleVars(config, keyVaultName)
+		vars["keys"] = map[string]interface{}{
+			"wrap": map[string]interface{}{
+				"name":     "wrap-only-key",
+				"key_type": "RSA",
+				"key_size": 2048,
+				"key_opts": []string{"wrapKey", "unwrapKey"},
+			},
+		}
+
+		terraformOptions := BuildTerraformOptions(t, config, vars)
+
+		defer terraform.Destroy(t, terraformOptions)
+		terraform.InitAndApply(t, terraformOptions)
+
+		WaitForVaultReady(t, config, keyVaultName, 5*time.Minute)
+
+		ValidateKeyOperations(t, config, keyVaultName, "wrap-only-key", []string{"wrapKey", "unwrapKey"})
+
+		resp, err := keysClient(t, keyVaultName).GetKey(context.Background(), "wrap-only-key", "", nil)
+		require.NoError(t, err)
+		require.NotNil(t, resp.Key)
+		for _, op := range resp.Key.KeyOps {
+			assert.NotContains(t, []azkeys.KeyOperation{azkeys.KeyOperationSign, azkeys.KeyOperationVerify}, *op, "the key should not permit %s", *op)
+		}
+	})
+}
+
+func TestKeyVaultMinimumKeySize(t *testing.T) {
+	t.Parallel()
+
+	MultiTenantTestRunner(t, func(t *testing.T, config TestConfig) {
+		SetupAzureAuth(t, config)
+		CreateResourceGroup(t, &config)
+
+		keyVaultName := PurgeSoftDeletedVault(t, config, fmt.Sprintf("kv-ksize-%s", config.UniqueID))
+		vars := baseModuleVars(config, keyVaultName)
+		vars["keys"] = map[string]interface{}{
+			"legacy": map[string]interface{}{
+				"name":     "legacy-2048",
+				"key_type": "RSA",
+				"key_size": 2048,
+				"key_opts": []string{"sign", "verify"},
+			},
+			"signing": map[string]interface{}{
+				"name":     "signing-p256",
+				"key_type": "EC",
+				"curve":    "P-256",
+				"key_opts": []string{"sign", "verify"},
+			},
+		}
+
+		terraformOptions := BuildTerraformOptions(t, config, vars)
+
+		defer terraform.Destroy(t, terraformOptions)
+		terraform.InitAndApply(t, terraformOptions)
+
+		WaitForVaultReady(t, config, keyVaultName, 5*time.Minute)
+
+		// The key meets a 2048-bit minimum, so the helper passes
+		ValidateMinimumKeySize(t, config, keyVaultName, 2048, []string{"P-256", "P-384"})
+
+		problems, err := weakKeyProblems(context.Background(), azureKeyInspector{keysClient(t, keyVaultName)}, 3072, []string{"P-256", "P-384"})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"legacy-2048 is a 2048-bit RSA key, below the minimum of 3072 bits"}, problems, "only the 2048-bit key should be flagged under a 3072-bit minimum")
+	})
+}
+
+func TestKeyVaultHSMBackedKey(t *testing.T) {
+	t.Parallel()
+
+	hsmKey := map[string]interface{}{
+		"hsm": map[string]interface{}{
+			"name":     "hsm-key",
+			"key_type": "RSA-HSM",
+			"key_size": 2048,
+			"key_opts": []string{"sign", "verify", "wrapKey", "unwrapKey"},
+		},
+	}
+
+	MultiTenantTestRunner(t, func(t *testing.T, config TestConfig) {
+		SetupAzureAuth(t, config)
+
+		runPlanCases(t, config, "kv-hsms", []planCase{
+			{
+				name:          "standard sku",
+				vars:          map[string]interface{}{"sku_name": "standard", "keys": hsmKey},
+				expectedError: "Key 'hsm' is HSM-backed (key_type = RSA-HSM), which the standard SKU does not support",
+			},
+			{
+				name: "standard sku certificate",
+				vars: map[string]interface{}{"certificates": map[string]interface{}{
+					"tls": map[string]interface{}{
+						"name":           "tls",
+						"key_properties": map[string]interface{}{"key_type": "RSA-HSM"},
+						"x509_certificate_properties": map[string]interface{}{
+							"subject": "CN=hsm.example.com",
+						},
+					},
+				}},
+				expectedError: "Certificate 'tls' has an HSM-backed key (key_type = RSA-HSM), which the standard SKU does not support. Set sku_name = \"premium\"",
+			},
+		})
+
+		t.Run("premium sku", func(t *testing.T) {
+			CreateResourceGroup(t, &config)
+
+			keyVaultName := PurgeSoftDeletedVault(t, config, fmt.Sprintf("kv-hsm-%s", config.UniqueID))
+			vars := baseModuleVars(config, keyVaultName)
+			vars["sku_name"] = "premium"
+			vars["keys"] = hsmKey
+
+			terraformOptions := BuildTerraformOptions(t, config, vars)
+
+			defer terraform.Destroy(t, terraformOptions)
+			terraform.InitAndApply(t, terraformOptions)
+
+			WaitForVaultReady(t, config, keyVaultName, 5*time.Minute)
+
+			resp, err := keysClient(t, keyVaultName).GetKey(context.Background(), "hsm-key", "", nil)
+			require.NoError(t, err, "failed to get key hsm-key")
+			require.NotNil(t, resp.Key)
+			require.NotNil(t, resp.Key.Kty)
+			assert.Equal(t, azkeys.KeyTypeRSAHSM, *resp.Key.Kty, "the key should be backed by an HSM")
+		})
+	})
+}
+
+func TestKeyVaultKeyRotationPolicy(t *testing.T) {
+	t.Parallel()
+
+	MultiTenantTestRunner(t, func(t *testing.T, config TestConfig) {
+		SetupAzureAuth(t, config)
+		CreateResourceGroup(t, &config)
+
+		keyVaultName := fmt.Sprintf("kv-rot-%s", config.UniqueID)
+		keyVaultName = PurgeSoftDeletedVault(t, config, keyVaultName)
+
+		vars := baseModuleVars(config, keyVaultName)
+		vars["keys"] = map[string]interface{}{
+			"cmk": map[string]interface{}{
+				"name":     "cmk",
+				"key_type": "RSA",
+				"key_size": 2048,
+				"key_opts": []string{"wrapKey", "unwrapKey"},
+				"rotation_policy": map[string]interface{}{
+					"time_after_creation":  "P90D",
+					"expire_after":         "P120D",
+					"notify_before_expiry": "P29D",
+				},
+			},
+		}
+
+		terraformOptions := BuildTerraformOptions(t, config, vars)
+
+		defer terraform.Destroy(t, terraformOptions)
+		terraform.InitAndApply(t, terraformOptions)
+
+		vaultURI := terraform.Output(t, terraformOptions, "key_vault_uri")
+		policy := getKeyRotationPolicy(t, vaultURI, "cmk")
+
+		require.NotNil(t, policy.Attributes)
+		assert.Equal(t, "P120D", *policy.Attributes.ExpiryTime)
+
+		triggers := map[azkeys.KeyRotationPolicyAction]azkeys.LifetimeActionTrigger{}
+		for _, action := range policy.LifetimeActions {
+			triggers[*action.Action.Type] = *action.Trigger
+		}
+		require.Contains(t, triggers, azkeys.KeyRotationPolicyActionRotate)
+		require.Contains(t, triggers, azkeys.KeyRotationPolicyActionNotify)
+		assert.Equal(t, "P90D", *triggers[azkeys.KeyRotationPolicyActionRotate].TimeAfterCreate)
+		assert.Nil(t, triggers[azkeys.KeyRotationPolicyActionRotate].TimeBeforeExpiry)
+		assert.Equal(t, "P29D", *triggers[azkeys.KeyRotationPolicyActionNotify].TimeBeforeExpiry)
+	})
+}
+
+func TestKeyVaultKeyBackupRestore(t *testing.T) {
+	t.Parallel()
+
+	if os.Getenv("KV_TEST_KEY_BACKUP") == "" {
+		t.Skip("KV_TEST_KEY_BACKUP is not set; skipping key backup and restore round trip")
+	}
+
+	MultiTenantTestRunner(t, func(t *testing.T, config TestConfig) {
+		SetupAzureAuth(t, config)
+		CreateResourceGroup(t, &config)
+
+		keyVaultName := fmt.Sprintf("kv-bak-%s", config.UniqueID)
+		keyVaultName = PurgeSoftDeletedVault(t, config, keyVaultName)
+
+		vars := baseModuleVars(config, keyVaultName)
+		vars["keys"] = map[string]interface{}{
+			"dr": map[string]interface{}{
+				"name":     "dr",
+				"key_type": "RSA",
+				"key_size": 2048,
+				"key_opts": []string{"encrypt", "decrypt", "wrapKey", "unwrapKey"},
+			},
+		}
+
+		terraformOptions := BuildTerraformOptions(t, config, vars)
+
+		defer terraform.Destroy(t, terraformOptions)
+		terraform.InitAndApply(t, terraformOptions)
+
+		keyVersion := terraform.OutputMap(t, terraformOptions, "key_versions")["dr"]
+		require.NotEmpty(t, keyVersion)
+
+		backup := BackupKeyVaultKey(t, config, keyVaultName, "dr")
+		require.NotEmpty(t, backup)
+
+		DeleteKeyVaultKey(t, config, keyVaultName, "dr")
+		RestoreKeyVaultKey(t, config, keyVaultName, backup)
+
+		client, err := azkeys.NewClient(terraform.Output(t, terraformOptions, "key_vault_uri"), azureCredential(t), nil)
+		require.NoError(t, err)
+		restored, err := client.GetKey(context.Background(), "dr", "", nil)
+		require.NoError(t, err)
+		require.NotNil(t, restored.Key)
+		assert.Equal(t, keyVersion, restored.Key.KID.Version())
+	})
+}
+
+func TestKeyVaultKeyRecovery(t *testing.T) {
+	t.Parallel()
+
+	if os.Getenv("KV_TEST_KEY_RECOVERY") == "" {
+		t.Skip("KV_TEST_KEY_RECOVERY is not set; skipping the key delete and recover cycle")
+	}
+
+	MultiTenantTestRunner(t, func(t *testing.T, config TestConfig) {
+		SetupAzureAuth(t, config)
+		CreateResourceGroup(t, &config)
+
+		keyVaultName := fmt.Sprintf("kv-rcv-%s", config.UniqueID)
+		keyVaultName = PurgeSoftDeletedVault(t, config, keyVaultName)
+
+		vars := baseModuleVars(config, keyVaultName)
+		vars["keys"] = map[string]interface{}{
+			"dr": map[string]interface{}{
+				"name":     "dr",
+				"key_type": "RSA",
+				"key_size": 2048,
+				"key_opts": []string{"encrypt", "decrypt", "wrapKey", "unwrapKey"},
+			},
+		}
+
+		terraformOptions := BuildTerraformOptions(t, config, vars)
+
+		defer terraform.Destroy(t, terraformOptions)
+		terraform.InitAndApply(t, terraformOptions)
+
+		ValidateKeyRecoverable(t, config, keyVaultName, "dr")
+
+		// The recovered key is the one in state, so there is nothing to change
+		assert.Equal(t, 0, terraform.PlanExitCode(t, terraformOptions), "plan after recovery should be empty")
+	})
+}
+
+func TestKeyVaultDiskEncryptionKey(t *testing.T) {
+	t.Parallel()
+
+	MultiTenantTestRunner(t, func(t *testing.T, config TestConfig) {
+		SetupAzureAuth(t, config)
+		CreateResourceGroup(t, &config)
+
+		keyVaultName := fmt.Sprintf("kv-des-%s", config.UniqueID)
+		keyVaultName = PurgeSoftDeletedVault(t, config, keyVaultName)
+
+		vars := baseModuleVars(config, keyVaultName)
+		vars["enabled_for_disk_encryption"] = true
+		vars["disk_encryption_key"] = map[string]interface{}{}
+
+		terraformOptions := BuildTerraformOptions(t, config, vars)
+
+		defer terraform.Destroy(t, terraformOptions)
+		terraform.InitAndApply(t, terraformOptions)
+
+		// Disk encryption sets need the versionless form to follow rotations
+		keyID := terraform.Output(t, terraformOptions, "disk_encryption_key_id")
+		assert.Equal(t, keyVaultURI(t, keyVaultName)+"keys/disk-encryption", keyID)
+
+		client, err := azkeys.NewClient(terraform.Output(t, terraformOptions, "key_vault_uri"), azureCredential(t), nil)
+		require.NoError(t, err)
+		key, err := client.GetKey(context.Background(), "disk-encryption", "", nil)
+		require.NoError(t, err)
+		require.NotNil(t, key.Key)
+
+		require.NotNil(t, key.Key.Kty)
+		assert.Equal(t, azkeys.KeyType("RSA"), *key.Key.Kty)
+		assert.Len(t, key.Key.N, 2048/8, "disk encryption key should be RSA 2048")
+
+		operations := []string{}
+		for _, op := range key.Key.KeyOps {
+			operations = append(operations, string(*op))
+		}
+		assert.Subset(t, operations, []string{"wrapKey", "unwrapKey"})
+	})
+}
+
+func TestKeyVaultKeyImport(t *testing.T) {
+	t.Parallel()
+
+	MultiTenantTestRunner(t, func(t *testing.T, config TestConfig) {
+		SetupAzureAuth(t, config)
+		CreateResourceGroup(t, &config)
+
+		keyVaultName := fmt.Sprintf("kv-imp-%s", config.UniqueID)
+		keyVaultName = PurgeSoftDeletedVault(t, config, keyVaultName)
+		material, privateKey := generateKeyMaterial(t, "imported-signing")
+
+		vars := baseModuleVars(config, keyVaultName)
+		vars["keys"] = map[string]interface{}{
+			"signing": map[string]interface{}{
+				"name":         "imported-signing",
+				"key_type":     "RSA",
+				"key_opts":     []string{},
+				"key_material": map[string]interface{}{"contents": material},
+			},
+		}
+
+		terraformOptions := BuildTerraformOptions(t, config, vars)
+
+		defer terraform.Destroy(t, terraformOptions)
+		terraform.InitAndApply(t, terraformOptions)
+
+		keyID := terraform.OutputMap(t, terraformOptions, "key_ids")["signing"]
+		require.True(t, strings.HasPrefix(keyID, keyVaultURI(t, keyVaultName)+"keys/imported-signing/"), "unexpected key ID %q", keyID)
+		WaitForVaultReady(t, config, keyVaultName, 5*time.Minute)
+
+		// A signature from the vault that verifies against the local public key
+		// shows the key ID resolves to the imported material.
+		id := azkeys.ID(keyID)
+		digest := sha256.Sum256([]byte("key import"))
+		var signed azkeys.SignResponse
+		RetryUntilRbacPropagated(t, func() error {
+			var err error
+			signed, err = keysClient(t, keyVaultName).Sign(context.Background(), id.Name(), id.Version(), azkeys.SignParameters{
+				Algorithm: to.Ptr(azkeys.SignatureAlgorithmRS256),
+				Value:     digest[:],
+			}, nil)
+			return err
+		}, 5*time.Minute)
+		assert.NoError(t, rsa.VerifyPKCS1v15(&privateKey.PublicKey, crypto.SHA256, digest[:], signed.Result))
+	})
+}
+
+// generateKeyMaterial returns a PEM holding a self-signed certificate for
+// commonName and its private key, in the form the keys key_material input takes.
+func generateKeyMaterial(t *testing.T, commonName string) (string, *rsa.PrivateKey) {
+	t.Helper()
+
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().AddDate(1, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+	}
+	certificate, err := x509.CreateCertificate(rand.Reader, template, template, &privateKey.PublicKey, privateKey)
+	require.NoError(t, err)
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	require.NoError(t, err)
+
+	material := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate})
+	material = append(material, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8})...)
+	return string(material), privateKey
+}
+
+func TestKeyVaultExpirationEnforcement(t *testing.T) {
+	t.Parallel()
+
+	expirationVars := func(require bool, defaultDays interface{}) map[string]interface{} {
+		return map[string]interface{}{
+			"require_expiration":      require,
+			"default_expiration_days": defaultDays,
+			"keys": map[string]interface{}{
+				"expiry": map[string]interface{}{
+					"name":            "expiry-key",
+					"key_type":        "RSA",
+					"key_size":        2048,
+					"key_opts":        []string{"sign", "verify"},
+					"expiration_date": "2030-01-01T00:00:00Z",
+				},
+				"no-expiry": map[string]interface{}{
+					"name":     "no-expiry-key",
+					"key_type": "RSA",
+					"key_size": 2048,
+					"key_opts": []string{"sign", "verify"},
+				},
+			},
+			"secrets": map[string]interface{}{
+				"expiry":    map[string]interface{}{"value": "expires", "expiration_date": "2030-01-01T00:00:00Z"},
+				"no-expiry": map[string]interface{}{"value": "never-expires"},
+			},
+		}
+	}
+	timestamps := func(expected ...string) func(t *testing.T, plan *terraform.PlanStruct) {
+		return func(t *testing.T, plan *terraform.PlanStruct) {
+			// Explicit dates are kept, and only entries without one get a
+			// recorded creation time to expire from.
+			key, ok := plan.ResourcePlannedValuesMap[`azurerm_key_vault_key.this["expiry"]`]
+			require.True(t, ok, "plan should create the key with an expiration date")
+			assert.Equal(t, "2030-01-01T00:00:00Z", key.AttributeValues["expiration_date"])
+			secret, ok := plan.ResourcePlannedValuesMap[`azurerm_key_vault_secret.this["expiry"]`]
+			require.True(t, ok, "plan should create the secret with an expiration date")
+			assert.Equal(t, "2030-01-01T00:00:00Z", secret.AttributeValues["expiration_date"])
+
+			var created []string
+			for address := range plan.ResourcePlannedValuesMap {
+				if strings.HasPrefix(address, "time_static.created") {
+					created = append(created, address)
+				}
+			}
+			assert.ElementsMatch(t, expected, created)
+		}
+	}
+
+	testCases := []planCase{
+		{name: "not required", vars: expirationVars(false, nil), check: timestamps()},
+		{name: "required without default secret", vars: expirationVars(true, nil), expectedError: "Secret 'no-expiry' has no expiration_date and require_expiration is set"},
+		{name: "required without default key", vars: expirationVars(true, nil), expectedError: "Key 'no-expiry' has no expiration_date"},
+		{name: "required with default", vars: expirationVars(true, 90), check: timestamps(`time_static.created["key:no-expiry"]`, `time_static.created["secret:no-expiry"]`)},
+	}
+
+	MultiTenantTestRunner(t, func(t *testing.T, config TestConfig) {
+		SetupAzureAuth(t, config)
+		runPlanCases(t, config, "kv-exp", testCases)
+	})
+}
@@ -0,0 +1,125 @@
+package test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	// latencyAPIVersion is the Key Vault data-plane API version of the
+	// requests MeasureVaultLatency times.
+	latencyAPIVersion = "7.4"
+
+	latencyThrottleInitialWait = time.Second
+	latencyThrottleMaxWait     = 30 * time.Second
+)
+
+// LatencyStats summarizes the data-plane round trips MeasureVaultLatency
+// timed. Throttled counts the requests answered with 429, which were retried
+// and left out of the percentiles.
+type LatencyStats struct {
+	Samples   int
+	Throttled int
+	P50       time.Duration
+	P95       time.Duration
+	Max       time.Duration
+}
+
+// MeasureVaultLatency times samples lightweight data-plane requests from the
+// runner to vaultURI, listing at most one secret each, and returns their
+// p50, p95 and maximum latency, for comparing regions. Responses other than
+// 429 count whatever their status, since a denied request still makes the
+// round trip. Throttled requests back off, honoring Retry-After, and are not
+// counted; the test fails if throttling outnumbers samples.
+func MeasureVaultLatency(t *testing.T, vaultURI string, samples int) LatencyStats {
+	t.Helper()
+
+	u, err := url.Parse(vaultURI)
+	require.NoError(t, err, "invalid vault URI %q", vaultURI)
+	_, suffix, ok := strings.Cut(u.Hostname(), ".")
+	require.True(t, ok, "vault URI %q has no DNS suffix", vaultURI)
+
+	ctx := context.Background()
+	token, err := azureCredential(t).GetToken(ctx, policy.TokenRequestOptions{Scopes: []string{"https://" + suffix + "/.default"}})
+	require.NoError(t, err, "failed to get a data-plane token for %s", vaultURI)
+
+	stats, err := measureVaultLatency(ctx, http.DefaultClient, vaultURI, token.Token, samples, time.Now, time.Sleep)
+	require.NoError(t, err, "failed to measure the latency of %s", vaultURI)
+	t.Logf("Key Vault %s latency over %d samples: p50 %s, p95 %s, max %s (%d throttled)", vaultURI, stats.Samples, stats.P50, stats.P95, stats.Max, stats.Throttled)
+	return stats
+}
+
+func measureVaultLatency(ctx context.Context, client *http.Client, vaultURI string, token string, samples int, now func() time.Time, sleep func(time.Duration)) (LatencyStats, error) {
+	target := strings.TrimSuffix(vaultURI, "/") + "/secrets?maxresults=1&api-version=" + latencyAPIVersion
+	stats := LatencyStats{}
+	var latencies []time.Duration
+	wait := latencyThrottleInitialWait
+
+	for len(latencies) < samples {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+		if err != nil {
+			return stats, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		start := now()
+		resp, err := client.Do(req)
+		elapsed := now().Sub(start)
+		if err != nil {
+			return stats, err
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode == http.StatusTooManyRequests {
+			stats.Throttled++
+			if stats.Throttled > samples {
+				return stats, fmt.Errorf("throttled %d times while collecting %d of %d samples", stats.Throttled, len(latencies), samples)
+			}
+			sleep(retryAfter(resp, wait))
+			wait = min(2*wait, latencyThrottleMaxWait)
+			continue
+		}
+		latencies = append(latencies, elapsed)
+	}
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	stats.Samples = len(latencies)
+	stats.P50 = percentile(latencies, 0.50)
+	stats.P95 = percentile(latencies, 0.95)
+	if len(latencies) > 0 {
+		stats.Max = latencies[len(latencies)-1]
+	}
+	return stats, nil
+}
+
+// retryAfter returns the wait a throttled response asks for in its
+// Retry-After seconds, or fallback when it names none.
+func retryAfter(resp *http.Response, fallback time.Duration) time.Duration {
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	return fallback
+}
+
+// percentile returns the nearest-rank p-th percentile, 0 < p <= 1, of the
+// sorted latencies, or 0 when there are none.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p * float64(len(sorted))))
+	return sorted[max(rank, 1)-1]
+}
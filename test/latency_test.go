@@ -0,0 +1,95 @@
+package test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// scriptedTransport answers each request with the next status in statuses,
+// advancing clock by the matching latency first, and records the requests.
+type scriptedTransport struct {
+	clock     *fakeClock
+	statuses  []int
+	latencies []time.Duration
+	requests  []*http.Request
+}
+
+func (s *scriptedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	i := len(s.requests)
+	s.requests = append(s.requests, req)
+	s.clock.now = s.clock.now.Add(s.latencies[i])
+	resp := &http.Response{StatusCode: s.statuses[i], Header: http.Header{}, Body: io.NopCloser(strings.NewReader("{}")), Request: req}
+	if s.statuses[i] == http.StatusTooManyRequests {
+		resp.Header.Set("Retry-After", "3")
+	}
+	return resp, nil
+}
+
+func TestMeasureVaultLatency(t *testing.T) {
+	t.Parallel()
+
+	t.Run("computes percentiles and excludes throttled samples", func(t *testing.T) {
+		clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+		transport := &scriptedTransport{clock: clock}
+		// Twenty samples of 10ms to 200ms, with a slow throttled response in
+		// the middle that must not count.
+		for i := 1; i <= 20; i++ {
+			if i == 10 {
+				transport.statuses = append(transport.statuses, http.StatusTooManyRequests)
+				transport.latencies = append(transport.latencies, 5*time.Second)
+			}
+			transport.statuses = append(transport.statuses, http.StatusOK)
+			transport.latencies = append(transport.latencies, time.Duration(i)*10*time.Millisecond)
+		}
+		// A denied request still makes the round trip.
+		transport.statuses[0] = http.StatusForbidden
+
+		stats, err := measureVaultLatency(context.Background(), &http.Client{Transport: transport}, "https://kv-test.vault.azure.net/", "token", 20, clock.Now, clock.Sleep)
+
+		require.NoError(t, err)
+		assert.Equal(t, 20, stats.Samples)
+		assert.Equal(t, 1, stats.Throttled)
+		assert.Equal(t, 100*time.Millisecond, stats.P50)
+		assert.Equal(t, 190*time.Millisecond, stats.P95)
+		assert.Equal(t, 200*time.Millisecond, stats.Max, "the throttled response is left out")
+		assert.Equal(t, []time.Duration{3 * time.Second}, clock.sleeps, "throttling honors Retry-After")
+
+		require.Len(t, transport.requests, 21)
+		req := transport.requests[0]
+		assert.Equal(t, "https://kv-test.vault.azure.net/secrets?maxresults=1&api-version="+latencyAPIVersion, req.URL.String())
+		assert.Equal(t, "Bearer token", req.Header.Get("Authorization"))
+	})
+
+	t.Run("gives up when throttling outnumbers samples", func(t *testing.T) {
+		clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+		transport := &scriptedTransport{clock: clock}
+		for i := 0; i < 3; i++ {
+			transport.statuses = append(transport.statuses, http.StatusTooManyRequests)
+			transport.latencies = append(transport.latencies, time.Millisecond)
+		}
+
+		_, err := measureVaultLatency(context.Background(), &http.Client{Transport: transport}, "https://kv-test.vault.azure.net", "token", 2, clock.Now, clock.Sleep)
+
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "throttled 3 times")
+	})
+}
+
+func TestPercentile(t *testing.T) {
+	t.Parallel()
+
+	assert.Zero(t, percentile(nil, 0.5))
+	one := []time.Duration{42 * time.Millisecond}
+	assert.Equal(t, 42*time.Millisecond, percentile(one, 0.5))
+	assert.Equal(t, 42*time.Millisecond, percentile(one, 0.95))
+	four := []time.Duration{1, 2, 3, 4}
+	assert.Equal(t, time.Duration(2), percentile(four, 0.5))
+	assert.Equal(t, time.Duration(4), percentile(four, 0.95))
+}
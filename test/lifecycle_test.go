@@ -0,0 +1,318 @@
+package test
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armlocks"
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	test_structure "github.com/gruntwork-io/terratest/modules/test-structure"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyVaultSoftDeleteRetention(t *testing.T) {
+	t.Parallel()
+
+	retention := func(days float64) func(t *testing.T, plan *terraform.PlanStruct) {
+		return plannedVaultAttribute("soft_delete_retention_days", days)
+	}
+
+	testCases := []planCase{
+		{name: "clamped up", vars: map[string]interface{}{"soft_delete_retention_days": 3, "clamp_soft_delete_retention": true}, check: retention(7)},
+		{name: "clamped down", vars: map[string]interface{}{"soft_delete_retention_days": 365, "clamp_soft_delete_retention": true}, check: retention(90)},
+		{name: "in range", vars: map[string]interface{}{"soft_delete_retention_days": 30, "clamp_soft_delete_retention": true}, check: retention(30)},
+		{name: "strict", vars: map[string]interface{}{"soft_delete_retention_days": 3, "clamp_soft_delete_retention": false}, expectedError: "Set clamp_soft_delete_retention = true"},
+		{name: "prod default", vars: map[string]interface{}{"environment": "prod"}, check: retention(90)},
+		{name: "production default", vars: map[string]interface{}{"environment": "Production"}, check: retention(90)},
+		{name: "nonprod default", vars: map[string]interface{}{"environment": "dev"}, check: retention(7)},
+		{name: "explicit in prod", vars: map[string]interface{}{"environment": "prod", "soft_delete_retention_days": 30}, check: retention(30)},
+		{name: "explicit in nonprod", vars: map[string]interface{}{"environment": "dev", "soft_delete_retention_days": 90}, check: retention(90)},
+	}
+
+	MultiTenantTestRunner(t, func(t *testing.T, config TestConfig) {
+		SetupAzureAuth(t, config)
+		runPlanCases(t, config, "kv-sdr", testCases)
+	})
+}
+
+func TestKeyVaultPurgeProtectionCannotBeDisabled(t *testing.T) {
+	t.Parallel()
+
+	MultiTenantTestRunner(t, func(t *testing.T, config TestConfig) {
+		SetupAzureAuth(t, config)
+		CreateResourceGroup(t, &config)
+
+		keyVaultName := fmt.Sprintf("kv-ppg-%s", config.UniqueID)
+		keyVaultName = PurgeSoftDeletedVault(t, config, keyVaultName)
+
+		vars := baseModuleVars(config, keyVaultName)
+		vars["purge_protection_enabled"] = true
+
+		terraformOptions := BuildTerraformOptions(t, config, vars)
+		terraformOptions.NoColor = true
+
+		defer terraform.Destroy(t, terraformOptions)
+		terraform.InitAndApply(t, terraformOptions)
+
+		ValidatePurgeProtectionImmutable(t, terraformOptions)
+		assert.Equal(t, true, terraformOptions.Vars["purge_protection_enabled"], "the deployed setting should be restored for the deferred destroy")
+	})
+}
+
+func TestKeyVaultProductionPurgeProtection(t *testing.T) {
+	t.Parallel()
+
+	const warning = "purge_protection_enabled = false is ignored in the"
+	purge := func(expected bool) func(t *testing.T, plan *terraform.PlanStruct) {
+		return plannedVaultAttribute("purge_protection_enabled", expected)
+	}
+	unset := []string{"purge_protection_enabled"}
+
+	testCases := []planCase{
+		{name: "prod default", unset: unset, vars: map[string]interface{}{"environment": "prod"}, check: purge(true), warning: warning, warned: false},
+		{name: "prod forced", unset: unset, vars: map[string]interface{}{"environment": "prod", "purge_protection_enabled": false}, check: purge(true), warning: warning, warned: true},
+		{name: "production forced", unset: unset, vars: map[string]interface{}{"environment": "Production", "purge_protection_enabled": false}, check: purge(true), warning: warning, warned: true},
+		{name: "dev default", unset: unset, vars: map[string]interface{}{"environment": "dev"}, check: purge(false), warning: warning, warned: false},
+		{name: "dev opt out", unset: unset, vars: map[string]interface{}{"environment": "dev", "purge_protection_enabled": false}, check: purge(false), warning: warning, warned: false},
+		{name: "dev opt in", unset: unset, vars: map[string]interface{}{"environment": "dev", "purge_protection_enabled": true}, check: purge(true), warning: warning, warned: false},
+	}
+
+	MultiTenantTestRunner(t, func(t *testing.T, config TestConfig) {
+		SetupAzureAuth(t, config)
+		runPlanCases(t, config, "kv-ppe", testCases)
+	})
+}
+
+func TestKeyVaultPurgeOnDestroy(t *testing.T) {
+	t.Parallel()
+
+	MultiTenantTestRunner(t, func(t *testing.T, config TestConfig) {
+		SetupAzureAuth(t, config)
+		CreateResourceGroup(t, &config)
+
+		// The fixture turns off the provider's own purge on destroy, so only
+		// the module can have purged the vault.
+		fixtureDir := test_structure.CopyTerraformFolderToTemp(t, "..", "test/fixtures/purge_on_destroy")
+		keyVaultName := PurgeSoftDeletedVault(t, config, fmt.Sprintf("kv-pod-%s", config.UniqueID))
+
+		terraformOptions := BuildTerraformOptions(t, config, map[string]interface{}{
+			"key_vault_name":      keyVaultName,
+			"location":            config.Region,
+			"resource_group_name": config.ResourceGroupName(),
+		}, WithTerraformDir(fixtureDir))
+
+		destroyed := false
+		defer func() {
+			if !destroyed {
+				terraform.Destroy(t, terraformOptions)
+			}
+		}()
+		terraform.InitAndApply(t, terraformOptions)
+		require.NotEmpty(t, terraform.Output(t, terraformOptions, "key_vault_id"))
+
+		_, err := terraform.DestroyE(t, terraformOptions)
+		destroyed = err == nil
+		require.NoError(t, err)
+
+		assert.Nil(t, getDeletedVault(t, config, keyVaultName), "Key Vault %s should have been purged on destroy", keyVaultName)
+	})
+}
+
+func TestKeyVaultRecoverSoftDeleted(t *testing.T) {
+	t.Parallel()
+
+	MultiTenantTestRunner(t, func(t *testing.T, config TestConfig) {
+		SetupAzureAuth(t, config)
+		CreateResourceGroup(t, &config)
+
+		// The fixture soft-deletes on destroy and recovers on create, so the
+		// second apply reuses the name of the vault the first destroy deleted.
+		fixtureDir := test_structure.CopyTerraformFolderToTemp(t, "..", "test/fixtures/recover_soft_deleted")
+		keyVaultName := PurgeSoftDeletedVault(t, config, fmt.Sprintf("kv-rec-%s", config.UniqueID))
+
+		terraformOptions := BuildTerraformOptions(t, config, map[string]interface{}{
+			"key_vault_name":      keyVaultName,
+			"location":            config.Region,
+			"resource_group_name": config.ResourceGroupName(),
+		}, WithTerraformDir(fixtureDir))
+
+		defer func() {
+			terraform.Destroy(t, terraformOptions)
+			PurgeSoftDeletedVault(t, config, keyVaultName)
+		}()
+		terraform.InitAndApply(t, terraformOptions)
+		keyVaultID := terraform.Output(t, terraformOptions, "key_vault_id")
+
+		terraform.Destroy(t, terraformOptions)
+		require.NotNil(t, getDeletedVault(t, config, keyVaultName), "Key Vault %s should be soft-deleted after destroy", keyVaultName)
+
+		terraform.Apply(t, terraformOptions)
+		assert.Equal(t, keyVaultID, terraform.Output(t, terraformOptions, "key_vault_id"))
+		assert.Equal(t, keyVaultName, terraform.Output(t, terraformOptions, "key_vault_name"))
+		assert.Nil(t, getDeletedVault(t, config, keyVaultName), "Key Vault %s should have been recovered", keyVaultName)
+
+		WaitForVaultReady(t, config, keyVaultName, 5*time.Minute)
+	})
+}
+
+func TestKeyVaultCreateModeDefault(t *testing.T) {
+	t.Parallel()
+
+	MultiTenantTestRunner(t, func(t *testing.T, config TestConfig) {
+		SetupAzureAuth(t, config)
+		CreateResourceGroup(t, &config)
+
+		keyVaultName := PurgeSoftDeletedVault(t, config, fmt.Sprintf("kv-cm-%s", config.UniqueID))
+		terraformOptions := BuildTerraformOptions(t, config, baseModuleVars(config, keyVaultName))
+
+		defer terraform.Destroy(t, terraformOptions)
+		terraform.InitAndApply(t, terraformOptions)
+
+		AssertVaultCreateMode(t, config, keyVaultName, "default")
+	}, PlanOnlyOptions(planOnlyModuleOptions("kv-cm")))
+}
+
+func TestKeyVaultReapplyIsNonDestructive(t *testing.T) {
+	t.Parallel()
+
+	MultiTenantTestRunner(t, func(t *testing.T, config TestConfig) {
+		SetupAzureAuth(t, config)
+		CreateResourceGroup(t, &config)
+
+		keyVaultName := PurgeSoftDeletedVault(t, config, fmt.Sprintf("kv-rap-%s", config.UniqueID))
+		terraformOptions := BuildTerraformOptions(t, config, baseModuleVars(config, keyVaultName))
+
+		defer terraform.Destroy(t, terraformOptions)
+		terraform.InitAndApply(t, terraformOptions)
+
+		// The CreatedDate tag is recomputed on every plan, so only destroys
+		// and replacements are ruled out
+		AssertNoDestroyOnReapply(t, terraformOptions)
+	})
+}
+
+func TestKeyVaultUpgradeMovesUncountedVault(t *testing.T) {
+	t.Parallel()
+
+	MultiTenantTestRunner(t, func(t *testing.T, config TestConfig) {
+		SetupAzureAuth(t, config)
+		CreateResourceGroup(t, &config)
+
+		keyVaultName := PurgeSoftDeletedVault(t, config, fmt.Sprintf("kv-mvd-%s", config.UniqueID))
+		terraformOptions := BuildTerraformOptions(t, config, baseModuleVars(config, keyVaultName))
+
+		defer terraform.Destroy(t, terraformOptions)
+		terraform.InitAndApply(t, terraformOptions)
+
+		// Put the vault where versions without count kept it; the moved
+		// block must bring it back instead of replacing it
+		terraform.RunTerraformCommand(t, terraformOptions, "state", "mv", "azurerm_key_vault.this[0]", "azurerm_key_vault.this")
+		AssertNoDestroyOnReapply(t, terraformOptions)
+	})
+}
+
+func TestKeyVaultCreateBeforeDestroy(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name            string
+		lifecycle       bool
+		expectedAddress string
+		createFirst     bool
+	}{
+		{name: "off", lifecycle: false, expectedAddress: "azurerm_key_vault.this[0]", createFirst: false},
+		{name: "on", lifecycle: true, expectedAddress: "azurerm_key_vault.this_create_before_destroy[0]", createFirst: true},
+	}
+
+	MultiTenantTestRunner(t, func(t *testing.T, config TestConfig) {
+		SetupAzureAuth(t, config)
+
+		// Without use_random_suffix the flag is ignored, since a replacement
+		// would collide with the old vault's name
+		runPlanCases(t, config, "kv-cbd", []planCase{{
+			name:    "without random suffix",
+			vars:    map[string]interface{}{"lifecycle_create_before_destroy": true},
+			warning: "lifecycle_create_before_destroy is ignored without use_random_suffix",
+			warned:  true,
+			check: func(t *testing.T, plan *terraform.PlanStruct) {
+				assert.Contains(t, plan.ResourcePlannedValuesMap, "azurerm_key_vault.this[0]")
+				assert.NotContains(t, plan.ResourcePlannedValuesMap, "azurerm_key_vault.this_create_before_destroy[0]")
+			},
+		}})
+
+		CreateResourceGroup(t, &config)
+
+		for _, tc := range testCases {
+			tc := tc
+			t.Run(tc.name, func(t *testing.T) {
+				vars := baseModuleVars(config, fmt.Sprintf("kv-cbd%s-%s", tc.name, config.UniqueID))
+				vars["use_random_suffix"] = true
+				vars["lifecycle_create_before_destroy"] = tc.lifecycle
+				terraformOptions := BuildTerraformOptions(t, config, vars)
+
+				defer terraform.Destroy(t, terraformOptions)
+				terraform.InitAndApply(t, terraformOptions)
+
+				// A rename gets a fresh suffix, so the replacement has a name
+				// of its own and can be created before the old vault is
+				// destroyed when the flag is on
+				planOptions, err := terraformOptions.Clone()
+				require.NoError(t, err)
+				planOptions.Vars["custom_name"] = fmt.Sprintf("kv-cbr%s-%s", tc.name, config.UniqueID)
+				planOptions.PlanFilePath = filepath.Join(t.TempDir(), "rename.out")
+
+				plan := terraform.InitAndPlanAndShowWithStruct(t, planOptions)
+				change, ok := plan.ResourceChangesMap[tc.expectedAddress]
+				require.True(t, ok, "renaming should change %s", tc.expectedAddress)
+				if tc.createFirst {
+					assert.True(t, change.Change.Actions.CreateBeforeDestroy(), "the renamed vault should be created before the old one is destroyed, got %v", change.Change.Actions)
+				} else {
+					assert.True(t, change.Change.Actions.DestroyBeforeCreate(), "the old vault should be destroyed before the renamed one is created, got %v", change.Change.Actions)
+				}
+			})
+		}
+	})
+}
+
+func TestKeyVaultResourceLock(t *testing.T) {
+	t.Parallel()
+
+	MultiTenantTestRunner(t, func(t *testing.T, config TestConfig) {
+		SetupAzureAuth(t, config)
+		CreateResourceGroup(t, &config)
+
+		keyVaultName := fmt.Sprintf("kv-lock-%s", config.UniqueID)
+		keyVaultName = PurgeSoftDeletedVault(t, config, keyVaultName)
+		lockName := fmt.Sprintf("%s-lock", keyVaultName)
+
+		// A vault-scoped role assignment makes destroy depend on the lock
+		// being removed first.
+		vars := baseModuleVars(config, keyVaultName)
+		vars["enable_resource_lock"] = true
+		vars["resource_lock_level"] = "CanNotDelete"
+		vars["resource_lock_name"] = lockName
+		vars["rbac_secrets_users"] = []string{currentPrincipalObjectID(t, azureCredential(t))}
+
+		terraformOptions := BuildTerraformOptions(t, config, vars)
+
+		destroyed := false
+		defer func() {
+			if !destroyed {
+				SafeDestroy(t, terraformOptions)
+			}
+		}()
+		terraform.InitAndApply(t, terraformOptions)
+
+		require.NotEmpty(t, terraform.Output(t, terraformOptions, "resource_lock_id"))
+
+		lock := getManagementLock(t, config, terraform.Output(t, terraformOptions, "key_vault_id"), lockName)
+		assert.Equal(t, armlocks.LockLevelCanNotDelete, *lock.Properties.Level)
+
+		_, err := terraform.DestroyE(t, terraformOptions)
+		destroyed = err == nil
+		require.NoError(t, err, "destroy should remove the lock before the resources it protects")
+	})
+}
@@ -0,0 +1,93 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestMetrics is what one test run records for trend dashboards: how long
+// the deployment took, how many resources it holds and which compliance
+// rules it failed. ComplianceChecks is the number of rules evaluated, so a
+// pass rate can be derived from Violations.
+type TestMetrics struct {
+	Tenant           string
+	Duration         time.Duration
+	ResourceCount    int
+	ComplianceChecks int
+	Violations       []Violation
+}
+
+// testMetricsLine is the JSON line EmitTestMetrics appends.
+type testMetricsLine struct {
+	Timestamp        time.Time          `json:"timestamp"`
+	Test             string             `json:"test"`
+	Tenant           string             `json:"tenant"`
+	DurationSeconds  float64            `json:"duration_seconds"`
+	ResourceCount    int                `json:"resource_count"`
+	ComplianceChecks int                `json:"compliance_checks"`
+	Violations       []metricsViolation `json:"violations"`
+}
+
+type metricsViolation struct {
+	Rule     string `json:"rule"`
+	Severity string `json:"severity"`
+	Reason   string `json:"reason"`
+}
+
+// metricsFileMu serializes appends from parallel tests, so lines never
+// interleave.
+var metricsFileMu sync.Mutex
+
+// EmitTestMetrics appends metrics to the JSON Lines file at path as one line
+// with the current time and t's name, creating the file and its directory
+// if needed. An empty path disables it.
+func EmitTestMetrics(t *testing.T, metrics TestMetrics, path string) {
+	t.Helper()
+
+	if path == "" {
+		return
+	}
+	require.NoError(t, appendTestMetrics(path, t.Name(), metrics, time.Now()), "failed to write test metrics to %s", path)
+}
+
+func appendTestMetrics(path string, test string, metrics TestMetrics, now time.Time) error {
+	violations := make([]metricsViolation, 0, len(metrics.Violations))
+	for _, v := range metrics.Violations {
+		violations = append(violations, metricsViolation{Rule: v.Rule, Severity: v.Severity.String(), Reason: v.Reason})
+	}
+	line, err := json.Marshal(testMetricsLine{
+		Timestamp:        now.UTC(),
+		Test:             test,
+		Tenant:           metrics.Tenant,
+		DurationSeconds:  metrics.Duration.Seconds(),
+		ResourceCount:    metrics.ResourceCount,
+		ComplianceChecks: metrics.ComplianceChecks,
+		Violations:       violations,
+	})
+	if err != nil {
+		return err
+	}
+
+	metricsFileMu.Lock()
+	defer metricsFileMu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to append to %s: %w", path, err)
+	}
+	return f.Close()
+}
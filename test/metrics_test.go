@@ -0,0 +1,59 @@
+package test
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAppendTestMetrics(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "metrics", "runs.jsonl")
+	now := time.Date(2024, 5, 1, 12, 30, 0, 0, time.FixedZone("CEST", 2*60*60))
+
+	require.NoError(t, appendTestMetrics(path, "TestKeyVaultBasic/primary", TestMetrics{
+		Tenant:           "primary",
+		Duration:         95500 * time.Millisecond,
+		ResourceCount:    3,
+		ComplianceChecks: 4,
+		Violations: []Violation{
+			{Rule: "purge-protection", Severity: SeverityCritical, Reason: "purge protection is disabled"},
+		},
+	}, now))
+	require.NoError(t, appendTestMetrics(path, "TestKeyVaultBasic/secondary", TestMetrics{Tenant: "secondary"}, now))
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	var lines []map[string]interface{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var line map[string]interface{}
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &line), "each line should be a JSON object: %s", scanner.Text())
+		lines = append(lines, line)
+	}
+	require.NoError(t, scanner.Err())
+	require.Len(t, lines, 2, "each run should append one line")
+
+	assert.Equal(t, map[string]interface{}{
+		"timestamp":         "2024-05-01T10:30:00Z",
+		"test":              "TestKeyVaultBasic/primary",
+		"tenant":            "primary",
+		"duration_seconds":  95.5,
+		"resource_count":    float64(3),
+		"compliance_checks": float64(4),
+		"violations": []interface{}{
+			map[string]interface{}{"rule": "purge-protection", "severity": "CRITICAL", "reason": "purge protection is disabled"},
+		},
+	}, lines[0])
+	assert.Equal(t, "secondary", lines[1]["tenant"])
+	assert.Equal(t, []interface{}{}, lines[1]["violations"], "no violations should be an empty list, not null")
+}
@@ -0,0 +1,186 @@
+package test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	test_structure "github.com/gruntwork-io/terratest/modules/test-structure"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyVaultEventGrid(t *testing.T) {
+	t.Parallel()
+
+	MultiTenantTestRunner(t, func(t *testing.T, config TestConfig) {
+		SetupAzureAuth(t, config)
+		CreateResourceGroup(t, &config)
+
+		fixtureDir := test_structure.CopyTerraformFolderToTemp(t, "..", "test/fixtures/event_grid")
+		keyVaultName := PurgeSoftDeletedVault(t, config, fmt.Sprintf("kv-evg-%s", config.UniqueID))
+
+		terraformOptions := BuildTerraformOptions(t, config, map[string]interface{}{
+			"key_vault_name":      keyVaultName,
+			"location":            config.Region,
+			"resource_group_name": config.ResourceGroupName(),
+		}, WithTerraformDir(fixtureDir))
+
+		defer terraform.Destroy(t, terraformOptions)
+		terraform.InitAndApply(t, terraformOptions)
+
+		topicID := terraform.Output(t, terraformOptions, "event_grid_system_topic_id")
+		require.NotEmpty(t, topicID)
+		require.NotEmpty(t, terraform.Output(t, terraformOptions, "event_grid_subscription_id"))
+
+		topic := getResourceByID(t, config, topicID, "2022-06-15")
+		require.NotNil(t, topic.Type)
+		assert.True(t, strings.EqualFold("Microsoft.EventGrid/systemTopics", *topic.Type))
+
+		properties, ok := topic.Properties.(map[string]interface{})
+		require.True(t, ok, "system topic has no properties")
+		source, _ := properties["source"].(string)
+		assert.True(t, strings.EqualFold(terraform.Output(t, terraformOptions, "key_vault_id"), source), "system topic should be sourced from the Key Vault")
+	})
+}
+
+func TestKeyVaultEventGridManagedIdentity(t *testing.T) {
+	t.Parallel()
+
+	MultiTenantTestRunner(t, func(t *testing.T, config TestConfig) {
+		SetupAzureAuth(t, config)
+		CreateResourceGroup(t, &config)
+
+		fixtureDir := test_structure.CopyTerraformFolderToTemp(t, "..", "test/fixtures/event_grid")
+		keyVaultName := PurgeSoftDeletedVault(t, config, fmt.Sprintf("kv-evi-%s", config.UniqueID))
+
+		terraformOptions := BuildTerraformOptions(t, config, map[string]interface{}{
+			"key_vault_name":       keyVaultName,
+			"location":             config.Region,
+			"resource_group_name":  config.ResourceGroupName(),
+			"use_managed_identity": true,
+		}, WithTerraformDir(fixtureDir))
+
+		defer terraform.Destroy(t, terraformOptions)
+		terraform.InitAndApply(t, terraformOptions)
+
+		identityID := terraform.Output(t, terraformOptions, "user_assigned_identity_id")
+		require.NotEmpty(t, identityID)
+
+		subscription := getResourceByID(t, config, terraform.Output(t, terraformOptions, "event_grid_subscription_id"), "2022-06-15")
+		properties, ok := subscription.Properties.(map[string]interface{})
+		require.True(t, ok, "event subscription has no properties")
+		delivery, _ := properties["deliveryWithResourceIdentity"].(map[string]interface{})
+		require.NotNil(t, delivery, "event subscription should deliver with a managed identity")
+		identity, _ := delivery["identity"].(map[string]interface{})
+		require.NotNil(t, identity)
+		assert.Equal(t, "UserAssigned", identity["type"])
+		userAssigned, _ := identity["userAssignedIdentity"].(string)
+		assert.True(t, strings.EqualFold(identityID, userAssigned), "event subscription delivers as %q, want %q", userAssigned, identityID)
+
+		topic := getResourceByID(t, config, terraform.Output(t, terraformOptions, "event_grid_system_topic_id"), "2022-06-15")
+		require.NotNil(t, topic.Identity, "system topic should have an identity")
+		attached := false
+		for id := range topic.Identity.UserAssignedIdentities {
+			attached = attached || strings.EqualFold(id, identityID)
+		}
+		assert.True(t, attached, "system topic should have identity %s attached", identityID)
+	})
+}
+
+func TestKeyVaultAvailabilityAlert(t *testing.T) {
+	t.Parallel()
+
+	MultiTenantTestRunner(t, func(t *testing.T, config TestConfig) {
+		SetupAzureAuth(t, config)
+		CreateResourceGroup(t, &config)
+
+		fixtureDir := test_structure.CopyTerraformFolderToTemp(t, "..", "test/fixtures/availability_alert")
+		keyVaultName := PurgeSoftDeletedVault(t, config, fmt.Sprintf("kv-alr-%s", config.UniqueID))
+
+		terraformOptions := BuildTerraformOptions(t, config, map[string]interface{}{
+			"key_vault_name":      keyVaultName,
+			"location":            config.Region,
+			"resource_group_name": config.ResourceGroupName(),
+		}, WithTerraformDir(fixtureDir))
+
+		defer terraform.Destroy(t, terraformOptions)
+		terraform.InitAndApply(t, terraformOptions)
+
+		alertID := terraform.Output(t, terraformOptions, "availability_alert_id")
+		require.NotEmpty(t, alertID)
+
+		alert := getResourceByID(t, config, alertID, "2018-03-01")
+		properties, ok := alert.Properties.(map[string]interface{})
+		require.True(t, ok, "metric alert has no properties")
+
+		scopes, _ := properties["scopes"].([]interface{})
+		require.Len(t, scopes, 1)
+		scope, _ := scopes[0].(string)
+		assert.True(t, strings.EqualFold(terraform.Output(t, terraformOptions, "key_vault_id"), scope), "the alert should target the Key Vault, got %q", scope)
+
+		actions, _ := properties["actions"].([]interface{})
+		require.Len(t, actions, 1)
+		action, _ := actions[0].(map[string]interface{})
+		actionGroupID, _ := action["actionGroupId"].(string)
+		assert.True(t, strings.EqualFold(terraform.Output(t, terraformOptions, "action_group_id"), actionGroupID), "the alert should notify the supplied action group, got %q", actionGroupID)
+	})
+}
+
+func TestKeyVaultAvailabilityAlertActionGroup(t *testing.T) {
+	t.Parallel()
+
+	actionGroup := map[string]interface{}{
+		"email_receivers": map[string]interface{}{
+			"oncall": map[string]interface{}{"email_address": "oncall@example.com"},
+		},
+		"webhook_receivers": map[string]interface{}{
+			"pager": map[string]interface{}{"service_uri": "https://pager.example.com/hook", "use_common_alert_schema": false},
+		},
+	}
+
+	MultiTenantTestRunner(t, func(t *testing.T, config TestConfig) {
+		SetupAzureAuth(t, config)
+
+		keyVaultName := fmt.Sprintf("kv-agrp-%s", config.UniqueID)
+		runPlanCases(t, config, "kv-agrp", []planCase{
+			{
+				name: "created action group",
+				vars: map[string]interface{}{"create_availability_alert": true, "action_group": actionGroup},
+				check: func(t *testing.T, plan *terraform.PlanStruct) {
+					group, ok := plan.ResourcePlannedValuesMap["azurerm_monitor_action_group.this[0]"]
+					require.True(t, ok, "plan should create the action group")
+					assert.Equal(t, keyVaultName+"-alerts", group.AttributeValues["name"])
+					assert.Equal(t, keyVaultName[:12], group.AttributeValues["short_name"])
+
+					emails, _ := group.AttributeValues["email_receiver"].([]interface{})
+					require.Len(t, emails, 1)
+					email, _ := emails[0].(map[string]interface{})
+					assert.Equal(t, "oncall", email["name"])
+					assert.Equal(t, "oncall@example.com", email["email_address"])
+					assert.Equal(t, true, email["use_common_alert_schema"])
+
+					webhooks, _ := group.AttributeValues["webhook_receiver"].([]interface{})
+					require.Len(t, webhooks, 1)
+					webhook, _ := webhooks[0].(map[string]interface{})
+					assert.Equal(t, "pager", webhook["name"])
+					assert.Equal(t, "https://pager.example.com/hook", webhook["service_uri"])
+					assert.Equal(t, false, webhook["use_common_alert_schema"])
+
+					_, ok = plan.ResourcePlannedValuesMap["azurerm_monitor_metric_alert.availability[0]"]
+					assert.True(t, ok, "the availability alert should be planned with the created action group")
+				},
+			},
+			{
+				name: "conflicts with a supplied action group",
+				vars: map[string]interface{}{
+					"create_availability_alert":          true,
+					"action_group":                       actionGroup,
+					"availability_alert_action_group_id": "/subscriptions/" + config.SubscriptionID + "/resourceGroups/rg-monitoring/providers/Microsoft.Insights/actionGroups/ag-oncall",
+				},
+				expectedError: "Set either availability_alert_action_group_id or action_group, not both.",
+			},
+		})
+	})
+}
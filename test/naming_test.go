@@ -0,0 +1,211 @@
+package test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/gruntwork-io/terratest/modules/azure"
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	test_structure "github.com/gruntwork-io/terratest/modules/test-structure"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyVaultRandomSuffix(t *testing.T) {
+	t.Parallel()
+
+	MultiTenantTestRunner(t, func(t *testing.T, config TestConfig) {
+		SetupAzureAuth(t, config)
+		CreateResourceGroup(t, &config)
+
+		// Long enough to be truncated: the suffix must still fit in 24 characters.
+		baseName := fmt.Sprintf("kv-random-suffix-%s", config.UniqueID)
+		vars := baseModuleVars(config, baseName)
+		vars["use_random_suffix"] = true
+
+		names := make([]string, 2)
+		for i := range names {
+			terraformOptions := BuildTerraformOptions(t, config, vars, WithTerraformDir(test_structure.CopyTerraformFolderToTemp(t, "..", ".")))
+
+			defer terraform.Destroy(t, terraformOptions)
+			terraform.InitAndApply(t, terraformOptions)
+
+			names[i] = terraform.Output(t, terraformOptions, "key_vault_name")
+			assert.Regexp(t, `^kv-random-suffix-[a-z0-9]{6}$`, names[i])
+			assert.LessOrEqual(t, len(names[i]), 24)
+		}
+
+		assert.NotEqual(t, names[0], names[1], "two deployments of the same base name should get different suffixes")
+	})
+}
+
+func TestKeyVaultWorkloadNaming(t *testing.T) {
+	t.Parallel()
+
+	named := func(expectedName string) func(t *testing.T, plan *terraform.PlanStruct) {
+		return func(t *testing.T, plan *terraform.PlanStruct) {
+			vault, ok := plan.ResourcePlannedValuesMap["azurerm_key_vault.this[0]"]
+			require.True(t, ok, "plan should create the Key Vault")
+			assert.Equal(t, expectedName, vault.AttributeValues["name"])
+			assert.LessOrEqual(t, len(expectedName), 24)
+		}
+	}
+	unset := []string{"custom_name"}
+
+	testCases := []planCase{
+		{name: "composed", unset: unset, vars: map[string]interface{}{"name_prefix": "corp", "workload": "payments", "environment": "dev"}, check: named("corp-kv-payments-dev")},
+		{name: "with suffix", unset: unset, vars: map[string]interface{}{"name_prefix": "corp", "workload": "payments", "environment": "dev", "name_suffix": "01"}, check: named("corp-kv-payments-dev-01")},
+		{name: "truncated", unset: unset, vars: map[string]interface{}{"name_prefix": "corp", "workload": "customeridentityplatform", "environment": "prod"}, check: named("corp-kv-customeride-prod")},
+		{name: "truncated before hyphen", unset: unset, vars: map[string]interface{}{"name_prefix": "corp", "workload": "customer-identity", "environment": "preprd"}, check: named("corp-kv-customer-preprd")},
+		{name: "explicit name wins", unset: unset, vars: map[string]interface{}{"name_prefix": "corp", "workload": "payments", "environment": "dev", "key_vault_name": "kv-payments-legacy"}, check: named("kv-payments-legacy")},
+	}
+
+	MultiTenantTestRunner(t, func(t *testing.T, config TestConfig) {
+		SetupAzureAuth(t, config)
+		runPlanCases(t, config, "kv-name", testCases)
+	})
+}
+
+func TestKeyVaultCloudSuffix(t *testing.T) {
+	t.Parallel()
+
+	suffixes := map[string]string{
+		EnvironmentPublic:       ".vault.azure.net",
+		EnvironmentUSGovernment: ".vault.usgovcloudapi.net",
+		EnvironmentChina:        ".vault.azure.cn",
+	}
+
+	MultiTenantTestRunner(t, func(t *testing.T, config TestConfig) {
+		SetupAzureAuth(t, config)
+		CreateResourceGroup(t, &config)
+
+		cloud := moduleCloudEnvironment(config)
+		// The expectation the tenant's cloud must fail: gov for the public
+		// cloud, public for the sovereign ones
+		wrongCloud := EnvironmentUSGovernment
+		if cloud != EnvironmentPublic {
+			wrongCloud = EnvironmentPublic
+		}
+
+		t.Run("matching cloud", func(t *testing.T) {
+			keyVaultName := PurgeSoftDeletedVault(t, config, fmt.Sprintf("kv-sfx-%s", config.UniqueID))
+			terraformOptions := BuildTerraformOptions(t, config, baseModuleVars(config, keyVaultName))
+
+			defer terraform.Destroy(t, terraformOptions)
+			terraform.InitAndApply(t, terraformOptions)
+
+			uri := terraform.Output(t, terraformOptions, "key_vault_uri")
+			assert.True(t, strings.HasSuffix(strings.TrimSuffix(uri, "/"), suffixes[cloud]), "vault URI %s should end in %s", uri, suffixes[cloud])
+		})
+
+		t.Run("wrong cloud", func(t *testing.T) {
+			keyVaultName := PurgeSoftDeletedVault(t, config, fmt.Sprintf("kv-sfxw-%s", config.UniqueID))
+			vars := baseModuleVars(config, keyVaultName)
+			vars["cloud_environment"] = wrongCloud
+
+			AssertApplyFails(t, BuildTerraformOptions(t, config, vars), fmt.Sprintf("which does not end in %s as expected for cloud_environment = \"%s\"", suffixes[wrongCloud], wrongCloud))
+		})
+	})
+}
+
+func TestKeyVaultCustomDNSSuffix(t *testing.T) {
+	t.Parallel()
+
+	MultiTenantTestRunner(t, func(t *testing.T, config TestConfig) {
+		SetupAzureAuth(t, config)
+
+		keyVaultName := fmt.Sprintf("kv-dns-%s", config.UniqueID)
+		suffixed := func(expectedURI string) func(t *testing.T, plan *terraform.PlanStruct) {
+			return func(t *testing.T, plan *terraform.PlanStruct) {
+				require.NotNil(t, plan.RawPlan.PlannedValues)
+				for _, name := range []string{"key_vault_uri", "vault_uri"} {
+					output, ok := plan.RawPlan.PlannedValues.Outputs[name]
+					require.True(t, ok, "plan should know the %s output", name)
+					assert.Equal(t, fmt.Sprintf(expectedURI, keyVaultName), output.Value)
+				}
+			}
+		}
+
+		runPlanCases(t, config, "kv-dns", []planCase{
+			{
+				name: "cloud default",
+				vars: map[string]interface{}{"cloud_environment": "public"},
+				check: func(t *testing.T, plan *terraform.PlanStruct) {
+					require.NotNil(t, plan.RawPlan.PlannedValues)
+					// The provider's URI is only known after apply
+					output, ok := plan.RawPlan.PlannedValues.Outputs["key_vault_uri"]
+					assert.True(t, !ok || output.Value == nil, "the cloud default URI should come from the provider")
+				},
+			},
+			{name: "azure stack suffix", vars: map[string]interface{}{"cloud_environment": "public", "vault_dns_suffix": "vault.local.azurestack.external"}, check: suffixed("https://%s.vault.local.azurestack.external/")},
+			{name: "scheme rejected", vars: map[string]interface{}{"cloud_environment": "public", "vault_dns_suffix": "https://vault.local.azurestack.external"}, expectedError: "vault_dns_suffix must be a lowercase domain name"},
+			{name: "leading dot rejected", vars: map[string]interface{}{"cloud_environment": "public", "vault_dns_suffix": ".vault.local.azurestack.external"}, expectedError: "vault_dns_suffix must be a lowercase domain name"},
+		})
+	})
+}
+
+func TestKeyVaultTags(t *testing.T) {
+	t.Parallel()
+
+	MultiTenantTestRunner(t, func(t *testing.T, config TestConfig) {
+		SetupAzureAuth(t, config)
+		CreateResourceGroup(t, &config)
+
+		keyVaultName := fmt.Sprintf("kv-tag-%s", config.UniqueID)
+		keyVaultName = PurgeSoftDeletedVault(t, config, keyVaultName)
+
+		vars := baseModuleVars(config, keyVaultName)
+		vars["tags"] = map[string]string{
+			"CostCenter": "kv-test",
+			"ManagedBy":  "someone-else",
+		}
+
+		terraformOptions := BuildTerraformOptions(t, config, vars)
+
+		defer terraform.Destroy(t, terraformOptions)
+		terraform.InitAndApply(t, terraformOptions)
+
+		commonTags := terraform.OutputMap(t, terraformOptions, "common_tags")
+		assert.Equal(t, "kv-test", commonTags["CostCenter"])
+		assert.Equal(t, "Terraform", commonTags["ManagedBy"])
+
+		keyVault := azure.GetKeyVault(t, config.ResourceGroupName(), keyVaultName, config.SubscriptionID)
+		require.NotNil(t, keyVault.Tags["CostCenter"])
+		assert.Equal(t, "kv-test", *keyVault.Tags["CostCenter"])
+		require.NotNil(t, keyVault.Tags["ManagedBy"])
+		assert.Equal(t, "Terraform", *keyVault.Tags["ManagedBy"])
+	})
+}
+
+func TestKeyVaultProvenanceTags(t *testing.T) {
+	t.Parallel()
+
+	MultiTenantTestRunner(t, func(t *testing.T, config TestConfig) {
+		SetupAzureAuth(t, config)
+		CreateResourceGroup(t, &config)
+
+		keyVaultName := PurgeSoftDeletedVault(t, config, fmt.Sprintf("kv-prv-%s", config.UniqueID))
+		vars := baseModuleVars(config, keyVaultName)
+		vars["pipeline_run_id"] = "run-" + config.UniqueID
+		vars["tags"] = map[string]string{"CreatedByObjectId": "spoofed"}
+
+		terraformOptions := BuildTerraformOptions(t, config, vars)
+
+		defer terraform.Destroy(t, terraformOptions)
+		terraform.InitAndApply(t, terraformOptions)
+
+		tags := GetVaultView(t, config, config.ResourceGroupName(), keyVaultName).Tags
+		assert.Equal(t, currentPrincipalObjectID(t, azureCredential(t)), tags["CreatedByObjectId"], "caller tags should not override provenance")
+		assert.Equal(t, "run-"+config.UniqueID, tags["PipelineRunId"])
+
+		// Opting out drops both tags on the next apply
+		terraformOptions.Vars["enable_provenance_tags"] = false
+		delete(terraformOptions.Vars, "tags")
+		terraform.Apply(t, terraformOptions)
+
+		tags = GetVaultView(t, config, config.ResourceGroupName(), keyVaultName).Tags
+		assert.NotContains(t, tags, "CreatedByObjectId")
+		assert.NotContains(t, tags, "PipelineRunId")
+	})
+}
@@ -0,0 +1,342 @@
+package test
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gruntwork-io/terratest/modules/random"
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	test_structure "github.com/gruntwork-io/terratest/modules/test-structure"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyVaultNetworkACLIPRules(t *testing.T) {
+	t.Parallel()
+
+	MultiTenantTestRunner(t, func(t *testing.T, config TestConfig) {
+		SetupAzureAuth(t, config)
+		CreateResourceGroup(t, &config)
+
+		keyVaultName := fmt.Sprintf("kv-ip-%s", config.UniqueID)
+		keyVaultName = PurgeSoftDeletedVault(t, config, keyVaultName)
+
+		vars := baseModuleVars(config, keyVaultName)
+		vars["network_acls_default_action"] = "Deny"
+		vars["network_acls_ip_rules"] = []string{"198.51.100.7", "2001:db8:1234::/48"}
+
+		terraformOptions := BuildTerraformOptions(t, config, vars)
+
+		defer terraform.Destroy(t, terraformOptions)
+		terraform.InitAndApply(t, terraformOptions)
+
+		keyVault := getDeployedVault(t, terraformOptions)
+		require.NotNil(t, keyVault.Properties)
+		require.NotNil(t, keyVault.Properties.NetworkACLs)
+
+		ipRules := []string{}
+		for _, rule := range keyVault.Properties.NetworkACLs.IPRules {
+			ipRules = append(ipRules, stringValue(rule.Value))
+		}
+		assert.ElementsMatch(t, []string{"198.51.100.7/32", "2001:db8:1234::/48"}, ipRules)
+	})
+}
+
+// TestKeyVaultNetworkACLIPRuleForms plans IP rules written in forms Azure
+// stores differently and checks they are accepted and sent canonically.
+func TestKeyVaultNetworkACLIPRuleForms(t *testing.T) {
+	t.Parallel()
+
+	ipRules := func(expected ...string) func(t *testing.T, plan *terraform.PlanStruct) {
+		return func(t *testing.T, plan *terraform.PlanStruct) {
+			vault, ok := plan.ResourcePlannedValuesMap["azurerm_key_vault.this[0]"]
+			require.True(t, ok, "plan should create the Key Vault")
+			networkACLs, _ := vault.AttributeValues["network_acls"].([]interface{})
+			require.Len(t, networkACLs, 1)
+			acls, _ := networkACLs[0].(map[string]interface{})
+			assert.ElementsMatch(t, expected, acls["ip_rules"])
+		}
+	}
+	rules := func(rules ...string) map[string]interface{} {
+		return map[string]interface{}{"network_acls_default_action": "Deny", "network_acls_ip_rules": rules}
+	}
+
+	testCases := []planCase{
+		{name: "ipv4 address", vars: rules("198.51.100.7"), check: ipRules("198.51.100.7/32")},
+		{name: "ipv6 range", vars: rules("2001:db8:1234::/48"), check: ipRules("2001:db8:1234::/48")},
+		{name: "uppercase ipv6 range", vars: rules("2001:DB8:1234::/48"), check: ipRules("2001:db8:1234::/48")},
+		{name: "uncompressed ipv6 range", vars: rules("2001:db8:0::/48"), check: ipRules("2001:db8::/48")},
+		{name: "uppercase ipv6 address", vars: rules("2001:DB8::7"), check: ipRules("2001:db8::7/128")},
+		{name: "ipv6 range with host bits", vars: rules("2001:DB8::1/48"), expectedError: "without host bits set"},
+	}
+
+	MultiTenantTestRunner(t, func(t *testing.T, config TestConfig) {
+		SetupAzureAuth(t, config)
+		runPlanCases(t, config, "kv-ipf", testCases)
+	})
+}
+
+func TestKeyVaultPublicAccessCombinations(t *testing.T) {
+	t.Parallel()
+
+	subnetIDs := []string{"/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/rg-net/providers/Microsoft.Network/virtualNetworks/vnet/subnets/apps"}
+	access := func(publicAccess bool, defaultAction string, ipRules []string) map[string]interface{} {
+		return map[string]interface{}{
+			"public_network_access_enabled": publicAccess,
+			"network_acls_default_action":   defaultAction,
+			"network_acls_ip_rules":         ipRules,
+		}
+	}
+	planned := func(publicAccess bool, expectedDefaultAction string, expectedSubnetIDs []string) func(t *testing.T, plan *terraform.PlanStruct) {
+		return func(t *testing.T, plan *terraform.PlanStruct) {
+			vault, ok := plan.ResourcePlannedValuesMap["azurerm_key_vault.this[0]"]
+			require.True(t, ok, "plan should create the Key Vault")
+			assert.Equal(t, publicAccess, vault.AttributeValues["public_network_access_enabled"])
+
+			networkACLs, _ := vault.AttributeValues["network_acls"].([]interface{})
+			require.Len(t, networkACLs, 1)
+			acls, _ := networkACLs[0].(map[string]interface{})
+			assert.Equal(t, expectedDefaultAction, acls["default_action"])
+			if expectedSubnetIDs != nil {
+				assert.ElementsMatch(t, expectedSubnetIDs, acls["virtual_network_subnet_ids"])
+			}
+		}
+	}
+	privateWithSubnets := access(false, "Deny", []string{})
+	privateWithSubnets["network_acls_subnet_ids"] = subnetIDs
+
+	testCases := []planCase{
+		{name: "private only", vars: access(false, "Deny", []string{}), check: planned(false, "Deny", nil)},
+		{name: "private with ip rules", vars: access(false, "Deny", []string{"203.0.113.0/24"}), expectedError: "network_acls_ip_rules only filter public network access"},
+		{name: "private with subnet rules", vars: privateWithSubnets, check: planned(false, "Deny", subnetIDs)},
+		{name: "public firewalled", vars: access(true, "Allow", []string{"203.0.113.0/24"}), check: planned(true, "Deny", nil)},
+		{name: "public open", vars: access(true, "Allow", []string{}), check: planned(true, "Allow", nil)},
+	}
+
+	MultiTenantTestRunner(t, func(t *testing.T, config TestConfig) {
+		SetupAzureAuth(t, config)
+		runPlanCases(t, config, "kv-pna", testCases)
+	})
+}
+
+func TestKeyVaultBootstrapPublicAccess(t *testing.T) {
+	t.Parallel()
+
+	MultiTenantTestRunner(t, func(t *testing.T, config TestConfig) {
+		SetupAzureAuth(t, config)
+		CreateResourceGroup(t, &config)
+
+		keyVaultName := PurgeSoftDeletedVault(t, config, fmt.Sprintf("kv-boot-%s", config.UniqueID))
+		vars := baseModuleVars(config, keyVaultName)
+		vars["public_network_access_enabled"] = false
+		vars["network_acls_default_action"] = "Deny"
+		vars["bootstrap_public_access"] = true
+		vars["rbac_secrets_officers"] = []string{currentPrincipalObjectID(t, azureCredential(t))}
+
+		terraformOptions := BuildTerraformOptions(t, config, vars)
+
+		defer terraform.Destroy(t, terraformOptions)
+		terraform.InitAndApply(t, terraformOptions)
+
+		bootstrapped := GetVaultView(t, config, config.ResourceGroupName(), keyVaultName)
+		require.True(t, bootstrapped.PublicNetworkAccessEnabled, "bootstrap_public_access should open the vault")
+		assert.Equal(t, "Allow", bootstrapped.NetworkDefaultAction)
+
+		WaitForVaultReady(t, config, keyVaultName, 5*time.Minute)
+		SeedSecrets(t, config, keyVaultName, map[string]string{"bootstrap-seeded": random.UniqueId()}, 1)
+
+		LockDownVault(t, terraformOptions)
+
+		locked := GetVaultView(t, config, config.ResourceGroupName(), keyVaultName)
+		assert.False(t, locked.PublicNetworkAccessEnabled, "public access should be Disabled after LockDownVault")
+		assert.Equal(t, "Deny", locked.NetworkDefaultAction)
+	})
+}
+
+func TestKeyVaultPrivateEndpoint(t *testing.T) {
+	t.Parallel()
+
+	MultiTenantTestRunner(t, func(t *testing.T, config TestConfig) {
+		SetupAzureAuth(t, config)
+		CreateResourceGroup(t, &config)
+
+		// The fixture references the module by relative path, so copy the
+		// whole repository to keep that path valid in the temp folder.
+		fixtureDir := test_structure.CopyTerraformFolderToTemp(t, "..", "test/fixtures/private_endpoint")
+		keyVaultName := PurgeSoftDeletedVault(t, config, fmt.Sprintf("kv-pe-%s", config.UniqueID))
+
+		terraformOptions := BuildTerraformOptions(t, config, map[string]interface{}{
+			"key_vault_name":      keyVaultName,
+			"location":            config.Region,
+			"resource_group_name": fmt.Sprintf("%s-%s", config.ResourceGroup, config.UniqueID),
+		}, WithTerraformDir(fixtureDir))
+
+		defer terraform.Destroy(t, terraformOptions)
+		terraform.InitAndApply(t, terraformOptions)
+
+		assert.NotEmpty(t, terraform.Output(t, terraformOptions, "private_endpoint_id"))
+
+		privateIP := net.ParseIP(terraform.Output(t, terraformOptions, "private_endpoint_ip_address"))
+		require.NotNil(t, privateIP, "private endpoint NIC should have a private IP")
+
+		_, subnet, err := net.ParseCIDR(terraform.Output(t, terraformOptions, "private_endpoint_subnet_cidr"))
+		require.NoError(t, err)
+		assert.True(t, subnet.Contains(privateIP), "private IP %s should be inside %s", privateIP, subnet)
+
+		assert.Equal(t, keyVaultName, terraform.Output(t, terraformOptions, "key_vault_name"))
+		assert.True(t, strings.HasPrefix(terraform.Output(t, terraformOptions, "private_endpoint_fqdn"), keyVaultName))
+	})
+}
+
+func TestKeyVaultPrivateDNSZoneGroup(t *testing.T) {
+	t.Parallel()
+
+	MultiTenantTestRunner(t, func(t *testing.T, config TestConfig) {
+		SetupAzureAuth(t, config)
+		CreateResourceGroup(t, &config)
+
+		fixtureDir := test_structure.CopyTerraformFolderToTemp(t, "..", "test/fixtures/private_endpoint")
+		keyVaultName := PurgeSoftDeletedVault(t, config, fmt.Sprintf("kv-pdz-%s", config.UniqueID))
+
+		terraformOptions := BuildTerraformOptions(t, config, map[string]interface{}{
+			"key_vault_name":                  keyVaultName,
+			"location":                        config.Region,
+			"resource_group_name":             fmt.Sprintf("%s-%s", config.ResourceGroup, config.UniqueID),
+			"secondary_private_dns_zone_name": "secondary.privatelink.vaultcore.azure.net",
+		}, WithTerraformDir(fixtureDir))
+
+		defer terraform.Destroy(t, terraformOptions)
+		terraform.InitAndApply(t, terraformOptions)
+
+		zoneIDs := terraform.OutputList(t, terraformOptions, "private_dns_zone_ids")
+		require.Len(t, zoneIDs, 2)
+
+		configs := terraform.OutputMap(t, terraformOptions, "private_dns_zone_configs")
+		assert.Len(t, configs, 2, "the DNS zone group should hold one zone config per zone")
+		for _, zoneID := range zoneIDs {
+			found := false
+			for _, configZoneID := range configs {
+				found = found || strings.EqualFold(zoneID, configZoneID)
+			}
+			assert.True(t, found, "zone %s should be in the private endpoint's DNS zone group", zoneID)
+		}
+	})
+}
+
+func TestKeyVaultPrivateDNSZoneLookup(t *testing.T) {
+	t.Parallel()
+
+	MultiTenantTestRunner(t, func(t *testing.T, config TestConfig) {
+		SetupAzureAuth(t, config)
+
+		runPlanCases(t, config, "kv-pdn", []planCase{{
+			name: "NameAndIDConflict",
+			vars: map[string]interface{}{
+				"enable_private_endpoint":         true,
+				"private_endpoint_subnet_id":      "/subscriptions/x/resourceGroups/rg-net/providers/Microsoft.Network/virtualNetworks/vnet/subnets/snet-pe",
+				"private_dns_zone_ids":            []string{"/subscriptions/x/resourceGroups/rg-dns/providers/Microsoft.Network/privateDnsZones/privatelink.vaultcore.azure.net"},
+				"private_dns_zone_name":           "privatelink.vaultcore.azure.net",
+				"private_dns_zone_resource_group": "rg-dns",
+			},
+			expectedError: "Set either private_dns_zone_ids or private_dns_zone_name, not both",
+		}})
+
+		t.Run("LookupByName", func(t *testing.T) {
+			CreateResourceGroup(t, &config)
+
+			fixtureDir := test_structure.CopyTerraformFolderToTemp(t, "..", "test/fixtures/private_endpoint")
+			keyVaultName := PurgeSoftDeletedVault(t, config, fmt.Sprintf("kv-pdn-%s", config.UniqueID))
+
+			terraformOptions := BuildTerraformOptions(t, config, map[string]interface{}{
+				"key_vault_name":                  keyVaultName,
+				"location":                        config.Region,
+				"resource_group_name":             config.ResourceGroupName(),
+				"lookup_private_dns_zone_by_name": true,
+			}, WithTerraformDir(fixtureDir))
+
+			defer terraform.Destroy(t, terraformOptions)
+			terraform.InitAndApply(t, terraformOptions)
+
+			zoneIDs := terraform.OutputList(t, terraformOptions, "private_dns_zone_ids")
+			require.Len(t, zoneIDs, 1)
+
+			configs := terraform.OutputMap(t, terraformOptions, "private_dns_zone_configs")
+			require.Len(t, configs, 1, "the DNS zone group should hold the looked-up zone")
+			for _, configZoneID := range configs {
+				assert.True(t, strings.EqualFold(zoneIDs[0], configZoneID), "the looked-up zone %s should be in the private endpoint's DNS zone group, got %s", zoneIDs[0], configZoneID)
+			}
+		})
+	})
+}
+
+func TestKeyVaultNetworkPosture(t *testing.T) {
+	t.Parallel()
+
+	MultiTenantTestRunner(t, func(t *testing.T, config TestConfig) {
+		SetupAzureAuth(t, config)
+		CreateResourceGroup(t, &config)
+
+		fixtureDir := test_structure.CopyTerraformFolderToTemp(t, "..", "test/fixtures/private_endpoint")
+		keyVaultName := PurgeSoftDeletedVault(t, config, fmt.Sprintf("kv-net-%s", config.UniqueID))
+
+		terraformOptions := BuildTerraformOptions(t, config, map[string]interface{}{
+			"key_vault_name":                keyVaultName,
+			"location":                      config.Region,
+			"resource_group_name":           fmt.Sprintf("%s-%s", config.ResourceGroup, config.UniqueID),
+			"allow_subnet_through_firewall": true,
+		}, WithTerraformDir(fixtureDir))
+
+		defer terraform.Destroy(t, terraformOptions)
+		terraform.InitAndApply(t, terraformOptions)
+
+		var posture struct {
+			PublicNetworkAccess    bool   `json:"public_network_access"`
+			DefaultAction          string `json:"default_action"`
+			Bypass                 string `json:"bypass"`
+			IPRuleCount            int    `json:"ip_rule_count"`
+			SubnetRuleCount        int    `json:"subnet_rule_count"`
+			PrivateEndpointEnabled bool   `json:"private_endpoint_enabled"`
+		}
+		terraform.OutputStruct(t, terraformOptions, "network_posture", &posture)
+
+		assert.False(t, posture.PublicNetworkAccess)
+		assert.Equal(t, "Deny", posture.DefaultAction)
+		assert.Equal(t, "AzureServices", posture.Bypass)
+		assert.Zero(t, posture.IPRuleCount)
+		assert.Equal(t, 1, posture.SubnetRuleCount)
+		assert.True(t, posture.PrivateEndpointEnabled)
+	})
+}
+
+func TestKeyVaultPrivateEndpointApproved(t *testing.T) {
+	t.Parallel()
+
+	if os.Getenv("KV_TEST_PRIVATE_ENDPOINT_APPROVAL") == "" {
+		t.Skip("KV_TEST_PRIVATE_ENDPOINT_APPROVAL is not set; skipping the private endpoint approval check")
+	}
+
+	MultiTenantTestRunner(t, func(t *testing.T, config TestConfig) {
+		SetupAzureAuth(t, config)
+		CreateResourceGroup(t, &config)
+
+		fixtureDir := test_structure.CopyTerraformFolderToTemp(t, "..", "test/fixtures/private_endpoint")
+		keyVaultName := PurgeSoftDeletedVault(t, config, fmt.Sprintf("kv-pea-%s", config.UniqueID))
+
+		terraformOptions := BuildTerraformOptions(t, config, map[string]interface{}{
+			"key_vault_name":      keyVaultName,
+			"location":            config.Region,
+			"resource_group_name": config.ResourceGroupName(),
+		}, WithTerraformDir(fixtureDir))
+
+		defer terraform.Destroy(t, terraformOptions)
+		terraform.InitAndApply(t, terraformOptions)
+
+		// The endpoint is in the vault's subscription, so Azure approves its
+		// connection without a manual step.
+		AssertPrivateEndpointApproved(t, config, keyVaultName)
+	})
+}
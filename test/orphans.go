@@ -0,0 +1,234 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/authorization/armauthorization/v2"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/keyvault/armkeyvault"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
+	"github.com/stretchr/testify/require"
+)
+
+// AssertVaultDeleted checks that vaultName no longer exists in config's
+// resource group and returns whether it is gone. A soft-deleted vault counts
+// as deleted, since only live vaults are looked up.
+func AssertVaultDeleted(t *testing.T, config TestConfig, vaultName string) bool {
+	t.Helper()
+
+	client, err := armkeyvault.NewVaultsClient(config.SubscriptionID, azureCredential(t), armClientOptions())
+	require.NoError(t, err)
+	_, err = client.Get(context.Background(), config.ResourceGroupName(), vaultName, nil)
+	if problem := deletionProblem("Key Vault "+vaultName, err); problem != "" {
+		t.Error(problem)
+		return false
+	}
+	return true
+}
+
+// AssertResourceDeleted checks that the ARM resource resourceID, read with
+// the given API version, no longer exists and returns whether it is gone. Use
+// it for resources such as private endpoints that live outside the vault.
+func AssertResourceDeleted(t *testing.T, config TestConfig, resourceID string, apiVersion string) bool {
+	t.Helper()
+
+	client, err := armresources.NewClient(config.SubscriptionID, azureCredential(t), armClientOptions())
+	require.NoError(t, err)
+	_, err = client.GetByID(context.Background(), resourceID, apiVersion, nil)
+	if problem := deletionProblem(resourceID, err); problem != "" {
+		t.Error(problem)
+		return false
+	}
+	return true
+}
+
+// AssertNoOrphanedRoleAssignments checks that no role assignment is left
+// directly at scope, typically the ID of a destroyed vault, and returns
+// whether none are. ARM does not always remove assignments together with the
+// resource they are scoped to. Every leftover assignment is reported in a
+// single failure.
+func AssertNoOrphanedRoleAssignments(t *testing.T, config TestConfig, scope string) bool {
+	t.Helper()
+
+	cred := azureCredential(t)
+	assignments, err := armauthorization.NewRoleAssignmentsClient(config.SubscriptionID, cred, armClientOptions())
+	require.NoError(t, err)
+	definitions, err := armauthorization.NewRoleDefinitionsClient(cred, armClientOptions())
+	require.NoError(t, err)
+
+	orphans, err := findOrphanedRoleAssignments(context.Background(), armRoleAssignments{assignments, definitions}, scope)
+	require.NoError(t, err, "failed to list role assignments at %s", scope)
+	if len(orphans) > 0 {
+		t.Errorf("%d role assignment(s) left at %s:\n  %s", len(orphans), scope, strings.Join(orphans, "\n  "))
+		return false
+	}
+	return true
+}
+
+// deletionProblem describes why the lookup that returned err does not show
+// what as deleted, or returns an empty string when it does: only a 404 counts
+// as gone.
+func deletionProblem(what string, err error) string {
+	var respErr *azcore.ResponseError
+	switch {
+	case errors.As(err, &respErr) && respErr.StatusCode == http.StatusNotFound:
+		return ""
+	case err != nil:
+		return fmt.Sprintf("could not confirm %s was deleted: %v", what, err)
+	default:
+		return fmt.Sprintf("%s still exists after destroy", what)
+	}
+}
+
+// findOrphanedRoleAssignments returns one line per role assignment left at
+// scope, sorted. A scope that no longer resolves holds no assignments. A role
+// whose name cannot be resolved is shown by its definition ID.
+func findOrphanedRoleAssignments(ctx context.Context, source roleAssignmentSource, scope string) ([]string, error) {
+	assignments, err := source.listAtScope(ctx, scope)
+	var respErr *azcore.ResponseError
+	if errors.As(err, &respErr) && respErr.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var orphans []string
+	for _, assignment := range assignments {
+		props := assignment.Properties
+		if props == nil || props.PrincipalID == nil || props.RoleDefinitionID == nil {
+			continue
+		}
+		role, err := source.roleName(ctx, *props.RoleDefinitionID)
+		if err != nil || role == "" {
+			role = *props.RoleDefinitionID
+		}
+		id := "<unknown id>"
+		if assignment.ID != nil {
+			id = *assignment.ID
+		}
+		orphans = append(orphans, fmt.Sprintf("%s: %s holds %q", id, *props.PrincipalID, role))
+	}
+	sort.Strings(orphans)
+	return orphans, nil
+}
+
+// freeResourceTypes are resource types that cost nothing while they exist, so
+// AssertZeroCostResiduals tolerates them. Types are lowercased.
+var freeResourceTypes = map[string]bool{
+	"microsoft.network/virtualnetworks":                true,
+	"microsoft.network/networksecuritygroups":          true,
+	"microsoft.network/routetables":                    true,
+	"microsoft.network/networkinterfaces":              true,
+	"microsoft.managedidentity/userassignedidentities": true,
+	"microsoft.insights/actiongroups":                  true,
+}
+
+// residualSource is the subset of the ARM resources and vaults APIs used to
+// find what a teardown left behind.
+type residualSource interface {
+	// listInResourceGroup returns the resources in the group, or none when
+	// the group itself is gone.
+	listInResourceGroup(ctx context.Context, resourceGroup string) ([]*armresources.GenericResourceExpanded, error)
+	listDeletedVaults(ctx context.Context) ([]*armkeyvault.DeletedVault, error)
+}
+
+type armResidualSource struct {
+	resources *armresources.Client
+	vaults    *armkeyvault.VaultsClient
+}
+
+func (a armResidualSource) listInResourceGroup(ctx context.Context, resourceGroup string) ([]*armresources.GenericResourceExpanded, error) {
+	var result []*armresources.GenericResourceExpanded
+	pager := a.resources.NewListByResourceGroupPager(resourceGroup, nil)
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		var respErr *azcore.ResponseError
+		if errors.As(err, &respErr) && respErr.StatusCode == http.StatusNotFound {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, page.Value...)
+	}
+	return result, nil
+}
+
+func (a armResidualSource) listDeletedVaults(ctx context.Context) ([]*armkeyvault.DeletedVault, error) {
+	var result []*armkeyvault.DeletedVault
+	pager := a.vaults.NewListDeletedPager(nil)
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, page.Value...)
+	}
+	return result, nil
+}
+
+// AssertZeroCostResiduals checks, after destroy, that nothing which keeps
+// costing money is left behind: no resource in resourceGroup other than
+// freeResourceTypes, and no soft-deleted vault of the group that could still
+// be purged. Soft-deleted vaults with purge protection are not counted, as
+// nothing can remove them before their scheduled purge date. It returns
+// whether the teardown was clean; every residual is reported in a single
+// failure.
+func AssertZeroCostResiduals(t *testing.T, config TestConfig, resourceGroup string) bool {
+	t.Helper()
+
+	cred := azureCredential(t)
+	resources, err := armresources.NewClient(config.SubscriptionID, cred, armClientOptions())
+	require.NoError(t, err)
+	vaults, err := armkeyvault.NewVaultsClient(config.SubscriptionID, cred, armClientOptions())
+	require.NoError(t, err)
+
+	residuals, err := findCostResiduals(context.Background(), armResidualSource{resources, vaults}, resourceGroup)
+	require.NoError(t, err, "failed to list what is left of resource group %s", resourceGroup)
+	if len(residuals) > 0 {
+		t.Errorf("%d cost-accruing resource(s) left after destroying resource group %s:\n  %s", len(residuals), resourceGroup, strings.Join(residuals, "\n  "))
+		return false
+	}
+	return true
+}
+
+// findCostResiduals returns one line per cost-accruing resource left in
+// resourceGroup and per purgeable soft-deleted vault that lived there, sorted.
+func findCostResiduals(ctx context.Context, source residualSource, resourceGroup string) ([]string, error) {
+	resources, err := source.listInResourceGroup(ctx, resourceGroup)
+	if err != nil {
+		return nil, err
+	}
+	deleted, err := source.listDeletedVaults(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var residuals []string
+	for _, resource := range resources {
+		if resource == nil || resource.ID == nil || freeResourceTypes[strings.ToLower(stringValue(resource.Type))] {
+			continue
+		}
+		residuals = append(residuals, fmt.Sprintf("%s (%s)", *resource.ID, stringValue(resource.Type)))
+	}
+	for _, vault := range deleted {
+		if vault == nil || vault.Properties == nil || vault.Properties.VaultID == nil || isTrue(vault.Properties.PurgeProtectionEnabled) {
+			continue
+		}
+		id, err := arm.ParseResourceID(*vault.Properties.VaultID)
+		if err != nil || !strings.EqualFold(id.ResourceGroupName, resourceGroup) {
+			continue
+		}
+		residuals = append(residuals, fmt.Sprintf("%s (soft-deleted, not purged)", *vault.Properties.VaultID))
+	}
+	sort.Strings(residuals)
+	return residuals, nil
+}
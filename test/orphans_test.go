@@ -0,0 +1,171 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/keyvault/armkeyvault"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	test_structure "github.com/gruntwork-io/terratest/modules/test-structure"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeletionProblem(t *testing.T) {
+	t.Parallel()
+
+	assert.Empty(t, deletionProblem("Key Vault kv-test", &azcore.ResponseError{StatusCode: http.StatusNotFound, ErrorCode: "ResourceNotFound"}))
+	assert.Equal(t, "Key Vault kv-test still exists after destroy", deletionProblem("Key Vault kv-test", nil))
+	assert.Equal(t, "could not confirm Key Vault kv-test was deleted: forbidden", deletionProblem("Key Vault kv-test", errors.New("forbidden")))
+}
+
+func TestFindOrphanedRoleAssignments(t *testing.T) {
+	t.Parallel()
+
+	ops := roleAssignment("ops", cryptoOfficerRoleID)
+	ops.ID = to.Ptr("/vault/providers/Microsoft.Authorization/roleAssignments/2")
+	app := roleAssignment("app", secretsUserRoleID)
+	app.ID = to.Ptr("/vault/providers/Microsoft.Authorization/roleAssignments/1")
+	custom := roleAssignment("ci", "/providers/Microsoft.Authorization/roleDefinitions/custom")
+	custom.ID = to.Ptr("/vault/providers/Microsoft.Authorization/roleAssignments/3")
+
+	orphans, err := findOrphanedRoleAssignments(context.Background(), newFakeRoleAssignments(ops, app, custom), "/vault")
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		`/vault/providers/Microsoft.Authorization/roleAssignments/1: app holds "Key Vault Secrets User"`,
+		`/vault/providers/Microsoft.Authorization/roleAssignments/2: ops holds "Key Vault Crypto Officer"`,
+		`/vault/providers/Microsoft.Authorization/roleAssignments/3: ci holds "/providers/Microsoft.Authorization/roleDefinitions/custom"`,
+	}, orphans)
+
+	orphans, err = findOrphanedRoleAssignments(context.Background(), newFakeRoleAssignments(), "/vault")
+	require.NoError(t, err)
+	assert.Empty(t, orphans)
+}
+
+func TestFindOrphanedRoleAssignmentsListErrors(t *testing.T) {
+	t.Parallel()
+
+	source := newFakeRoleAssignments()
+	source.err = &azcore.ResponseError{StatusCode: http.StatusNotFound, ErrorCode: "ResourceNotFound"}
+	orphans, err := findOrphanedRoleAssignments(context.Background(), source, "/vault")
+	require.NoError(t, err, "a scope that no longer exists holds no assignments")
+	assert.Empty(t, orphans)
+
+	source.err = errors.New("forbidden")
+	_, err = findOrphanedRoleAssignments(context.Background(), source, "/vault")
+	assert.EqualError(t, err, "forbidden")
+}
+
+// fakeResidualSource holds what a teardown left behind.
+type fakeResidualSource struct {
+	resources []*armresources.GenericResourceExpanded
+	deleted   []*armkeyvault.DeletedVault
+	err       error
+}
+
+func (f fakeResidualSource) listInResourceGroup(ctx context.Context, resourceGroup string) ([]*armresources.GenericResourceExpanded, error) {
+	return f.resources, f.err
+}
+
+func (f fakeResidualSource) listDeletedVaults(ctx context.Context) ([]*armkeyvault.DeletedVault, error) {
+	return f.deleted, nil
+}
+
+func deletedVault(resourceGroup string, name string, purgeProtected bool) *armkeyvault.DeletedVault {
+	return &armkeyvault.DeletedVault{
+		Name: to.Ptr(name),
+		Properties: &armkeyvault.DeletedVaultProperties{
+			VaultID:                to.Ptr("/subscriptions/x/resourceGroups/" + resourceGroup + "/providers/Microsoft.KeyVault/vaults/" + name),
+			PurgeProtectionEnabled: to.Ptr(purgeProtected),
+		},
+	}
+}
+
+func TestFindCostResiduals(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	resource := func(resourceType string, name string) *armresources.GenericResourceExpanded {
+		return &armresources.GenericResourceExpanded{
+			ID:   to.Ptr("/subscriptions/x/resourceGroups/rg-kv-test/providers/" + resourceType + "/" + name),
+			Type: to.Ptr(resourceType),
+		}
+	}
+
+	residuals, err := findCostResiduals(ctx, fakeResidualSource{}, "rg-kv-test")
+	require.NoError(t, err)
+	assert.Empty(t, residuals, "an empty or deleted group leaves nothing behind")
+
+	residuals, err = findCostResiduals(ctx, fakeResidualSource{
+		resources: []*armresources.GenericResourceExpanded{
+			resource("Microsoft.Network/virtualNetworks", "vnet-test"),
+			resource("Microsoft.Network/privateEndpoints", "kv-test-pe"),
+			resource("Microsoft.Storage/storageAccounts", "kvtestdiag"),
+		},
+		deleted: []*armkeyvault.DeletedVault{
+			deletedVault("RG-KV-TEST", "kv-leaked", false),
+			deletedVault("rg-kv-test", "kv-protected", true),
+			deletedVault("rg-other", "kv-elsewhere", false),
+		},
+	}, "rg-kv-test")
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"/subscriptions/x/resourceGroups/RG-KV-TEST/providers/Microsoft.KeyVault/vaults/kv-leaked (soft-deleted, not purged)",
+		"/subscriptions/x/resourceGroups/rg-kv-test/providers/Microsoft.Network/privateEndpoints/kv-test-pe (Microsoft.Network/privateEndpoints)",
+		"/subscriptions/x/resourceGroups/rg-kv-test/providers/Microsoft.Storage/storageAccounts/kvtestdiag (Microsoft.Storage/storageAccounts)",
+	}, residuals)
+
+	_, err = findCostResiduals(ctx, fakeResidualSource{err: errors.New("forbidden")}, "rg-kv-test")
+	assert.EqualError(t, err, "forbidden")
+}
+
+func TestKeyVaultDestroyLeavesNoOrphans(t *testing.T) {
+	t.Parallel()
+
+	MultiTenantTestRunner(t, func(t *testing.T, config TestConfig) {
+		SetupAzureAuth(t, config)
+		CreateResourceGroup(t, &config)
+
+		fixtureDir := test_structure.CopyTerraformFolderToTemp(t, "..", "test/fixtures/private_endpoint")
+		keyVaultName := PurgeSoftDeletedVault(t, config, fmt.Sprintf("kv-orph-%s", config.UniqueID))
+
+		terraformOptions := BuildTerraformOptions(t, config, map[string]interface{}{
+			"key_vault_name":      keyVaultName,
+			"location":            config.Region,
+			"resource_group_name": fmt.Sprintf("%s-%s", config.ResourceGroup, config.UniqueID),
+			"role_assignments": map[string]interface{}{
+				"test-runner": map[string]interface{}{
+					"principal_id":         currentPrincipalObjectID(t, azureCredential(t)),
+					"role_definition_name": "Key Vault Secrets User",
+					"principal_type":       "ServicePrincipal",
+				},
+			},
+		}, WithTerraformDir(fixtureDir))
+
+		// Still destroys if the test fails before the explicit destroy below,
+		// and is a no-op otherwise.
+		defer terraform.Destroy(t, terraformOptions)
+		terraform.InitAndApply(t, terraformOptions)
+
+		vaultID := terraform.Output(t, terraformOptions, "key_vault_id")
+		privateEndpointID := terraform.Output(t, terraformOptions, "private_endpoint_id")
+		require.Contains(t, terraform.OutputMap(t, terraformOptions, "role_assignment_ids"), "test-runner")
+
+		terraform.Destroy(t, terraformOptions)
+
+		AssertVaultDeleted(t, config, keyVaultName)
+		AssertResourceDeleted(t, config, privateEndpointID, "2023-09-01")
+		AssertNoOrphanedRoleAssignments(t, config, vaultID)
+		AssertZeroCostResiduals(t, config, config.ResourceGroupName())
+
+		// A second destroy must find nothing left to remove.
+		output := terraform.Destroy(t, terraformOptions)
+		assert.Contains(t, output, "Resources: 0 destroyed", "a repeated destroy should be a no-op")
+	})
+}
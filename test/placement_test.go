@@ -0,0 +1,231 @@
+package test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	test_structure "github.com/gruntwork-io/terratest/modules/test-structure"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyVaultSeparateResourceGroupLocation(t *testing.T) {
+	t.Parallel()
+
+	MultiTenantTestRunner(t, func(t *testing.T, config TestConfig) {
+		SetupAzureAuth(t, config)
+
+		// Any other region will do for the group, preferably one the tenant
+		// is already configured for
+		groupRegion := "northeurope"
+		for _, region := range append(append([]string(nil), config.RegionList...), "northeurope", "westeurope") {
+			if !strings.EqualFold(region, config.Region) {
+				groupRegion = region
+				break
+			}
+		}
+
+		keyVaultName := PurgeSoftDeletedVault(t, config, fmt.Sprintf("kv-rgl-%s", config.UniqueID))
+		vars := baseModuleVars(config, keyVaultName)
+		vars["create_resource_group"] = true
+		vars["resource_group_location"] = groupRegion
+
+		terraformOptions := BuildTerraformOptions(t, config, vars)
+
+		defer terraform.Destroy(t, terraformOptions)
+		terraform.InitAndApply(t, terraformOptions)
+
+		assert.Equal(t, strings.ToLower(groupRegion), strings.ToLower(terraform.Output(t, terraformOptions, "resource_group_location")))
+		assert.Equal(t, strings.ToLower(config.Region), strings.ToLower(terraform.Output(t, terraformOptions, "key_vault_location")))
+
+		view := GetVaultView(t, config, config.ResourceGroupName(), keyVaultName)
+		assert.Equal(t, strings.ToLower(config.Region), view.Location, "the vault should stay in location")
+	})
+}
+
+func TestKeyVaultResourceGroupModes(t *testing.T) {
+	t.Parallel()
+
+	MultiTenantTestRunner(t, func(t *testing.T, config TestConfig) {
+		SetupAzureAuth(t, config)
+
+		testCases := []struct {
+			name                string
+			createResourceGroup bool
+			resourceGroupName   string
+		}{
+			{"existing", false, fmt.Sprintf("%s-%s", config.ResourceGroup, config.UniqueID)},
+			{"created", true, fmt.Sprintf("%s-new-%s", config.ResourceGroup, config.UniqueID)},
+		}
+
+		for _, tc := range testCases {
+			tc := tc
+			t.Run(tc.name, func(t *testing.T) {
+				if !tc.createResourceGroup {
+					CreateResourceGroup(t, &config)
+				}
+
+				keyVaultName := fmt.Sprintf("kv-rg%s-%s", tc.name[:1], config.UniqueID)
+				keyVaultName = PurgeSoftDeletedVault(t, config, keyVaultName)
+
+				vars := baseModuleVars(config, keyVaultName)
+				vars["create_resource_group"] = tc.createResourceGroup
+				vars["resource_group_name"] = tc.resourceGroupName
+
+				terraformOptions := BuildTerraformOptions(t, config, vars)
+
+				defer terraform.Destroy(t, terraformOptions)
+				terraform.InitAndApply(t, terraformOptions)
+
+				assert.Equal(t, tc.resourceGroupName, terraform.Output(t, terraformOptions, "resource_group_name"))
+				assert.Equal(t, tc.resourceGroupName, terraform.Output(t, terraformOptions, "key_vault_resource_group_name"))
+				assert.Equal(t,
+					strings.ToLower(strings.ReplaceAll(config.Region, " ", "")),
+					terraform.Output(t, terraformOptions, "resource_group_location"),
+				)
+				assert.Equal(t,
+					terraform.Output(t, terraformOptions, "resource_group_location"),
+					terraform.Output(t, terraformOptions, "key_vault_location"),
+				)
+			})
+		}
+	})
+}
+
+func TestKeyVaultTenantSource(t *testing.T) {
+	t.Parallel()
+
+	const otherTenantID = "00000000-0000-0000-0000-0000000000b1"
+
+	MultiTenantTestRunner(t, func(t *testing.T, config TestConfig) {
+		SetupAzureAuth(t, config)
+
+		tenant := func(expected string) func(t *testing.T, plan *terraform.PlanStruct) {
+			return plannedVaultAttribute("tenant_id", expected)
+		}
+
+		runPlanCases(t, config, "kv-tnt", []planCase{
+			{name: "default", check: tenant(config.TenantID)},
+			{name: "current tenant", vars: map[string]interface{}{"use_current_tenant": true}, check: tenant(config.TenantID)},
+			{name: "explicit tenant", vars: map[string]interface{}{"tenant_id": otherTenantID}, check: tenant(otherTenantID)},
+			{name: "explicit tenant opted in", vars: map[string]interface{}{"use_current_tenant": false, "tenant_id": otherTenantID}, check: tenant(otherTenantID)},
+			{name: "both sources", vars: map[string]interface{}{"use_current_tenant": true, "tenant_id": otherTenantID}, expectedError: "tenant_id is set while use_current_tenant = true"},
+			{name: "no source", vars: map[string]interface{}{"use_current_tenant": false}, expectedError: "use_current_tenant = false needs tenant_id"},
+		})
+	})
+}
+
+// TestKeyVaultTenantRegions deploys a vault per tenant and checks each lands
+// in its tenant's region. Run it with a KV_TEST_TENANTS_FILE listing tenants
+// in different regions to cover data residency pinning.
+func TestKeyVaultTenantRegions(t *testing.T) {
+	t.Parallel()
+
+	MultiTenantTestRunner(t, func(t *testing.T, config TestConfig) {
+		SetupAzureAuth(t, config)
+		CreateResourceGroup(t, &config)
+
+		keyVaultName := PurgeSoftDeletedVault(t, config, fmt.Sprintf("kv-reg-%s", config.UniqueID))
+		terraformOptions := BuildTerraformOptions(t, config, baseModuleVars(config, keyVaultName))
+
+		defer terraform.Destroy(t, terraformOptions)
+		terraform.InitAndApply(t, terraformOptions)
+
+		region := strings.ToLower(strings.ReplaceAll(config.Region, " ", ""))
+		assert.Equal(t, region, terraform.Output(t, terraformOptions, "key_vault_location"))
+		kv := getDeployedVault(t, terraformOptions)
+		require.NotNil(t, kv.Location)
+		assert.Equal(t, region, strings.ToLower(strings.ReplaceAll(*kv.Location, " ", "")), "Key Vault %s should be deployed in %s", keyVaultName, config.Region)
+	})
+}
+
+func TestKeyVaultAllowedRegions(t *testing.T) {
+	t.Parallel()
+
+	MultiTenantTestRunner(t, func(t *testing.T, config TestConfig) {
+		SetupAzureAuth(t, config)
+
+		otherRegion := "eastus2"
+		if strings.EqualFold(config.Region, otherRegion) {
+			otherRegion = "westeurope"
+		}
+		planned := func(t *testing.T, plan *terraform.PlanStruct) {
+			_, ok := plan.ResourcePlannedValuesMap["azurerm_key_vault.this[0]"]
+			assert.True(t, ok, "plan should create the Key Vault")
+		}
+
+		runPlanCases(t, config, "kv-reg", []planCase{
+			{name: "no policy", vars: map[string]interface{}{"allowed_regions": []string{}}, check: planned},
+			{name: "allowed", vars: map[string]interface{}{"allowed_regions": []string{otherRegion, strings.ToUpper(config.Region)}}, check: planned},
+			{name: "disallowed", vars: map[string]interface{}{"allowed_regions": []string{otherRegion}}, expectedError: fmt.Sprintf("The Key Vault would be deployed to %s, outside allowed_regions (%s)", config.Region, otherRegion)},
+			{name: "disallowed dr region", vars: map[string]interface{}{"allowed_regions": []string{config.Region}, "create_dr_vault": true, "dr_location": otherRegion}, expectedError: fmt.Sprintf("The Key Vault would be deployed to %s, outside allowed_regions", otherRegion)},
+		})
+	})
+}
+
+func TestKeyVaultDRVaultPairedRegion(t *testing.T) {
+	t.Parallel()
+
+	MultiTenantTestRunner(t, func(t *testing.T, config TestConfig) {
+		SetupAzureAuth(t, config)
+
+		paired := func(expected string) func(t *testing.T, plan *terraform.PlanStruct) {
+			return func(t *testing.T, plan *terraform.PlanStruct) {
+				dr, ok := plan.ResourcePlannedValuesMap["azurerm_key_vault.dr[0]"]
+				require.True(t, ok, "plan should create the disaster recovery vault")
+				location, _ := dr.AttributeValues["location"].(string)
+				assert.Equal(t, expected, normalizeLocation(location))
+				assert.Equal(t, fmt.Sprintf("kv-dr-%s-dr", config.UniqueID), dr.AttributeValues["name"])
+
+				vault := plan.ResourcePlannedValuesMap["azurerm_key_vault.this[0]"]
+				for _, setting := range []string{"sku_name", "enable_rbac_authorization", "purge_protection_enabled", "soft_delete_retention_days", "public_network_access_enabled", "network_acls"} {
+					assert.Equal(t, vault.AttributeValues[setting], dr.AttributeValues[setting], "the disaster recovery vault should mirror %s", setting)
+				}
+			}
+		}
+
+		runPlanCases(t, config, "kv-dr", []planCase{
+			{name: "display name", vars: map[string]interface{}{"location": "East US", "create_dr_vault": true}, check: paired("westus")},
+			{name: "short name", vars: map[string]interface{}{"location": "westeurope", "create_dr_vault": true}, check: paired("northeurope")},
+			{name: "one-way pair", vars: map[string]interface{}{"location": "westus3", "create_dr_vault": true}, check: paired("eastus")},
+			{name: "explicit dr_location", vars: map[string]interface{}{"location": "westeurope", "create_dr_vault": true, "dr_location": "swedencentral"}, check: paired("swedencentral")},
+			{name: "unpaired region", vars: map[string]interface{}{"location": "polandcentral", "create_dr_vault": true}, expectedError: "Set dr_location"},
+		})
+	})
+}
+
+func TestKeyVaultParityAcrossRegions(t *testing.T) {
+	t.Parallel()
+
+	MultiTenantTestRunner(t, func(t *testing.T, config TestConfig) {
+		SetupAzureAuth(t, config)
+		CreateResourceGroup(t, &config)
+
+		cloneRegion := "northeurope"
+		if strings.EqualFold(config.Region, cloneRegion) {
+			cloneRegion = "westeurope"
+		}
+
+		names := []string{
+			PurgeSoftDeletedVault(t, config, fmt.Sprintf("kv-par-%s", config.UniqueID)),
+			PurgeSoftDeletedVault(t, config, fmt.Sprintf("kv-parc-%s", config.UniqueID)),
+		}
+		for i, region := range []string{config.Region, cloneRegion} {
+			vars := baseModuleVars(config, names[i])
+			vars["location"] = region
+			vars["sku_name"] = "premium"
+			vars["soft_delete_retention_days"] = 30
+			vars["network_acls_default_action"] = "Deny"
+			vars["network_acls_ip_rules"] = []string{"203.0.113.0/24"}
+
+			terraformOptions := BuildTerraformOptions(t, config, vars, WithTerraformDir(test_structure.CopyTerraformFolderToTemp(t, "..", ".")))
+
+			defer terraform.Destroy(t, terraformOptions)
+			terraform.InitAndApply(t, terraformOptions)
+		}
+
+		AssertVaultParity(t, config, names[0], names[1], []string{"name", "location"})
+	})
+}
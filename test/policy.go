@@ -0,0 +1,124 @@
+package test
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/policyinsights/armpolicyinsights"
+	"github.com/stretchr/testify/require"
+)
+
+// Compliance states reported by Azure Policy.
+const (
+	PolicyCompliant    = "Compliant"
+	PolicyNonCompliant = "NonCompliant"
+)
+
+// PolicyState is Azure Policy's latest compliance verdict on a resource for
+// one policy assignment or, for initiatives, one policy in it.
+type PolicyState struct {
+	AssignmentID          string
+	AssignmentName        string
+	DefinitionID          string
+	DefinitionReferenceID string
+	ComplianceState       string
+	Timestamp             time.Time
+}
+
+// policyStateLister is the subset of the Policy Insights API used to read
+// compliance states.
+type policyStateLister interface {
+	listLatest(ctx context.Context, resourceID string) ([]*armpolicyinsights.PolicyState, error)
+}
+
+type azurePolicyStates struct {
+	client *armpolicyinsights.PolicyStatesClient
+}
+
+func (a azurePolicyStates) listLatest(ctx context.Context, resourceID string) ([]*armpolicyinsights.PolicyState, error) {
+	var result []*armpolicyinsights.PolicyState
+	pager := a.client.NewListQueryResultsForResourcePager(armpolicyinsights.PolicyStatesResourceLatest, resourceID, nil, nil)
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, page.Value...)
+	}
+	return result, nil
+}
+
+// GetPolicyComplianceState returns Azure Policy's latest compliance state of
+// the vault with ID vaultID for every assignment that covers it, sorted by
+// assignment. Azure evaluates new resources within about 30 minutes; see
+// TriggerPolicyEvaluation to evaluate sooner.
+func GetPolicyComplianceState(t *testing.T, config TestConfig, vaultID string) []PolicyState {
+	t.Helper()
+
+	client, err := armpolicyinsights.NewPolicyStatesClient(azureCredential(t), armClientOptions())
+	require.NoError(t, err)
+	states, err := policyComplianceStates(context.Background(), azurePolicyStates{client}, vaultID)
+	require.NoError(t, err, "failed to read the policy states of %s in subscription %s", vaultID, config.SubscriptionID)
+	return states
+}
+
+// TriggerPolicyEvaluation starts an Azure Policy compliance scan of the
+// config's resource group and waits for it to finish, which usually takes a
+// few minutes.
+func TriggerPolicyEvaluation(t *testing.T, config TestConfig) {
+	t.Helper()
+
+	client, err := armpolicyinsights.NewPolicyStatesClient(azureCredential(t), armClientOptions())
+	require.NoError(t, err)
+	poller, err := client.BeginTriggerResourceGroupEvaluation(context.Background(), config.SubscriptionID, config.ResourceGroupName(), nil)
+	require.NoError(t, err, "failed to start the policy evaluation of %s", config.ResourceGroupName())
+	_, err = poller.PollUntilDone(context.Background(), nil)
+	require.NoError(t, err, "policy evaluation of %s failed", config.ResourceGroupName())
+}
+
+func policyComplianceStates(ctx context.Context, lister policyStateLister, resourceID string) ([]PolicyState, error) {
+	raw, err := lister.listLatest(ctx, resourceID)
+	if err != nil {
+		return nil, err
+	}
+
+	states := make([]PolicyState, 0, len(raw))
+	for _, state := range raw {
+		if state == nil {
+			continue
+		}
+		converted := PolicyState{
+			AssignmentID:          stringValue(state.PolicyAssignmentID),
+			AssignmentName:        stringValue(state.PolicyAssignmentName),
+			DefinitionID:          stringValue(state.PolicyDefinitionID),
+			DefinitionReferenceID: stringValue(state.PolicyDefinitionReferenceID),
+			ComplianceState:       stringValue(state.ComplianceState),
+		}
+		if state.Timestamp != nil {
+			converted.Timestamp = *state.Timestamp
+		}
+		states = append(states, converted)
+	}
+	sort.Slice(states, func(i, j int) bool {
+		if states[i].AssignmentID != states[j].AssignmentID {
+			return states[i].AssignmentID < states[j].AssignmentID
+		}
+		return states[i].DefinitionReferenceID < states[j].DefinitionReferenceID
+	})
+	return states, nil
+}
+
+// policyStateFor returns the state reported for the assignment with ID
+// assignmentID, compared case-insensitively as ARM IDs are, and whether
+// there is one.
+func policyStateFor(states []PolicyState, assignmentID string) (PolicyState, bool) {
+	for _, state := range states {
+		if strings.EqualFold(state.AssignmentID, assignmentID) {
+			return state, true
+		}
+	}
+	return PolicyState{}, false
+}
@@ -0,0 +1,195 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/policyinsights/armpolicyinsights"
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakePolicyStates struct {
+	states map[string][]*armpolicyinsights.PolicyState
+	err    error
+}
+
+func (f fakePolicyStates) listLatest(ctx context.Context, resourceID string) ([]*armpolicyinsights.PolicyState, error) {
+	return f.states[resourceID], f.err
+}
+
+func TestPolicyComplianceStates(t *testing.T) {
+	t.Parallel()
+
+	const vaultID = "/subscriptions/x/resourceGroups/rg-kv-test/providers/Microsoft.KeyVault/vaults/kv-test"
+	evaluated := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	lister := fakePolicyStates{states: map[string][]*armpolicyinsights.PolicyState{vaultID: {
+		{
+			PolicyAssignmentID:          to.Ptr("/subscriptions/x/providers/Microsoft.Authorization/policyAssignments/kv-baseline"),
+			PolicyAssignmentName:        to.Ptr("kv-baseline"),
+			PolicyDefinitionReferenceID: to.Ptr("softDelete"),
+			ComplianceState:             to.Ptr(PolicyNonCompliant),
+		},
+		nil,
+		{
+			PolicyAssignmentID:   to.Ptr("/subscriptions/x/providers/Microsoft.Authorization/policyAssignments/kv-applies-to-all"),
+			PolicyAssignmentName: to.Ptr("kv-applies-to-all"),
+			PolicyDefinitionID:   to.Ptr("/providers/Microsoft.Authorization/policyDefinitions/0b60c0b2-2dc2-4e1c-b5c9-abbed971de53"),
+			ComplianceState:      to.Ptr(PolicyCompliant),
+			Timestamp:            &evaluated,
+		},
+		{
+			PolicyAssignmentID:          to.Ptr("/subscriptions/x/providers/Microsoft.Authorization/policyAssignments/kv-baseline"),
+			PolicyAssignmentName:        to.Ptr("kv-baseline"),
+			PolicyDefinitionReferenceID: to.Ptr("purgeProtection"),
+			ComplianceState:             to.Ptr(PolicyCompliant),
+		},
+	}}}
+
+	states, err := policyComplianceStates(context.Background(), lister, vaultID)
+	require.NoError(t, err)
+	require.Len(t, states, 3)
+	assert.Equal(t, PolicyState{
+		AssignmentID:    "/subscriptions/x/providers/Microsoft.Authorization/policyAssignments/kv-applies-to-all",
+		AssignmentName:  "kv-applies-to-all",
+		DefinitionID:    "/providers/Microsoft.Authorization/policyDefinitions/0b60c0b2-2dc2-4e1c-b5c9-abbed971de53",
+		ComplianceState: PolicyCompliant,
+		Timestamp:       evaluated,
+	}, states[0])
+	assert.Equal(t, "purgeProtection", states[1].DefinitionReferenceID, "states should be sorted by assignment, then policy")
+	assert.Equal(t, "softDelete", states[2].DefinitionReferenceID)
+
+	state, ok := policyStateFor(states, "/SUBSCRIPTIONS/x/providers/Microsoft.Authorization/policyAssignments/KV-APPLIES-TO-ALL")
+	require.True(t, ok, "assignment IDs should match case-insensitively")
+	assert.Equal(t, PolicyCompliant, state.ComplianceState)
+	_, ok = policyStateFor(states, "/subscriptions/x/providers/Microsoft.Authorization/policyAssignments/other")
+	assert.False(t, ok)
+}
+
+func TestPolicyComplianceStatesError(t *testing.T) {
+	t.Parallel()
+
+	boom := errors.New("boom")
+	_, err := policyComplianceStates(context.Background(), fakePolicyStates{err: boom}, "/subscriptions/x")
+	assert.ErrorIs(t, err, boom)
+}
+
+func TestKeyVaultPolicyCompliance(t *testing.T) {
+	t.Parallel()
+
+	// An assignment of "Key vaults should have deletion protection enabled"
+	// (0b60c0b2-2dc2-4e1c-b5c9-abbed971de53) or an initiative containing it,
+	// covering the test resource groups
+	assignmentID := os.Getenv("KV_TEST_PURGE_PROTECTION_ASSIGNMENT_ID")
+	if assignmentID == "" {
+		t.Skip("KV_TEST_PURGE_PROTECTION_ASSIGNMENT_ID is not set; skipping the Azure Policy compliance check")
+	}
+
+	MultiTenantTestRunner(t, func(t *testing.T, config TestConfig) {
+		SetupAzureAuth(t, config)
+		CreateResourceGroup(t, &config)
+
+		// Purge protection keeps the vault for soft_delete_retention_days
+		// after destroy, so keep that short
+		keyVaultName := PurgeSoftDeletedVault(t, config, fmt.Sprintf("kv-pol-%s", config.UniqueID))
+		vars := baseModuleVars(config, keyVaultName)
+		vars["purge_protection_enabled"] = true
+		vars["soft_delete_retention_days"] = 7
+
+		terraformOptions := BuildTerraformOptions(t, config, vars)
+
+		defer terraform.Destroy(t, terraformOptions)
+		terraform.InitAndApply(t, terraformOptions)
+
+		TriggerPolicyEvaluation(t, config)
+		states := GetPolicyComplianceState(t, config, terraform.Output(t, terraformOptions, "key_vault_id"))
+		state, ok := policyStateFor(states, assignmentID)
+		require.True(t, ok, "Azure Policy reported no state for %s; is it assigned over the test resource groups?", assignmentID)
+		assert.Equal(t, PolicyCompliant, state.ComplianceState)
+	})
+}
+
+func TestKeyVaultRotationPolicyAssignment(t *testing.T) {
+	t.Parallel()
+
+	// Built-in "Keys should have a rotation policy ensuring that their
+	// rotation is scheduled within the specified number of days after creation."
+	const rotationPolicyDefinitionID = "/providers/Microsoft.Authorization/policyDefinitions/d8cf8476-a2ec-4916-896e-992351803c44"
+
+	MultiTenantTestRunner(t, func(t *testing.T, config TestConfig) {
+		SetupAzureAuth(t, config)
+		CreateResourceGroup(t, &config)
+
+		keyVaultName := PurgeSoftDeletedVault(t, config, fmt.Sprintf("kv-rotp-%s", config.UniqueID))
+		vars := baseModuleVars(config, keyVaultName)
+		vars["assign_rotation_policy"] = true
+		vars["rotation_policy_max_days"] = 365
+
+		terraformOptions := BuildTerraformOptions(t, config, vars)
+
+		defer terraform.Destroy(t, terraformOptions)
+		terraform.InitAndApply(t, terraformOptions)
+
+		assignmentID := terraform.Output(t, terraformOptions, "rotation_policy_assignment_id")
+		require.NotEmpty(t, assignmentID)
+
+		assignment := getResourceByID(t, config, assignmentID, "2022-06-01")
+		properties, ok := assignment.Properties.(map[string]interface{})
+		require.True(t, ok, "policy assignment has no properties")
+		definitionID, _ := properties["policyDefinitionId"].(string)
+		assert.True(t, strings.EqualFold(rotationPolicyDefinitionID, definitionID), "assignment references %q, want %q", definitionID, rotationPolicyDefinitionID)
+		scope, _ := properties["scope"].(string)
+		assert.True(t, strings.HasSuffix(strings.ToLower(scope), "/resourcegroups/"+strings.ToLower(config.ResourceGroupName())), "assignment should be scoped to the resource group, got %q", scope)
+
+		parameters, _ := properties["parameters"].(map[string]interface{})
+		maxDays, _ := parameters["maximumDaysToRotate"].(map[string]interface{})
+		assert.Equal(t, float64(365), maxDays["value"])
+	})
+}
+
+func TestKeyVaultPolicyExemption(t *testing.T) {
+	t.Parallel()
+
+	MultiTenantTestRunner(t, func(t *testing.T, config TestConfig) {
+		SetupAzureAuth(t, config)
+		CreateResourceGroup(t, &config)
+
+		keyVaultName := PurgeSoftDeletedVault(t, config, fmt.Sprintf("kv-pex-%s", config.UniqueID))
+		vars := baseModuleVars(config, keyVaultName)
+		vars["assign_rotation_policy"] = true
+
+		terraformOptions := BuildTerraformOptions(t, config, vars)
+
+		defer terraform.Destroy(t, terraformOptions)
+		terraform.InitAndApply(t, terraformOptions)
+
+		// Exempt the resource group from the module's own assignment, which
+		// only exists after the first apply.
+		expiresOn := time.Now().UTC().AddDate(0, 1, 0).Truncate(time.Hour)
+		vars["policy_exemptions"] = []map[string]interface{}{{
+			"name":                 "legacy-migration",
+			"policy_assignment_id": terraform.Output(t, terraformOptions, "rotation_policy_assignment_id"),
+			"exemption_category":   "Waiver",
+			"expires_on":           expiresOn.Format(time.RFC3339),
+		}}
+		terraform.Apply(t, terraformOptions)
+
+		exemptionID := terraform.OutputMap(t, terraformOptions, "policy_exemption_ids")["legacy-migration"]
+		require.NotEmpty(t, exemptionID)
+
+		exemption := getResourceByID(t, config, exemptionID, "2022-07-01-preview")
+		properties, ok := exemption.Properties.(map[string]interface{})
+		require.True(t, ok, "policy exemption has no properties")
+		assert.Equal(t, "Waiver", properties["exemptionCategory"])
+		actual, err := time.Parse(time.RFC3339, fmt.Sprint(properties["expiresOn"]))
+		require.NoError(t, err)
+		assert.True(t, expiresOn.Equal(actual), "exemption expires on %s, want %s", actual, expiresOn)
+	})
+}
@@ -0,0 +1,90 @@
+package test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	providerRegistrationTimeout     = 10 * time.Minute
+	providerRegistrationInitialWait = 5 * time.Second
+	providerRegistrationMaxWait     = 30 * time.Second
+)
+
+// registeredProviders records the subscription and namespace pairs already
+// found registered, so parallel tests check each one only once per run.
+var registeredProviders sync.Map
+
+// providerRegistrar is the subset of the ARM resource providers API used to
+// register a resource provider.
+type providerRegistrar interface {
+	// registrationState returns the provider's state, such as NotRegistered,
+	// Registering or Registered.
+	registrationState(ctx context.Context, namespace string) (string, error)
+	register(ctx context.Context, namespace string) error
+}
+
+type armProviders struct {
+	client *armresources.ProvidersClient
+}
+
+func (a armProviders) registrationState(ctx context.Context, namespace string) (string, error) {
+	resp, err := a.client.Get(ctx, namespace, nil)
+	if err != nil {
+		return "", err
+	}
+	return stringValue(resp.RegistrationState), nil
+}
+
+func (a armProviders) register(ctx context.Context, namespace string) error {
+	_, err := a.client.Register(ctx, namespace, nil)
+	return err
+}
+
+// EnsureProviderRegistered registers the resource provider namespace, such as
+// Microsoft.KeyVault, in config's subscription when it is not registered yet
+// and waits until registration completes. A fresh subscription may not have
+// it, and creating a vault there fails with an error that does not say why.
+// Registering needs the subscription-level */register/action permission.
+func EnsureProviderRegistered(t *testing.T, config TestConfig, namespace string) {
+	t.Helper()
+
+	key := strings.ToLower(config.SubscriptionID + "/" + namespace)
+	if _, ok := registeredProviders.Load(key); ok {
+		return
+	}
+
+	client, err := armresources.NewProvidersClient(config.SubscriptionID, azureCredential(t), armClientOptions())
+	require.NoError(t, err)
+	err = ensureProviderRegistered(context.Background(), t, armProviders{client}, namespace, providerRegistrationTimeout, time.Now, time.Sleep)
+	require.NoError(t, err, "resource provider %s is not registered in subscription %s", namespace, config.SubscriptionID)
+	registeredProviders.Store(key, true)
+}
+
+// ensureProviderRegistered registers namespace unless it already is, then
+// polls with backoff until its state is Registered or timeout elapses.
+func ensureProviderRegistered(ctx context.Context, t logT, providers providerRegistrar, namespace string, timeout time.Duration, now func() time.Time, sleep func(time.Duration)) error {
+	state, err := providers.registrationState(ctx, namespace)
+	if err != nil {
+		return err
+	}
+	if strings.EqualFold(state, "Registered") {
+		return nil
+	}
+
+	t.Logf("registering resource provider %s (state %s)", namespace, state)
+	if err := providers.register(ctx, namespace); err != nil {
+		return fmt.Errorf("failed to register %s: %w", namespace, err)
+	}
+	return pollWithBackoff(ctx, timeout, providerRegistrationInitialWait, providerRegistrationMaxWait, now, sleep, func(ctx context.Context) (bool, error) {
+		state, err := providers.registrationState(ctx, namespace)
+		return strings.EqualFold(state, "Registered"), err
+	})
+}
@@ -0,0 +1,65 @@
+package test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeProviders reports states in order, one per registrationState call, and
+// keeps reporting the last one.
+type fakeProviders struct {
+	states    []string
+	calls     int
+	registers int
+}
+
+func (f *fakeProviders) registrationState(ctx context.Context, namespace string) (string, error) {
+	state := f.states[min(f.calls, len(f.states)-1)]
+	f.calls++
+	return state, nil
+}
+
+func (f *fakeProviders) register(ctx context.Context, namespace string) error {
+	f.registers++
+	return nil
+}
+
+func TestEnsureProviderRegistered(t *testing.T) {
+	t.Parallel()
+
+	t.Run("waits until registered", func(t *testing.T) {
+		clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+		providers := &fakeProviders{states: []string{"NotRegistered", "Registering", "Registering", "Registered"}}
+
+		err := ensureProviderRegistered(context.Background(), t, providers, "Microsoft.KeyVault", time.Hour, clock.Now, clock.Sleep)
+
+		require.NoError(t, err)
+		assert.Equal(t, 1, providers.registers)
+		assert.Equal(t, 4, providers.calls)
+		assert.Equal(t, []time.Duration{5 * time.Second, 10 * time.Second}, clock.sleeps)
+	})
+
+	t.Run("leaves a registered provider alone", func(t *testing.T) {
+		clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+		providers := &fakeProviders{states: []string{"Registered"}}
+
+		require.NoError(t, ensureProviderRegistered(context.Background(), t, providers, "Microsoft.KeyVault", time.Hour, clock.Now, clock.Sleep))
+		assert.Zero(t, providers.registers)
+		assert.Empty(t, clock.sleeps)
+	})
+
+	t.Run("times out while still registering", func(t *testing.T) {
+		clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+		providers := &fakeProviders{states: []string{"NotRegistered", "Registering"}}
+
+		err := ensureProviderRegistered(context.Background(), t, providers, "Microsoft.KeyVault", time.Minute, clock.Now, clock.Sleep)
+
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "timed out after 1m0s")
+		assert.Equal(t, 1, providers.registers)
+	})
+}
@@ -0,0 +1,383 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/authorization/armauthorization/v2"
+	"github.com/stretchr/testify/require"
+)
+
+// roleAssignmentSource is the subset of the ARM authorization API used to
+// check role assignments.
+type roleAssignmentSource interface {
+	// listAtScope returns the role assignments made directly at scope, not
+	// those inherited from a parent scope.
+	listAtScope(ctx context.Context, scope string) ([]*armauthorization.RoleAssignment, error)
+	roleName(ctx context.Context, roleDefinitionID string) (string, error)
+}
+
+type armRoleAssignments struct {
+	assignments *armauthorization.RoleAssignmentsClient
+	definitions *armauthorization.RoleDefinitionsClient
+}
+
+func (a armRoleAssignments) listAtScope(ctx context.Context, scope string) ([]*armauthorization.RoleAssignment, error) {
+	var result []*armauthorization.RoleAssignment
+	pager := a.assignments.NewListForScopePager(scope, &armauthorization.RoleAssignmentsClientListForScopeOptions{Filter: to.Ptr("atScope()")})
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, assignment := range page.Value {
+			// atScope() also returns assignments on parent scopes
+			if assignment.Properties != nil && assignment.Properties.Scope != nil && strings.EqualFold(*assignment.Properties.Scope, scope) {
+				result = append(result, assignment)
+			}
+		}
+	}
+	return result, nil
+}
+
+func (a armRoleAssignments) roleName(ctx context.Context, roleDefinitionID string) (string, error) {
+	definition, err := a.definitions.GetByID(ctx, roleDefinitionID, nil)
+	if err != nil {
+		return "", err
+	}
+	if definition.Properties == nil || definition.Properties.RoleName == nil {
+		return "", fmt.Errorf("role definition %s has no name", roleDefinitionID)
+	}
+	return *definition.Properties.RoleName, nil
+}
+
+// ValidateRbacRoleAssignments checks that every principal ID in expected holds
+// the mapped role, by name, through an assignment made directly at
+// vaultScope. Other assignments at the scope are ignored. All missing
+// pairings are reported in a single failure.
+func ValidateRbacRoleAssignments(t *testing.T, config TestConfig, vaultScope string, expected map[string]string) {
+	t.Helper()
+
+	cred := azureCredential(t)
+	assignments, err := armauthorization.NewRoleAssignmentsClient(config.SubscriptionID, cred, armClientOptions())
+	require.NoError(t, err)
+	definitions, err := armauthorization.NewRoleDefinitionsClient(cred, armClientOptions())
+	require.NoError(t, err)
+
+	problems, err := findRoleAssignmentProblems(context.Background(), armRoleAssignments{assignments, definitions}, vaultScope, expected)
+	require.NoError(t, err, "failed to list role assignments at %s", vaultScope)
+	if len(problems) > 0 {
+		t.Errorf("%d expected role assignment(s) missing at %s:\n  %s", len(problems), vaultScope, strings.Join(problems, "\n  "))
+	}
+}
+
+// findRoleAssignmentProblems returns one line per expected principal that
+// does not hold its role at scope, sorted by principal ID. Role definition
+// names are looked up once per definition.
+func findRoleAssignmentProblems(ctx context.Context, source roleAssignmentSource, scope string, expected map[string]string) ([]string, error) {
+	assignments, err := source.listAtScope(ctx, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	names := map[string]string{}
+	held := map[string][]string{}
+	for _, assignment := range assignments {
+		props := assignment.Properties
+		if props == nil || props.PrincipalID == nil || props.RoleDefinitionID == nil {
+			continue
+		}
+		name, ok := names[*props.RoleDefinitionID]
+		if !ok {
+			name, err = source.roleName(ctx, *props.RoleDefinitionID)
+			if err != nil {
+				return nil, err
+			}
+			names[*props.RoleDefinitionID] = name
+		}
+		held[*props.PrincipalID] = append(held[*props.PrincipalID], name)
+	}
+
+	principals := make([]string, 0, len(expected))
+	for principal := range expected {
+		principals = append(principals, principal)
+	}
+	sort.Strings(principals)
+
+	var problems []string
+	for _, principal := range principals {
+		role := expected[principal]
+		found := false
+		for _, name := range held[principal] {
+			if strings.EqualFold(name, role) {
+				found = true
+				break
+			}
+		}
+		if found {
+			continue
+		}
+		if roles := held[principal]; len(roles) > 0 {
+			sort.Strings(roles)
+			problems = append(problems, fmt.Sprintf("%s: missing %q, has %s", principal, role, strings.Join(roles, ", ")))
+		} else {
+			problems = append(problems, fmt.Sprintf("%s: missing %q, has no roles", principal, role))
+		}
+	}
+	return problems, nil
+}
+
+// everyonePrincipalID is the principal a deny assignment lists to apply to
+// all principals, leaving its excluded principals as the only ones allowed.
+const everyonePrincipalID = "00000000-0000-0000-0000-000000000000"
+
+// denyAssignmentSource is the subset of the ARM authorization API used to
+// read deny assignments.
+type denyAssignmentSource interface {
+	// listDenyAssignments returns the deny assignments at scope and its
+	// parent scopes.
+	listDenyAssignments(ctx context.Context, scope string) ([]*armauthorization.DenyAssignment, error)
+}
+
+type armDenyAssignments struct {
+	client *armauthorization.DenyAssignmentsClient
+}
+
+func (a armDenyAssignments) listDenyAssignments(ctx context.Context, scope string) ([]*armauthorization.DenyAssignment, error) {
+	var result []*armauthorization.DenyAssignment
+	pager := a.client.NewListForScopePager(scope, &armauthorization.DenyAssignmentsClientListForScopeOptions{Filter: to.Ptr("atScope()")})
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, page.Value...)
+	}
+	return result, nil
+}
+
+// ValidateNoDenyAssignmentGaps checks that no deny assignment at or above
+// vaultScope blocks Key Vault data-plane actions for a principal holding a
+// role assignment made directly at vaultScope, which would leave the
+// principal with a role it cannot use. It only reads assignments. All
+// blocked principals are reported in a single failure.
+func ValidateNoDenyAssignmentGaps(t *testing.T, config TestConfig, vaultScope string) {
+	t.Helper()
+
+	cred := azureCredential(t)
+	assignments, err := armauthorization.NewRoleAssignmentsClient(config.SubscriptionID, cred, armClientOptions())
+	require.NoError(t, err)
+	definitions, err := armauthorization.NewRoleDefinitionsClient(cred, armClientOptions())
+	require.NoError(t, err)
+	denies, err := armauthorization.NewDenyAssignmentsClient(config.SubscriptionID, cred, armClientOptions())
+	require.NoError(t, err)
+
+	gaps, err := findDenyAssignmentGaps(context.Background(), armRoleAssignments{assignments, definitions}, armDenyAssignments{denies}, vaultScope)
+	require.NoError(t, err, "failed to list role and deny assignments at %s", vaultScope)
+	if len(gaps) > 0 {
+		t.Errorf("%d principal(s) with roles at %s blocked by deny assignments:\n  %s", len(gaps), vaultScope, strings.Join(gaps, "\n  "))
+	}
+}
+
+// findDenyAssignmentGaps returns one line per principal with a role
+// assignment at scope that a deny assignment applying to scope blocks from
+// Key Vault data actions, sorted by principal ID. Each line names the
+// blocking deny assignments.
+func findDenyAssignmentGaps(ctx context.Context, roles roleAssignmentSource, denies denyAssignmentSource, scope string) ([]string, error) {
+	assignments, err := roles.listAtScope(ctx, scope)
+	if err != nil {
+		return nil, err
+	}
+	denyAssignments, err := denies.listDenyAssignments(ctx, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	var blocking []*armauthorization.DenyAssignmentProperties
+	for _, deny := range denyAssignments {
+		if deny.Properties != nil && denyAppliesToScope(deny.Properties, scope) && deniesKeyVaultDataActions(deny.Properties) {
+			blocking = append(blocking, deny.Properties)
+		}
+	}
+
+	principals := map[string]bool{}
+	for _, assignment := range assignments {
+		if assignment.Properties != nil && assignment.Properties.PrincipalID != nil {
+			principals[strings.ToLower(*assignment.Properties.PrincipalID)] = true
+		}
+	}
+	sorted := make([]string, 0, len(principals))
+	for principal := range principals {
+		sorted = append(sorted, principal)
+	}
+	sort.Strings(sorted)
+
+	var gaps []string
+	for _, principal := range sorted {
+		var names []string
+		for _, deny := range blocking {
+			if deniesPrincipal(deny, principal) {
+				names = append(names, fmt.Sprintf("%q", denyAssignmentName(deny)))
+			}
+		}
+		if len(names) > 0 {
+			gaps = append(gaps, fmt.Sprintf("%s: denied by %s", principal, strings.Join(names, ", ")))
+		}
+	}
+	return gaps, nil
+}
+
+// denyAppliesToScope reports whether a deny assignment covers scope: it must
+// be made at scope or a parent of it, and at scope itself when it does not
+// apply to child scopes.
+func denyAppliesToScope(deny *armauthorization.DenyAssignmentProperties, scope string) bool {
+	if deny.Scope == nil {
+		return false
+	}
+	denyScope := strings.TrimSuffix(strings.ToLower(*deny.Scope), "/")
+	target := strings.TrimSuffix(strings.ToLower(scope), "/")
+	if denyScope == target {
+		return true
+	}
+	if deny.DoNotApplyToChildScopes != nil && *deny.DoNotApplyToChildScopes {
+		return false
+	}
+	return denyScope == "" || strings.HasPrefix(target, denyScope+"/")
+}
+
+// deniesKeyVaultDataActions reports whether any permission of a deny
+// assignment denies a Key Vault data action that it does not also carve out
+// through NotDataActions.
+func deniesKeyVaultDataActions(deny *armauthorization.DenyAssignmentProperties) bool {
+	for _, permission := range deny.Permissions {
+		if permission == nil {
+			continue
+		}
+		for _, action := range permission.DataActions {
+			if action == nil {
+				continue
+			}
+			denied, ok := keyVaultDataActions(*action)
+			if !ok {
+				continue
+			}
+			excluded := false
+			for _, notAction := range permission.NotDataActions {
+				if notAction != nil && actionPatternCovers(*notAction, denied) {
+					excluded = true
+					break
+				}
+			}
+			if !excluded {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// keyVaultDataActions narrows an action pattern such as "*",
+// "Microsoft.KeyVault/*" or "Microsoft.KeyVault/vaults/keys/decrypt/action"
+// to the vault data actions it matches, reporting false if it matches none.
+func keyVaultDataActions(pattern string) (string, bool) {
+	const vaultActions = "microsoft.keyvault/vaults/*"
+	if actionPatternCovers(pattern, vaultActions) {
+		return vaultActions, true
+	}
+	if p := strings.ToLower(pattern); strings.HasPrefix(p, "microsoft.keyvault/vaults/") {
+		return p, true
+	}
+	return "", false
+}
+
+// actionPatternCovers reports whether pattern matches every action that
+// action matches, treating a trailing "*" as a wildcard.
+func actionPatternCovers(pattern, action string) bool {
+	pattern = strings.ToLower(pattern)
+	action = strings.ToLower(action)
+	if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+		return strings.HasPrefix(action, prefix)
+	}
+	return pattern == action
+}
+
+// deniesPrincipal reports whether a deny assignment applies to principalID,
+// either by listing it or through the Everyone principal, without excluding
+// it.
+func deniesPrincipal(deny *armauthorization.DenyAssignmentProperties, principalID string) bool {
+	for _, excluded := range deny.ExcludePrincipals {
+		if excluded != nil && excluded.ID != nil && strings.EqualFold(*excluded.ID, principalID) {
+			return false
+		}
+	}
+	for _, principal := range deny.Principals {
+		if principal != nil && principal.ID != nil && (strings.EqualFold(*principal.ID, principalID) || *principal.ID == everyonePrincipalID) {
+			return true
+		}
+	}
+	return false
+}
+
+// denyAssignmentName returns the display name of a deny assignment, falling
+// back to its scope.
+func denyAssignmentName(deny *armauthorization.DenyAssignmentProperties) string {
+	if deny.DenyAssignmentName != nil && *deny.DenyAssignmentName != "" {
+		return *deny.DenyAssignmentName
+	}
+	if deny.Scope != nil {
+		return *deny.Scope
+	}
+	return ""
+}
+
+// Backoff between attempts of RetryUntilRbacPropagated. Data-plane role
+// assignments usually take effect within a few minutes.
+const (
+	rbacPropagationInitialWait = 5 * time.Second
+	rbacPropagationMaxWait     = 30 * time.Second
+)
+
+// RetryUntilRbacPropagated calls op until it stops failing with 403
+// Forbidden, sleeping with exponential backoff in between, for data-plane
+// operations run right after the role assignment that allows them. Any other
+// error fails t at once, and so does a 403 that outlasts timeout.
+func RetryUntilRbacPropagated(t *testing.T, op func() error, timeout time.Duration) {
+	t.Helper()
+
+	err := retryUntilRbacPropagated(context.Background(), op, timeout, time.Now, time.Sleep)
+	require.NoError(t, err, "operation failed while waiting for RBAC to propagate")
+}
+
+func retryUntilRbacPropagated(ctx context.Context, op func() error, timeout time.Duration, now func() time.Time, sleep func(time.Duration)) error {
+	var fatal error
+	err := pollWithBackoff(ctx, timeout, rbacPropagationInitialWait, rbacPropagationMaxWait, now, sleep, func(ctx context.Context) (bool, error) {
+		err := op()
+		if err != nil && !isForbidden(err) {
+			fatal = err
+			return true, nil
+		}
+		return err == nil, err
+	})
+	if fatal != nil {
+		return fatal
+	}
+	return err
+}
+
+// isForbidden reports whether err is a 403 from Azure, either as an SDK
+// response error or in the output of a tool such as Terraform.
+func isForbidden(err error) bool {
+	var respErr *azcore.ResponseError
+	if errors.As(err, &respErr) {
+		return respErr.StatusCode == http.StatusForbidden
+	}
+	return strings.Contains(err.Error(), "ForbiddenByRbac")
+}
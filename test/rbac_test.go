@@ -0,0 +1,548 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/authorization/armauthorization/v2"
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	secretsUserRoleID   = "/providers/Microsoft.Authorization/roleDefinitions/4633458b-17de-408a-b874-0445c86b69e6"
+	cryptoOfficerRoleID = "/providers/Microsoft.Authorization/roleDefinitions/14b46e9e-c2b7-41b4-b07b-48a6ebf60603"
+)
+
+type fakeRoleAssignments struct {
+	assignments []*armauthorization.RoleAssignment
+	roles       map[string]string
+	lookups     int
+	err         error
+}
+
+func (f *fakeRoleAssignments) listAtScope(ctx context.Context, scope string) ([]*armauthorization.RoleAssignment, error) {
+	return f.assignments, f.err
+}
+
+func (f *fakeRoleAssignments) roleName(ctx context.Context, roleDefinitionID string) (string, error) {
+	f.lookups++
+	return f.roles[roleDefinitionID], nil
+}
+
+func roleAssignment(principalID string, roleDefinitionID string) *armauthorization.RoleAssignment {
+	return &armauthorization.RoleAssignment{
+		Properties: &armauthorization.RoleAssignmentProperties{
+			PrincipalID:      to.Ptr(principalID),
+			RoleDefinitionID: to.Ptr(roleDefinitionID),
+		},
+	}
+}
+
+func newFakeRoleAssignments(assignments ...*armauthorization.RoleAssignment) *fakeRoleAssignments {
+	return &fakeRoleAssignments{
+		assignments: assignments,
+		roles: map[string]string{
+			secretsUserRoleID:   "Key Vault Secrets User",
+			cryptoOfficerRoleID: "Key Vault Crypto Officer",
+		},
+	}
+}
+
+func TestFindRoleAssignmentProblems(t *testing.T) {
+	t.Parallel()
+
+	source := newFakeRoleAssignments(
+		roleAssignment("app", secretsUserRoleID),
+		roleAssignment("ops", cryptoOfficerRoleID),
+		roleAssignment("ci", secretsUserRoleID),
+	)
+
+	problems, err := findRoleAssignmentProblems(context.Background(), source, "/vault", map[string]string{
+		"app": "Key Vault Secrets User",
+		"ci":  "key vault secrets user",
+	})
+	require.NoError(t, err)
+	assert.Empty(t, problems)
+	assert.Equal(t, 2, source.lookups, "each role definition should be resolved once")
+}
+
+func TestFindRoleAssignmentProblemsReportsMissingPairings(t *testing.T) {
+	t.Parallel()
+
+	source := newFakeRoleAssignments(roleAssignment("ops", cryptoOfficerRoleID))
+
+	problems, err := findRoleAssignmentProblems(context.Background(), source, "/vault", map[string]string{
+		"ops": "Key Vault Secrets User",
+		"app": "Key Vault Secrets User",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		`app: missing "Key Vault Secrets User", has no roles`,
+		`ops: missing "Key Vault Secrets User", has Key Vault Crypto Officer`,
+	}, problems)
+}
+
+func TestFindRoleAssignmentProblemsListError(t *testing.T) {
+	t.Parallel()
+
+	source := newFakeRoleAssignments()
+	source.err = errors.New("forbidden")
+
+	_, err := findRoleAssignmentProblems(context.Background(), source, "/vault", map[string]string{"app": "Key Vault Secrets User"})
+	assert.EqualError(t, err, "forbidden")
+}
+
+const denyTestVaultScope = "/subscriptions/00000000-0000-0000-0000-000000000001/resourceGroups/rg-kv/providers/Microsoft.KeyVault/vaults/kv-test"
+
+type fakeDenyAssignments struct {
+	assignments []*armauthorization.DenyAssignment
+	err         error
+}
+
+func (f *fakeDenyAssignments) listDenyAssignments(ctx context.Context, scope string) ([]*armauthorization.DenyAssignment, error) {
+	return f.assignments, f.err
+}
+
+// loadDenyAssignments reads a recorded deny assignment list response.
+func loadDenyAssignments(t *testing.T, name string) *fakeDenyAssignments {
+	t.Helper()
+
+	recorded, err := os.ReadFile(filepath.Join("fixtures", "deny_assignments", name))
+	require.NoError(t, err)
+	var result armauthorization.DenyAssignmentListResult
+	require.NoError(t, json.Unmarshal(recorded, &result))
+	return &fakeDenyAssignments{assignments: result.Value}
+}
+
+func TestFindDenyAssignmentGaps(t *testing.T) {
+	t.Parallel()
+
+	denies := loadDenyAssignments(t, "list_for_scope.json")
+	require.Len(t, denies.assignments, 4)
+
+	roles := newFakeRoleAssignments(
+		roleAssignment("aaaaaaaa-0000-0000-0000-000000000001", secretsUserRoleID),
+		roleAssignment("bbbbbbbb-0000-0000-0000-000000000002", cryptoOfficerRoleID),
+		roleAssignment("cccccccc-0000-0000-0000-000000000003", secretsUserRoleID),
+	)
+
+	gaps, err := findDenyAssignmentGaps(context.Background(), roles, denies, denyTestVaultScope)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		`bbbbbbbb-0000-0000-0000-000000000002: denied by "Block secret reads", "Block key operations"`,
+		`cccccccc-0000-0000-0000-000000000003: denied by "Block secret reads"`,
+	}, gaps)
+}
+
+func TestFindDenyAssignmentGapsIgnoresOtherScopes(t *testing.T) {
+	t.Parallel()
+
+	denies := loadDenyAssignments(t, "list_for_scope.json")
+	roles := newFakeRoleAssignments(roleAssignment("bbbbbbbb-0000-0000-0000-000000000002", cryptoOfficerRoleID))
+
+	// Only the subscription-wide assignment reaches a sibling vault, and it
+	// excludes nobody on that list.
+	gaps, err := findDenyAssignmentGaps(context.Background(), roles, denies, "/subscriptions/00000000-0000-0000-0000-000000000001/resourceGroups/rg-kv/providers/Microsoft.KeyVault/vaults/kv-test-2")
+	require.NoError(t, err)
+	assert.Equal(t, []string{`bbbbbbbb-0000-0000-0000-000000000002: denied by "Block secret reads"`}, gaps)
+}
+
+func TestFindDenyAssignmentGapsNoRoles(t *testing.T) {
+	t.Parallel()
+
+	gaps, err := findDenyAssignmentGaps(context.Background(), newFakeRoleAssignments(), loadDenyAssignments(t, "list_for_scope.json"), denyTestVaultScope)
+	require.NoError(t, err)
+	assert.Empty(t, gaps)
+}
+
+func TestFindDenyAssignmentGapsListError(t *testing.T) {
+	t.Parallel()
+
+	denies := &fakeDenyAssignments{err: errors.New("forbidden")}
+
+	_, err := findDenyAssignmentGaps(context.Background(), newFakeRoleAssignments(roleAssignment("app", secretsUserRoleID)), denies, denyTestVaultScope)
+	assert.EqualError(t, err, "forbidden")
+}
+
+func TestDeniesKeyVaultDataActions(t *testing.T) {
+	t.Parallel()
+
+	permission := func(dataActions []string, notDataActions []string) *armauthorization.DenyAssignmentProperties {
+		p := &armauthorization.DenyAssignmentPermission{}
+		for _, a := range dataActions {
+			p.DataActions = append(p.DataActions, to.Ptr(a))
+		}
+		for _, a := range notDataActions {
+			p.NotDataActions = append(p.NotDataActions, to.Ptr(a))
+		}
+		return &armauthorization.DenyAssignmentProperties{Permissions: []*armauthorization.DenyAssignmentPermission{p}}
+	}
+
+	assert.True(t, deniesKeyVaultDataActions(permission([]string{"*"}, nil)))
+	assert.True(t, deniesKeyVaultDataActions(permission([]string{"Microsoft.KeyVault/*"}, nil)))
+	assert.True(t, deniesKeyVaultDataActions(permission([]string{"Microsoft.KeyVault/vaults/keys/decrypt/action"}, nil)))
+	assert.True(t, deniesKeyVaultDataActions(permission([]string{"*"}, []string{"Microsoft.Storage/*"})))
+	assert.False(t, deniesKeyVaultDataActions(permission([]string{"*"}, []string{"Microsoft.KeyVault/*"})))
+	assert.False(t, deniesKeyVaultDataActions(permission([]string{"Microsoft.Storage/storageAccounts/blobServices/containers/blobs/read"}, nil)))
+	assert.False(t, deniesKeyVaultDataActions(permission(nil, nil)))
+}
+
+// failingOp returns errs in order, one per call, then succeeds.
+func failingOp(errs ...error) (func() error, *int) {
+	calls := 0
+	return func() error {
+		calls++
+		if calls <= len(errs) {
+			return errs[calls-1]
+		}
+		return nil
+	}, &calls
+}
+
+func TestRetryUntilRbacPropagated(t *testing.T) {
+	t.Parallel()
+
+	forbidden := &azcore.ResponseError{StatusCode: http.StatusForbidden, ErrorCode: "Forbidden"}
+	op, calls := failingOp(forbidden, forbidden)
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+
+	require.NoError(t, retryUntilRbacPropagated(context.Background(), op, time.Minute, clock.Now, clock.Sleep))
+	assert.Equal(t, 3, *calls)
+	assert.Equal(t, []time.Duration{5 * time.Second, 10 * time.Second}, clock.sleeps)
+}
+
+func TestRetryUntilRbacPropagatedOtherErrorsAreFatal(t *testing.T) {
+	t.Parallel()
+
+	conflict := &azcore.ResponseError{StatusCode: http.StatusConflict, ErrorCode: "Conflict"}
+	op, calls := failingOp(&azcore.ResponseError{StatusCode: http.StatusForbidden}, conflict)
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+
+	err := retryUntilRbacPropagated(context.Background(), op, time.Minute, clock.Now, clock.Sleep)
+	assert.ErrorIs(t, err, conflict)
+	assert.Equal(t, 2, *calls, "a non-403 error should not be retried")
+}
+
+func TestRetryUntilRbacPropagatedTimesOut(t *testing.T) {
+	t.Parallel()
+
+	forbidden := errors.New(`Status=403 Code="Forbidden" InnerError={"code":"ForbiddenByRbac"}`)
+	op, _ := failingOp(forbidden, forbidden, forbidden, forbidden, forbidden)
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+
+	err := retryUntilRbacPropagated(context.Background(), op, 10*time.Second, clock.Now, clock.Sleep)
+	require.Error(t, err)
+	assert.ErrorIs(t, err, forbidden)
+	assert.Contains(t, err.Error(), "timed out after 10s")
+}
+
+func TestKeyVaultRoleAssignments(t *testing.T) {
+	t.Parallel()
+
+	MultiTenantTestRunner(t, func(t *testing.T, config TestConfig) {
+		SetupAzureAuth(t, config)
+		CreateResourceGroup(t, &config)
+
+		keyVaultName := fmt.Sprintf("kv-rbac-%s", config.UniqueID)
+		keyVaultName = PurgeSoftDeletedVault(t, config, keyVaultName)
+		principalID := currentPrincipalObjectID(t, azureCredential(t))
+
+		vars := baseModuleVars(config, keyVaultName)
+		vars["role_assignments"] = map[string]interface{}{
+			"test-runner": map[string]interface{}{
+				"principal_id":         principalID,
+				"role_definition_name": "Key Vault Secrets User",
+				"principal_type":       "ServicePrincipal",
+			},
+		}
+
+		terraformOptions := BuildTerraformOptions(t, config, vars)
+
+		defer terraform.Destroy(t, terraformOptions)
+		terraform.InitAndApply(t, terraformOptions)
+
+		roleAssignmentIDs := terraform.OutputMap(t, terraformOptions, "role_assignment_ids")
+		require.Contains(t, roleAssignmentIDs, "test-runner")
+
+		assignment, roleName := getRoleAssignment(t, config, roleAssignmentIDs["test-runner"])
+		assert.Equal(t, principalID, *assignment.Properties.PrincipalID)
+		assert.Equal(t, "Key Vault Secrets User", roleName)
+		assert.True(t, strings.EqualFold(terraform.Output(t, terraformOptions, "key_vault_id"), *assignment.Properties.Scope))
+
+		ValidateRbacRoleAssignments(t, config, terraform.Output(t, terraformOptions, "key_vault_id"), map[string]string{
+			principalID: "Key Vault Secrets User",
+		})
+		ValidateNoDenyAssignmentGaps(t, config, terraform.Output(t, terraformOptions, "key_vault_id"))
+	})
+}
+
+func TestKeyVaultAuthorizationModes(t *testing.T) {
+	t.Parallel()
+
+	MultiTenantTestRunner(t, func(t *testing.T, config TestConfig) {
+		SetupAzureAuth(t, config)
+		CreateResourceGroup(t, &config)
+
+		principalID := currentPrincipalObjectID(t, azureCredential(t))
+
+		for _, rbacEnabled := range []bool{true, false} {
+			t.Run(fmt.Sprintf("rbac=%t", rbacEnabled), func(t *testing.T) {
+				mode := "rbac"
+				if !rbacEnabled {
+					mode = "ap"
+				}
+				keyVaultName := fmt.Sprintf("kv-%s-%s", mode, config.UniqueID)
+				keyVaultName = PurgeSoftDeletedVault(t, config, keyVaultName)
+
+				vars := baseModuleVars(config, keyVaultName)
+				vars["enable_rbac_authorization"] = rbacEnabled
+				if !rbacEnabled {
+					vars["access_policies"] = map[string]interface{}{
+						"test-runner": map[string]interface{}{
+							"object_id":          principalID,
+							"secret_permissions": []string{"Get", "List", "Set", "Delete"},
+						},
+					}
+				}
+
+				terraformOptions := BuildTerraformOptions(t, config, vars)
+
+				defer terraform.Destroy(t, terraformOptions)
+				terraform.InitAndApply(t, terraformOptions)
+
+				keyVault := getDeployedVault(t, terraformOptions)
+				require.NotNil(t, keyVault.Properties)
+				assert.Equal(t, rbacEnabled, isTrue(keyVault.Properties.EnableRbacAuthorization))
+
+				accessPolicyIDs := terraform.OutputMap(t, terraformOptions, "access_policy_ids")
+				if rbacEnabled {
+					assert.Empty(t, accessPolicyIDs, "an RBAC vault should have no access policies")
+				} else {
+					assert.Contains(t, accessPolicyIDs, "test-runner")
+				}
+			})
+		}
+	})
+}
+
+func TestKeyVaultAuthorizationModeGuards(t *testing.T) {
+	t.Parallel()
+
+	accessPolicies := map[string]interface{}{
+		"platform-team": map[string]interface{}{
+			"object_id":          "00000000-0000-0000-0000-000000000001",
+			"secret_permissions": []string{"Get", "List"},
+		},
+	}
+	testCases := []planCase{
+		{name: "rbac with access policies", vars: map[string]interface{}{"enable_rbac_authorization": true, "access_policies": accessPolicies}, expectedError: "access_policies are set while enable_rbac_authorization is true"},
+		{name: "access policies mode without policies", vars: map[string]interface{}{"enable_rbac_authorization": false, "access_policies": map[string]interface{}{}}, expectedError: "enable_rbac_authorization is false but access_policies is empty"},
+		{
+			name: "access policies mode with policies",
+			vars: map[string]interface{}{"enable_rbac_authorization": false, "access_policies": accessPolicies},
+			check: func(t *testing.T, plan *terraform.PlanStruct) {
+				assert.Contains(t, plan.ResourcePlannedValuesMap, `azurerm_key_vault_access_policy.this["platform-team"]`)
+			},
+		},
+	}
+
+	MultiTenantTestRunner(t, func(t *testing.T, config TestConfig) {
+		SetupAzureAuth(t, config)
+		runPlanCases(t, config, "kv-amg", testCases)
+	})
+}
+
+func TestKeyVaultCrossTenantAccessPolicies(t *testing.T) {
+	t.Parallel()
+
+	const guestTenantID = "11111111-2222-3333-4444-555555555555"
+	crossTenant := func(additionalTenantIDs []string) map[string]interface{} {
+		return map[string]interface{}{
+			"enable_rbac_authorization": false,
+			"additional_tenant_ids":     additionalTenantIDs,
+			"access_policies": map[string]interface{}{
+				"home-team": map[string]interface{}{
+					"object_id":          "00000000-0000-0000-0000-000000000001",
+					"secret_permissions": []string{"Get", "List"},
+				},
+				"guest-app": map[string]interface{}{
+					"tenant_id":          guestTenantID,
+					"object_id":          "00000000-0000-0000-0000-000000000002",
+					"secret_permissions": []string{"Get"},
+				},
+			},
+		}
+	}
+
+	MultiTenantTestRunner(t, func(t *testing.T, config TestConfig) {
+		SetupAzureAuth(t, config)
+
+		runPlanCases(t, config, "kv-xtn", []planCase{
+			{
+				name: "listed guest tenant",
+				vars: crossTenant([]string{strings.ToUpper(guestTenantID)}),
+				check: func(t *testing.T, plan *terraform.PlanStruct) {
+					guest, ok := plan.ResourcePlannedValuesMap[`azurerm_key_vault_access_policy.this["guest-app"]`]
+					require.True(t, ok, "plan should create the guest tenant's access policy")
+					assert.Equal(t, guestTenantID, guest.AttributeValues["tenant_id"])
+					home, ok := plan.ResourcePlannedValuesMap[`azurerm_key_vault_access_policy.this["home-team"]`]
+					require.True(t, ok)
+					assert.Equal(t, config.TenantID, home.AttributeValues["tenant_id"], "tenant_id should default to the vault's tenant")
+				},
+			},
+			{name: "unlisted tenant", vars: crossTenant([]string{}), expectedError: "which is neither the vault's tenant nor listed in additional_tenant_ids"},
+		})
+	})
+}
+
+func TestKeyVaultDeploymentPrincipals(t *testing.T) {
+	t.Parallel()
+
+	const warning = "deployment_principal_ids are only granted Key Vault Secrets User with enabled_for_deployment = true"
+	principalIDs := []string{"00000000-0000-0000-0000-0000000000a1", "00000000-0000-0000-0000-0000000000a2"}
+
+	var testCases []planCase
+	for _, enabledForDeployment := range []bool{true, false} {
+		enabledForDeployment := enabledForDeployment
+		testCases = append(testCases, planCase{
+			name:    fmt.Sprintf("enabled_for_deployment=%t", enabledForDeployment),
+			vars:    map[string]interface{}{"enabled_for_deployment": enabledForDeployment, "deployment_principal_ids": principalIDs},
+			warning: warning,
+			warned:  !enabledForDeployment,
+			check: func(t *testing.T, plan *terraform.PlanStruct) {
+				for i, principalID := range principalIDs {
+					address := fmt.Sprintf("azurerm_role_assignment.deployment_secrets_user[\"%d\"]", i)
+					assignment, ok := plan.ResourcePlannedValuesMap[address]
+					if !enabledForDeployment {
+						assert.False(t, ok, "%s should not be planned without enabled_for_deployment", address)
+						continue
+					}
+					require.True(t, ok, "plan should grant %s Key Vault Secrets User", principalID)
+					assert.Equal(t, principalID, assignment.AttributeValues["principal_id"])
+					assert.Equal(t, "Key Vault Secrets User", assignment.AttributeValues["role_definition_name"])
+					assert.Equal(t, "ServicePrincipal", assignment.AttributeValues["principal_type"])
+				}
+			},
+		})
+	}
+
+	MultiTenantTestRunner(t, func(t *testing.T, config TestConfig) {
+		SetupAzureAuth(t, config)
+		runPlanCases(t, config, "kv-dpl", testCases)
+	})
+}
+
+func TestKeyVaultBreakGlassAccess(t *testing.T) {
+	t.Parallel()
+
+	const breakGlassID = "00000000-0000-0000-0000-0000000000c1"
+
+	var testCases []planCase
+	for _, rbacEnabled := range []bool{true, false} {
+		for _, enabled := range []bool{true, false} {
+			rbacEnabled, enabled := rbacEnabled, enabled
+			vars := map[string]interface{}{
+				"enable_rbac_authorization": rbacEnabled,
+				"break_glass_object_id":     breakGlassID,
+				"break_glass_enabled":       enabled,
+			}
+			if !rbacEnabled {
+				vars["access_policies"] = map[string]interface{}{
+					"reader": map[string]interface{}{
+						"object_id":          "00000000-0000-0000-0000-0000000000c2",
+						"secret_permissions": []string{"Get", "List"},
+					},
+				}
+			}
+
+			testCases = append(testCases, planCase{
+				name: fmt.Sprintf("rbac=%t/break_glass_enabled=%t", rbacEnabled, enabled),
+				vars: vars,
+				check: func(t *testing.T, plan *terraform.PlanStruct) {
+					assignment, hasAssignment := plan.ResourcePlannedValuesMap["azurerm_role_assignment.break_glass[0]"]
+					policy, hasPolicy := plan.ResourcePlannedValuesMap["azurerm_key_vault_access_policy.break_glass[0]"]
+					vault := plan.ResourcePlannedValuesMap["azurerm_key_vault.this[0]"]
+					require.NotNil(t, vault, "the vault should be planned")
+					tags, _ := vault.AttributeValues["tags"].(map[string]interface{})
+
+					if !enabled {
+						assert.False(t, hasAssignment, "no break-glass role assignment should be planned while disabled")
+						assert.False(t, hasPolicy, "no break-glass access policy should be planned while disabled")
+						assert.NotContains(t, tags, "BreakGlass")
+						return
+					}
+					assert.Equal(t, "enabled", tags["BreakGlass"])
+					if rbacEnabled {
+						require.True(t, hasAssignment, "plan should grant the break-glass identity Key Vault Administrator")
+						assert.False(t, hasPolicy)
+						assert.Equal(t, breakGlassID, assignment.AttributeValues["principal_id"])
+						assert.Equal(t, "Key Vault Administrator", assignment.AttributeValues["role_definition_name"])
+						assert.Equal(t, "break-glass", assignment.AttributeValues["description"])
+						return
+					}
+					require.True(t, hasPolicy, "plan should grant the break-glass identity an access policy")
+					assert.False(t, hasAssignment)
+					assert.Equal(t, breakGlassID, policy.AttributeValues["object_id"])
+					for _, permissions := range []string{"key_permissions", "secret_permissions", "certificate_permissions", "storage_permissions"} {
+						assert.Contains(t, policy.AttributeValues[permissions], "Purge", "%s should be complete", permissions)
+					}
+				},
+			})
+		}
+	}
+
+	MultiTenantTestRunner(t, func(t *testing.T, config TestConfig) {
+		SetupAzureAuth(t, config)
+		runPlanCases(t, config, "kv-bg", testCases)
+	})
+}
+
+func TestKeyVaultRecommendedRoles(t *testing.T) {
+	t.Parallel()
+
+	recommends := func(expected ...interface{}) func(t *testing.T, plan *terraform.PlanStruct) {
+		if expected == nil {
+			expected = []interface{}{}
+		}
+		return func(t *testing.T, plan *terraform.PlanStruct) {
+			require.NotNil(t, plan.RawPlan.PlannedValues)
+			output, ok := plan.RawPlan.PlannedValues.Outputs["recommended_roles"]
+			require.True(t, ok, "plan should have the recommended_roles output")
+			assert.Equal(t, expected, output.Value)
+		}
+	}
+
+	testCases := []planCase{
+		{
+			name:  "secrets only",
+			vars:  map[string]interface{}{"secrets": map[string]interface{}{"db-password": map[string]interface{}{"value": "not-a-real-password"}}},
+			check: recommends("Key Vault Secrets User"),
+		},
+		{
+			name: "keys and certificates",
+			vars: map[string]interface{}{
+				"keys":         map[string]interface{}{"app": map[string]interface{}{"name": "app-key", "key_type": "RSA", "key_size": 2048, "key_opts": []string{"wrapKey", "unwrapKey"}}},
+				"certificates": map[string]interface{}{"web": map[string]interface{}{"name": "web", "x509_certificate_properties": map[string]interface{}{"subject": "CN=web"}}},
+			},
+			check: recommends("Key Vault Crypto User", "Key Vault Certificate User"),
+		},
+		{name: "empty vault", check: recommends()},
+	}
+
+	MultiTenantTestRunner(t, func(t *testing.T, config TestConfig) {
+		SetupAzureAuth(t, config)
+		runPlanCases(t, config, "kv-rr", testCases)
+	})
+}
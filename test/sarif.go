@@ -0,0 +1,156 @@
+package test
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	sarifVersion = "2.1.0"
+	sarifSchema  = "https://json.schemastore.org/sarif-2.1.0.json"
+
+	// sarifToolName names the compliance checks as the tool of the SARIF run.
+	sarifToolName = "azure-key-vault-module-compliance"
+
+	// sarifArtifact is the file results point at. Code scanning needs a
+	// location for every result, and violations are about the deployed vault,
+	// so they are all attributed to the module's main configuration.
+	sarifArtifact = "main.tf"
+)
+
+type sarifLog struct {
+	Version string     `json:"version"`
+	Schema  string     `json:"$schema"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name  string      `json:"name"`
+	Rules []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID                   string             `json:"id"`
+	ShortDescription     sarifMessage       `json:"shortDescription"`
+	DefaultConfiguration sarifConfiguration `json:"defaultConfiguration"`
+	Properties           sarifProperties    `json:"properties"`
+}
+
+type sarifConfiguration struct {
+	Level string `json:"level"`
+}
+
+// sarifProperties carries the security-severity score, 0.0 to 10.0, code
+// scanning ranks security alerts by.
+type sarifProperties struct {
+	SecuritySeverity string   `json:"security-severity"`
+	Tags             []string `json:"tags"`
+}
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId"`
+	RuleIndex int             `json:"ruleIndex"`
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+// WriteComplianceSARIF writes violations, as returned by
+// ValidateSecurityCompliance, to path as a SARIF 2.1.0 log for GitHub code
+// scanning: one rule per distinct Rule with its severity, and one result per
+// violation carrying its reason. Without violations the log has no results,
+// which clears earlier alerts on upload. An empty path disables it.
+func WriteComplianceSARIF(t *testing.T, violations []Violation, path string) {
+	t.Helper()
+
+	if path == "" {
+		return
+	}
+	data, err := json.MarshalIndent(complianceSARIF(violations), "", "  ")
+	require.NoError(t, err)
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+	require.NoError(t, os.WriteFile(path, append(data, '\n'), 0o644), "failed to write compliance SARIF to %s", path)
+}
+
+func complianceSARIF(violations []Violation) sarifLog {
+	run := sarifRun{
+		Tool:    sarifTool{Driver: sarifDriver{Name: sarifToolName, Rules: []sarifRule{}}},
+		Results: []sarifResult{},
+	}
+	ruleIndex := map[string]int{}
+	for _, v := range violations {
+		index, ok := ruleIndex[v.Rule]
+		if !ok {
+			index = len(run.Tool.Driver.Rules)
+			ruleIndex[v.Rule] = index
+			run.Tool.Driver.Rules = append(run.Tool.Driver.Rules, sarifRule{
+				ID:                   v.Rule,
+				ShortDescription:     sarifMessage{Text: "Key Vault compliance rule " + v.Rule},
+				DefaultConfiguration: sarifConfiguration{Level: sarifLevel(v.Severity)},
+				Properties:           sarifProperties{SecuritySeverity: sarifSecuritySeverity(v.Severity), Tags: []string{"security"}},
+			})
+		}
+		run.Results = append(run.Results, sarifResult{
+			RuleID:    v.Rule,
+			RuleIndex: index,
+			Level:     sarifLevel(v.Severity),
+			Message:   sarifMessage{Text: v.Reason},
+			Locations: []sarifLocation{{PhysicalLocation: sarifPhysicalLocation{ArtifactLocation: sarifArtifactLocation{URI: sarifArtifact}}}},
+		})
+	}
+	return sarifLog{Version: sarifVersion, Schema: sarifSchema, Runs: []sarifRun{run}}
+}
+
+// sarifLevel maps a severity to a SARIF result level.
+func sarifLevel(s Severity) string {
+	switch s {
+	case SeverityCritical, SeverityHigh:
+		return "error"
+	case SeverityMedium:
+		return "warning"
+	}
+	return "note"
+}
+
+// sarifSecuritySeverity maps a severity to the score code scanning shows as
+// critical (9.0 and up), high (7.0), medium (4.0) or low.
+func sarifSecuritySeverity(s Severity) string {
+	switch s {
+	case SeverityCritical:
+		return "9.5"
+	case SeverityHigh:
+		return "7.5"
+	case SeverityMedium:
+		return "5.0"
+	}
+	return "2.0"
+}
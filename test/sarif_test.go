@@ -0,0 +1,73 @@
+package test
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteComplianceSARIF(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "reports", "compliance.sarif")
+	WriteComplianceSARIF(t, []Violation{
+		{Rule: "purge-protection", Severity: SeverityCritical, Reason: "purge protection is disabled"},
+		{Rule: "public-network-access", Severity: SeverityHigh, Reason: "public network access is enabled"},
+		{Rule: "purge-protection", Severity: SeverityCritical, Reason: "purge protection is disabled on kv-dr"},
+	}, path)
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	var log map[string]interface{}
+	require.NoError(t, json.Unmarshal(data, &log))
+
+	// Required properties of the SARIF 2.1.0 schema, down to each result.
+	assert.Equal(t, "2.1.0", log["version"])
+	runs, _ := log["runs"].([]interface{})
+	require.Len(t, runs, 1)
+	run, _ := runs[0].(map[string]interface{})
+	tool, _ := run["tool"].(map[string]interface{})
+	driver, _ := tool["driver"].(map[string]interface{})
+	require.NotNil(t, driver, "a run needs tool.driver")
+	assert.NotEmpty(t, driver["name"], "tool.driver needs a name")
+
+	rules, _ := driver["rules"].([]interface{})
+	require.Len(t, rules, 2, "a rule violated twice is described once")
+	for i, id := range []string{"purge-protection", "public-network-access"} {
+		rule, _ := rules[i].(map[string]interface{})
+		assert.Equal(t, id, rule["id"])
+		properties, _ := rule["properties"].(map[string]interface{})
+		assert.NotEmpty(t, properties["security-severity"])
+	}
+
+	results, _ := run["results"].([]interface{})
+	require.Len(t, results, 3)
+	for _, r := range results {
+		result, _ := r.(map[string]interface{})
+		message, _ := result["message"].(map[string]interface{})
+		assert.NotEmpty(t, message["text"], "every result needs message.text")
+		assert.NotEmpty(t, result["ruleId"])
+		assert.Equal(t, "error", result["level"])
+		locations, _ := result["locations"].([]interface{})
+		assert.Len(t, locations, 1, "code scanning needs a location per result")
+	}
+	repeated, _ := results[2].(map[string]interface{})
+	assert.Equal(t, "purge-protection", repeated["ruleId"])
+	assert.EqualValues(t, 0, repeated["ruleIndex"], "a repeated rule points at its first description")
+}
+
+func TestComplianceSARIFWithoutViolations(t *testing.T) {
+	t.Parallel()
+
+	data, err := json.Marshal(complianceSARIF(nil))
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"results":[]`, "an empty result list clears earlier alerts")
+	assert.Contains(t, string(data), `"rules":[]`)
+
+	assert.Equal(t, "warning", sarifLevel(SeverityMedium))
+	assert.Equal(t, "note", sarifLevel(SeverityLow))
+}
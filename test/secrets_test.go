@@ -0,0 +1,412 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azsecrets"
+	"github.com/gruntwork-io/terratest/modules/azure"
+	"github.com/gruntwork-io/terratest/modules/logger"
+	"github.com/gruntwork-io/terratest/modules/random"
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestKeyVaultSecrets(t *testing.T) {
+	t.Parallel()
+
+	MultiTenantTestRunner(t, func(t *testing.T, config TestConfig) {
+		SetupAzureAuth(t, config)
+		CreateResourceGroup(t, &config)
+
+		keyVaultName := fmt.Sprintf("kv-sec-%s", config.UniqueID)
+		keyVaultName = PurgeSoftDeletedVault(t, config, keyVaultName)
+		secretValues := map[string]string{
+			"db-connection": fmt.Sprintf("Server=tcp:db.internal;Password=%s", random.UniqueId()),
+			"api-token":     random.UniqueId(),
+		}
+
+		// Secret values go through a var file rather than -var flags so they
+		// never appear in the logged terraform command line.
+		secrets := map[string]interface{}{}
+		for name, value := range secretValues {
+			secrets[name] = map[string]interface{}{
+				"value":        value,
+				"content_type": "text/plain",
+			}
+		}
+		content, err := json.Marshal(map[string]interface{}{"secrets": secrets})
+		require.NoError(t, err)
+		varFile := filepath.Join(t.TempDir(), "secrets.tfvars.json")
+		require.NoError(t, os.WriteFile(varFile, content, 0o600))
+
+		testLogger := &capturingLogger{}
+		terraformOptions := BuildTerraformOptions(t, config, baseModuleVars(config, keyVaultName))
+		terraformOptions.VarFiles = []string{varFile}
+		terraformOptions.Logger = logger.New(testLogger)
+
+		defer terraform.Destroy(t, terraformOptions)
+		terraform.InitAndApply(t, terraformOptions)
+
+		WaitForVaultReady(t, config, keyVaultName, 5*time.Minute)
+
+		secretIDs := terraform.OutputMap(t, terraformOptions, "secret_ids")
+		assert.Len(t, secretIDs, len(secretValues))
+
+		for name, value := range secretValues {
+			assert.True(t, azure.KeyVaultSecretExists(t, keyVaultName, name), "secret %s should exist", name)
+			assert.NotContains(t, secretIDs[name], value)
+			assert.NotContains(t, testLogger.String(), value, "value of secret %s leaked into test output", name)
+		}
+	})
+}
+
+func TestKeyVaultSecretContentTypes(t *testing.T) {
+	t.Parallel()
+
+	MultiTenantTestRunner(t, func(t *testing.T, config TestConfig) {
+		SetupAzureAuth(t, config)
+		CreateResourceGroup(t, &config)
+
+		keyVaultName := fmt.Sprintf("kv-sct-%s", config.UniqueID)
+		keyVaultName = PurgeSoftDeletedVault(t, config, keyVaultName)
+
+		content, err := json.Marshal(map[string]interface{}{"secrets": map[string]interface{}{
+			"typed": map[string]interface{}{
+				"value":        random.UniqueId(),
+				"content_type": "text/plain",
+			},
+			"untyped": map[string]interface{}{
+				"value": random.UniqueId(),
+			},
+		}})
+		require.NoError(t, err)
+		varFile := filepath.Join(t.TempDir(), "secrets.tfvars.json")
+		require.NoError(t, os.WriteFile(varFile, content, 0o600))
+
+		terraformOptions := BuildTerraformOptions(t, config, baseModuleVars(config, keyVaultName))
+		terraformOptions.VarFiles = []string{varFile}
+
+		defer terraform.Destroy(t, terraformOptions)
+		terraform.InitAndApply(t, terraformOptions)
+
+		WaitForVaultReady(t, config, keyVaultName, 5*time.Minute)
+
+		// Not required, so the missing content type is reported without
+		// failing the test.
+		assert.Equal(t, []string{"untyped"}, ValidateSecretContentTypes(t, config, keyVaultName, false))
+	})
+}
+
+func TestKeyVaultSecretNotBeforeDate(t *testing.T) {
+	t.Parallel()
+
+	MultiTenantTestRunner(t, func(t *testing.T, config TestConfig) {
+		SetupAzureAuth(t, config)
+		CreateResourceGroup(t, &config)
+
+		keyVaultName := PurgeSoftDeletedVault(t, config, fmt.Sprintf("kv-snb-%s", config.UniqueID))
+		notBefore := time.Now().UTC().AddDate(0, 0, 7).Truncate(time.Second)
+		vars := baseModuleVars(config, keyVaultName)
+		vars["secrets"] = map[string]interface{}{
+			"next": map[string]interface{}{
+				"name":            "db-password-next",
+				"value":           "rotated-credential",
+				"not_before_date": notBefore.Format(time.RFC3339),
+				"expiration_date": notBefore.AddDate(0, 3, 0).Format(time.RFC3339),
+			},
+		}
+
+		terraformOptions := BuildTerraformOptions(t, config, vars)
+
+		defer terraform.Destroy(t, terraformOptions)
+		terraform.InitAndApply(t, terraformOptions)
+
+		WaitForVaultReady(t, config, keyVaultName, 5*time.Minute)
+
+		ValidateSecretNotBefore(t, config, keyVaultName, "db-password-next", notBefore)
+	})
+}
+
+func TestKeyVaultSecretFromFile(t *testing.T) {
+	t.Parallel()
+
+	MultiTenantTestRunner(t, func(t *testing.T, config TestConfig) {
+		SetupAzureAuth(t, config)
+		CreateResourceGroup(t, &config)
+
+		keyVaultName := PurgeSoftDeletedVault(t, config, fmt.Sprintf("kv-sff-%s", config.UniqueID))
+
+		// Multi-line, to check the file is stored as is
+		value := fmt.Sprintf("-----BEGIN TOKEN-----\n%s\n-----END TOKEN-----\n", random.UniqueId())
+		valueFile := filepath.Join(t.TempDir(), "db-password.txt")
+		require.NoError(t, os.WriteFile(valueFile, []byte(value), 0o600))
+
+		vars := baseModuleVars(config, keyVaultName)
+		vars["secrets"] = map[string]interface{}{
+			"db-password": map[string]interface{}{"value_from_file": valueFile},
+		}
+
+		terraformOptions := BuildTerraformOptions(t, config, vars)
+
+		defer terraform.Destroy(t, terraformOptions)
+		terraform.InitAndApply(t, terraformOptions)
+
+		WaitForVaultReady(t, config, keyVaultName, 5*time.Minute)
+		secret, err := secretsClient(t, keyVaultName).GetSecret(context.Background(), "db-password", "", nil)
+		require.NoError(t, err, "failed to read db-password")
+		require.NotNil(t, secret.Value)
+		assert.Equal(t, value, *secret.Value)
+	})
+}
+
+func TestKeyVaultExpiredSecrets(t *testing.T) {
+	t.Parallel()
+
+	MultiTenantTestRunner(t, func(t *testing.T, config TestConfig) {
+		SetupAzureAuth(t, config)
+		CreateResourceGroup(t, &config)
+
+		keyVaultName := fmt.Sprintf("kv-sex-%s", config.UniqueID)
+		keyVaultName = PurgeSoftDeletedVault(t, config, keyVaultName)
+
+		vars := baseModuleVars(config, keyVaultName)
+		vars["secrets"] = map[string]interface{}{
+			"fresh": map[string]interface{}{"value": random.UniqueId(), "expiration_date": time.Now().AddDate(1, 0, 0).UTC().Format(time.RFC3339)},
+			"soon":  map[string]interface{}{"value": random.UniqueId(), "expiration_date": time.Now().AddDate(0, 0, 3).UTC().Format(time.RFC3339)},
+		}
+
+		terraformOptions := BuildTerraformOptions(t, config, vars)
+
+		defer terraform.Destroy(t, terraformOptions)
+		terraform.InitAndApply(t, terraformOptions)
+
+		WaitForVaultReady(t, config, keyVaultName, 5*time.Minute)
+
+		// The provider reads secret values on refresh, which Key Vault refuses
+		// for expired secrets, so the expired one is created outside Terraform.
+		ctx := context.Background()
+		client := secretsClient(t, keyVaultName)
+		_, err := client.SetSecret(ctx, "expired", azsecrets.SetSecretParameters{
+			Value:            to.Ptr(random.UniqueId()),
+			SecretAttributes: &azsecrets.SecretAttributes{Expires: to.Ptr(time.Now().Add(-time.Hour))},
+		}, nil)
+		require.NoError(t, err)
+
+		// ValidateNoExpiredSecrets would fail this test on purpose, so check
+		// what it reports directly.
+		expiring, err := findExpiringSecrets(ctx, azureSecretLister{client}, time.Now(), 7*24*time.Hour)
+		require.NoError(t, err)
+		require.Len(t, expiring, 2)
+		assert.Contains(t, expiring[0], "expired expired at")
+		assert.Contains(t, expiring[1], "soon expires at")
+	})
+}
+
+func TestKeyVaultSecretRotation(t *testing.T) {
+	t.Parallel()
+
+	MultiTenantTestRunner(t, func(t *testing.T, config TestConfig) {
+		SetupAzureAuth(t, config)
+		CreateResourceGroup(t, &config)
+
+		keyVaultName := PurgeSoftDeletedVault(t, config, fmt.Sprintf("kv-rot-%s", config.UniqueID))
+
+		content, err := json.Marshal(map[string]interface{}{"secrets": map[string]interface{}{
+			"api-token": map[string]interface{}{"value": random.UniqueId()},
+		}})
+		require.NoError(t, err)
+		varFile := filepath.Join(t.TempDir(), "secrets.tfvars.json")
+		require.NoError(t, os.WriteFile(varFile, content, 0o600))
+
+		terraformOptions := BuildTerraformOptions(t, config, baseModuleVars(config, keyVaultName))
+		terraformOptions.VarFiles = []string{varFile}
+
+		defer terraform.Destroy(t, terraformOptions)
+		terraform.InitAndApply(t, terraformOptions)
+
+		WaitForVaultReady(t, config, keyVaultName, 5*time.Minute)
+		ctx := context.Background()
+		client := secretsClient(t, keyVaultName)
+		assert.Equal(t, 1, GetSecretVersionCount(t, config, keyVaultName, "api-token"))
+
+		for i, disableCurrent := range []bool{false, true} {
+			if disableCurrent {
+				// Rotation must still work when the current version is disabled.
+				RetryUntilRbacPropagated(t, func() error {
+					_, err := client.UpdateSecretProperties(ctx, "api-token", "", azsecrets.UpdateSecretPropertiesParameters{
+						SecretAttributes: &azsecrets.SecretAttributes{Enabled: to.Ptr(false)},
+					}, nil)
+					return err
+				}, 5*time.Minute)
+			}
+
+			newValue := random.UniqueId()
+			id := RotateSecret(t, config, keyVaultName, "api-token", newValue)
+			assert.Equal(t, i+2, GetSecretVersionCount(t, config, keyVaultName, "api-token"))
+
+			latest, err := client.GetSecret(ctx, "api-token", "", nil)
+			require.NoError(t, err, "failed to read the latest version of api-token")
+			require.NotNil(t, latest.ID)
+			assert.Equal(t, id, string(*latest.ID), "the rotated version should be the latest")
+			require.NotNil(t, latest.Value)
+			assert.Equal(t, newValue, *latest.Value)
+		}
+	})
+}
+
+func TestKeyVaultSecretRecovery(t *testing.T) {
+	t.Parallel()
+
+	if os.Getenv("KV_TEST_SECRET_RECOVERY") == "" {
+		t.Skip("KV_TEST_SECRET_RECOVERY is not set; skipping the secret delete and recover cycle")
+	}
+
+	MultiTenantTestRunner(t, func(t *testing.T, config TestConfig) {
+		SetupAzureAuth(t, config)
+		CreateResourceGroup(t, &config)
+
+		keyVaultName := fmt.Sprintf("kv-srcv-%s", config.UniqueID)
+		keyVaultName = PurgeSoftDeletedVault(t, config, keyVaultName)
+
+		// Purge protection blocks purging the deleted secret, so recovery is
+		// the only way back. The vault stays soft-deleted for the 7 days of
+		// retention after the test.
+		vars := baseModuleVars(config, keyVaultName)
+		vars["purge_protection_enabled"] = true
+		vars["soft_delete_retention_days"] = 7
+		vars["rbac_secrets_officers"] = []string{currentPrincipalObjectID(t, azureCredential(t))}
+		vars["secrets"] = map[string]interface{}{
+			"db-password": map[string]interface{}{
+				"name":  "db-password",
+				"value": random.UniqueId(),
+			},
+		}
+
+		terraformOptions := BuildTerraformOptions(t, config, vars)
+
+		defer terraform.Destroy(t, terraformOptions)
+		terraform.InitAndApply(t, terraformOptions)
+
+		ValidateSecretRecoverable(t, config, keyVaultName, "db-password")
+
+		// The recovered secret is the one in state, so there is nothing to change
+		assert.Equal(t, 0, terraform.PlanExitCode(t, terraformOptions), "plan after recovery should be empty")
+	})
+}
+
+func TestKeyVaultSeedSecrets(t *testing.T) {
+	t.Parallel()
+
+	MultiTenantTestRunner(t, func(t *testing.T, config TestConfig) {
+		SetupAzureAuth(t, config)
+		CreateResourceGroup(t, &config)
+
+		keyVaultName := PurgeSoftDeletedVault(t, config, fmt.Sprintf("kv-seed-%s", config.UniqueID))
+		vars := baseModuleVars(config, keyVaultName)
+		vars["rbac_secrets_officers"] = []string{currentPrincipalObjectID(t, azureCredential(t))}
+
+		terraformOptions := BuildTerraformOptions(t, config, vars)
+
+		defer terraform.Destroy(t, terraformOptions)
+		terraform.InitAndApply(t, terraformOptions)
+
+		WaitForVaultReady(t, config, keyVaultName, 5*time.Minute)
+
+		secrets := map[string]string{}
+		for i := 0; i < 20; i++ {
+			secrets[fmt.Sprintf("seeded-%02d", i)] = random.UniqueId()
+		}
+		SeedSecrets(t, config, keyVaultName, secrets, 5)
+
+		listed, err := azureSecretLister{secretsClient(t, keyVaultName)}.listSecrets(context.Background())
+		require.NoError(t, err)
+		names := map[string]bool{}
+		for _, secret := range listed {
+			if secret.ID != nil {
+				names[secret.ID.Name()] = true
+			}
+		}
+		for name := range secrets {
+			assert.True(t, names[name], "secret %s should have been seeded", name)
+		}
+	})
+}
+
+func TestKeyVaultDataLifecycleTag(t *testing.T) {
+	t.Parallel()
+
+	MultiTenantTestRunner(t, func(t *testing.T, config TestConfig) {
+		SetupAzureAuth(t, config)
+		CreateResourceGroup(t, &config)
+
+		keyVaultName := PurgeSoftDeletedVault(t, config, fmt.Sprintf("kv-lcy-%s", config.UniqueID))
+		vars := baseModuleVars(config, keyVaultName)
+		vars["data_lifecycle"] = "ephemeral"
+		vars["secrets"] = map[string]interface{}{
+			"scratch": map[string]interface{}{"value": random.UniqueId()},
+			"keep-me": map[string]interface{}{"value": random.UniqueId(), "tags": map[string]string{"lifecycle": "permanent"}},
+		}
+
+		terraformOptions := BuildTerraformOptions(t, config, vars)
+
+		defer terraform.Destroy(t, terraformOptions)
+		terraform.InitAndApply(t, terraformOptions)
+
+		WaitForVaultReady(t, config, keyVaultName, 5*time.Minute)
+		client := secretsClient(t, keyVaultName)
+		for name, expected := range map[string]string{"scratch": "ephemeral", "keep-me": "permanent"} {
+			secret, err := client.GetSecret(context.Background(), name, "", nil)
+			require.NoError(t, err, "failed to read %s", name)
+			require.NotNil(t, secret.Tags["lifecycle"], "secret %s should carry the lifecycle tag", name)
+			assert.Equal(t, expected, *secret.Tags["lifecycle"], "lifecycle tag of secret %s", name)
+		}
+	})
+}
+
+func TestKeyVaultCSIProviderClass(t *testing.T) {
+	t.Parallel()
+
+	MultiTenantTestRunner(t, func(t *testing.T, config TestConfig) {
+		SetupAzureAuth(t, config)
+
+		keyVaultName := fmt.Sprintf("kv-csi-%s", config.UniqueID)
+		vars := baseModuleVars(config, keyVaultName)
+		vars["create_resource_group"] = true
+		vars["secrets"] = map[string]interface{}{
+			"db":  map[string]interface{}{"name": "db-password", "value": random.UniqueId()},
+			"api": map[string]interface{}{"value": random.UniqueId()},
+		}
+		vars["emit_csi_provider_class"] = true
+		vars["csi_provider_class"] = map[string]interface{}{
+			"path":      filepath.Join(t.TempDir(), "secret-provider-class.yaml"),
+			"namespace": "payments",
+		}
+
+		terraformOptions := planFixture(t, config, vars)
+
+		plan := terraform.InitAndPlanAndShowWithStruct(t, terraformOptions)
+		manifest, ok := plan.ResourcePlannedValuesMap["local_file.csi_provider_class[0]"]
+		require.True(t, ok, "plan should write the SecretProviderClass manifest")
+
+		content, _ := manifest.AttributeValues["content"].(string)
+		assert.Contains(t, content, "kind: SecretProviderClass")
+		assert.Contains(t, content, "namespace: payments")
+		assert.Contains(t, content, fmt.Sprintf("keyvaultName: %q", keyVaultName))
+		assert.Contains(t, content, fmt.Sprintf("tenantId: %q", config.TenantID))
+		assert.Contains(t, content, "objectName: api\n")
+		assert.Contains(t, content, "objectName: db-password\n")
+		assert.NotContains(t, content, "clientID")
+
+		rendered := map[string]interface{}{}
+		require.NoError(t, yaml.Unmarshal([]byte(content), &rendered), "manifest should be valid YAML")
+	})
+}
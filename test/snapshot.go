@@ -0,0 +1,138 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"testing"
+
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// updateSnapshots rewrites golden files with the current plan instead of
+// comparing against them: go test ./... -run Snapshot -update
+var updateSnapshots = flag.Bool("update", false, "update plan snapshot golden files")
+
+var (
+	snapshotUUID      = regexp.MustCompile(`[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}`)
+	snapshotTimestamp = regexp.MustCompile(`\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}:\d{2})`)
+	snapshotDate      = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}$`)
+)
+
+// planSnapshotChange is the part of a plan's resource change kept in a
+// snapshot: what would happen to which resource, and its known planned values.
+type planSnapshotChange struct {
+	Address string                 `json:"address"`
+	Actions []string               `json:"actions"`
+	After   map[string]interface{} `json:"after,omitempty"`
+}
+
+// RunPlanSnapshot plans terraformOptions and compares the planned managed
+// resource changes against goldenFile, so resource count and attribute
+// changes show up in review without a deployment. GUIDs (tenant and
+// subscription IDs, including inside resource IDs), timestamps and dates are
+// masked; callers should use fixed names rather than random suffixes. With
+// -update the golden file is rewritten instead.
+func RunPlanSnapshot(t *testing.T, terraformOptions *terraform.Options, goldenFile string) {
+	t.Helper()
+
+	planOptions, err := terraformOptions.Clone()
+	require.NoError(t, err)
+	planOptions.PlanFilePath = filepath.Join(t.TempDir(), "plan.out")
+
+	snapshot, err := normalizePlanSnapshot([]byte(terraform.InitAndPlanAndShow(t, planOptions)))
+	require.NoError(t, err)
+
+	if *updateSnapshots {
+		require.NoError(t, os.MkdirAll(filepath.Dir(goldenFile), 0o755))
+		require.NoError(t, os.WriteFile(goldenFile, snapshot, 0o644))
+		t.Logf("updated plan snapshot %s", goldenFile)
+		return
+	}
+
+	golden, err := os.ReadFile(goldenFile)
+	require.NoError(t, err, "missing plan snapshot %s; run with -update to create it", goldenFile)
+	assert.Equal(t, string(golden), string(snapshot), "plan differs from snapshot %s; run with -update if the change is intended", goldenFile)
+}
+
+// normalizePlanSnapshot reduces `terraform show -json` output to the managed
+// resource changes, sorted by address, with volatile values masked.
+func normalizePlanSnapshot(planJSON []byte) ([]byte, error) {
+	var plan struct {
+		ResourceChanges []struct {
+			Address string `json:"address"`
+			Mode    string `json:"mode"`
+			Change  struct {
+				Actions []string               `json:"actions"`
+				After   map[string]interface{} `json:"after"`
+			} `json:"change"`
+		} `json:"resource_changes"`
+	}
+	if err := json.Unmarshal(planJSON, &plan); err != nil {
+		return nil, fmt.Errorf("failed to parse plan JSON: %w", err)
+	}
+
+	changes := []planSnapshotChange{}
+	for _, rc := range plan.ResourceChanges {
+		if rc.Mode != "managed" {
+			continue
+		}
+		after, _ := normalizeSnapshotValue(rc.Change.After).(map[string]interface{})
+		changes = append(changes, planSnapshotChange{
+			Address: rc.Address,
+			Actions: rc.Change.Actions,
+			After:   after,
+		})
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Address < changes[j].Address
+	})
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(map[string]interface{}{"resource_changes": changes}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// normalizeSnapshotValue masks volatile strings and drops null attributes,
+// which only say an optional argument was left unset.
+func normalizeSnapshotValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if v == nil {
+			return nil
+		}
+		out := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			if item == nil {
+				continue
+			}
+			out[key] = normalizeSnapshotValue(item)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			out[i] = normalizeSnapshotValue(item)
+		}
+		return out
+	case string:
+		if snapshotDate.MatchString(v) {
+			return "<date>"
+		}
+		v = snapshotTimestamp.ReplaceAllString(v, "<timestamp>")
+		return snapshotUUID.ReplaceAllString(v, "<uuid>")
+	}
+	return value
+}
@@ -0,0 +1,127 @@
+package test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizePlanSnapshot(t *testing.T) {
+	planJSON := `{
+		"format_version": "1.2",
+		"terraform_version": "1.6.6",
+		"timestamp": "2024-03-01T10:00:00Z",
+		"resource_changes": [
+			{
+				"address": "azurerm_key_vault.this[0]",
+				"mode": "managed",
+				"change": {
+					"actions": ["create"],
+					"after": {
+						"name": "kv-snapshot",
+						"tenant_id": "72f988bf-86f1-41af-91ab-2d7cd011db47",
+						"resource_group_id": "/subscriptions/00000000-1111-2222-3333-444444444444/resourceGroups/rg",
+						"contact": null,
+						"tags": {"CreatedDate": "2024-03-01", "ManagedBy": "Terraform"}
+					}
+				}
+			},
+			{
+				"address": "data.azurerm_client_config.current",
+				"mode": "data",
+				"change": {"actions": ["read"], "after": {}}
+			},
+			{
+				"address": "azurerm_resource_group.this[0]",
+				"mode": "managed",
+				"change": {
+					"actions": ["create"],
+					"after": {"name": "rg", "expires": "2024-03-01T10:00:00.123+01:00", "ip_rules": []}
+				}
+			}
+		]
+	}`
+
+	snapshot, err := normalizePlanSnapshot([]byte(planJSON))
+	require.NoError(t, err)
+
+	assert.JSONEq(t, `{
+		"resource_changes": [
+			{
+				"address": "azurerm_key_vault.this[0]",
+				"actions": ["create"],
+				"after": {
+					"name": "kv-snapshot",
+					"tenant_id": "<uuid>",
+					"resource_group_id": "/subscriptions/<uuid>/resourceGroups/rg",
+					"tags": {"CreatedDate": "<date>", "ManagedBy": "Terraform"}
+				}
+			},
+			{
+				"address": "azurerm_resource_group.this[0]",
+				"actions": ["create"],
+				"after": {"name": "rg", "expires": "<timestamp>", "ip_rules": []}
+			}
+		]
+	}`, string(snapshot))
+}
+
+func TestNormalizePlanSnapshotIsStable(t *testing.T) {
+	planJSON := `{"resource_changes": [
+		{"address": "b", "mode": "managed", "change": {"actions": ["create"], "after": {"z": 1, "a": 2}}},
+		{"address": "a", "mode": "managed", "change": {"actions": ["delete"], "after": null}}
+	]}`
+
+	first, err := normalizePlanSnapshot([]byte(planJSON))
+	require.NoError(t, err)
+	second, err := normalizePlanSnapshot([]byte(planJSON))
+	require.NoError(t, err)
+
+	assert.Equal(t, string(first), string(second))
+	assert.Equal(t, `{
+  "resource_changes": [
+    {
+      "address": "a",
+      "actions": [
+        "delete"
+      ]
+    },
+    {
+      "address": "b",
+      "actions": [
+        "create"
+      ],
+      "after": {
+        "a": 2,
+        "z": 1
+      }
+    }
+  ]
+}
+`, string(first))
+}
+
+func TestNormalizePlanSnapshotInvalidJSON(t *testing.T) {
+	_, err := normalizePlanSnapshot([]byte("not json"))
+	assert.Error(t, err)
+}
+
+func TestKeyVaultPlanSnapshot(t *testing.T) {
+	t.Parallel()
+
+	MultiTenantTestRunner(t, func(t *testing.T, config TestConfig) {
+		SetupAzureAuth(t, config)
+
+		// Fixed names and region keep the plan identical across runs and tenants
+		vars := baseModuleVars(config, "kv-snapshot-base")
+		vars["location"] = "westeurope"
+		vars["resource_group_name"] = "rg-kv-snapshot"
+		vars["create_resource_group"] = true
+
+		terraformOptions := planFixture(t, config, vars)
+
+		RunPlanSnapshot(t, terraformOptions, filepath.Join("fixtures", "snapshots", "base_vault.json"))
+	})
+}
@@ -0,0 +1,61 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// tenantsFileEnv names the environment variable pointing at a YAML or JSON
+// list of TestConfig entries, one per tenant to test against.
+const tenantsFileEnv = "KV_TEST_TENANTS_FILE"
+
+// loadTenantsFile reads TestConfig entries from a .json, .yaml or .yml file.
+// Tenants are often pinned to a region for data residency, so every entry
+// must set region or region_list; there is no default region. Without region
+// it takes the first of region_list. ResourceGroup falls back to the harness
+// default when omitted.
+func loadTenantsFile(path string) ([]TestConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var configs []TestConfig
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".json":
+		err = json.Unmarshal(data, &configs)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &configs)
+	default:
+		return nil, fmt.Errorf("unsupported tenants file extension %q; use .json, .yaml or .yml", ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	if len(configs) == 0 {
+		return nil, fmt.Errorf("%s lists no tenants", path)
+	}
+
+	for i := range configs {
+		c := &configs[i]
+		if c.TenantID == "" || c.SubscriptionID == "" {
+			return nil, fmt.Errorf("%s: entry %d must set tenant_id and subscription_id", path, i)
+		}
+		c.Region = strings.TrimSpace(c.Region)
+		if c.Region == "" && len(c.RegionList) > 0 {
+			c.Region = c.RegionList[0]
+		}
+		if c.Region == "" {
+			return nil, fmt.Errorf("%s: entry %d (tenant %s) must set region or region_list", path, i, c.TenantID)
+		}
+		if c.ResourceGroup == "" {
+			c.ResourceGroup = defaultTestResourceGroup
+		}
+	}
+	return configs, nil
+}
@@ -0,0 +1,251 @@
+package test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const tenantsJSON = `[
+  {"name": "corp", "tenant_id": "00000000-0000-0000-0000-000000000001", "subscription_id": "00000000-0000-0000-0001-000000000001", "region": "northeurope", "resource_group": "rg-kv-corp", "unique_id_prefix": "c"},
+  {"tenant_id": "00000000-0000-0000-0000-000000000002", "subscription_id": "00000000-0000-0000-0001-000000000002", "region_list": ["swedencentral", "westeurope"]},
+  {"name": "partner", "tenant_id": "00000000-0000-0000-0000-000000000003", "subscription_id": "00000000-0000-0000-0001-000000000003", "region": "eastus2"}
+]`
+
+const tenantsYAML = `
+- name: corp
+  tenant_id: 00000000-0000-0000-0000-000000000001
+  subscription_id: 00000000-0000-0000-0001-000000000001
+  region: northeurope
+  resource_group: rg-kv-corp
+  unique_id_prefix: c
+- tenant_id: 00000000-0000-0000-0000-000000000002
+  subscription_id: 00000000-0000-0000-0001-000000000002
+  region_list:
+    - swedencentral
+    - westeurope
+- name: partner
+  tenant_id: 00000000-0000-0000-0000-000000000003
+  subscription_id: 00000000-0000-0000-0001-000000000003
+  region: eastus2
+`
+
+func writeTenantsFile(t *testing.T, name string, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestLoadTenantsFile(t *testing.T) {
+	t.Parallel()
+
+	expected := []TestConfig{
+		{Name: "corp", TenantID: "00000000-0000-0000-0000-000000000001", SubscriptionID: "00000000-0000-0000-0001-000000000001", Region: "northeurope", ResourceGroup: "rg-kv-corp", UniqueIDPrefix: "c"},
+		{TenantID: "00000000-0000-0000-0000-000000000002", SubscriptionID: "00000000-0000-0000-0001-000000000002", Region: "swedencentral", RegionList: []string{"swedencentral", "westeurope"}, ResourceGroup: defaultTestResourceGroup},
+		{Name: "partner", TenantID: "00000000-0000-0000-0000-000000000003", SubscriptionID: "00000000-0000-0000-0001-000000000003", Region: "eastus2", ResourceGroup: defaultTestResourceGroup},
+	}
+
+	for name, content := range map[string]string{"tenants.json": tenantsJSON, "tenants.yaml": tenantsYAML} {
+		name, content := name, content
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			configs, err := loadTenantsFile(writeTenantsFile(t, name, content))
+			require.NoError(t, err)
+			assert.Equal(t, expected, configs)
+		})
+	}
+}
+
+func TestLoadTenantsFileErrors(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		file          string
+		content       string
+		expectedError string
+	}{
+		"unsupported extension": {"tenants.toml", "", `unsupported tenants file extension ".toml"`},
+		"empty list":            {"tenants.json", "[]", "lists no tenants"},
+		"missing subscription":  {"tenants.json", `[{"tenant_id": "t"}]`, "entry 0 must set tenant_id and subscription_id"},
+		"malformed":             {"tenants.json", `{`, "failed to parse"},
+		"missing region":        {"tenants.json", `[{"tenant_id": "t", "subscription_id": "s", "region": " "}]`, "entry 0 (tenant t) must set region or region_list"},
+	}
+
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := loadTenantsFile(writeTenantsFile(t, tc.file, tc.content))
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tc.expectedError)
+		})
+	}
+}
+
+func TestMultiTenantTestRunnerEnumeratesTenantsFile(t *testing.T) {
+	t.Setenv(tenantsFileEnv, writeTenantsFile(t, "tenants.json", tenantsJSON))
+
+	var mu sync.Mutex
+	seen := map[string]TestConfig{}
+	MultiTenantTestRunner(t, func(t *testing.T, config TestConfig) {
+		mu.Lock()
+		defer mu.Unlock()
+		seen[t.Name()] = config
+	})
+
+	require.Len(t, seen, 3)
+	corp := seen["TestMultiTenantTestRunnerEnumeratesTenantsFile/corp"]
+	assert.Equal(t, "00000000-0000-0000-0001-000000000001", corp.SubscriptionID)
+	assert.True(t, strings.HasPrefix(corp.UniqueID, "c"), "UniqueID %q should start with the configured prefix", corp.UniqueID)
+	assert.Contains(t, seen, "TestMultiTenantTestRunnerEnumeratesTenantsFile/tenant=00000000-0000-0000-0000-000000000002")
+	assert.Contains(t, seen, "TestMultiTenantTestRunnerEnumeratesTenantsFile/partner")
+
+	uniqueIDs := map[string]bool{}
+	for _, config := range seen {
+		uniqueIDs[config.UniqueID] = true
+	}
+	assert.Len(t, uniqueIDs, 3, "every tenant should get its own UniqueID")
+}
+
+func TestMultiTenantTestRunnerKeepsTenantRegions(t *testing.T) {
+	t.Setenv(tenantsFileEnv, writeTenantsFile(t, "tenants.json", `[
+  {"name": "eu", "tenant_id": "00000000-0000-0000-0000-000000000001", "subscription_id": "00000000-0000-0000-0001-000000000001", "region": "westeurope"},
+  {"name": "us", "tenant_id": "00000000-0000-0000-0000-000000000002", "subscription_id": "00000000-0000-0000-0001-000000000002", "region": "eastus2"}
+]`))
+
+	var mu sync.Mutex
+	regions := map[string]string{}
+	MultiTenantTestRunner(t, func(t *testing.T, config TestConfig) {
+		mu.Lock()
+		defer mu.Unlock()
+		regions[config.Name] = config.Region
+	})
+
+	assert.Equal(t, map[string]string{"eu": "westeurope", "us": "eastus2"}, regions)
+}
+
+func TestMultiTenantTestRunnerBoundsParallelism(t *testing.T) {
+	var entries []string
+	for i := 0; i < 7; i++ {
+		entries = append(entries, fmt.Sprintf(`{"name": "tenant-%d", "tenant_id": "00000000-0000-0000-0000-00000000000%d", "subscription_id": "00000000-0000-0000-0001-00000000000%d", "region": "westeurope"}`, i, i, i))
+	}
+	t.Setenv(tenantsFileEnv, writeTenantsFile(t, "tenants.json", "["+strings.Join(entries, ",")+"]"))
+
+	var (
+		mu        sync.Mutex
+		running   int
+		maxSeen   int
+		uniqueIDs = map[string]bool{}
+	)
+	t.Run("runner", func(t *testing.T) {
+		MultiTenantTestRunner(t, func(t *testing.T, config TestConfig) {
+			mu.Lock()
+			running++
+			if running > maxSeen {
+				maxSeen = running
+			}
+			uniqueIDs[config.UniqueID] = true
+			mu.Unlock()
+
+			time.Sleep(20 * time.Millisecond)
+
+			mu.Lock()
+			running--
+			mu.Unlock()
+		}, MaxParallelTenants(3))
+	})
+
+	assert.LessOrEqual(t, maxSeen, 3, "no more than three tenants should run at once")
+	assert.Len(t, uniqueIDs, 7, "every tenant should get its own UniqueID")
+}
+
+func TestMultiTenantTestRunnerTenantHooks(t *testing.T) {
+	t.Setenv(tenantsFileEnv, writeTenantsFile(t, "tenants.json", `[
+  {"name": "corp", "tenant_id": "00000000-0000-0000-0000-000000000001", "subscription_id": "00000000-0000-0000-0001-000000000001", "region": "westeurope"},
+  {"name": "partner", "tenant_id": "00000000-0000-0000-0000-000000000002", "subscription_id": "00000000-0000-0000-0001-000000000002", "region": "westeurope"}
+]`))
+
+	var events []string
+	record := func(event string) func(t *testing.T, config TestConfig) {
+		return func(t *testing.T, config TestConfig) {
+			events = append(events, event+" "+config.Name)
+		}
+	}
+	MultiTenantTestRunner(t, func(t *testing.T, config TestConfig) {
+		record("test")(t, config)
+		if config.Name == "partner" {
+			t.SkipNow()
+		}
+	}, BeforeTenant(record("before")), AfterTenant(record("after")), MaxParallelTenants(1))
+
+	assert.Equal(t, []string{
+		"before corp", "test corp", "after corp",
+		"before partner", "test partner", "after partner",
+	}, events, "AfterTenant should run even when the tenant's test stops early")
+}
+
+func TestMultiTenantTestRunnerPlanOnly(t *testing.T) {
+	t.Setenv(tenantsFileEnv, writeTenantsFile(t, "tenants.json", tenantsJSON))
+	t.Setenv(planOnlyEnv, "1")
+
+	var (
+		mu       sync.Mutex
+		applied  []string
+		planned  = map[string]string{}
+		planFake = func(o *runnerOptions) {
+			o.initAndPlan = func(t *testing.T, options *terraform.Options) (string, error) {
+				mu.Lock()
+				defer mu.Unlock()
+				planned[options.EnvVars["ARM_TENANT_ID"]] = options.Vars["location"].(string)
+				return "Plan: 1 to add, 0 to change, 0 to destroy.", nil
+			}
+		}
+	)
+	MultiTenantTestRunner(t, func(t *testing.T, config TestConfig) {
+		mu.Lock()
+		defer mu.Unlock()
+		applied = append(applied, config.TenantID)
+	}, planFake, PlanOnlyOptions(func(t *testing.T, config TestConfig) *terraform.Options {
+		return &terraform.Options{
+			Vars:    map[string]interface{}{"location": config.Region},
+			EnvVars: TerraformEnvVars(config),
+		}
+	}))
+
+	assert.Empty(t, applied, "testFunc, which applies and destroys, must not run in plan-only mode")
+	assert.Equal(t, map[string]string{
+		"00000000-0000-0000-0000-000000000001": "northeurope",
+		"00000000-0000-0000-0000-000000000002": "swedencentral",
+		"00000000-0000-0000-0000-000000000003": "eastus2",
+	}, planned, "every tenant should be planned with its own config")
+
+	var skipped bool
+	t.Run("without PlanOnlyOptions", func(t *testing.T) {
+		defer func() { skipped = t.Skipped() }()
+		MultiTenantTestRunner(t, func(t *testing.T, config TestConfig) {
+			applied = append(applied, config.TenantID)
+		}, planFake)
+	})
+	assert.True(t, skipped, "tests without PlanOnlyOptions should be skipped in plan-only mode")
+	assert.Empty(t, applied)
+}
+
+func TestDefaultParallelism(t *testing.T) {
+	t.Setenv(parallelismEnv, "")
+	assert.Equal(t, 1, defaultParallelism(t))
+
+	t.Setenv(parallelismEnv, "4")
+	assert.Equal(t, 4, defaultParallelism(t))
+}
@@ -0,0 +1,562 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armlocks"
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// keyVaultRetryableErrors are transient Key Vault and Azure AD failures, keyed
+// by the regular expression matched against Terraform's output, retried on top
+// of terratest's defaults.
+var keyVaultRetryableErrors = map[string]string{
+	`(?i)429 Too Many Requests|TooManyRequests`: "Key Vault throttled the request",
+	`AnotherOperationInProgress`:                "Another operation on the vault was still in progress",
+	`ForbiddenByRbac`:                           "A new role assignment had not yet propagated to the Key Vault data plane",
+	`PrincipalNotFound`:                         "A new principal had not yet replicated across Azure AD",
+	`(?i)vault\.azure\.net.*no such host`:       "DNS for a new vault had not yet propagated",
+}
+
+// terraformBinaryEnv names the environment variable holding the Terraform
+// binary the tests run, such as a pinned terraform or tofu.
+const terraformBinaryEnv = "TERRAFORM_BINARY"
+
+// TerraformBinary returns the binary named by TERRAFORM_BINARY, or terraform
+// when it is unset, so the suite can run against OpenTofu.
+func TerraformBinary() string {
+	if binary := os.Getenv(terraformBinaryEnv); binary != "" {
+		return binary
+	}
+	return "terraform"
+}
+
+type terraformOptionsConfig struct {
+	terraformDir       string
+	maxRetries         int
+	timeBetweenRetries time.Duration
+}
+
+// TerraformOption configures BuildTerraformOptions.
+type TerraformOption func(*terraformOptionsConfig)
+
+// WithTerraformDir points the options at dir instead of the module root.
+func WithTerraformDir(dir string) TerraformOption {
+	return func(c *terraformOptionsConfig) {
+		c.terraformDir = dir
+	}
+}
+
+// WithMaxRetries sets how many times a retryable error is retried.
+func WithMaxRetries(n int) TerraformOption {
+	return func(c *terraformOptionsConfig) {
+		c.maxRetries = n
+	}
+}
+
+// WithTimeBetweenRetries sets how long to wait before retrying.
+func WithTimeBetweenRetries(d time.Duration) TerraformOption {
+	return func(c *terraformOptionsConfig) {
+		c.timeBetweenRetries = d
+	}
+}
+
+// BuildTerraformOptions returns options for the module root (or
+// WithTerraformDir) with vars, the Terraform auth environment for config, the
+// binary from TerraformBinary, and terratest's default retryable errors plus
+// keyVaultRetryableErrors. Retries
+// default to terratest's and can be changed with WithMaxRetries and
+// WithTimeBetweenRetries. With KV_TEST_ARTIFACTS_DIR set, failures leave
+// artifacts behind (see CaptureArtifactsOnFailure).
+func BuildTerraformOptions(t *testing.T, config TestConfig, vars map[string]interface{}, opts ...TerraformOption) *terraform.Options {
+	t.Helper()
+
+	c := terraformOptionsConfig{terraformDir: ".."}
+	for _, opt := range opts {
+		opt(&c)
+	}
+
+	terraformOptions := terraform.WithDefaultRetryableErrors(t, &terraform.Options{
+		TerraformDir:    c.terraformDir,
+		TerraformBinary: TerraformBinary(),
+		Vars:            vars,
+		EnvVars:         TerraformEnvVars(config),
+	})
+
+	retryableErrors := make(map[string]string, len(terraformOptions.RetryableTerraformErrors)+len(keyVaultRetryableErrors))
+	for pattern, message := range terraformOptions.RetryableTerraformErrors {
+		retryableErrors[pattern] = message
+	}
+	for pattern, message := range keyVaultRetryableErrors {
+		retryableErrors[pattern] = message
+	}
+	terraformOptions.RetryableTerraformErrors = retryableErrors
+
+	if c.maxRetries > 0 {
+		terraformOptions.MaxRetries = c.maxRetries
+	}
+	if c.timeBetweenRetries > 0 {
+		terraformOptions.TimeBetweenRetries = c.timeBetweenRetries
+	}
+
+	CaptureArtifactsOnFailure(t, terraformOptions)
+	return terraformOptions
+}
+
+// AssertApplyFails runs init and apply, expecting them to fail with an error
+// containing expectedErrorSubstring. Whatever the failed apply left behind is
+// destroyed afterwards; a failing destroy is logged rather than failing the
+// test, since there is usually no state to destroy.
+func AssertApplyFails(t *testing.T, terraformOptions *terraform.Options, expectedErrorSubstring string) {
+	t.Helper()
+
+	defer func() {
+		if _, err := terraform.DestroyE(t, terraformOptions); err != nil {
+			t.Logf("destroy after expected apply failure: %v", err)
+		}
+	}()
+
+	_, err := terraform.InitAndApplyE(t, terraformOptions)
+	require.Error(t, err, "apply should fail")
+	assert.Contains(t, flattenDiagnostics(err.Error()), expectedErrorSubstring)
+}
+
+// purgeProtectionImmutableMessages are the errors that refuse to turn off
+// purge protection: the module's precondition and, should a plan get past it,
+// the azurerm provider's own check.
+var purgeProtectionImmutableMessages = []string{
+	"Azure does not allow disabling it",
+	"once Purge Protection has been Enabled it's not possible to disable it",
+}
+
+// ValidatePurgeProtectionImmutable plans terraformOptions, which must have
+// deployed a non-production vault with purge protection, with
+// purge_protection_enabled = false and fails t unless the plan is refused with
+// one of the known messages. The deployed setting is restored afterwards, so
+// later plans and the destroy run cleanly.
+func ValidatePurgeProtectionImmutable(t *testing.T, terraformOptions *terraform.Options) {
+	t.Helper()
+
+	if terraformOptions.Vars == nil {
+		terraformOptions.Vars = map[string]interface{}{}
+	}
+	previous, set := terraformOptions.Vars["purge_protection_enabled"]
+	defer func() {
+		if set {
+			terraformOptions.Vars["purge_protection_enabled"] = previous
+		} else {
+			delete(terraformOptions.Vars, "purge_protection_enabled")
+		}
+	}()
+	terraformOptions.Vars["purge_protection_enabled"] = false
+
+	_, err := terraform.PlanE(t, terraformOptions)
+	require.Error(t, err, "plan should refuse to disable purge protection")
+	if problem := purgeProtectionImmutableProblem(err.Error()); problem != "" {
+		t.Error(problem)
+	}
+}
+
+// purgeProtectionImmutableProblem describes a failed plan whose output does
+// not explain that purge protection cannot be disabled, or returns "" when
+// it does.
+func purgeProtectionImmutableProblem(output string) string {
+	flattened := flattenDiagnostics(output)
+	for _, message := range purgeProtectionImmutableMessages {
+		if strings.Contains(flattened, message) {
+			return ""
+		}
+	}
+	return fmt.Sprintf("plan failed, but not because purge protection cannot be disabled: %s", flattened)
+}
+
+// LockDownVault is the second phase of a bootstrap_public_access deployment:
+// it applies terraformOptions again with bootstrap_public_access and
+// public_network_access_enabled false, so a vault opened for seeding is
+// private from then on, including for the destroy.
+func LockDownVault(t *testing.T, terraformOptions *terraform.Options) {
+	t.Helper()
+
+	if terraformOptions.Vars == nil {
+		terraformOptions.Vars = map[string]interface{}{}
+	}
+	terraformOptions.Vars["bootstrap_public_access"] = false
+	terraformOptions.Vars["public_network_access_enabled"] = false
+	terraform.Apply(t, terraformOptions)
+}
+
+// transientDestroyErrors are destroy failures that clear up on their own: a
+// management lock or a dependent resource still being removed, another
+// operation in progress, or a role assignment not yet propagated.
+var transientDestroyErrors = []string{
+	"Conflict",
+	"InUse",
+	"ScopeLocked",
+	"AnotherOperationInProgress",
+	"ForbiddenByRbac",
+}
+
+// Backoff between attempts of SafeDestroy.
+const (
+	safeDestroyTimeout     = 20 * time.Minute
+	safeDestroyInitialWait = 15 * time.Second
+	safeDestroyMaxWait     = 2 * time.Minute
+)
+
+// SafeDestroy is terraform.Destroy for CI teardown. It first deletes the
+// management lock behind the resource_lock_id output, if any, so a lock whose
+// removal has not propagated cannot block deleting the vault, then retries
+// destroy with backoff while it fails with transientDestroyErrors. Any other
+// failure fails t at once.
+func SafeDestroy(t *testing.T, terraformOptions *terraform.Options) {
+	t.Helper()
+
+	if lockID, err := terraform.OutputE(t, terraformOptions, "resource_lock_id"); err == nil && lockID != "" {
+		removeManagementLock(t, lockID)
+	}
+	err := safeDestroy(context.Background(), func() error {
+		_, err := terraform.DestroyE(t, terraformOptions)
+		return err
+	}, safeDestroyTimeout, time.Now, time.Sleep)
+	require.NoError(t, err, "failed to destroy %s", terraformOptions.TerraformDir)
+}
+
+// removeManagementLock deletes the management lock with ID lockID. Terraform
+// drops the lock from its state on the next refresh.
+func removeManagementLock(t *testing.T, lockID string) {
+	t.Helper()
+
+	id, err := arm.ParseResourceID(lockID)
+	require.NoError(t, err, "invalid management lock ID %q", lockID)
+	scope, name, err := splitLockID(lockID)
+	require.NoError(t, err)
+
+	client, err := armlocks.NewManagementLocksClient(id.SubscriptionID, azureCredential(t), armClientOptions())
+	require.NoError(t, err)
+	_, err = client.DeleteByScope(context.Background(), scope, name, nil)
+	require.NoError(t, err, "failed to delete management lock %s", lockID)
+}
+
+// splitLockID splits the ID of a management lock into the scope it locks and
+// its name.
+func splitLockID(lockID string) (string, string, error) {
+	scope, name, ok := strings.Cut(lockID, "/providers/Microsoft.Authorization/locks/")
+	if !ok || scope == "" || name == "" || strings.Contains(name, "/") {
+		return "", "", fmt.Errorf("%q is not a management lock ID", lockID)
+	}
+	return scope, name, nil
+}
+
+func safeDestroy(ctx context.Context, destroy func() error, timeout time.Duration, now func() time.Time, sleep func(time.Duration)) error {
+	var fatal error
+	err := pollWithBackoff(ctx, timeout, safeDestroyInitialWait, safeDestroyMaxWait, now, sleep, func(ctx context.Context) (bool, error) {
+		err := destroy()
+		if err != nil && !isTransientDestroyError(err) {
+			fatal = err
+			return true, nil
+		}
+		return err == nil, err
+	})
+	if fatal != nil {
+		return fatal
+	}
+	return err
+}
+
+func isTransientDestroyError(err error) bool {
+	for _, pattern := range transientDestroyErrors {
+		if strings.Contains(err.Error(), pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// TimedApply runs init and apply like terraform.InitAndApply and returns how
+// long they took, to track provisioning time across changes to the module.
+func TimedApply(t *testing.T, terraformOptions *terraform.Options) time.Duration {
+	t.Helper()
+
+	start := time.Now()
+	terraform.InitAndApply(t, terraformOptions)
+	took := time.Since(start)
+	t.Logf("init and apply of %s took %s", terraformOptions.TerraformDir, took.Round(time.Millisecond))
+	return took
+}
+
+// AssertApplyUnder runs init and apply with TimedApply and fails t if they
+// took longer than max. It returns whether they finished in time.
+func AssertApplyUnder(t *testing.T, terraformOptions *terraform.Options, max time.Duration) bool {
+	t.Helper()
+
+	if problem := applyDurationProblem(terraformOptions.TerraformDir, TimedApply(t, terraformOptions), max); problem != "" {
+		t.Error(problem)
+		return false
+	}
+	return true
+}
+
+// applyDurationProblem describes an apply of dir that took longer than max,
+// or returns "" when it did not.
+func applyDurationProblem(dir string, took time.Duration, max time.Duration) string {
+	if took <= max {
+		return ""
+	}
+	return fmt.Sprintf("init and apply of %s took %s, over the %s limit by %s", dir, took.Round(time.Millisecond), max, (took - max).Round(time.Millisecond))
+}
+
+// AssertResourceCount reads the state of an applied terraformOptions with
+// terraform show -json and fails t unless it holds expected managed
+// resources, counting those of child modules and leaving data sources out.
+// It returns whether the count matched. A lighter check than
+// RunPlanSnapshot against resources appearing or disappearing in a refactor.
+func AssertResourceCount(t *testing.T, terraformOptions *terraform.Options, expected int) bool {
+	t.Helper()
+
+	out, err := terraform.ShowE(t, terraformOptions)
+	require.NoError(t, err, "failed to show the terraform state")
+	addresses, err := managedResourceAddresses([]byte(out))
+	require.NoError(t, err)
+	if problem := resourceCountProblem(terraformOptions.TerraformDir, addresses, expected); problem != "" {
+		t.Error(problem)
+		return false
+	}
+	return true
+}
+
+// managedResourceAddresses returns the sorted addresses of the managed
+// resources in terraform show -json output of a state.
+func managedResourceAddresses(stateJSON []byte) ([]string, error) {
+	type stateModule struct {
+		Resources []struct {
+			Address string `json:"address"`
+			Mode    string `json:"mode"`
+		} `json:"resources"`
+		ChildModules []json.RawMessage `json:"child_modules"`
+	}
+	var state struct {
+		Values *struct {
+			RootModule json.RawMessage `json:"root_module"`
+		} `json:"values"`
+	}
+	if err := json.Unmarshal(stateJSON, &state); err != nil {
+		return nil, fmt.Errorf("failed to parse state JSON: %w", err)
+	}
+
+	addresses := []string{}
+	if state.Values == nil {
+		return addresses, nil
+	}
+	modules := []json.RawMessage{state.Values.RootModule}
+	for len(modules) > 0 {
+		var module stateModule
+		if err := json.Unmarshal(modules[0], &module); err != nil {
+			return nil, fmt.Errorf("failed to parse state JSON: %w", err)
+		}
+		modules = append(modules[1:], module.ChildModules...)
+		for _, resource := range module.Resources {
+			if resource.Mode == "managed" {
+				addresses = append(addresses, resource.Address)
+			}
+		}
+	}
+	sort.Strings(addresses)
+	return addresses, nil
+}
+
+// resourceCountProblem describes a state of dir that does not hold expected
+// managed resources, listing those it holds, or returns "" when it does.
+func resourceCountProblem(dir string, addresses []string, expected int) string {
+	if len(addresses) == expected {
+		return ""
+	}
+	return fmt.Sprintf("state of %s holds %d managed resource(s), expected %d: %s", dir, len(addresses), expected, strings.Join(addresses, ", "))
+}
+
+// AssertNoDestroyOnReapply plans terraformOptions again after an apply, such
+// as one made with an earlier version of the module, and fails t if the plan
+// would destroy or replace any resource. In-place updates are allowed. It
+// returns whether the plan was free of destructive changes.
+func AssertNoDestroyOnReapply(t *testing.T, terraformOptions *terraform.Options) bool {
+	t.Helper()
+
+	planOptions, err := terraformOptions.Clone()
+	require.NoError(t, err)
+	planOptions.PlanFilePath = filepath.Join(t.TempDir(), "reapply.out")
+
+	changes, err := destructiveChanges([]byte(terraform.InitAndPlanAndShow(t, planOptions)))
+	require.NoError(t, err)
+	if len(changes) > 0 {
+		t.Errorf("re-plan of %s would destroy %d resource(s):\n  %s", terraformOptions.TerraformDir, len(changes), strings.Join(changes, "\n  "))
+		return false
+	}
+	return true
+}
+
+// destructiveChanges returns "<address>: destroy" or "<address>: replace"
+// for each resource change in terraform show -json output of a plan that
+// deletes the resource, sorted by address.
+func destructiveChanges(planJSON []byte) ([]string, error) {
+	var plan struct {
+		ResourceChanges []struct {
+			Address string `json:"address"`
+			Change  struct {
+				Actions []string `json:"actions"`
+			} `json:"change"`
+		} `json:"resource_changes"`
+	}
+	if err := json.Unmarshal(planJSON, &plan); err != nil {
+		return nil, fmt.Errorf("failed to parse plan JSON: %w", err)
+	}
+
+	changes := []string{}
+	for _, rc := range plan.ResourceChanges {
+		deletes, creates := false, false
+		for _, action := range rc.Change.Actions {
+			deletes = deletes || action == "delete"
+			creates = creates || action == "create"
+		}
+		switch {
+		case deletes && creates:
+			changes = append(changes, rc.Address+": replace")
+		case deletes:
+			changes = append(changes, rc.Address+": destroy")
+		}
+	}
+	sort.Strings(changes)
+	return changes, nil
+}
+
+// redactedOutput is written in place of the value of a sensitive output.
+const redactedOutput = "***"
+
+// WriteOutputsJSON writes every output of terraformOptions to path as
+// indented JSON keyed by output name, for later pipeline stages to read.
+// Outputs marked sensitive are written as "***". The outputs are read with
+// terraform output -json rather than terraform.OutputAll, which drops the
+// sensitive flag.
+func WriteOutputsJSON(t *testing.T, terraformOptions *terraform.Options, path string) {
+	t.Helper()
+
+	raw, err := terraform.OutputJsonE(t, terraformOptions, "")
+	require.NoError(t, err, "failed to read terraform outputs")
+	data, err := redactOutputs([]byte(raw))
+	require.NoError(t, err, "failed to parse terraform outputs")
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+	require.NoError(t, os.WriteFile(path, append(data, '\n'), 0o644), "failed to write outputs to %s", path)
+}
+
+// redactOutputs turns the output of terraform output -json into a map of
+// output names to values, with sensitive values replaced by redactedOutput.
+func redactOutputs(raw []byte) ([]byte, error) {
+	var outputs map[string]struct {
+		Sensitive bool            `json:"sensitive"`
+		Value     json.RawMessage `json:"value"`
+	}
+	if err := json.Unmarshal(raw, &outputs); err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]interface{}, len(outputs))
+	for name, output := range outputs {
+		if output.Sensitive {
+			result[name] = redactedOutput
+			continue
+		}
+		result[name] = output.Value
+	}
+	return json.MarshalIndent(result, "", "  ")
+}
+
+// AssertOutputSchema reads every output of terraformOptions with terraform
+// output -json and fails t unless each output named in expected exists and
+// its value has the expected JSON type: "string", "number", "bool", "list",
+// "object" (or "map") or "null". It returns whether all matched. A contract
+// test for the module's output surface, since downstream modules break when
+// an output changes type.
+func AssertOutputSchema(t *testing.T, terraformOptions *terraform.Options, expected map[string]string) bool {
+	t.Helper()
+
+	raw, err := terraform.OutputJsonE(t, terraformOptions, "")
+	require.NoError(t, err, "failed to read terraform outputs")
+	problems, err := outputSchemaProblems([]byte(raw), expected)
+	require.NoError(t, err, "failed to parse terraform outputs")
+	for _, problem := range problems {
+		t.Error(problem)
+	}
+	return len(problems) == 0
+}
+
+// outputSchemaProblems describes each output named in expected that is
+// missing from the output of terraform output -json, or whose value has
+// another JSON type, sorted by output name.
+func outputSchemaProblems(raw []byte, expected map[string]string) ([]string, error) {
+	var outputs map[string]struct {
+		Value json.RawMessage `json:"value"`
+	}
+	if err := json.Unmarshal(raw, &outputs); err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(expected))
+	for name := range expected {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	problems := []string{}
+	for _, name := range names {
+		want := strings.ToLower(expected[name])
+		if want == "map" {
+			want = "object"
+		}
+		output, ok := outputs[name]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("output %s is missing, expected a %s", name, want))
+			continue
+		}
+		if got := jsonType(output.Value); got != want {
+			problems = append(problems, fmt.Sprintf("output %s is a %s, expected a %s", name, got, want))
+		}
+	}
+	return problems, nil
+}
+
+// jsonType returns the JSON type of value: "string", "number", "bool",
+// "list", "object" or "null".
+func jsonType(value json.RawMessage) string {
+	trimmed := strings.TrimSpace(string(value))
+	if trimmed == "" {
+		return "null"
+	}
+	switch trimmed[0] {
+	case '"':
+		return "string"
+	case '[':
+		return "list"
+	case '{':
+		return "object"
+	case 't', 'f':
+		return "bool"
+	case 'n':
+		return "null"
+	}
+	return "number"
+}
+
+// flattenDiagnostics undoes Terraform's line wrapping and box drawing in
+// diagnostic output so error messages can be matched as plain sentences.
+func flattenDiagnostics(output string) string {
+	return strings.Join(strings.Fields(strings.ReplaceAll(output, "│", " ")), " ")
+}
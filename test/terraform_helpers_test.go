@@ -0,0 +1,297 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	test_structure "github.com/gruntwork-io/terratest/modules/test-structure"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildTerraformOptions(t *testing.T) {
+	t.Parallel()
+
+	config := TestConfig{TenantID: "tenant", SubscriptionID: "subscription"}
+	vars := map[string]interface{}{"location": "westeurope"}
+
+	options := BuildTerraformOptions(t, config, vars)
+
+	assert.Equal(t, "..", options.TerraformDir)
+	assert.Equal(t, vars, options.Vars)
+	assert.Equal(t, "subscription", options.EnvVars["ARM_SUBSCRIPTION_ID"])
+
+	for pattern, message := range keyVaultRetryableErrors {
+		assert.Equal(t, message, options.RetryableTerraformErrors[pattern], "missing Key Vault retryable error %q", pattern)
+	}
+	for pattern := range terraform.DefaultRetryableTerraformErrors {
+		assert.Contains(t, options.RetryableTerraformErrors, pattern, "missing default retryable error %q", pattern)
+	}
+	for pattern := range keyVaultRetryableErrors {
+		assert.NotContains(t, terraform.DefaultRetryableTerraformErrors, pattern, "terratest defaults must not be modified")
+	}
+
+	assert.Positive(t, options.MaxRetries)
+	assert.Positive(t, options.TimeBetweenRetries)
+}
+
+func TestBuildTerraformOptionsOverrides(t *testing.T) {
+	t.Parallel()
+
+	options := BuildTerraformOptions(t, TestConfig{}, nil,
+		WithTerraformDir("fixtures/event_grid"),
+		WithMaxRetries(8),
+		WithTimeBetweenRetries(30*time.Second),
+	)
+
+	assert.Equal(t, "fixtures/event_grid", options.TerraformDir)
+	assert.Equal(t, 8, options.MaxRetries)
+	assert.Equal(t, 30*time.Second, options.TimeBetweenRetries)
+}
+
+func TestBuildTerraformOptionsTerraformBinary(t *testing.T) {
+	t.Setenv(terraformBinaryEnv, "/opt/tofu/1.8.0/tofu")
+
+	options := BuildTerraformOptions(t, TestConfig{}, nil)
+	assert.Equal(t, "/opt/tofu/1.8.0/tofu", options.TerraformBinary)
+
+	t.Setenv(terraformBinaryEnv, "")
+	assert.Equal(t, "terraform", TerraformBinary())
+}
+
+func TestKeyVaultRetryableErrorsMatch(t *testing.T) {
+	t.Parallel()
+
+	outputs := []string{
+		"StatusCode=429 -- Original Error: autorest/azure: Service returned an error. Status=429 Code=\"TooManyRequests\"",
+		"Code=\"AnotherOperationInProgress\" Message=\"Another operation on this or dependent resource is in progress.\"",
+		"Status=403 Code=\"Forbidden\" Message=\"Caller is not authorized to perform action on resource.\" InnerError={\"code\":\"ForbiddenByRbac\"}",
+		"Code=\"PrincipalNotFound\" Message=\"Principal 00000000 does not exist in the directory.\"",
+		"dial tcp: lookup kv-test-abc123.vault.azure.net: no such host",
+	}
+
+	for _, output := range outputs {
+		matched := false
+		for pattern := range keyVaultRetryableErrors {
+			re, err := regexp.Compile(pattern)
+			require.NoError(t, err)
+			if re.MatchString(output) {
+				matched = true
+			}
+		}
+		assert.True(t, matched, "no retryable error matches %q", output)
+	}
+}
+
+func TestRedactOutputs(t *testing.T) {
+	t.Parallel()
+
+	data, err := redactOutputs([]byte(`{
+  "key_vault_name": {"sensitive": false, "type": "string", "value": "kv-test"},
+  "key_ids": {"sensitive": false, "type": ["map", "string"], "value": {"app": "https://kv-test.vault.azure.net/keys/app/1"}},
+  "key_public_keys": {"sensitive": true, "type": ["map", "string"], "value": {"app": "-----BEGIN PUBLIC KEY-----"}},
+  "hsm_uri": {"sensitive": false, "type": "string", "value": null}
+}`))
+	require.NoError(t, err)
+	assert.Equal(t, `{
+  "hsm_uri": null,
+  "key_ids": {
+    "app": "https://kv-test.vault.azure.net/keys/app/1"
+  },
+  "key_public_keys": "***",
+  "key_vault_name": "kv-test"
+}`, string(data))
+
+	_, err = redactOutputs([]byte(`not json`))
+	assert.Error(t, err)
+}
+
+func TestOutputSchemaProblems(t *testing.T) {
+	t.Parallel()
+
+	raw := []byte(`{
+  "key_vault_name": {"sensitive": false, "type": "string", "value": "kv-test"},
+  "key_ids": {"sensitive": false, "type": ["map", "string"], "value": {"app": "https://kv-test.vault.azure.net/keys/app/1"}},
+  "secret_names": {"sensitive": false, "type": ["list", "string"], "value": ["db-password"]},
+  "soft_delete_retention_days": {"sensitive": false, "type": "number", "value": 7},
+  "purge_protection_enabled": {"sensitive": false, "type": "bool", "value": false},
+  "hsm_uri": {"sensitive": false, "type": "string", "value": null}
+}`)
+
+	problems, err := outputSchemaProblems(raw, map[string]string{
+		"key_vault_name":             "string",
+		"key_ids":                    "map",
+		"secret_names":               "list",
+		"soft_delete_retention_days": "number",
+		"purge_protection_enabled":   "bool",
+		"hsm_uri":                    "null",
+	})
+	require.NoError(t, err)
+	assert.Empty(t, problems)
+
+	problems, err = outputSchemaProblems(raw, map[string]string{
+		"key_vault_name": "list",
+		"key_ids":        "object",
+		"vault_id":       "string",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"output key_vault_name is a string, expected a list",
+		"output vault_id is missing, expected a string",
+	}, problems)
+
+	_, err = outputSchemaProblems([]byte(`not json`), nil)
+	assert.Error(t, err)
+}
+
+func TestTimedApply(t *testing.T) {
+	t.Parallel()
+
+	terraformOptions := &terraform.Options{
+		TerraformDir:    test_structure.CopyTerraformFolderToTemp(t, "..", "test/fixtures/noop"),
+		TerraformBinary: TerraformBinary(),
+		NoColor:         true,
+	}
+	defer terraform.Destroy(t, terraformOptions)
+
+	took := TimedApply(t, terraformOptions)
+	assert.Positive(t, took)
+	assert.Equal(t, "noop", terraform.Output(t, terraformOptions, "value"))
+
+	// Nothing changes on the second apply, well within any sensible limit
+	assert.True(t, AssertApplyUnder(t, terraformOptions, 10*time.Minute))
+}
+
+func TestApplyDurationProblem(t *testing.T) {
+	t.Parallel()
+
+	assert.Empty(t, applyDurationProblem("fixtures/noop", 90*time.Second, 2*time.Minute))
+	assert.Empty(t, applyDurationProblem("fixtures/noop", 2*time.Minute, 2*time.Minute))
+	assert.Equal(t, "init and apply of fixtures/noop took 2m30.25s, over the 2m0s limit by 30.25s",
+		applyDurationProblem("fixtures/noop", 150250*time.Millisecond, 2*time.Minute))
+}
+
+func TestManagedResourceAddresses(t *testing.T) {
+	t.Parallel()
+
+	state := []byte(`{
+  "format_version": "1.0",
+  "values": {
+    "root_module": {
+      "resources": [
+        {"address": "data.azurerm_client_config.current", "mode": "data"},
+        {"address": "azurerm_key_vault.this[0]", "mode": "managed"}
+      ],
+      "child_modules": [
+        {
+          "address": "module.network",
+          "resources": [{"address": "module.network.azurerm_subnet.this", "mode": "managed"}],
+          "child_modules": [
+            {"address": "module.network.module.dns", "resources": [{"address": "module.network.module.dns.azurerm_private_dns_zone.this", "mode": "managed"}]}
+          ]
+        }
+      ]
+    }
+  }
+}`)
+	addresses, err := managedResourceAddresses(state)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"azurerm_key_vault.this[0]",
+		"module.network.azurerm_subnet.this",
+		"module.network.module.dns.azurerm_private_dns_zone.this",
+	}, addresses)
+
+	empty, err := managedResourceAddresses([]byte(`{"format_version": "1.0"}`))
+	require.NoError(t, err)
+	assert.Empty(t, empty, "an empty state has no values")
+
+	_, err = managedResourceAddresses([]byte("not json"))
+	assert.Error(t, err)
+}
+
+func TestResourceCountProblem(t *testing.T) {
+	t.Parallel()
+
+	addresses := []string{"azurerm_key_vault.this[0]", "azurerm_role_assignment.this[\"reader\"]"}
+	assert.Empty(t, resourceCountProblem("..", addresses, 2))
+	assert.Equal(t, `state of .. holds 2 managed resource(s), expected 1: azurerm_key_vault.this[0], azurerm_role_assignment.this["reader"]`,
+		resourceCountProblem("..", addresses, 1))
+}
+
+func TestDestructiveChanges(t *testing.T) {
+	t.Parallel()
+
+	plan := []byte(`{
+  "resource_changes": [
+    {"address": "azurerm_key_vault.this[0]", "change": {"actions": ["update"]}},
+    {"address": "azurerm_role_assignment.this[\"reader\"]", "change": {"actions": ["delete"]}},
+    {"address": "azurerm_key_vault_key.this[\"app\"]", "change": {"actions": ["delete", "create"]}},
+    {"address": "azurerm_private_endpoint.this[0]", "change": {"actions": ["create", "delete"]}},
+    {"address": "azurerm_key_vault_secret.this[\"db\"]", "change": {"actions": ["no-op"]}},
+    {"address": "data.azurerm_client_config.current", "change": {"actions": ["read"]}}
+  ]
+}`)
+	changes, err := destructiveChanges(plan)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		`azurerm_key_vault_key.this["app"]: replace`,
+		"azurerm_private_endpoint.this[0]: replace",
+		`azurerm_role_assignment.this["reader"]: destroy`,
+	}, changes)
+
+	changes, err = destructiveChanges([]byte(`{"format_version": "1.2"}`))
+	require.NoError(t, err)
+	assert.Empty(t, changes, "a plan without changes is not destructive")
+
+	_, err = destructiveChanges([]byte("not json"))
+	assert.Error(t, err)
+}
+
+func TestPurgeProtectionImmutableProblem(t *testing.T) {
+	t.Parallel()
+
+	precondition := "Error: Resource precondition failed\n\n│ Purge protection is enabled on Key Vault 'kv-test' and Azure does not\n│ allow disabling it."
+	assert.Empty(t, purgeProtectionImmutableProblem(precondition))
+	assert.Empty(t, purgeProtectionImmutableProblem("Error: updating Key Vault: once Purge Protection has been Enabled it's not possible to disable it"))
+	assert.Contains(t, purgeProtectionImmutableProblem("Error: building account: could not acquire access token"), "not because purge protection cannot be disabled")
+}
+
+func TestSafeDestroyRetriesConflicts(t *testing.T) {
+	t.Parallel()
+
+	conflict := errors.New(`Error: deleting Key Vault: unexpected status 409 (409 Conflict) with error: ScopeLocked`)
+	op, calls := failingOp(conflict)
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+
+	require.NoError(t, safeDestroy(context.Background(), op, time.Hour, clock.Now, clock.Sleep))
+	assert.Equal(t, 2, *calls)
+	assert.Equal(t, []time.Duration{15 * time.Second}, clock.sleeps)
+}
+
+func TestSafeDestroyOtherErrorsAreFatal(t *testing.T) {
+	t.Parallel()
+
+	denied := errors.New("Error: deleting Key Vault: AuthorizationFailed")
+	op, calls := failingOp(denied)
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+
+	assert.ErrorIs(t, safeDestroy(context.Background(), op, time.Hour, clock.Now, clock.Sleep), denied)
+	assert.Equal(t, 1, *calls)
+}
+
+func TestSplitLockID(t *testing.T) {
+	t.Parallel()
+
+	scope, name, err := splitLockID("/subscriptions/x/resourceGroups/rg-kv/providers/Microsoft.KeyVault/vaults/kv-test/providers/Microsoft.Authorization/locks/kv-test-lock")
+	require.NoError(t, err)
+	assert.Equal(t, "/subscriptions/x/resourceGroups/rg-kv/providers/Microsoft.KeyVault/vaults/kv-test", scope)
+	assert.Equal(t, "kv-test-lock", name)
+
+	_, _, err = splitLockID("/subscriptions/x/resourceGroups/rg-kv/providers/Microsoft.KeyVault/vaults/kv-test")
+	assert.Error(t, err)
+}
@@ -0,0 +1,204 @@
+package test
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const tlsDialTimeout = 10 * time.Second
+
+// weakTLSCipherSuites are the TLS 1.2 suites security scans flag: RC4 and
+// 3DES, with and without forward secrecy.
+var weakTLSCipherSuites = []uint16{
+	tls.TLS_RSA_WITH_RC4_128_SHA,
+	tls.TLS_RSA_WITH_3DES_EDE_CBC_SHA,
+	tls.TLS_ECDHE_RSA_WITH_RC4_128_SHA,
+	tls.TLS_ECDHE_RSA_WITH_3DES_EDE_CBC_SHA,
+}
+
+// ValidateVaultTLS handshakes with the data-plane endpoint of vaultURI and
+// fails t unless it negotiates at least minVersion (such as
+// tls.VersionTLS12), refuses versions below it and refuses handshakes that
+// only offer weak cipher suites. The test is skipped when the endpoint cannot
+// be reached, as with a private endpoint outside the runner's network.
+func ValidateVaultTLS(t *testing.T, vaultURI string, minVersion uint16) {
+	t.Helper()
+
+	u, err := url.Parse(vaultURI)
+	require.NoError(t, err, "invalid vault URI %q", vaultURI)
+	port := u.Port()
+	if port == "" {
+		port = "443"
+	}
+	addr := net.JoinHostPort(u.Hostname(), port)
+
+	dialer := &net.Dialer{Timeout: tlsDialTimeout}
+	conn, err := dialer.Dial("tcp", addr)
+	if err != nil {
+		t.Skipf("Key Vault endpoint %s is not reachable from the runner, skipping the TLS check: %v", addr, err)
+	}
+	conn.Close()
+
+	for _, problem := range tlsProblems(dialer, addr, &tls.Config{ServerName: u.Hostname()}, minVersion) {
+		t.Errorf("Key Vault endpoint %s: %s", addr, problem)
+	}
+}
+
+// tlsProblems describes every way the endpoint at addr falls short of
+// minVersion and the weak cipher suite ban. base carries the server name and
+// roots to verify against.
+func tlsProblems(dialer *net.Dialer, addr string, base *tls.Config, minVersion uint16) []string {
+	var problems []string
+
+	config := base.Clone()
+	config.MinVersion = tls.VersionTLS10
+	state, err := tlsHandshake(dialer, addr, config)
+	if err != nil {
+		return append(problems, fmt.Sprintf("TLS handshake failed: %v", err))
+	}
+	if state.Version < minVersion {
+		problems = append(problems, fmt.Sprintf("negotiated %s, below the minimum %s", tls.VersionName(state.Version), tls.VersionName(minVersion)))
+	}
+
+	if minVersion > tls.VersionTLS10 {
+		config = base.Clone()
+		config.MinVersion = tls.VersionTLS10
+		config.MaxVersion = minVersion - 1
+		if state, err := tlsHandshake(dialer, addr, config); err == nil {
+			problems = append(problems, fmt.Sprintf("accepted %s, below the minimum %s", tls.VersionName(state.Version), tls.VersionName(minVersion)))
+		}
+	}
+
+	// Cipher suites cannot be chosen in TLS 1.3, whose suites are all strong
+	config = base.Clone()
+	config.MinVersion = tls.VersionTLS10
+	config.MaxVersion = tls.VersionTLS12
+	config.CipherSuites = weakTLSCipherSuites
+	if state, err := tlsHandshake(dialer, addr, config); err == nil {
+		problems = append(problems, fmt.Sprintf("accepted weak cipher suite %s", tls.CipherSuiteName(state.CipherSuite)))
+	}
+
+	return problems
+}
+
+// legacyTLSVersions are the versions below the TLS 1.2 floor Key Vault
+// enforces.
+var legacyTLSVersions = []uint16{tls.VersionTLS10, tls.VersionTLS11}
+
+// AssertMinTLSRejected handshakes with the data-plane endpoint of vaultURI
+// offering only TLS 1.0, then only TLS 1.1, and fails t if either is
+// accepted, proving the platform enforces the TLS 1.2 floor rather than the
+// clients. It returns whether both were refused. It skips for a private
+// endpoint, whose host only resolves to private addresses, and like
+// ValidateVaultTLS when the endpoint cannot be reached.
+func AssertMinTLSRejected(t *testing.T, vaultURI string) bool {
+	t.Helper()
+
+	u, err := url.Parse(vaultURI)
+	require.NoError(t, err, "invalid vault URI %q", vaultURI)
+	port := u.Port()
+	if port == "" {
+		port = "443"
+	}
+	addr := net.JoinHostPort(u.Hostname(), port)
+
+	if ips, err := net.DefaultResolver.LookupIP(context.Background(), "ip", u.Hostname()); err == nil && privateOnly(ips) {
+		t.Skipf("Key Vault endpoint %s resolves to a private endpoint, skipping the legacy TLS check", addr)
+	}
+	dialer := &net.Dialer{Timeout: tlsDialTimeout}
+	conn, err := dialer.Dial("tcp", addr)
+	if err != nil {
+		t.Skipf("Key Vault endpoint %s is not reachable from the runner, skipping the legacy TLS check: %v", addr, err)
+	}
+	conn.Close()
+
+	accepted := acceptedLegacyTLS(dialer, addr, &tls.Config{ServerName: u.Hostname()})
+	for _, version := range accepted {
+		t.Errorf("Key Vault endpoint %s accepted a %s handshake, below the TLS 1.2 floor", addr, version)
+	}
+	return len(accepted) == 0
+}
+
+// acceptedLegacyTLS returns the names of the legacyTLSVersions the endpoint
+// at addr completes a handshake with when a client offers nothing newer.
+// base carries the server name and roots to verify against.
+func acceptedLegacyTLS(dialer *net.Dialer, addr string, base *tls.Config) []string {
+	var accepted []string
+	for _, version := range legacyTLSVersions {
+		config := base.Clone()
+		config.MinVersion = version
+		config.MaxVersion = version
+		if _, err := tlsHandshake(dialer, addr, config); err == nil {
+			accepted = append(accepted, tls.VersionName(version))
+		}
+	}
+	return accepted
+}
+
+// AssertVaultHTTPSOnly sends a plaintext HTTP request to port 80 of the
+// data-plane endpoint of vaultName and fails t unless it is refused, times
+// out or is redirected to HTTPS. It returns whether no plaintext access was
+// found. Like ValidateVaultTLS it skips when port 443 cannot be reached, as
+// with a private-only vault outside the runner's network.
+func AssertVaultHTTPSOnly(t *testing.T, vaultName string) bool {
+	t.Helper()
+
+	u, err := url.Parse(keyVaultURI(t, vaultName))
+	require.NoError(t, err)
+	addr := net.JoinHostPort(u.Hostname(), "443")
+	conn, err := net.DialTimeout("tcp", addr, tlsDialTimeout)
+	if err != nil {
+		t.Skipf("Key Vault endpoint %s is not reachable from the runner, skipping the plaintext HTTP check: %v", addr, err)
+	}
+	conn.Close()
+
+	client := &http.Client{
+		Timeout: tlsDialTimeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	if problem := plaintextProblem(client, "http://"+u.Hostname()+"/"); problem != "" {
+		t.Errorf("Key Vault %s: %s", vaultName, problem)
+		return false
+	}
+	return true
+}
+
+// plaintextProblem describes how the endpoint at the http:// URL target
+// answered over plaintext, or returns "" when it refused the request or
+// redirected to HTTPS. client must not follow redirects.
+func plaintextProblem(client *http.Client, target string) string {
+	resp, err := client.Get(target)
+	if err != nil {
+		return ""
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 && resp.StatusCode < 400 {
+		location := resp.Header.Get("Location")
+		if strings.HasPrefix(strings.ToLower(location), "https://") {
+			return ""
+		}
+		return fmt.Sprintf("plaintext HTTP was redirected to %q rather than to HTTPS", location)
+	}
+	return fmt.Sprintf("plaintext HTTP was answered with %s", resp.Status)
+}
+
+func tlsHandshake(dialer *net.Dialer, addr string, config *tls.Config) (tls.ConnectionState, error) {
+	conn, err := tls.DialWithDialer(dialer, "tcp", addr, config)
+	if err != nil {
+		return tls.ConnectionState{}, err
+	}
+	defer conn.Close()
+	return conn.ConnectionState(), nil
+}
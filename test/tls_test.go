@@ -0,0 +1,214 @@
+package test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gruntwork-io/terratest/modules/terraform"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// tlsTestServer starts a TLS server restricted by configure and returns its
+// address and a client config trusting it.
+func tlsTestServer(t *testing.T, configure func(*tls.Config)) (string, *tls.Config) {
+	t.Helper()
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = &tls.Config{}
+	configure(server.TLS)
+	server.StartTLS()
+	t.Cleanup(server.Close)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(server.Certificate())
+	return server.Listener.Addr().String(), &tls.Config{ServerName: "example.com", RootCAs: roots}
+}
+
+func TestTLSProblems(t *testing.T) {
+	t.Parallel()
+
+	dialer := &net.Dialer{Timeout: tlsDialTimeout}
+
+	t.Run("modern endpoint", func(t *testing.T) {
+		addr, base := tlsTestServer(t, func(c *tls.Config) { c.MinVersion = tls.VersionTLS12 })
+		assert.Empty(t, tlsProblems(dialer, addr, base, tls.VersionTLS12))
+	})
+
+	t.Run("old versions accepted", func(t *testing.T) {
+		addr, base := tlsTestServer(t, func(c *tls.Config) {
+			c.MinVersion = tls.VersionTLS10
+			c.MaxVersion = tls.VersionTLS11
+		})
+		problems := tlsProblems(dialer, addr, base, tls.VersionTLS12)
+		assert.Contains(t, problems, "negotiated TLS 1.1, below the minimum TLS 1.2")
+		assert.Contains(t, problems, "accepted TLS 1.1, below the minimum TLS 1.2")
+	})
+
+	t.Run("weak cipher accepted", func(t *testing.T) {
+		addr, base := tlsTestServer(t, func(c *tls.Config) {
+			c.MinVersion = tls.VersionTLS12
+			c.CipherSuites = []uint16{tls.TLS_ECDHE_RSA_WITH_3DES_EDE_CBC_SHA, tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256}
+		})
+		assert.Equal(t, []string{"accepted weak cipher suite TLS_ECDHE_RSA_WITH_3DES_EDE_CBC_SHA"}, tlsProblems(dialer, addr, base, tls.VersionTLS12))
+	})
+
+	t.Run("handshake fails", func(t *testing.T) {
+		addr, _ := tlsTestServer(t, func(c *tls.Config) {})
+		problems := tlsProblems(dialer, addr, &tls.Config{ServerName: "example.com"}, tls.VersionTLS12)
+		assert.Len(t, problems, 1)
+		assert.Contains(t, problems[0], "TLS handshake failed")
+	})
+}
+
+func TestAcceptedLegacyTLS(t *testing.T) {
+	t.Parallel()
+
+	dialer := &net.Dialer{Timeout: tlsDialTimeout}
+
+	t.Run("floor enforced", func(t *testing.T) {
+		addr, base := tlsTestServer(t, func(c *tls.Config) { c.MinVersion = tls.VersionTLS12 })
+		assert.Empty(t, acceptedLegacyTLS(dialer, addr, base))
+	})
+
+	t.Run("TLS 1.1 accepted", func(t *testing.T) {
+		addr, base := tlsTestServer(t, func(c *tls.Config) { c.MinVersion = tls.VersionTLS11 })
+		assert.Equal(t, []string{"TLS 1.1"}, acceptedLegacyTLS(dialer, addr, base))
+	})
+
+	t.Run("everything accepted", func(t *testing.T) {
+		addr, base := tlsTestServer(t, func(c *tls.Config) { c.MinVersion = tls.VersionTLS10 })
+		assert.Equal(t, []string{"TLS 1.0", "TLS 1.1"}, acceptedLegacyTLS(dialer, addr, base))
+	})
+}
+
+func TestPlaintextProblem(t *testing.T) {
+	t.Parallel()
+
+	client := &http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	server := func(t *testing.T, handler http.HandlerFunc) string {
+		server := httptest.NewServer(handler)
+		t.Cleanup(server.Close)
+		return server.URL + "/"
+	}
+
+	t.Run("redirected to https", func(t *testing.T) {
+		url := server(t, func(w http.ResponseWriter, r *http.Request) {
+			http.Redirect(w, r, "https://kv-test.vault.azure.net/", http.StatusMovedPermanently)
+		})
+		assert.Empty(t, plaintextProblem(client, url))
+	})
+
+	t.Run("redirected to http", func(t *testing.T) {
+		url := server(t, func(w http.ResponseWriter, r *http.Request) {
+			http.Redirect(w, r, "http://kv-test.vault.azure.net/secrets", http.StatusFound)
+		})
+		assert.Equal(t, `plaintext HTTP was redirected to "http://kv-test.vault.azure.net/secrets" rather than to HTTPS`, plaintextProblem(client, url))
+	})
+
+	t.Run("answered", func(t *testing.T) {
+		url := server(t, func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusUnauthorized)
+		})
+		assert.Equal(t, "plaintext HTTP was answered with 401 Unauthorized", plaintextProblem(client, url))
+	})
+
+	t.Run("refused", func(t *testing.T) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		addr := listener.Addr().String()
+		listener.Close()
+		assert.Empty(t, plaintextProblem(client, "http://"+addr+"/"))
+	})
+}
+
+func TestKeyVaultDataPlaneTLS(t *testing.T) {
+	t.Parallel()
+
+	if os.Getenv("KV_TEST_TLS") == "" {
+		t.Skip("KV_TEST_TLS is not set; skipping the data-plane TLS probe")
+	}
+
+	MultiTenantTestRunner(t, func(t *testing.T, config TestConfig) {
+		SetupAzureAuth(t, config)
+		CreateResourceGroup(t, &config)
+
+		keyVaultName := PurgeSoftDeletedVault(t, config, fmt.Sprintf("kv-tls-%s", config.UniqueID))
+		vars := baseModuleVars(config, keyVaultName)
+		vars["public_network_access_enabled"] = true
+
+		terraformOptions := BuildTerraformOptions(t, config, vars)
+
+		defer terraform.Destroy(t, terraformOptions)
+		terraform.InitAndApply(t, terraformOptions)
+
+		ValidateVaultTLS(t, terraform.Output(t, terraformOptions, "key_vault_uri"), tls.VersionTLS12)
+	})
+}
+
+func TestKeyVaultRejectsLegacyTLS(t *testing.T) {
+	t.Parallel()
+
+	if os.Getenv("KV_TEST_TLS") == "" {
+		t.Skip("KV_TEST_TLS is not set; skipping the legacy TLS probe")
+	}
+
+	MultiTenantTestRunner(t, func(t *testing.T, config TestConfig) {
+		SetupAzureAuth(t, config)
+		CreateResourceGroup(t, &config)
+
+		keyVaultName := PurgeSoftDeletedVault(t, config, fmt.Sprintf("kv-tls11-%s", config.UniqueID))
+		vars := baseModuleVars(config, keyVaultName)
+		vars["public_network_access_enabled"] = true
+
+		terraformOptions := BuildTerraformOptions(t, config, vars)
+
+		defer terraform.Destroy(t, terraformOptions)
+		terraform.InitAndApply(t, terraformOptions)
+
+		vaultURI := terraform.Output(t, terraformOptions, "key_vault_uri")
+		AssertMinTLSRejected(t, vaultURI)
+
+		// A client that cannot go above TLS 1.1 must not get a session
+		u, err := url.Parse(vaultURI)
+		require.NoError(t, err)
+		dialer := &net.Dialer{Timeout: tlsDialTimeout}
+		_, err = tlsHandshake(dialer, net.JoinHostPort(u.Hostname(), "443"), &tls.Config{ServerName: u.Hostname(), MinVersion: tls.VersionTLS10, MaxVersion: tls.VersionTLS11})
+		assert.Error(t, err, "a TLS 1.1 handshake with %s should be refused", u.Hostname())
+	})
+}
+
+func TestKeyVaultHTTPSOnly(t *testing.T) {
+	t.Parallel()
+
+	if os.Getenv("KV_TEST_TLS") == "" {
+		t.Skip("KV_TEST_TLS is not set; skipping the plaintext HTTP probe")
+	}
+
+	MultiTenantTestRunner(t, func(t *testing.T, config TestConfig) {
+		SetupAzureAuth(t, config)
+		CreateResourceGroup(t, &config)
+
+		keyVaultName := PurgeSoftDeletedVault(t, config, fmt.Sprintf("kv-http-%s", config.UniqueID))
+		vars := baseModuleVars(config, keyVaultName)
+		vars["public_network_access_enabled"] = true
+
+		terraformOptions := BuildTerraformOptions(t, config, vars)
+
+		defer terraform.Destroy(t, terraformOptions)
+		terraform.InitAndApply(t, terraformOptions)
+
+		WaitForVaultReady(t, config, keyVaultName, 5*time.Minute)
+		AssertVaultHTTPSOnly(t, keyVaultName)
+	})
+}
@@ -0,0 +1,1157 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/keyvault/armkeyvault"
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azkeys"
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azsecrets"
+	"github.com/gruntwork-io/terratest/modules/azure"
+	"github.com/gruntwork-io/terratest/modules/random"
+	"github.com/gruntwork-io/terratest/modules/retry"
+	"github.com/stretchr/testify/require"
+)
+
+// keyGetter is the subset of *azkeys.Client used to look keys up.
+type keyGetter interface {
+	GetKey(ctx context.Context, name string, version string, options *azkeys.GetKeyOptions) (azkeys.GetKeyResponse, error)
+}
+
+// ValidateKeyVaultKeys checks that every key in expectedKeys exists in the
+// vault and is enabled. All problems are reported in a single failure.
+func ValidateKeyVaultKeys(t *testing.T, config TestConfig, vaultName string, expectedKeys []string) {
+	t.Helper()
+
+	client := keysClient(t, vaultName)
+	if problems := findKeyProblems(context.Background(), client, expectedKeys); len(problems) > 0 {
+		t.Errorf("Key Vault %s has %d key problem(s):\n  %s", vaultName, len(problems), strings.Join(problems, "\n  "))
+	}
+}
+
+// findKeyProblems returns one line per expected key that is missing, disabled
+// or could not be read.
+func findKeyProblems(ctx context.Context, client keyGetter, expectedKeys []string) []string {
+	var problems []string
+	for _, name := range expectedKeys {
+		key, err := client.GetKey(ctx, name, "", nil)
+		var respErr *azcore.ResponseError
+		switch {
+		case errors.As(err, &respErr) && respErr.StatusCode == http.StatusNotFound:
+			problems = append(problems, fmt.Sprintf("%s: not found", name))
+		case err != nil:
+			problems = append(problems, fmt.Sprintf("%s: %v", name, err))
+		case key.Attributes == nil || key.Attributes.Enabled == nil || !*key.Attributes.Enabled:
+			problems = append(problems, fmt.Sprintf("%s: disabled", name))
+		}
+	}
+	return problems
+}
+
+// ValidateKeyOperations reads the current version of keyName and fails t,
+// in a single failure, if it permits any operation (key_ops) outside allowed,
+// such as sign on a key meant only for wrapKey and unwrapKey. Operations are
+// compared case-insensitively.
+func ValidateKeyOperations(t *testing.T, config TestConfig, vaultName string, keyName string, allowed []string) {
+	t.Helper()
+
+	resp, err := keysClient(t, vaultName).GetKey(context.Background(), keyName, "", nil)
+	require.NoError(t, err, "failed to read key %s in Key Vault %s", keyName, vaultName)
+	if disallowed := disallowedKeyOperations(resp.Key, allowed); len(disallowed) > 0 {
+		t.Errorf("Key %s in Key Vault %s permits %d operation(s) outside %s: %s",
+			keyName, vaultName, len(disallowed), strings.Join(allowed, ", "), strings.Join(disallowed, ", "))
+	}
+}
+
+// disallowedKeyOperations returns the operations of key not in allowed,
+// sorted.
+func disallowedKeyOperations(key *azkeys.JSONWebKey, allowed []string) []string {
+	if key == nil {
+		return nil
+	}
+	permitted := map[string]bool{}
+	for _, op := range allowed {
+		permitted[strings.ToLower(op)] = true
+	}
+
+	var disallowed []string
+	for _, op := range key.KeyOps {
+		if op != nil && !permitted[strings.ToLower(string(*op))] {
+			disallowed = append(disallowed, string(*op))
+		}
+	}
+	sort.Strings(disallowed)
+	return disallowed
+}
+
+// secretLister lists the properties of every secret in a vault.
+type secretLister interface {
+	listSecrets(ctx context.Context) ([]*azsecrets.SecretProperties, error)
+}
+
+type azureSecretLister struct {
+	client *azsecrets.Client
+}
+
+func (l azureSecretLister) listSecrets(ctx context.Context) ([]*azsecrets.SecretProperties, error) {
+	var secrets []*azsecrets.SecretProperties
+	pager := l.client.NewListSecretPropertiesPager(nil)
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		secrets = append(secrets, page.Value...)
+	}
+	return secrets, nil
+}
+
+// ValidateSecretContentTypes lists every secret in the vault and returns the
+// names of those without a content type, sorted. When required is set, each
+// of them also fails the test, in a single failure. Secrets Key Vault manages
+// for certificates are skipped, since their content type comes from the
+// certificate policy.
+func ValidateSecretContentTypes(t *testing.T, config TestConfig, vaultName string, required bool) []string {
+	t.Helper()
+
+	missing, err := findSecretsWithoutContentType(context.Background(), azureSecretLister{secretsClient(t, vaultName)})
+	require.NoError(t, err, "failed to list secrets in Key Vault %s", vaultName)
+	if required && len(missing) > 0 {
+		t.Errorf("Key Vault %s has %d secret(s) without a content type: %s", vaultName, len(missing), strings.Join(missing, ", "))
+	}
+	return missing
+}
+
+func findSecretsWithoutContentType(ctx context.Context, lister secretLister) ([]string, error) {
+	secrets, err := lister.listSecrets(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var missing []string
+	for _, secret := range secrets {
+		if secret == nil || secret.ID == nil || isTrue(secret.Managed) {
+			continue
+		}
+		if secret.ContentType == nil || strings.TrimSpace(*secret.ContentType) == "" {
+			missing = append(missing, secret.ID.Name())
+		}
+	}
+	sort.Strings(missing)
+	return missing, nil
+}
+
+// ValidateNoExpiredSecrets lists every secret in the vault and fails the test,
+// in a single failure, for each one that has expired or expires within
+// warnWindow. It returns those secrets as "name expired at ..." or
+// "name expires at ..." lines, sorted by name. Secrets without an expiry date
+// are not checked, nor are those Key Vault manages for certificates, which
+// expire with the certificate.
+func ValidateNoExpiredSecrets(t *testing.T, config TestConfig, vaultName string, warnWindow time.Duration) []string {
+	t.Helper()
+
+	expiring, err := findExpiringSecrets(context.Background(), azureSecretLister{secretsClient(t, vaultName)}, time.Now(), warnWindow)
+	require.NoError(t, err, "failed to list secrets in Key Vault %s", vaultName)
+	if len(expiring) > 0 {
+		t.Errorf("Key Vault %s has %d secret(s) expired or expiring within %s:\n  %s", vaultName, len(expiring), warnWindow, strings.Join(expiring, "\n  "))
+	}
+	return expiring
+}
+
+func findExpiringSecrets(ctx context.Context, lister secretLister, now time.Time, warnWindow time.Duration) ([]string, error) {
+	secrets, err := lister.listSecrets(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var expiring []string
+	for _, secret := range secrets {
+		if secret == nil || secret.ID == nil || isTrue(secret.Managed) || secret.Attributes == nil || secret.Attributes.Expires == nil {
+			continue
+		}
+		expires := secret.Attributes.Expires.UTC()
+		switch {
+		case !expires.After(now):
+			expiring = append(expiring, fmt.Sprintf("%s expired at %s", secret.ID.Name(), expires.Format(time.RFC3339)))
+		case expires.Before(now.Add(warnWindow)):
+			expiring = append(expiring, fmt.Sprintf("%s expires at %s", secret.ID.Name(), expires.Format(time.RFC3339)))
+		}
+	}
+	sort.Strings(expiring)
+	return expiring, nil
+}
+
+// ValidateSecretNotBefore checks the current version of secretName in
+// vaultName carries the activation time expected, as set by not_before_date.
+// Key Vault does not enforce the time for secrets, so the secret can be read
+// before it.
+func ValidateSecretNotBefore(t *testing.T, config TestConfig, vaultName string, secretName string, expected time.Time) {
+	t.Helper()
+
+	resp, err := secretsClient(t, vaultName).GetSecret(context.Background(), secretName, "", nil)
+	require.NoError(t, err, "failed to read secret %s in Key Vault %s", secretName, vaultName)
+	var notBefore *time.Time
+	if resp.Attributes != nil {
+		notBefore = resp.Attributes.NotBefore
+	}
+	if problem := notBeforeProblem(notBefore, expected); problem != "" {
+		t.Errorf("secret %s in Key Vault %s %s", secretName, vaultName, problem)
+	}
+}
+
+// notBeforeProblem describes how notBefore differs from expected, or returns
+// "" when they are the same instant. Key Vault keeps whole seconds.
+func notBeforeProblem(notBefore *time.Time, expected time.Time) string {
+	want := expected.UTC().Truncate(time.Second)
+	if notBefore == nil {
+		return fmt.Sprintf("has no not before time, expected %s", want.Format(time.RFC3339))
+	}
+	if got := notBefore.UTC().Truncate(time.Second); !got.Equal(want) {
+		return fmt.Sprintf("is not valid before %s, expected %s", got.Format(time.RFC3339), want.Format(time.RFC3339))
+	}
+	return ""
+}
+
+// secretVersions is the subset of the Key Vault secrets API used to rotate
+// secrets.
+type secretVersions interface {
+	// setSecret creates a new version of name and returns its ID.
+	setSecret(ctx context.Context, name string, value string) (string, error)
+	listVersions(ctx context.Context, name string) ([]*azsecrets.SecretProperties, error)
+}
+
+type azureSecretVersions struct {
+	client *azsecrets.Client
+}
+
+func (v azureSecretVersions) setSecret(ctx context.Context, name string, value string) (string, error) {
+	resp, err := v.client.SetSecret(ctx, name, azsecrets.SetSecretParameters{
+		Value:            &value,
+		SecretAttributes: &azsecrets.SecretAttributes{Enabled: to.Ptr(true)},
+	}, nil)
+	if err != nil {
+		return "", err
+	}
+	if resp.ID == nil {
+		return "", fmt.Errorf("new version of secret %s has no ID", name)
+	}
+	return string(*resp.ID), nil
+}
+
+func (v azureSecretVersions) listVersions(ctx context.Context, name string) ([]*azsecrets.SecretProperties, error) {
+	var versions []*azsecrets.SecretProperties
+	pager := v.client.NewListSecretPropertiesVersionsPager(name, nil)
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, page.Value...)
+	}
+	return versions, nil
+}
+
+// RotateSecret sets newValue as a new, enabled version of secretName and
+// returns the ID of that version. A secret whose current version is disabled
+// can still be rotated; the disabled version is left as it is. A soft-deleted
+// secret must be recovered or purged first.
+func RotateSecret(t *testing.T, config TestConfig, vaultName string, secretName string, newValue string) string {
+	t.Helper()
+
+	id, err := rotateSecret(context.Background(), azureSecretVersions{secretsClient(t, vaultName)}, secretName, newValue)
+	require.NoError(t, err, "failed to rotate secret %s in Key Vault %s", secretName, vaultName)
+	return id
+}
+
+// GetSecretVersionCount returns how many versions secretName has, disabled
+// ones included, or 0 when there is no such secret.
+func GetSecretVersionCount(t *testing.T, config TestConfig, vaultName string, secretName string) int {
+	t.Helper()
+
+	count, err := countSecretVersions(context.Background(), azureSecretVersions{secretsClient(t, vaultName)}, secretName)
+	require.NoError(t, err, "failed to list versions of secret %s in Key Vault %s", secretName, vaultName)
+	return count
+}
+
+func rotateSecret(ctx context.Context, client secretVersions, name string, value string) (string, error) {
+	id, err := client.setSecret(ctx, name, value)
+	var respErr *azcore.ResponseError
+	if errors.As(err, &respErr) && respErr.StatusCode == http.StatusConflict {
+		return "", fmt.Errorf("secret %s is soft-deleted; recover or purge it before rotating: %w", name, err)
+	}
+	return id, err
+}
+
+func countSecretVersions(ctx context.Context, client secretVersions, name string) (int, error) {
+	versions, err := client.listVersions(ctx, name)
+	var respErr *azcore.ResponseError
+	if errors.As(err, &respErr) && respErr.StatusCode == http.StatusNotFound {
+		return 0, nil
+	}
+	return len(versions), err
+}
+
+// secretSetter writes a new version of a secret and returns its ID.
+type secretSetter interface {
+	setSecret(ctx context.Context, name string, value string) (string, error)
+}
+
+// SeedSecrets writes every secret in secrets, keyed by name, to vaultName
+// through the data plane, with up to parallelism writes at a time, for tests
+// needing more secrets than are worth declaring in the module. Every secret
+// is attempted; the ones that failed are reported in a single failure.
+func SeedSecrets(t *testing.T, config TestConfig, vaultName string, secrets map[string]string, parallelism int) {
+	t.Helper()
+
+	err := seedSecrets(context.Background(), azureSecretVersions{secretsClient(t, vaultName)}, secrets, parallelism)
+	require.NoError(t, err, "failed to seed secrets in Key Vault %s", vaultName)
+}
+
+func seedSecrets(ctx context.Context, client secretSetter, secrets map[string]string, parallelism int) error {
+	if parallelism < 1 {
+		parallelism = 1
+	}
+
+	names := make(chan string)
+	var (
+		mu       sync.Mutex
+		failures []string
+		wg       sync.WaitGroup
+	)
+	for i := 0; i < parallelism; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for name := range names {
+				if _, err := client.setSecret(ctx, name, secrets[name]); err != nil {
+					mu.Lock()
+					failures = append(failures, fmt.Sprintf("%s: %v", name, err))
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for name := range secrets {
+		names <- name
+	}
+	close(names)
+	wg.Wait()
+
+	if len(failures) > 0 {
+		sort.Strings(failures)
+		return fmt.Errorf("%d of %d secret(s) failed:\n  %s", len(failures), len(secrets), strings.Join(failures, "\n  "))
+	}
+	return nil
+}
+
+// Key restores conflict while the key name is still held by a deleted key,
+// which lasts a little while after a purge returns.
+const (
+	keyRestoreAttempts      = 10
+	keyRestoreRetryInterval = 15 * time.Second
+)
+
+// keyRestorer is the subset of *azkeys.Client used to restore key backups.
+type keyRestorer interface {
+	RestoreKey(ctx context.Context, parameters azkeys.RestoreKeyParameters, options *azkeys.RestoreKeyOptions) (azkeys.RestoreKeyResponse, error)
+}
+
+// BackupKeyVaultKey returns a protected backup of every version of keyName,
+// restorable with RestoreKeyVaultKey into a vault in the same subscription
+// and geography.
+func BackupKeyVaultKey(t *testing.T, config TestConfig, vaultName string, keyName string) []byte {
+	t.Helper()
+
+	resp, err := keysClient(t, vaultName).BackupKey(context.Background(), keyName, nil)
+	require.NoError(t, err, "failed to back up key %s in Key Vault %s", keyName, vaultName)
+	return resp.Value
+}
+
+// RestoreKeyVaultKey restores a backup taken with BackupKeyVaultKey. The key
+// name must be free: a deleted key keeps it until purged (see
+// DeleteKeyVaultKey), and for a short while after the purge returns, so
+// restores rejected with 409 Conflict are retried.
+func RestoreKeyVaultKey(t *testing.T, config TestConfig, vaultName string, backup []byte) {
+	t.Helper()
+
+	err := restoreKeyBackup(context.Background(), keysClient(t, vaultName), backup, keyRestoreAttempts, func() {
+		time.Sleep(keyRestoreRetryInterval)
+	})
+	require.NoError(t, err, "failed to restore key backup into Key Vault %s", vaultName)
+}
+
+func restoreKeyBackup(ctx context.Context, client keyRestorer, backup []byte, attempts int, wait func()) error {
+	var err error
+	for attempt := 0; attempt < attempts; attempt++ {
+		if attempt > 0 {
+			wait()
+		}
+		_, err = client.RestoreKey(ctx, azkeys.RestoreKeyParameters{KeyBackup: backup}, nil)
+		var respErr *azcore.ResponseError
+		if !errors.As(err, &respErr) || respErr.StatusCode != http.StatusConflict {
+			return err
+		}
+	}
+	return fmt.Errorf("key name still in use after %d restore attempts; a deleted key must be purged before its backup can be restored: %w", attempts, err)
+}
+
+// DeleteKeyVaultKey deletes keyName and purges it, freeing its name for
+// RestoreKeyVaultKey. Purging fails in a purge-protected vault, where a deleted
+// key stays until its retention period ends.
+func DeleteKeyVaultKey(t *testing.T, config TestConfig, vaultName string, keyName string) {
+	t.Helper()
+
+	ctx := context.Background()
+	client := keysClient(t, vaultName)
+
+	_, err := client.DeleteKey(ctx, keyName, nil)
+	require.NoError(t, err, "failed to delete key %s in Key Vault %s", keyName, vaultName)
+
+	// Deletion completes asynchronously and only a fully deleted key can be purged
+	retry.DoWithRetry(t, fmt.Sprintf("wait for key %s to be deleted", keyName), keyRestoreAttempts, keyRestoreRetryInterval, func() (string, error) {
+		_, err := client.GetDeletedKey(ctx, keyName, nil)
+		return "", err
+	})
+
+	_, err = client.PurgeDeletedKey(ctx, keyName, nil)
+	require.NoError(t, err, "failed to purge deleted key %s in Key Vault %s", keyName, vaultName)
+}
+
+// dataPlaneObjects is the subset of the key and secret data-plane APIs used
+// to delete and purge objects, plus listings of the vault's keys and secrets.
+type dataPlaneObjects interface {
+	keyLister
+	secretLister
+	deleteKey(ctx context.Context, name string) error
+	purgeKey(ctx context.Context, name string) error
+	deleteSecret(ctx context.Context, name string) error
+	purgeSecret(ctx context.Context, name string) error
+}
+
+type azureDataPlaneObjects struct {
+	azureKeyInspector
+	azureSecretLister
+}
+
+func (a azureDataPlaneObjects) deleteKey(ctx context.Context, name string) error {
+	_, err := a.azureKeyInspector.DeleteKey(ctx, name, nil)
+	return err
+}
+
+func (a azureDataPlaneObjects) purgeKey(ctx context.Context, name string) error {
+	_, err := a.azureKeyInspector.PurgeDeletedKey(ctx, name, nil)
+	return err
+}
+
+func (a azureDataPlaneObjects) deleteSecret(ctx context.Context, name string) error {
+	_, err := a.azureSecretLister.client.DeleteSecret(ctx, name, nil)
+	return err
+}
+
+func (a azureDataPlaneObjects) purgeSecret(ctx context.Context, name string) error {
+	_, err := a.azureSecretLister.client.PurgeDeletedSecret(ctx, name, nil)
+	return err
+}
+
+// CleanupTestDataPlaneObjects deletes exactly the keys and secrets named in
+// createdNames from vaultName, such as the names a test passed to
+// SeedSecrets, so a long-lived vault shared by test runs keeps everything
+// else. Objects whose recovery level allows it are purged too; in a
+// purge-protected vault they stay soft-deleted until retention ends. Names
+// no longer in the vault are skipped, and every name is attempted before the
+// failures are reported in a single failure.
+func CleanupTestDataPlaneObjects(t *testing.T, config TestConfig, vaultName string, createdNames []string) {
+	t.Helper()
+
+	objects := azureDataPlaneObjects{azureKeyInspector{keysClient(t, vaultName)}, azureSecretLister{secretsClient(t, vaultName)}}
+	err := cleanupDataPlaneObjects(context.Background(), t, objects, createdNames, keyRestoreAttempts, func() {
+		time.Sleep(keyRestoreRetryInterval)
+	})
+	require.NoError(t, err, "failed to clean up test objects in Key Vault %s", vaultName)
+}
+
+func cleanupDataPlaneObjects(ctx context.Context, t logT, objects dataPlaneObjects, names []string, attempts int, wait func()) error {
+	// The vault's keys and secrets by lowercased name, with their recovery level
+	type listedObject struct{ name, level string }
+	listedKeys, listedSecrets := map[string]listedObject{}, map[string]listedObject{}
+	keys, err := objects.listKeys(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list keys: %w", err)
+	}
+	for _, key := range keys {
+		if key != nil && key.KID != nil {
+			listedKeys[strings.ToLower(key.KID.Name())] = listedObject{key.KID.Name(), keyRecoveryLevel(azkeys.KeyBundle{Attributes: key.Attributes})}
+		}
+	}
+	secrets, err := objects.listSecrets(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list secrets: %w", err)
+	}
+	for _, secret := range secrets {
+		// Certificates own their managed secrets, which cannot be deleted alone
+		if secret != nil && secret.ID != nil && !isTrue(secret.Managed) {
+			listedSecrets[strings.ToLower(secret.ID.Name())] = listedObject{secret.ID.Name(), secretRecoveryLevel(azsecrets.Secret{Attributes: secret.Attributes})}
+		}
+	}
+
+	var failures []string
+	for _, name := range names {
+		key, isKey := listedKeys[strings.ToLower(name)]
+		secret, isSecret := listedSecrets[strings.ToLower(name)]
+		if !isKey && !isSecret {
+			t.Logf("no key or secret named %s left to clean up", name)
+			continue
+		}
+		if isKey {
+			if err := removeObject(ctx, objects.deleteKey, objects.purgeKey, key.name, key.level, attempts, wait); err != nil {
+				failures = append(failures, fmt.Sprintf("key %s: %v", key.name, err))
+			}
+		}
+		if isSecret {
+			if err := removeObject(ctx, objects.deleteSecret, objects.purgeSecret, secret.name, secret.level, attempts, wait); err != nil {
+				failures = append(failures, fmt.Sprintf("secret %s: %v", secret.name, err))
+			}
+		}
+	}
+
+	if len(failures) > 0 {
+		return fmt.Errorf("%d object(s) could not be cleaned up:\n  %s", len(failures), strings.Join(failures, "\n  "))
+	}
+	return nil
+}
+
+// removeObject deletes name and, when its recovery level allows purging a
+// soft-deleted object, purges it, retrying while the deletion completes.
+func removeObject(ctx context.Context, deleteObject, purgeObject func(context.Context, string) error, name string, level string, attempts int, wait func()) error {
+	if err := deleteObject(ctx, name); err != nil {
+		return fmt.Errorf("failed to delete: %w", err)
+	}
+	if !strings.HasSuffix(level, "+Purgeable") {
+		return nil
+	}
+	// Deletion completes asynchronously and only a fully deleted object can be purged
+	if err := retryKeyOperation(attempts, wait, func() error { return purgeObject(ctx, name) }); err != nil {
+		return fmt.Errorf("failed to purge: %w", err)
+	}
+	return nil
+}
+
+// keyLister lists the properties of every key in a vault.
+type keyLister interface {
+	listKeys(ctx context.Context) ([]*azkeys.KeyProperties, error)
+}
+
+// keyInspector is the subset of *azkeys.Client used to read keys, plus a
+// listing of the vault's keys.
+type keyInspector interface {
+	keyGetter
+	keyLister
+}
+
+type azureKeyInspector struct {
+	*azkeys.Client
+}
+
+func (i azureKeyInspector) listKeys(ctx context.Context) ([]*azkeys.KeyProperties, error) {
+	var keys []*azkeys.KeyProperties
+	pager := i.NewListKeyPropertiesPager(nil)
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, page.Value...)
+	}
+	return keys, nil
+}
+
+// hsmKeyProblem reads the vault's keys until one turns out HSM-backed
+// (RSA-HSM or EC-HSM) and returns "", or describes why none could be read.
+func hsmKeyProblem(ctx context.Context, keys keyInspector) string {
+	listed, err := keys.listKeys(ctx)
+	if err != nil {
+		return fmt.Sprintf("listing its keys failed: %v", err)
+	}
+	for _, props := range listed {
+		if props == nil || props.KID == nil {
+			continue
+		}
+		name := props.KID.Name()
+		resp, err := keys.GetKey(ctx, name, "", nil)
+		if err != nil {
+			return fmt.Sprintf("reading key %s failed: %v", name, err)
+		}
+		if resp.Key != nil && resp.Key.Kty != nil && (*resp.Key.Kty == azkeys.KeyTypeRSAHSM || *resp.Key.Kty == azkeys.KeyTypeECHSM) {
+			return ""
+		}
+	}
+	return "it holds no HSM-backed key (RSA-HSM or EC-HSM) to prove HSM operations are available"
+}
+
+// ValidateMinimumKeySize reads every key in vaultName and fails t, in a
+// single failure, for each RSA key whose modulus is shorter than minRSA bits
+// and each EC key on a curve outside allowedCurves, such as P-256 or P-384,
+// as crypto policy mandates. Curves compare case-insensitively; with no
+// allowedCurves any curve passes.
+func ValidateMinimumKeySize(t *testing.T, config TestConfig, vaultName string, minRSA int, allowedCurves []string) {
+	t.Helper()
+
+	problems, err := weakKeyProblems(context.Background(), azureKeyInspector{keysClient(t, vaultName)}, minRSA, allowedCurves)
+	require.NoError(t, err, "failed to read the keys of Key Vault %s", vaultName)
+	if len(problems) > 0 {
+		t.Errorf("Key Vault %s holds %d key(s) below the mandated strength:\n  %s", vaultName, len(problems), strings.Join(problems, "\n  "))
+	}
+}
+
+// weakKeyProblems describes each key too weak for minRSA and allowedCurves,
+// in listing order. Symmetric keys are not checked.
+func weakKeyProblems(ctx context.Context, keys keyInspector, minRSA int, allowedCurves []string) ([]string, error) {
+	curves := map[string]bool{}
+	for _, curve := range allowedCurves {
+		curves[strings.ToLower(curve)] = true
+	}
+
+	listed, err := keys.listKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var problems []string
+	for _, props := range listed {
+		if props == nil || props.KID == nil {
+			continue
+		}
+		name := props.KID.Name()
+		resp, err := keys.GetKey(ctx, name, "", nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read key %s: %w", name, err)
+		}
+		key := resp.Key
+		if key == nil || key.Kty == nil {
+			continue
+		}
+		switch *key.Kty {
+		case azkeys.KeyTypeRSA, azkeys.KeyTypeRSAHSM:
+			if bits := new(big.Int).SetBytes(key.N).BitLen(); bits < minRSA {
+				problems = append(problems, fmt.Sprintf("%s is a %d-bit %s key, below the minimum of %d bits", name, bits, *key.Kty, minRSA))
+			}
+		case azkeys.KeyTypeEC, azkeys.KeyTypeECHSM:
+			curve := ""
+			if key.Crv != nil {
+				curve = string(*key.Crv)
+			}
+			if len(curves) > 0 && !curves[strings.ToLower(curve)] {
+				problems = append(problems, fmt.Sprintf("%s is an %s key on curve %s, outside the allowed %s", name, *key.Kty, curve, strings.Join(allowedCurves, ", ")))
+			}
+		}
+	}
+	return problems, nil
+}
+
+// ValidateObjectEnabled checks the key or secret called name in vaultName is
+// enabled, or disabled when expected is false, failing t if it is not or if
+// the vault has no such key or secret. The state is read from the vault's
+// listings, as Key Vault refuses to return a disabled secret.
+func ValidateObjectEnabled(t *testing.T, config TestConfig, vaultName string, name string, expected bool) {
+	t.Helper()
+
+	problem, err := objectEnabledProblem(context.Background(), azureKeyInspector{keysClient(t, vaultName)}, azureSecretLister{secretsClient(t, vaultName)}, name, expected)
+	require.NoError(t, err, "failed to list the keys and secrets of Key Vault %s", vaultName)
+	if problem != "" {
+		t.Errorf("Key Vault %s: %s", vaultName, problem)
+	}
+}
+
+// objectEnabledProblem describes how the key or secret called name differs
+// from being enabled (or disabled, as expected says), or returns "" when
+// every object by that name matches. Names compare case-insensitively.
+func objectEnabledProblem(ctx context.Context, keys keyLister, secrets secretLister, name string, expected bool) (string, error) {
+	state := map[bool]string{true: "enabled", false: "disabled"}
+	found := false
+
+	listedKeys, err := keys.listKeys(ctx)
+	if err != nil {
+		return "", err
+	}
+	for _, key := range listedKeys {
+		if key == nil || key.KID == nil || !strings.EqualFold(key.KID.Name(), name) {
+			continue
+		}
+		found = true
+		if enabled := key.Attributes != nil && isTrue(key.Attributes.Enabled); enabled != expected {
+			return fmt.Sprintf("key %s is %s, expected %s", name, state[enabled], state[expected]), nil
+		}
+	}
+
+	listedSecrets, err := secrets.listSecrets(ctx)
+	if err != nil {
+		return "", err
+	}
+	for _, secret := range listedSecrets {
+		if secret == nil || secret.ID == nil || isTrue(secret.Managed) || !strings.EqualFold(secret.ID.Name(), name) {
+			continue
+		}
+		found = true
+		if enabled := secret.Attributes != nil && isTrue(secret.Attributes.Enabled); enabled != expected {
+			return fmt.Sprintf("secret %s is %s, expected %s", name, state[enabled], state[expected]), nil
+		}
+	}
+
+	if !found {
+		return fmt.Sprintf("no key or secret named %s", name), nil
+	}
+	return "", nil
+}
+
+// keyRecoverer is the subset of *azkeys.Client used to delete and recover a
+// key, plus a listing of the vault's deleted keys.
+type keyRecoverer interface {
+	keyGetter
+	DeleteKey(ctx context.Context, name string, options *azkeys.DeleteKeyOptions) (azkeys.DeleteKeyResponse, error)
+	RecoverDeletedKey(ctx context.Context, name string, options *azkeys.RecoverDeletedKeyOptions) (azkeys.RecoverDeletedKeyResponse, error)
+	listDeletedKeys(ctx context.Context) ([]*azkeys.DeletedKeyProperties, error)
+}
+
+type azureKeyRecoverer struct {
+	*azkeys.Client
+}
+
+func (r azureKeyRecoverer) listDeletedKeys(ctx context.Context) ([]*azkeys.DeletedKeyProperties, error) {
+	var keys []*azkeys.DeletedKeyProperties
+	pager := r.NewListDeletedKeyPropertiesPager(nil)
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, page.Value...)
+	}
+	return keys, nil
+}
+
+// ValidateKeyRecoverable deletes keyName, checks it is listed among the
+// vault's deleted keys with a purge date still ahead, recovers it and checks
+// the recovered key has the same version and key material. The key is never
+// purged, so the check holds in purge-protected vaults too; a key whose
+// recovery level says deletion is permanent is left alone and fails the test.
+func ValidateKeyRecoverable(t *testing.T, config TestConfig, vaultName string, keyName string) {
+	t.Helper()
+
+	err := recoverKey(context.Background(), azureKeyRecoverer{keysClient(t, vaultName)}, keyName, keyRestoreAttempts, time.Now, func() {
+		time.Sleep(keyRestoreRetryInterval)
+	})
+	require.NoError(t, err, "key %s in Key Vault %s is not recoverable", keyName, vaultName)
+}
+
+func recoverKey(ctx context.Context, client keyRecoverer, name string, attempts int, now func() time.Time, wait func()) error {
+	before, err := client.GetKey(ctx, name, "", nil)
+	if err != nil {
+		return fmt.Errorf("failed to read key: %w", err)
+	}
+	if level := keyRecoveryLevel(before.KeyBundle); !strings.Contains(level, "Recoverable") {
+		return fmt.Errorf("recovery level is %q, so deleting the key would destroy it", level)
+	}
+
+	if _, err := client.DeleteKey(ctx, name, nil); err != nil {
+		return fmt.Errorf("failed to delete key: %w", err)
+	}
+
+	// Deletion completes asynchronously, and so does recovery
+	var deleted *azkeys.DeletedKeyProperties
+	err = retryKeyOperation(attempts, wait, func() error {
+		keys, err := client.listDeletedKeys(ctx)
+		if err != nil {
+			return err
+		}
+		for _, k := range keys {
+			if k.KID != nil && k.KID.Name() == name {
+				deleted = k
+				return nil
+			}
+		}
+		return errors.New("key is not in the deleted keys list")
+	})
+	if err != nil {
+		return err
+	}
+	if deleted.ScheduledPurgeDate != nil && !deleted.ScheduledPurgeDate.After(now()) {
+		return fmt.Errorf("deleted key is scheduled for purge at %s, outside the retention window", deleted.ScheduledPurgeDate.Format(time.RFC3339))
+	}
+
+	if _, err := client.RecoverDeletedKey(ctx, name, nil); err != nil {
+		return fmt.Errorf("failed to recover deleted key: %w", err)
+	}
+	var after azkeys.GetKeyResponse
+	err = retryKeyOperation(attempts, wait, func() error {
+		after, err = client.GetKey(ctx, name, "", nil)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("recovered key cannot be read: %w", err)
+	}
+
+	if !sameKeyMaterial(before.Key, after.Key) {
+		return errors.New("recovered key differs from the deleted one")
+	}
+	return nil
+}
+
+// retryKeyOperation calls op up to attempts times, waiting between calls,
+// until it succeeds.
+func retryKeyOperation(attempts int, wait func(), op func() error) error {
+	var err error
+	for attempt := 0; attempt < attempts; attempt++ {
+		if attempt > 0 {
+			wait()
+		}
+		if err = op(); err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("gave up after %d attempts: %w", attempts, err)
+}
+
+func keyRecoveryLevel(key azkeys.KeyBundle) string {
+	if key.Attributes == nil || key.Attributes.RecoveryLevel == nil {
+		return ""
+	}
+	return *key.Attributes.RecoveryLevel
+}
+
+// sameKeyMaterial reports whether two keys have the same version and public
+// key material. Private material never leaves the vault, but a key that kept
+// its version and public half is the same key.
+func sameKeyMaterial(a, b *azkeys.JSONWebKey) bool {
+	if a == nil || b == nil || a.KID == nil || b.KID == nil {
+		return false
+	}
+	return *a.KID == *b.KID &&
+		bytes.Equal(a.N, b.N) && bytes.Equal(a.E, b.E) &&
+		bytes.Equal(a.X, b.X) && bytes.Equal(a.Y, b.Y)
+}
+
+// secretRecoverer is the subset of *azsecrets.Client used to delete and
+// recover a secret, plus a listing of the vault's deleted secrets.
+type secretRecoverer interface {
+	GetSecret(ctx context.Context, name string, version string, options *azsecrets.GetSecretOptions) (azsecrets.GetSecretResponse, error)
+	DeleteSecret(ctx context.Context, name string, options *azsecrets.DeleteSecretOptions) (azsecrets.DeleteSecretResponse, error)
+	RecoverDeletedSecret(ctx context.Context, name string, options *azsecrets.RecoverDeletedSecretOptions) (azsecrets.RecoverDeletedSecretResponse, error)
+	listDeletedSecrets(ctx context.Context) ([]*azsecrets.DeletedSecretProperties, error)
+}
+
+type azureSecretRecoverer struct {
+	*azsecrets.Client
+}
+
+func (r azureSecretRecoverer) listDeletedSecrets(ctx context.Context) ([]*azsecrets.DeletedSecretProperties, error) {
+	var secrets []*azsecrets.DeletedSecretProperties
+	pager := r.NewListDeletedSecretPropertiesPager(nil)
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		secrets = append(secrets, page.Value...)
+	}
+	return secrets, nil
+}
+
+// ValidateSecretRecoverable is ValidateKeyRecoverable for secrets: it deletes
+// secretName, checks it is listed among the vault's deleted secrets with a
+// purge date still ahead, recovers it and checks the recovered secret has the
+// same version and value. The secret is never purged, which a purge-protected
+// vault would refuse anyway.
+func ValidateSecretRecoverable(t *testing.T, config TestConfig, vaultName string, secretName string) {
+	t.Helper()
+
+	err := recoverSecret(context.Background(), azureSecretRecoverer{secretsClient(t, vaultName)}, secretName, keyRestoreAttempts, time.Now, func() {
+		time.Sleep(keyRestoreRetryInterval)
+	})
+	require.NoError(t, err, "secret %s in Key Vault %s is not recoverable", secretName, vaultName)
+}
+
+func recoverSecret(ctx context.Context, client secretRecoverer, name string, attempts int, now func() time.Time, wait func()) error {
+	before, err := client.GetSecret(ctx, name, "", nil)
+	if err != nil {
+		return fmt.Errorf("failed to read secret: %w", err)
+	}
+	if level := secretRecoveryLevel(before.Secret); !strings.Contains(level, "Recoverable") {
+		return fmt.Errorf("recovery level is %q, so deleting the secret would destroy it", level)
+	}
+
+	if _, err := client.DeleteSecret(ctx, name, nil); err != nil {
+		return fmt.Errorf("failed to delete secret: %w", err)
+	}
+
+	// Deletion completes asynchronously, and so does recovery
+	var deleted *azsecrets.DeletedSecretProperties
+	err = retryKeyOperation(attempts, wait, func() error {
+		secrets, err := client.listDeletedSecrets(ctx)
+		if err != nil {
+			return err
+		}
+		for _, s := range secrets {
+			if s.ID != nil && s.ID.Name() == name {
+				deleted = s
+				return nil
+			}
+		}
+		return errors.New("secret is not in the deleted secrets list")
+	})
+	if err != nil {
+		return err
+	}
+	if deleted.ScheduledPurgeDate != nil && !deleted.ScheduledPurgeDate.After(now()) {
+		return fmt.Errorf("deleted secret is scheduled for purge at %s, outside the retention window", deleted.ScheduledPurgeDate.Format(time.RFC3339))
+	}
+
+	if _, err := client.RecoverDeletedSecret(ctx, name, nil); err != nil {
+		return fmt.Errorf("failed to recover deleted secret: %w", err)
+	}
+	var after azsecrets.GetSecretResponse
+	err = retryKeyOperation(attempts, wait, func() error {
+		after, err = client.GetSecret(ctx, name, "", nil)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("recovered secret cannot be read: %w", err)
+	}
+
+	if !sameSecret(before.Secret, after.Secret) {
+		return errors.New("recovered secret differs from the deleted one")
+	}
+	return nil
+}
+
+func secretRecoveryLevel(secret azsecrets.Secret) string {
+	if secret.Attributes == nil || secret.Attributes.RecoveryLevel == nil {
+		return ""
+	}
+	return *secret.Attributes.RecoveryLevel
+}
+
+// sameSecret reports whether two reads of a secret returned the same version
+// with the same value.
+func sameSecret(a, b azsecrets.Secret) bool {
+	if a.ID == nil || b.ID == nil || a.Value == nil || b.Value == nil {
+		return false
+	}
+	return *a.ID == *b.ID && *a.Value == *b.Value
+}
+
+const (
+	vaultReadyInitialWait = 2 * time.Second
+	vaultReadyMaxWait     = 30 * time.Second
+)
+
+// vaultProbe checks whether a vault answers on the management and data
+// planes.
+type vaultProbe interface {
+	getVault(ctx context.Context) error
+	listKeys(ctx context.Context) error
+}
+
+type azureVaultProbe struct {
+	t         *testing.T
+	config    TestConfig
+	vaultName string
+	keys      *azkeys.Client
+}
+
+func (p azureVaultProbe) getVault(ctx context.Context) error {
+	_, err := azure.GetKeyVaultE(p.t, p.config.ResourceGroupName(), p.vaultName, p.config.SubscriptionID)
+	return err
+}
+
+func (p azureVaultProbe) listKeys(ctx context.Context) error {
+	_, err := p.keys.NewListKeyPropertiesPager(nil).NextPage(ctx)
+	return err
+}
+
+// WaitForVaultReady blocks until vaultName can be read from ARM and its keys
+// listed on the data plane, polling with exponential backoff. Right after
+// apply the vault's DNS name and the test identity's role assignments may not
+// have propagated yet, so data-plane calls can fail with 404, a lookup error
+// or 403 for a while.
+func WaitForVaultReady(t *testing.T, config TestConfig, vaultName string, timeout time.Duration) {
+	t.Helper()
+
+	probe := azureVaultProbe{t: t, config: config, vaultName: vaultName, keys: keysClient(t, vaultName)}
+	err := waitForVaultReady(context.Background(), probe, timeout, time.Now, time.Sleep)
+	require.NoError(t, err, "Key Vault %s did not become ready", vaultName)
+}
+
+func waitForVaultReady(ctx context.Context, probe vaultProbe, timeout time.Duration, now func() time.Time, sleep func(time.Duration)) error {
+	return pollWithBackoff(ctx, timeout, vaultReadyInitialWait, vaultReadyMaxWait, now, sleep, func(ctx context.Context) (bool, error) {
+		if err := probe.getVault(ctx); err != nil {
+			return false, fmt.Errorf("management plane: %w", err)
+		}
+		if err := probe.listKeys(ctx); err != nil {
+			return false, fmt.Errorf("data plane: %w", err)
+		}
+		return true, nil
+	})
+}
+
+func keysClient(t *testing.T, vaultName string) *azkeys.Client {
+	t.Helper()
+
+	client, err := azkeys.NewClient(keyVaultURI(t, vaultName), azureCredential(t), nil)
+	require.NoError(t, err)
+	return client
+}
+
+func secretsClient(t *testing.T, vaultName string) *azsecrets.Client {
+	t.Helper()
+
+	client, err := azsecrets.NewClient(keyVaultURI(t, vaultName), azureCredential(t), nil)
+	require.NoError(t, err)
+	return client
+}
+
+// keyVaultURI returns the data-plane URI of a vault in the current cloud.
+func keyVaultURI(t *testing.T, vaultName string) string {
+	t.Helper()
+
+	suffix, err := azure.GetKeyVaultURISuffixE()
+	require.NoError(t, err)
+	return fmt.Sprintf("https://%s.%s/", vaultName, suffix)
+}
+
+// deletedVaults is the subset of the ARM vaults API used to clear soft-deleted
+// vault names.
+type deletedVaults interface {
+	// getDeleted returns the soft-deleted vault with the given name, or nil
+	// when there is none.
+	getDeleted(ctx context.Context, name string, location string) (*armkeyvault.DeletedVault, error)
+	purge(ctx context.Context, name string, location string) error
+}
+
+type armDeletedVaults struct {
+	client *armkeyvault.VaultsClient
+}
+
+func (a armDeletedVaults) getDeleted(ctx context.Context, name string, location string) (*armkeyvault.DeletedVault, error) {
+	resp, err := a.client.GetDeleted(ctx, name, location, nil)
+	var respErr *azcore.ResponseError
+	if errors.As(err, &respErr) && respErr.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &resp.DeletedVault, nil
+}
+
+func (a armDeletedVaults) purge(ctx context.Context, name string, location string) error {
+	poller, err := a.client.BeginPurgeDeleted(ctx, name, location, nil)
+	if err != nil {
+		return err
+	}
+	_, err = poller.PollUntilDone(ctx, nil)
+	return err
+}
+
+// getDeletedVault returns the soft-deleted vault named vaultName in
+// config.Region, or nil when there is none.
+func getDeletedVault(t *testing.T, config TestConfig, vaultName string) *armkeyvault.DeletedVault {
+	t.Helper()
+
+	client, err := armkeyvault.NewVaultsClient(config.SubscriptionID, azureCredential(t), armClientOptions())
+	require.NoError(t, err)
+	deleted, err := armDeletedVaults{client}.getDeleted(context.Background(), vaultName, config.Region)
+	require.NoError(t, err, "failed to look up soft-deleted vault %s", vaultName)
+	return deleted
+}
+
+// maxVaultNameAttempts bounds how many fresh names PurgeSoftDeletedVault tries
+// when earlier candidates are held by purge-protected vaults.
+const maxVaultNameAttempts = 5
+
+// PurgeSoftDeletedVault makes vaultName usable for a new vault in
+// config.Region and returns the name to deploy with. Vault names are global
+// and stay reserved while a vault is soft-deleted, so a rerun after a failed
+// cleanup collides with its own leftovers. A soft-deleted vault without purge
+// protection is purged. A purge-protected one cannot be purged until its
+// retention period ends, so instead config.UniqueID in vaultName is replaced
+// with a fresh ID and the returned name must be used from then on.
+func PurgeSoftDeletedVault(t *testing.T, config TestConfig, vaultName string) string {
+	t.Helper()
+
+	client, err := armkeyvault.NewVaultsClient(config.SubscriptionID, azureCredential(t), armClientOptions())
+	require.NoError(t, err)
+
+	name, err := clearSoftDeletedVaultName(context.Background(), armDeletedVaults{client}, config, vaultName, func() string {
+		return strings.ToLower(random.UniqueId())
+	})
+	require.NoError(t, err)
+	if name != vaultName {
+		t.Logf("Key Vault name %s is held by a purge-protected soft-deleted vault; using %s", vaultName, name)
+	}
+	return name
+}
+
+func clearSoftDeletedVaultName(ctx context.Context, vaults deletedVaults, config TestConfig, vaultName string, newID func() string) (string, error) {
+	name := vaultName
+	for attempt := 0; attempt < maxVaultNameAttempts; attempt++ {
+		deleted, err := vaults.getDeleted(ctx, name, config.Region)
+		if err != nil {
+			return "", fmt.Errorf("failed to look up soft-deleted vault %s: %w", name, err)
+		}
+		if deleted == nil {
+			return name, nil
+		}
+
+		if deleted.Properties == nil || !isTrue(deleted.Properties.PurgeProtectionEnabled) {
+			if err := vaults.purge(ctx, name, config.Region); err != nil {
+				return "", fmt.Errorf("failed to purge soft-deleted vault %s: %w", name, err)
+			}
+			return name, nil
+		}
+
+		if config.UniqueID == "" || !strings.Contains(vaultName, config.UniqueID) {
+			return "", fmt.Errorf("soft-deleted vault %s is purge protected and its name has no UniqueID to replace", name)
+		}
+		name = strings.Replace(vaultName, config.UniqueID, config.UniqueIDPrefix+newID(), 1)
+	}
+	return "", fmt.Errorf("no free Key Vault name after %d attempts starting from %s", maxVaultNameAttempts, vaultName)
+}
+
+// AssertVaultCreateMode reads vaultName in config's resource group from ARM
+// and asserts its createMode is expected, usually "default". A vault left in
+// "recover" after a failed recovery behaves oddly on later updates. ARM
+// normally leaves createMode out of a vault it returns, which counts as
+// "default".
+func AssertVaultCreateMode(t *testing.T, config TestConfig, vaultName string, expected string) bool {
+	t.Helper()
+
+	client, err := armkeyvault.NewVaultsClient(config.SubscriptionID, azureCredential(t), armClientOptions())
+	require.NoError(t, err)
+	resp, err := client.Get(context.Background(), config.ResourceGroupName(), vaultName, nil)
+	require.NoError(t, err, "failed to get Key Vault %s", vaultName)
+	if mode := vaultCreateMode(&resp.Vault); !strings.EqualFold(mode, expected) {
+		t.Errorf("Key Vault %s has createMode %q, expected %q", vaultName, mode, expected)
+		return false
+	}
+	return true
+}
+
+// vaultCreateMode returns kv's createMode in lower case, or "default" when
+// it is unset.
+func vaultCreateMode(kv *armkeyvault.Vault) string {
+	if kv.Properties == nil || kv.Properties.CreateMode == nil || *kv.Properties.CreateMode == "" {
+		return string(armkeyvault.CreateModeDefault)
+	}
+	return strings.ToLower(string(*kv.Properties.CreateMode))
+}
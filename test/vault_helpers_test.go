@@ -0,0 +1,923 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/keyvault/armkeyvault"
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azkeys"
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azsecrets"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeKeyGetter map[string]*bool
+
+func (f fakeKeyGetter) GetKey(ctx context.Context, name string, version string, options *azkeys.GetKeyOptions) (azkeys.GetKeyResponse, error) {
+	enabled, ok := f[name]
+	if !ok {
+		return azkeys.GetKeyResponse{}, &azcore.ResponseError{StatusCode: http.StatusNotFound, ErrorCode: "KeyNotFound"}
+	}
+	if name == "broken" {
+		return azkeys.GetKeyResponse{}, errors.New("forbidden")
+	}
+	return azkeys.GetKeyResponse{KeyBundle: azkeys.KeyBundle{Attributes: &azkeys.KeyAttributes{Enabled: enabled}}}, nil
+}
+
+func TestFindKeyProblems(t *testing.T) {
+	t.Parallel()
+
+	client := fakeKeyGetter{
+		"enabled":  to.Ptr(true),
+		"disabled": to.Ptr(false),
+		"unknown":  nil,
+		"broken":   to.Ptr(true),
+	}
+
+	assert.Empty(t, findKeyProblems(context.Background(), client, []string{"enabled"}))
+	assert.Equal(t, []string{
+		"missing-a: not found",
+		"disabled: disabled",
+		"unknown: disabled",
+		"broken: forbidden",
+		"missing-b: not found",
+	}, findKeyProblems(context.Background(), client, []string{"missing-a", "enabled", "disabled", "unknown", "broken", "missing-b"}))
+}
+
+func TestDisallowedKeyOperations(t *testing.T) {
+	t.Parallel()
+
+	ops := func(names ...string) *azkeys.JSONWebKey {
+		key := &azkeys.JSONWebKey{}
+		for _, name := range names {
+			key.KeyOps = append(key.KeyOps, to.Ptr(azkeys.KeyOperation(name)))
+		}
+		return key
+	}
+
+	assert.Empty(t, disallowedKeyOperations(ops("wrapKey", "unwrapKey"), []string{"wrapKey", "unwrapKey"}))
+	assert.Empty(t, disallowedKeyOperations(ops("wrapKey"), []string{"WRAPKEY", "unwrapKey"}), "operations should compare case-insensitively")
+	assert.Equal(t, []string{"sign", "verify"}, disallowedKeyOperations(ops("wrapKey", "verify", "unwrapKey", "sign"), []string{"wrapKey", "unwrapKey"}))
+	assert.Equal(t, []string{"encrypt"}, disallowedKeyOperations(ops("encrypt"), nil))
+	assert.Empty(t, disallowedKeyOperations(nil, nil))
+}
+
+// fakeKeyInspector holds the key type of each key by name, listed in name
+// order.
+type fakeKeyInspector struct {
+	names []string
+	types map[string]azkeys.KeyType
+}
+
+func (f fakeKeyInspector) GetKey(ctx context.Context, name string, version string, options *azkeys.GetKeyOptions) (azkeys.GetKeyResponse, error) {
+	kty, ok := f.types[name]
+	if !ok {
+		return azkeys.GetKeyResponse{}, &azcore.ResponseError{StatusCode: http.StatusForbidden, ErrorCode: "Forbidden"}
+	}
+	return azkeys.GetKeyResponse{KeyBundle: azkeys.KeyBundle{Key: &azkeys.JSONWebKey{Kty: to.Ptr(kty)}}}, nil
+}
+
+func (f fakeKeyInspector) listKeys(ctx context.Context) ([]*azkeys.KeyProperties, error) {
+	var keys []*azkeys.KeyProperties
+	for _, name := range f.names {
+		kid := azkeys.ID("https://kv-test.vault.azure.net/keys/" + name)
+		keys = append(keys, &azkeys.KeyProperties{KID: &kid})
+	}
+	return keys, nil
+}
+
+func TestHSMKeyProblem(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	assert.Empty(t, hsmKeyProblem(ctx, fakeKeyInspector{
+		names: []string{"app", "cmk"},
+		types: map[string]azkeys.KeyType{"app": azkeys.KeyTypeRSA, "cmk": azkeys.KeyTypeRSAHSM},
+	}))
+	assert.Empty(t, hsmKeyProblem(ctx, fakeKeyInspector{names: []string{"sig"}, types: map[string]azkeys.KeyType{"sig": azkeys.KeyTypeECHSM}}))
+	assert.Contains(t, hsmKeyProblem(ctx, fakeKeyInspector{names: []string{"app"}, types: map[string]azkeys.KeyType{"app": azkeys.KeyTypeRSA}}), "holds no HSM-backed key")
+	assert.Contains(t, hsmKeyProblem(ctx, fakeKeyInspector{}), "holds no HSM-backed key")
+	assert.Contains(t, hsmKeyProblem(ctx, fakeKeyInspector{names: []string{"locked"}}), "reading key locked failed")
+}
+
+// fakeKeyMaterial holds the current version of each key by name, listed in
+// name order.
+type fakeKeyMaterial map[string]*azkeys.JSONWebKey
+
+func (f fakeKeyMaterial) GetKey(ctx context.Context, name string, version string, options *azkeys.GetKeyOptions) (azkeys.GetKeyResponse, error) {
+	key, ok := f[name]
+	if !ok {
+		return azkeys.GetKeyResponse{}, &azcore.ResponseError{StatusCode: http.StatusForbidden, ErrorCode: "Forbidden"}
+	}
+	return azkeys.GetKeyResponse{KeyBundle: azkeys.KeyBundle{Key: key}}, nil
+}
+
+func (f fakeKeyMaterial) listKeys(ctx context.Context) ([]*azkeys.KeyProperties, error) {
+	names := make([]string, 0, len(f))
+	for name := range f {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var keys []*azkeys.KeyProperties
+	for _, name := range names {
+		kid := azkeys.ID("https://kv-test.vault.azure.net/keys/" + name)
+		keys = append(keys, &azkeys.KeyProperties{KID: &kid})
+	}
+	return keys, nil
+}
+
+// rsaModulus returns a modulus of exactly bits bits.
+func rsaModulus(bits int) []byte {
+	n := make([]byte, bits/8)
+	n[0] = 0x80
+	return n
+}
+
+func TestWeakKeyProblems(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	keys := fakeKeyMaterial{
+		"app-2048": {Kty: to.Ptr(azkeys.KeyTypeRSA), N: rsaModulus(2048)},
+		"cmk-3072": {Kty: to.Ptr(azkeys.KeyTypeRSAHSM), N: rsaModulus(3072)},
+		"sig-p256": {Kty: to.Ptr(azkeys.KeyTypeEC), Crv: to.Ptr(azkeys.CurveName("P-256"))},
+		"sig-k256": {Kty: to.Ptr(azkeys.KeyTypeECHSM), Crv: to.Ptr(azkeys.CurveName("P-256K"))},
+	}
+
+	problems, err := weakKeyProblems(ctx, keys, 3072, []string{"p-256", "P-384"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"app-2048 is a 2048-bit RSA key, below the minimum of 3072 bits",
+		"sig-k256 is an EC-HSM key on curve P-256K, outside the allowed p-256, P-384",
+	}, problems)
+
+	problems, err = weakKeyProblems(ctx, keys, 2048, nil)
+	require.NoError(t, err)
+	assert.Empty(t, problems, "a 2048-bit key meets a 2048-bit minimum, and no allowed curves allows any")
+
+	_, err = weakKeyProblems(ctx, fakeKeyInspector{names: []string{"locked"}}, 3072, nil)
+	assert.ErrorContains(t, err, "failed to read key locked")
+}
+
+type fakeSecretLister struct {
+	secrets []*azsecrets.SecretProperties
+	err     error
+}
+
+func (f fakeSecretLister) listSecrets(ctx context.Context) ([]*azsecrets.SecretProperties, error) {
+	return f.secrets, f.err
+}
+
+func fakeSecret(name string, contentType *string, managed *bool) *azsecrets.SecretProperties {
+	id := azsecrets.ID("https://kv-test.vault.azure.net/secrets/" + name + "/0123456789abcdef")
+	return &azsecrets.SecretProperties{ID: &id, ContentType: contentType, Managed: managed}
+}
+
+// fakeKeyLister lists keys by name with their enabled attribute.
+type fakeKeyLister map[string]bool
+
+func (f fakeKeyLister) listKeys(ctx context.Context) ([]*azkeys.KeyProperties, error) {
+	var keys []*azkeys.KeyProperties
+	for name, enabled := range f {
+		kid := azkeys.ID("https://kv-test.vault.azure.net/keys/" + name + "/0123456789abcdef")
+		keys = append(keys, &azkeys.KeyProperties{KID: &kid, Attributes: &azkeys.KeyAttributes{Enabled: to.Ptr(enabled)}})
+	}
+	return keys, nil
+}
+
+func TestObjectEnabledProblem(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	disabledSecret := fakeSecret("api-token", nil, nil)
+	disabledSecret.Attributes = &azsecrets.SecretAttributes{Enabled: to.Ptr(false)}
+	certificateSecret := fakeSecret("tls", nil, to.Ptr(true))
+	secrets := fakeSecretLister{secrets: []*azsecrets.SecretProperties{disabledSecret, certificateSecret}}
+	keys := fakeKeyLister{"cmk": true}
+
+	for _, tc := range []struct {
+		name     string
+		expected bool
+		problem  string
+	}{
+		{"api-token", false, ""},
+		{"API-TOKEN", false, ""},
+		{"api-token", true, "secret api-token is disabled, expected enabled"},
+		{"cmk", true, ""},
+		{"cmk", false, "key cmk is enabled, expected disabled"},
+		{"tls", true, "no key or secret named tls"},
+		{"missing", true, "no key or secret named missing"},
+	} {
+		problem, err := objectEnabledProblem(ctx, keys, secrets, tc.name, tc.expected)
+		require.NoError(t, err)
+		assert.Equal(t, tc.problem, problem, "%s expected enabled=%t", tc.name, tc.expected)
+	}
+
+	_, err := objectEnabledProblem(ctx, keys, fakeSecretLister{err: errors.New("forbidden")}, "cmk", true)
+	assert.EqualError(t, err, "forbidden")
+}
+
+func TestNotBeforeProblem(t *testing.T) {
+	t.Parallel()
+
+	expected := time.Date(2030, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	assert.Empty(t, notBeforeProblem(to.Ptr(expected), expected))
+	assert.Empty(t, notBeforeProblem(to.Ptr(expected.In(time.FixedZone("CET", 3600))), expected), "the same instant in another zone matches")
+	assert.Empty(t, notBeforeProblem(to.Ptr(expected), expected.Add(400*time.Millisecond)), "sub-second precision is dropped")
+	assert.Equal(t, "has no not before time, expected 2030-01-01T12:00:00Z", notBeforeProblem(nil, expected))
+	assert.Equal(t, "is not valid before 2030-01-02T12:00:00Z, expected 2030-01-01T12:00:00Z", notBeforeProblem(to.Ptr(expected.AddDate(0, 0, 1)), expected))
+}
+
+func TestFindSecretsWithoutContentType(t *testing.T) {
+	t.Parallel()
+
+	missing, err := findSecretsWithoutContentType(context.Background(), fakeSecretLister{secrets: []*azsecrets.SecretProperties{
+		fakeSecret("typed", to.Ptr("text/plain"), nil),
+		fakeSecret("untyped-b", nil, nil),
+		fakeSecret("blank", to.Ptr("  "), nil),
+		fakeSecret("certificate", nil, to.Ptr(true)),
+		fakeSecret("untyped-a", nil, to.Ptr(false)),
+	}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"blank", "untyped-a", "untyped-b"}, missing)
+
+	_, err = findSecretsWithoutContentType(context.Background(), fakeSecretLister{err: errors.New("forbidden")})
+	assert.EqualError(t, err, "forbidden")
+}
+
+func TestFindExpiringSecrets(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	expiringSecret := func(name string, expires time.Time, managed *bool) *azsecrets.SecretProperties {
+		secret := fakeSecret(name, nil, managed)
+		secret.Attributes = &azsecrets.SecretAttributes{Expires: to.Ptr(expires)}
+		return secret
+	}
+
+	expiring, err := findExpiringSecrets(context.Background(), fakeSecretLister{secrets: []*azsecrets.SecretProperties{
+		expiringSecret("fresh", now.Add(90*24*time.Hour), nil),
+		expiringSecret("soon", now.Add(3*24*time.Hour), nil),
+		expiringSecret("expired", now.Add(-time.Hour), nil),
+		expiringSecret("certificate", now.Add(-time.Hour), to.Ptr(true)),
+		fakeSecret("no-expiry", nil, nil),
+	}}, now, 7*24*time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"expired expired at 2024-05-01T11:00:00Z",
+		"soon expires at 2024-05-04T12:00:00Z",
+	}, expiring)
+
+	_, err = findExpiringSecrets(context.Background(), fakeSecretLister{err: errors.New("forbidden")}, now, 0)
+	assert.EqualError(t, err, "forbidden")
+}
+
+// fakeSecretVersions keeps the versions of each secret in memory.
+type fakeSecretVersions struct {
+	versions map[string][]*azsecrets.SecretProperties
+	err      error
+}
+
+func (f *fakeSecretVersions) setSecret(ctx context.Context, name string, value string) (string, error) {
+	if f.err != nil {
+		return "", f.err
+	}
+	id := azsecrets.ID(fmt.Sprintf("https://kv-test.vault.azure.net/secrets/%s/v%d", name, len(f.versions[name])+1))
+	f.versions[name] = append(f.versions[name], &azsecrets.SecretProperties{ID: &id, Attributes: &azsecrets.SecretAttributes{Enabled: to.Ptr(true)}})
+	return string(id), nil
+}
+
+func (f *fakeSecretVersions) listVersions(ctx context.Context, name string) ([]*azsecrets.SecretProperties, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	versions, ok := f.versions[name]
+	if !ok {
+		return nil, &azcore.ResponseError{StatusCode: http.StatusNotFound, ErrorCode: "SecretNotFound"}
+	}
+	return versions, nil
+}
+
+func TestRotateSecret(t *testing.T) {
+	t.Parallel()
+
+	client := &fakeSecretVersions{versions: map[string][]*azsecrets.SecretProperties{}}
+	count, err := countSecretVersions(context.Background(), client, "api-token")
+	require.NoError(t, err, "a missing secret has no versions")
+	assert.Equal(t, 0, count)
+
+	id, err := rotateSecret(context.Background(), client, "api-token", "first")
+	require.NoError(t, err)
+	assert.Equal(t, "https://kv-test.vault.azure.net/secrets/api-token/v1", id)
+
+	// A disabled current version does not stop the rotation and still counts.
+	client.versions["api-token"][0].Attributes.Enabled = to.Ptr(false)
+	id, err = rotateSecret(context.Background(), client, "api-token", "second")
+	require.NoError(t, err)
+	assert.Equal(t, "https://kv-test.vault.azure.net/secrets/api-token/v2", id)
+
+	count, err = countSecretVersions(context.Background(), client, "api-token")
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+}
+
+func TestRotateSecretErrors(t *testing.T) {
+	t.Parallel()
+
+	client := &fakeSecretVersions{err: &azcore.ResponseError{StatusCode: http.StatusConflict, ErrorCode: "ObjectIsDeletedButRecoverable"}}
+	_, err := rotateSecret(context.Background(), client, "api-token", "value")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "secret api-token is soft-deleted; recover or purge it before rotating")
+
+	client.err = errors.New("forbidden")
+	_, err = rotateSecret(context.Background(), client, "api-token", "value")
+	assert.EqualError(t, err, "forbidden")
+	_, err = countSecretVersions(context.Background(), client, "api-token")
+	assert.EqualError(t, err, "forbidden")
+}
+
+// fakeKeyRestorer fails restores with the queued errors, then succeeds.
+type fakeKeyRestorer struct {
+	errs  []error
+	calls int
+}
+
+func (f *fakeKeyRestorer) RestoreKey(ctx context.Context, parameters azkeys.RestoreKeyParameters, options *azkeys.RestoreKeyOptions) (azkeys.RestoreKeyResponse, error) {
+	f.calls++
+	if len(f.errs) > 0 {
+		err := f.errs[0]
+		f.errs = f.errs[1:]
+		return azkeys.RestoreKeyResponse{}, err
+	}
+	return azkeys.RestoreKeyResponse{}, nil
+}
+
+// fakeSecretSeeder records the secrets written to it and the most writes it
+// saw in flight at once. Writing a secret named in fail returns an error.
+type fakeSecretSeeder struct {
+	mu          sync.Mutex
+	written     map[string]string
+	inFlight    int
+	maxInFlight int
+	fail        map[string]bool
+}
+
+func (f *fakeSecretSeeder) setSecret(ctx context.Context, name string, value string) (string, error) {
+	f.mu.Lock()
+	f.inFlight++
+	f.maxInFlight = max(f.maxInFlight, f.inFlight)
+	f.mu.Unlock()
+
+	// Give the other workers a chance to start writes of their own
+	time.Sleep(time.Millisecond)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.inFlight--
+	if f.fail[name] {
+		return "", errors.New("forbidden")
+	}
+	f.written[name] = value
+	return fmt.Sprintf("https://kv-test.vault.azure.net/secrets/%s/v1", name), nil
+}
+
+func TestSeedSecrets(t *testing.T) {
+	t.Parallel()
+
+	secrets := map[string]string{}
+	for i := 0; i < 20; i++ {
+		secrets[fmt.Sprintf("seed-%02d", i)] = fmt.Sprintf("value-%02d", i)
+	}
+	seeder := &fakeSecretSeeder{written: map[string]string{}}
+
+	require.NoError(t, seedSecrets(context.Background(), seeder, secrets, 5))
+	assert.Equal(t, secrets, seeder.written)
+	assert.LessOrEqual(t, seeder.maxInFlight, 5, "no more than parallelism secrets should be written at once")
+	assert.Greater(t, seeder.maxInFlight, 1, "secrets should be written concurrently")
+}
+
+func TestSeedSecretsAggregatesErrors(t *testing.T) {
+	t.Parallel()
+
+	secrets := map[string]string{"a": "1", "b": "2", "c": "3"}
+	seeder := &fakeSecretSeeder{written: map[string]string{}, fail: map[string]bool{"a": true, "c": true}}
+
+	err := seedSecrets(context.Background(), seeder, secrets, 0)
+	require.Error(t, err)
+	assert.Equal(t, "2 of 3 secret(s) failed:\n  a: forbidden\n  c: forbidden", err.Error())
+	assert.Equal(t, map[string]string{"b": "2"}, seeder.written, "every secret should be attempted")
+}
+
+// fakeDataPlane holds keys and secrets by name with their recovery level.
+// Deleted objects are soft-deleted, and purging one fails purgeFailures
+// times first, as while its deletion completes.
+type fakeDataPlane struct {
+	keys          map[string]string
+	secrets       map[string]string
+	deleted       []string
+	purged        []string
+	purgeFailures int
+}
+
+func (f *fakeDataPlane) listKeys(ctx context.Context) ([]*azkeys.KeyProperties, error) {
+	var keys []*azkeys.KeyProperties
+	for name, level := range f.keys {
+		kid := azkeys.ID("https://kv-test.vault.azure.net/keys/" + name + "/0123456789abcdef")
+		keys = append(keys, &azkeys.KeyProperties{KID: &kid, Attributes: &azkeys.KeyAttributes{RecoveryLevel: to.Ptr(level)}})
+	}
+	return keys, nil
+}
+
+func (f *fakeDataPlane) listSecrets(ctx context.Context) ([]*azsecrets.SecretProperties, error) {
+	var secrets []*azsecrets.SecretProperties
+	for name, level := range f.secrets {
+		secret := fakeSecret(name, nil, nil)
+		secret.Attributes = &azsecrets.SecretAttributes{RecoveryLevel: to.Ptr(level)}
+		secrets = append(secrets, secret)
+	}
+	return secrets, nil
+}
+
+func (f *fakeDataPlane) deleteKey(ctx context.Context, name string) error {
+	delete(f.keys, name)
+	f.deleted = append(f.deleted, "key "+name)
+	return nil
+}
+
+func (f *fakeDataPlane) purgeKey(ctx context.Context, name string) error {
+	return f.purge("key " + name)
+}
+
+func (f *fakeDataPlane) deleteSecret(ctx context.Context, name string) error {
+	if name == "locked" {
+		return errors.New("forbidden")
+	}
+	delete(f.secrets, name)
+	f.deleted = append(f.deleted, "secret "+name)
+	return nil
+}
+
+func (f *fakeDataPlane) purgeSecret(ctx context.Context, name string) error {
+	return f.purge("secret " + name)
+}
+
+func (f *fakeDataPlane) purge(object string) error {
+	if f.purgeFailures > 0 {
+		f.purgeFailures--
+		return errors.New("ObjectIsBeingDeleted")
+	}
+	f.purged = append(f.purged, object)
+	return nil
+}
+
+func TestCleanupDataPlaneObjects(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	t.Run("removes only the named objects", func(t *testing.T) {
+		vault := &fakeDataPlane{
+			keys:          map[string]string{"shared-cmk": "Recoverable+Purgeable"},
+			secrets:       map[string]string{"seeded-a": "Recoverable+Purgeable", "seeded-b": "Recoverable+Purgeable", "shared-db-password": "Recoverable+Purgeable"},
+			purgeFailures: 1,
+		}
+		waits := 0
+
+		require.NoError(t, cleanupDataPlaneObjects(ctx, t, vault, []string{"seeded-a", "seeded-b"}, 3, func() { waits++ }))
+
+		assert.ElementsMatch(t, []string{"secret seeded-a", "secret seeded-b"}, vault.deleted)
+		assert.ElementsMatch(t, []string{"secret seeded-a", "secret seeded-b"}, vault.purged)
+		assert.Equal(t, 1, waits, "a purge should be retried while the deletion completes")
+		assert.Equal(t, map[string]string{"shared-db-password": "Recoverable+Purgeable"}, vault.secrets, "the unrelated secret should be left intact")
+		assert.Contains(t, vault.keys, "shared-cmk")
+	})
+
+	t.Run("leaves purge-protected objects soft-deleted", func(t *testing.T) {
+		vault := &fakeDataPlane{keys: map[string]string{"test-cmk": "Recoverable"}, secrets: map[string]string{}}
+
+		require.NoError(t, cleanupDataPlaneObjects(ctx, t, vault, []string{"TEST-CMK", "already-gone"}, 3, func() {}))
+
+		assert.Equal(t, []string{"key test-cmk"}, vault.deleted)
+		assert.Empty(t, vault.purged)
+	})
+
+	t.Run("attempts every object before failing", func(t *testing.T) {
+		vault := &fakeDataPlane{keys: map[string]string{}, secrets: map[string]string{"locked": "Recoverable+Purgeable", "seeded": "Recoverable+Purgeable"}}
+
+		err := cleanupDataPlaneObjects(ctx, t, vault, []string{"locked", "seeded"}, 3, func() {})
+
+		require.Error(t, err)
+		assert.Equal(t, "1 object(s) could not be cleaned up:\n  secret locked: failed to delete: forbidden", err.Error())
+		assert.Equal(t, []string{"secret seeded"}, vault.deleted)
+	})
+}
+
+func TestRestoreKeyBackup(t *testing.T) {
+	t.Parallel()
+
+	conflict := &azcore.ResponseError{StatusCode: http.StatusConflict, ErrorCode: "Conflict"}
+
+	t.Run("retries while the name is held", func(t *testing.T) {
+		client := &fakeKeyRestorer{errs: []error{conflict, conflict}}
+		waits := 0
+		require.NoError(t, restoreKeyBackup(context.Background(), client, []byte("backup"), 5, func() { waits++ }))
+		assert.Equal(t, 3, client.calls)
+		assert.Equal(t, 2, waits)
+	})
+
+	t.Run("gives up after the last attempt", func(t *testing.T) {
+		client := &fakeKeyRestorer{errs: []error{conflict, conflict, conflict}}
+		err := restoreKeyBackup(context.Background(), client, []byte("backup"), 3, func() {})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "must be purged")
+		assert.Equal(t, 3, client.calls)
+	})
+
+	t.Run("does not retry other errors", func(t *testing.T) {
+		forbidden := &azcore.ResponseError{StatusCode: http.StatusForbidden, ErrorCode: "Forbidden"}
+		client := &fakeKeyRestorer{errs: []error{forbidden}}
+		err := restoreKeyBackup(context.Background(), client, []byte("backup"), 5, func() {})
+		assert.ErrorIs(t, err, forbidden)
+		assert.Equal(t, 1, client.calls)
+	})
+}
+
+// fakeKeyRecoverer holds live and deleted keys by name. Deleted keys are
+// missing from the first listsBeforeDeleted listings, like a deletion still
+// in progress, and recoverAs replaces the key material on recovery.
+type fakeKeyRecoverer struct {
+	keys               map[string]azkeys.KeyBundle
+	deleted            map[string]azkeys.KeyBundle
+	purgeDate          time.Time
+	listsBeforeDeleted int
+	recoverAs          *azkeys.JSONWebKey
+	deletes            int
+}
+
+func (f *fakeKeyRecoverer) GetKey(ctx context.Context, name string, version string, options *azkeys.GetKeyOptions) (azkeys.GetKeyResponse, error) {
+	key, ok := f.keys[name]
+	if !ok {
+		return azkeys.GetKeyResponse{}, &azcore.ResponseError{StatusCode: http.StatusNotFound, ErrorCode: "KeyNotFound"}
+	}
+	return azkeys.GetKeyResponse{KeyBundle: key}, nil
+}
+
+func (f *fakeKeyRecoverer) DeleteKey(ctx context.Context, name string, options *azkeys.DeleteKeyOptions) (azkeys.DeleteKeyResponse, error) {
+	f.deletes++
+	f.deleted[name] = f.keys[name]
+	delete(f.keys, name)
+	return azkeys.DeleteKeyResponse{}, nil
+}
+
+func (f *fakeKeyRecoverer) RecoverDeletedKey(ctx context.Context, name string, options *azkeys.RecoverDeletedKeyOptions) (azkeys.RecoverDeletedKeyResponse, error) {
+	key, ok := f.deleted[name]
+	if !ok {
+		return azkeys.RecoverDeletedKeyResponse{}, &azcore.ResponseError{StatusCode: http.StatusNotFound, ErrorCode: "KeyNotFound"}
+	}
+	if f.recoverAs != nil {
+		key.Key = f.recoverAs
+	}
+	delete(f.deleted, name)
+	f.keys[name] = key
+	return azkeys.RecoverDeletedKeyResponse{KeyBundle: key}, nil
+}
+
+func (f *fakeKeyRecoverer) listDeletedKeys(ctx context.Context) ([]*azkeys.DeletedKeyProperties, error) {
+	if f.listsBeforeDeleted > 0 {
+		f.listsBeforeDeleted--
+		return nil, nil
+	}
+	var keys []*azkeys.DeletedKeyProperties
+	for _, key := range f.deleted {
+		keys = append(keys, &azkeys.DeletedKeyProperties{KID: key.Key.KID, ScheduledPurgeDate: to.Ptr(f.purgeDate)})
+	}
+	return keys, nil
+}
+
+func recoverableKey(recoveryLevel string) azkeys.KeyBundle {
+	kid := azkeys.ID("https://kv-test.vault.azure.net/keys/dr/v1")
+	return azkeys.KeyBundle{
+		Attributes: &azkeys.KeyAttributes{RecoveryLevel: to.Ptr(recoveryLevel)},
+		Key:        &azkeys.JSONWebKey{KID: &kid, N: []byte("modulus"), E: []byte("AQAB")},
+	}
+}
+
+func TestRecoverKey(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	newClient := func(recoveryLevel string) *fakeKeyRecoverer {
+		return &fakeKeyRecoverer{
+			keys:      map[string]azkeys.KeyBundle{"dr": recoverableKey(recoveryLevel)},
+			deleted:   map[string]azkeys.KeyBundle{},
+			purgeDate: now.Add(7 * 24 * time.Hour),
+		}
+	}
+	clock := func() time.Time { return now }
+
+	t.Run("recovers the same key", func(t *testing.T) {
+		client := newClient("Recoverable")
+		client.listsBeforeDeleted = 2
+		waits := 0
+		require.NoError(t, recoverKey(context.Background(), client, "dr", 5, clock, func() { waits++ }))
+		assert.Equal(t, 2, waits)
+		assert.Contains(t, client.keys, "dr")
+		assert.Empty(t, client.deleted)
+	})
+
+	t.Run("leaves purgeable keys alone", func(t *testing.T) {
+		client := newClient("Purgeable")
+		err := recoverKey(context.Background(), client, "dr", 5, clock, func() {})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "would destroy it")
+		assert.Zero(t, client.deletes)
+	})
+
+	t.Run("gives up when the key is never listed", func(t *testing.T) {
+		client := newClient("Recoverable+Purgeable")
+		client.listsBeforeDeleted = 10
+		err := recoverKey(context.Background(), client, "dr", 3, clock, func() {})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "not in the deleted keys list")
+	})
+
+	t.Run("purge date already passed", func(t *testing.T) {
+		client := newClient("Recoverable")
+		client.purgeDate = now.Add(-time.Minute)
+		err := recoverKey(context.Background(), client, "dr", 3, clock, func() {})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "outside the retention window")
+	})
+
+	t.Run("recovered material differs", func(t *testing.T) {
+		client := newClient("CustomizedRecoverable")
+		other := recoverableKey("CustomizedRecoverable").Key
+		other.N = []byte("another modulus")
+		client.recoverAs = other
+		err := recoverKey(context.Background(), client, "dr", 3, clock, func() {})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "differs")
+	})
+}
+
+// fakeSecretRecoverer holds live and deleted secrets by name, like
+// fakeKeyRecoverer.
+type fakeSecretRecoverer struct {
+	secrets            map[string]azsecrets.Secret
+	deleted            map[string]azsecrets.Secret
+	purgeDate          time.Time
+	listsBeforeDeleted int
+	recoverAs          *string
+	deletes            int
+}
+
+func (f *fakeSecretRecoverer) GetSecret(ctx context.Context, name string, version string, options *azsecrets.GetSecretOptions) (azsecrets.GetSecretResponse, error) {
+	secret, ok := f.secrets[name]
+	if !ok {
+		return azsecrets.GetSecretResponse{}, &azcore.ResponseError{StatusCode: http.StatusNotFound, ErrorCode: "SecretNotFound"}
+	}
+	return azsecrets.GetSecretResponse{Secret: secret}, nil
+}
+
+func (f *fakeSecretRecoverer) DeleteSecret(ctx context.Context, name string, options *azsecrets.DeleteSecretOptions) (azsecrets.DeleteSecretResponse, error) {
+	f.deletes++
+	f.deleted[name] = f.secrets[name]
+	delete(f.secrets, name)
+	return azsecrets.DeleteSecretResponse{}, nil
+}
+
+func (f *fakeSecretRecoverer) RecoverDeletedSecret(ctx context.Context, name string, options *azsecrets.RecoverDeletedSecretOptions) (azsecrets.RecoverDeletedSecretResponse, error) {
+	secret, ok := f.deleted[name]
+	if !ok {
+		return azsecrets.RecoverDeletedSecretResponse{}, &azcore.ResponseError{StatusCode: http.StatusNotFound, ErrorCode: "SecretNotFound"}
+	}
+	if f.recoverAs != nil {
+		secret.Value = f.recoverAs
+	}
+	delete(f.deleted, name)
+	f.secrets[name] = secret
+	return azsecrets.RecoverDeletedSecretResponse{}, nil
+}
+
+func (f *fakeSecretRecoverer) listDeletedSecrets(ctx context.Context) ([]*azsecrets.DeletedSecretProperties, error) {
+	if f.listsBeforeDeleted > 0 {
+		f.listsBeforeDeleted--
+		return nil, nil
+	}
+	var secrets []*azsecrets.DeletedSecretProperties
+	for _, secret := range f.deleted {
+		secrets = append(secrets, &azsecrets.DeletedSecretProperties{ID: secret.ID, ScheduledPurgeDate: to.Ptr(f.purgeDate)})
+	}
+	return secrets, nil
+}
+
+func TestRecoverSecret(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	newClient := func(recoveryLevel string) *fakeSecretRecoverer {
+		id := azsecrets.ID("https://kv-test.vault.azure.net/secrets/db-password/v1")
+		return &fakeSecretRecoverer{
+			secrets: map[string]azsecrets.Secret{"db-password": {
+				Attributes: &azsecrets.SecretAttributes{RecoveryLevel: to.Ptr(recoveryLevel)},
+				ID:         &id,
+				Value:      to.Ptr("s3cret"),
+			}},
+			deleted:   map[string]azsecrets.Secret{},
+			purgeDate: now.Add(7 * 24 * time.Hour),
+		}
+	}
+	clock := func() time.Time { return now }
+
+	t.Run("recovers the same secret", func(t *testing.T) {
+		client := newClient("Recoverable+Purgeable")
+		client.listsBeforeDeleted = 2
+		waits := 0
+		require.NoError(t, recoverSecret(context.Background(), client, "db-password", 5, clock, func() { waits++ }))
+		assert.Equal(t, 2, waits)
+		assert.Contains(t, client.secrets, "db-password")
+		assert.Empty(t, client.deleted)
+	})
+
+	t.Run("recovers in a purge-protected vault", func(t *testing.T) {
+		client := newClient("Recoverable")
+		require.NoError(t, recoverSecret(context.Background(), client, "db-password", 3, clock, func() {}))
+		assert.Equal(t, 1, client.deletes)
+	})
+
+	t.Run("leaves purgeable secrets alone", func(t *testing.T) {
+		client := newClient("Purgeable")
+		err := recoverSecret(context.Background(), client, "db-password", 5, clock, func() {})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "would destroy it")
+		assert.Zero(t, client.deletes)
+	})
+
+	t.Run("gives up when the secret is never listed", func(t *testing.T) {
+		client := newClient("Recoverable")
+		client.listsBeforeDeleted = 10
+		err := recoverSecret(context.Background(), client, "db-password", 3, clock, func() {})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "not in the deleted secrets list")
+	})
+
+	t.Run("purge date already passed", func(t *testing.T) {
+		client := newClient("Recoverable")
+		client.purgeDate = now.Add(-time.Minute)
+		err := recoverSecret(context.Background(), client, "db-password", 3, clock, func() {})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "outside the retention window")
+	})
+
+	t.Run("recovered value differs", func(t *testing.T) {
+		client := newClient("CustomizedRecoverable")
+		client.recoverAs = to.Ptr("something else")
+		err := recoverSecret(context.Background(), client, "db-password", 3, clock, func() {})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "differs")
+	})
+}
+
+// fakeDeletedVaults holds soft-deleted vaults by name, with their purge
+// protection setting, and records purges.
+type fakeDeletedVaults struct {
+	deleted map[string]bool
+	purged  []string
+}
+
+func (f *fakeDeletedVaults) getDeleted(ctx context.Context, name string, location string) (*armkeyvault.DeletedVault, error) {
+	protected, ok := f.deleted[name]
+	if !ok {
+		return nil, nil
+	}
+	return &armkeyvault.DeletedVault{
+		Name:       to.Ptr(name),
+		Properties: &armkeyvault.DeletedVaultProperties{PurgeProtectionEnabled: to.Ptr(protected)},
+	}, nil
+}
+
+func (f *fakeDeletedVaults) purge(ctx context.Context, name string, location string) error {
+	delete(f.deleted, name)
+	f.purged = append(f.purged, name)
+	return nil
+}
+
+func sequentialIDs(ids ...string) func() string {
+	return func() string {
+		id := ids[0]
+		ids = ids[1:]
+		return id
+	}
+}
+
+func TestClearSoftDeletedVaultName(t *testing.T) {
+	t.Parallel()
+
+	config := TestConfig{Region: "westeurope", UniqueID: "abc123"}
+
+	t.Run("no collision", func(t *testing.T) {
+		vaults := &fakeDeletedVaults{deleted: map[string]bool{}}
+		name, err := clearSoftDeletedVaultName(context.Background(), vaults, config, "kv-keys-abc123", sequentialIDs())
+		require.NoError(t, err)
+		assert.Equal(t, "kv-keys-abc123", name)
+		assert.Empty(t, vaults.purged)
+	})
+
+	t.Run("unprotected collision is purged", func(t *testing.T) {
+		vaults := &fakeDeletedVaults{deleted: map[string]bool{"kv-keys-abc123": false}}
+		name, err := clearSoftDeletedVaultName(context.Background(), vaults, config, "kv-keys-abc123", sequentialIDs())
+		require.NoError(t, err)
+		assert.Equal(t, "kv-keys-abc123", name)
+		assert.Equal(t, []string{"kv-keys-abc123"}, vaults.purged)
+	})
+
+	t.Run("protected collision gets a fresh UniqueID", func(t *testing.T) {
+		vaults := &fakeDeletedVaults{deleted: map[string]bool{"kv-keys-abc123": true, "kv-keys-def456": true}}
+		name, err := clearSoftDeletedVaultName(context.Background(), vaults, config, "kv-keys-abc123", sequentialIDs("def456", "ghi789"))
+		require.NoError(t, err)
+		assert.Equal(t, "kv-keys-ghi789", name)
+		assert.Empty(t, vaults.purged)
+	})
+
+	t.Run("protected collision without UniqueID", func(t *testing.T) {
+		vaults := &fakeDeletedVaults{deleted: map[string]bool{"kv-static": true}}
+		_, err := clearSoftDeletedVaultName(context.Background(), vaults, config, "kv-static", sequentialIDs())
+		assert.ErrorContains(t, err, "purge protected and its name has no UniqueID")
+	})
+}
+
+// fakeVaultProbe fails each plane with the queued errors, then succeeds.
+type fakeVaultProbe struct {
+	vaultErrs []error
+	keysErrs  []error
+	calls     int
+}
+
+func (f *fakeVaultProbe) getVault(ctx context.Context) error {
+	f.calls++
+	return popError(&f.vaultErrs)
+}
+
+func (f *fakeVaultProbe) listKeys(ctx context.Context) error {
+	return popError(&f.keysErrs)
+}
+
+func popError(errs *[]error) error {
+	if len(*errs) == 0 {
+		return nil
+	}
+	err := (*errs)[0]
+	*errs = (*errs)[1:]
+	return err
+}
+
+func TestWaitForVaultReadyEventually(t *testing.T) {
+	t.Parallel()
+
+	notFound := &azcore.ResponseError{StatusCode: http.StatusNotFound, ErrorCode: "ResourceNotFound"}
+	probe := &fakeVaultProbe{
+		vaultErrs: []error{notFound},
+		keysErrs:  []error{errors.New("dial tcp: lookup kv-test.vault.azure.net: no such host"), &azcore.ResponseError{StatusCode: http.StatusForbidden}},
+	}
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+
+	require.NoError(t, waitForVaultReady(context.Background(), probe, time.Minute, clock.Now, clock.Sleep))
+	assert.Equal(t, 4, probe.calls)
+	assert.Equal(t, []time.Duration{2 * time.Second, 4 * time.Second, 8 * time.Second}, clock.sleeps)
+}
+
+func TestWaitForVaultReadyTimesOut(t *testing.T) {
+	t.Parallel()
+
+	lookupErr := errors.New("no such host")
+	probe := &fakeVaultProbe{keysErrs: []error{lookupErr, lookupErr, lookupErr, lookupErr, lookupErr}}
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+
+	err := waitForVaultReady(context.Background(), probe, 10*time.Second, clock.Now, clock.Sleep)
+	require.Error(t, err)
+	assert.ErrorIs(t, err, lookupErr)
+	assert.Contains(t, err.Error(), "data plane: no such host")
+}
+
+func TestVaultCreateMode(t *testing.T) {
+	t.Parallel()
+
+	recoverMode := armkeyvault.CreateModeRecover
+	empty := armkeyvault.CreateMode("")
+	upper := armkeyvault.CreateMode("Default")
+
+	assert.Equal(t, "default", vaultCreateMode(&armkeyvault.Vault{}), "a vault without properties")
+	assert.Equal(t, "default", vaultCreateMode(&armkeyvault.Vault{Properties: &armkeyvault.VaultProperties{}}), "ARM usually leaves createMode out")
+	assert.Equal(t, "default", vaultCreateMode(&armkeyvault.Vault{Properties: &armkeyvault.VaultProperties{CreateMode: &empty}}))
+	assert.Equal(t, "default", vaultCreateMode(&armkeyvault.Vault{Properties: &armkeyvault.VaultProperties{CreateMode: &upper}}))
+	assert.Equal(t, "recover", vaultCreateMode(&armkeyvault.Vault{Properties: &armkeyvault.VaultProperties{CreateMode: &recoverMode}}))
+}
@@ -0,0 +1,278 @@
+package test
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/keyvault/armkeyvault"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// VaultView is a flat copy of the settings tests assert on, read from the
+// ARM vault so assertions need no pointer checks. Properties Azure left unset
+// take the value Azure applies in their absence: soft delete and public
+// network access are on, and a vault without network ACLs allows all traffic.
+type VaultView struct {
+	ID                           string
+	Name                         string
+	Location                     string
+	TenantID                     string
+	VaultURI                     string
+	SKU                          string
+	ProvisioningState            string
+	PurgeProtectionEnabled       bool
+	SoftDeleteEnabled            bool
+	SoftDeleteRetentionDays      int
+	RBACAuthorizationEnabled     bool
+	EnabledForDeployment         bool
+	EnabledForDiskEncryption     bool
+	EnabledForTemplateDeployment bool
+	PublicNetworkAccessEnabled   bool
+	NetworkDefaultAction         string
+	NetworkBypass                string
+	IPRules                      []string
+	SubnetIDs                    []string
+	AccessPolicyCount            int
+	PrivateEndpointCount         int
+	Tags                         map[string]string
+}
+
+// GetVaultView reads vaultName in resourceGroupName from ARM and returns it
+// as a VaultView.
+func GetVaultView(t *testing.T, config TestConfig, resourceGroupName string, vaultName string) VaultView {
+	t.Helper()
+
+	client, err := armkeyvault.NewVaultsClient(config.SubscriptionID, azureCredential(t), armClientOptions())
+	require.NoError(t, err)
+	resp, err := client.Get(context.Background(), resourceGroupName, vaultName, nil)
+	require.NoError(t, err, "failed to get Key Vault %s", vaultName)
+	return vaultView(&resp.Vault)
+}
+
+func vaultView(kv *armkeyvault.Vault) VaultView {
+	props := kv.Properties
+	if props == nil {
+		props = &armkeyvault.VaultProperties{}
+	}
+
+	view := VaultView{
+		ID:                           stringValue(kv.ID),
+		Name:                         stringValue(kv.Name),
+		Location:                     normalizeLocation(stringValue(kv.Location)),
+		TenantID:                     stringValue(props.TenantID),
+		VaultURI:                     stringValue(props.VaultURI),
+		PurgeProtectionEnabled:       isTrue(props.EnablePurgeProtection),
+		SoftDeleteEnabled:            props.EnableSoftDelete == nil || *props.EnableSoftDelete,
+		RBACAuthorizationEnabled:     isTrue(props.EnableRbacAuthorization),
+		EnabledForDeployment:         isTrue(props.EnabledForDeployment),
+		EnabledForDiskEncryption:     isTrue(props.EnabledForDiskEncryption),
+		EnabledForTemplateDeployment: isTrue(props.EnabledForTemplateDeployment),
+		PublicNetworkAccessEnabled:   props.PublicNetworkAccess == nil || !strings.EqualFold(*props.PublicNetworkAccess, "Disabled"),
+		NetworkDefaultAction:         string(armkeyvault.NetworkRuleActionAllow),
+		NetworkBypass:                string(armkeyvault.NetworkRuleBypassOptionsAzureServices),
+		IPRules:                      []string{},
+		SubnetIDs:                    []string{},
+		AccessPolicyCount:            len(props.AccessPolicies),
+		PrivateEndpointCount:         len(props.PrivateEndpointConnections),
+		Tags:                         map[string]string{},
+	}
+	if props.SKU != nil && props.SKU.Name != nil {
+		view.SKU = strings.ToLower(string(*props.SKU.Name))
+	}
+	if props.ProvisioningState != nil {
+		view.ProvisioningState = string(*props.ProvisioningState)
+	}
+	if props.SoftDeleteRetentionInDays != nil {
+		view.SoftDeleteRetentionDays = int(*props.SoftDeleteRetentionInDays)
+	}
+
+	if acls := props.NetworkACLs; acls != nil {
+		if acls.DefaultAction != nil {
+			view.NetworkDefaultAction = string(*acls.DefaultAction)
+		}
+		if acls.Bypass != nil {
+			view.NetworkBypass = string(*acls.Bypass)
+		}
+		for _, rule := range acls.IPRules {
+			if rule != nil && rule.Value != nil {
+				view.IPRules = append(view.IPRules, *rule.Value)
+			}
+		}
+		for _, rule := range acls.VirtualNetworkRules {
+			if rule != nil && rule.ID != nil {
+				view.SubnetIDs = append(view.SubnetIDs, *rule.ID)
+			}
+		}
+		sort.Strings(view.IPRules)
+		sort.Strings(view.SubnetIDs)
+	}
+
+	for key, value := range kv.Tags {
+		view.Tags[key] = stringValue(value)
+	}
+	return view
+}
+
+// AssertVaultLocation checks kv is deployed in the region expected and
+// returns whether it is. Both sides are normalized first, so a display name
+// such as "East US" matches the "eastus" ARM reports.
+func AssertVaultLocation(t *testing.T, kv *armkeyvault.Vault, expected string) bool {
+	t.Helper()
+
+	return assert.Equal(t, normalizeLocation(expected), normalizeLocation(stringValue(kv.Location)),
+		"Key Vault %s is in the wrong region", stringValue(kv.Name))
+}
+
+// AssertVaultSku checks kv has the SKU expected, standard or premium,
+// compared case-insensitively, and returns whether it does. For premium it
+// also reads an HSM-backed key of the vault, proving HSM operations are
+// available, so the vault must hold one, such as an RSA-HSM key from the
+// module's keys.
+func AssertVaultSku(t *testing.T, kv *armkeyvault.Vault, expected string) bool {
+	t.Helper()
+
+	if problem := vaultSkuProblem(kv, expected); problem != "" {
+		t.Error(problem)
+		return false
+	}
+	if !strings.EqualFold(expected, string(armkeyvault.SKUNamePremium)) {
+		return true
+	}
+	name := stringValue(kv.Name)
+	if problem := hsmKeyProblem(context.Background(), azureKeyInspector{keysClient(t, name)}); problem != "" {
+		t.Errorf("Key Vault %s reports the premium SKU, but %s", name, problem)
+		return false
+	}
+	return true
+}
+
+// vaultSkuProblem describes how the SKU of kv differs from expected, or
+// returns "" when it matches. Key Vault has a single SKU family, A.
+func vaultSkuProblem(kv *armkeyvault.Vault, expected string) string {
+	var family, name string
+	if kv.Properties != nil && kv.Properties.SKU != nil {
+		if kv.Properties.SKU.Family != nil {
+			family = string(*kv.Properties.SKU.Family)
+		}
+		if kv.Properties.SKU.Name != nil {
+			name = string(*kv.Properties.SKU.Name)
+		}
+	}
+	if !strings.EqualFold(name, expected) {
+		return fmt.Sprintf("Key Vault %s has SKU %q, expected %q", stringValue(kv.Name), name, expected)
+	}
+	if family != string(armkeyvault.SKUFamilyA) {
+		return fmt.Sprintf("Key Vault %s has SKU family %q, expected %q", stringValue(kv.Name), family, armkeyvault.SKUFamilyA)
+	}
+	return ""
+}
+
+// AssertVaultSubscription checks the vault with resource ID vaultID lives in
+// subscription expectedSubID, compared case-insensitively, and returns
+// whether it does. config names the tenant in the failure, as the expected
+// subscription is usually config.SubscriptionID.
+func AssertVaultSubscription(t assert.TestingT, config TestConfig, vaultID string, expectedSubID string) bool {
+	if h, ok := t.(interface{ Helper() }); ok {
+		h.Helper()
+	}
+
+	if problem := vaultSubscriptionProblem(vaultID, expectedSubID); problem != "" {
+		return assert.Fail(t, fmt.Sprintf("tenant %s: %s", config.Name, problem))
+	}
+	return true
+}
+
+// vaultSubscriptionProblem describes how vaultID is not a resource ID in
+// subscription expectedSubID, or returns "" when it is one.
+func vaultSubscriptionProblem(vaultID string, expectedSubID string) string {
+	id, err := arm.ParseResourceID(vaultID)
+	if err != nil {
+		return fmt.Sprintf("invalid Key Vault ID %q: %v", vaultID, err)
+	}
+	if !strings.EqualFold(id.SubscriptionID, expectedSubID) {
+		return fmt.Sprintf("Key Vault %s is in subscription %s, expected %s", id.Name, id.SubscriptionID, expectedSubID)
+	}
+	return ""
+}
+
+// normalizeLocation turns an Azure region display name into its short form,
+// lowercased without spaces, as ARM returns it.
+func normalizeLocation(location string) string {
+	return strings.ToLower(strings.ReplaceAll(location, " ", ""))
+}
+
+func stringValue(v *string) string {
+	if v == nil {
+		return ""
+	}
+	return *v
+}
+
+// AssertPrivateEndpointApproved reads vaultName in config's resource group
+// from ARM and asserts at least one of its private endpoint connections is
+// Approved. An endpoint whose connection is left Pending or Rejected is
+// created without error but carries no traffic. Endpoints in the vault's own
+// subscription are approved automatically.
+func AssertPrivateEndpointApproved(t *testing.T, config TestConfig, vaultName string) bool {
+	t.Helper()
+
+	client, err := armkeyvault.NewVaultsClient(config.SubscriptionID, azureCredential(t), armClientOptions())
+	require.NoError(t, err)
+	resp, err := client.Get(context.Background(), config.ResourceGroupName(), vaultName, nil)
+	require.NoError(t, err, "failed to get Key Vault %s", vaultName)
+
+	if problem := privateEndpointApprovalProblem(&resp.Vault); problem != "" {
+		t.Error(problem)
+		return false
+	}
+	return true
+}
+
+// privateEndpointApprovalProblem describes why kv has no Approved private
+// endpoint connection, listing the state of every connection it has, or
+// returns "" when one is approved.
+func privateEndpointApprovalProblem(kv *armkeyvault.Vault) string {
+	var connections []*armkeyvault.PrivateEndpointConnectionItem
+	if kv.Properties != nil {
+		connections = kv.Properties.PrivateEndpointConnections
+	}
+	if len(connections) == 0 {
+		return fmt.Sprintf("Key Vault %s has no private endpoint connections", stringValue(kv.Name))
+	}
+
+	var states []string
+	for _, connection := range connections {
+		status := "unknown"
+		var endpointID, description string
+		if props := connection.Properties; props != nil {
+			if props.PrivateEndpoint != nil {
+				endpointID = stringValue(props.PrivateEndpoint.ID)
+			}
+			if state := props.PrivateLinkServiceConnectionState; state != nil {
+				if state.Status != nil {
+					status = string(*state.Status)
+				}
+				description = stringValue(state.Description)
+			}
+		}
+		if strings.EqualFold(status, string(armkeyvault.PrivateEndpointServiceConnectionStatusApproved)) {
+			return ""
+		}
+
+		name := endpointID[strings.LastIndex(endpointID, "/")+1:]
+		if name == "" {
+			name = stringValue(connection.ID)
+		}
+		state := fmt.Sprintf("%s is %s", name, status)
+		if description != "" {
+			state += fmt.Sprintf(" (%s)", description)
+		}
+		states = append(states, state)
+	}
+	return fmt.Sprintf("Key Vault %s has no Approved private endpoint connection: %s", stringValue(kv.Name), strings.Join(states, "; "))
+}
@@ -0,0 +1,182 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/keyvault/armkeyvault"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVaultView(t *testing.T) {
+	t.Parallel()
+
+	kv := &armkeyvault.Vault{
+		ID:       to.Ptr("/subscriptions/x/resourceGroups/rg-kv-test/providers/Microsoft.KeyVault/vaults/kv-test"),
+		Name:     to.Ptr("kv-test"),
+		Location: to.Ptr("West Europe"),
+		Tags:     map[string]*string{"Environment": to.Ptr("test"), "Empty": nil},
+		Properties: &armkeyvault.VaultProperties{
+			SKU:                       &armkeyvault.SKU{Name: to.Ptr(armkeyvault.SKUName("Premium"))},
+			TenantID:                  to.Ptr("00000000-0000-0000-0000-000000000001"),
+			VaultURI:                  to.Ptr("https://kv-test.vault.azure.net/"),
+			EnablePurgeProtection:     to.Ptr(true),
+			EnableSoftDelete:          to.Ptr(false),
+			SoftDeleteRetentionInDays: to.Ptr(int32(30)),
+			EnableRbacAuthorization:   to.Ptr(true),
+			EnabledForDiskEncryption:  to.Ptr(true),
+			PublicNetworkAccess:       to.Ptr("Disabled"),
+			ProvisioningState:         to.Ptr(armkeyvault.VaultProvisioningState("Succeeded")),
+			NetworkACLs: &armkeyvault.NetworkRuleSet{
+				DefaultAction: to.Ptr(armkeyvault.NetworkRuleActionDeny),
+				Bypass:        to.Ptr(armkeyvault.NetworkRuleBypassOptionsNone),
+				IPRules:       []*armkeyvault.IPRule{{Value: to.Ptr("203.0.113.7/32")}, nil, {Value: to.Ptr("198.51.100.0/24")}},
+				VirtualNetworkRules: []*armkeyvault.VirtualNetworkRule{
+					{ID: to.Ptr("/subscriptions/x/resourceGroups/rg-net/providers/Microsoft.Network/virtualNetworks/vnet/subnets/apps")},
+				},
+			},
+			AccessPolicies:             []*armkeyvault.AccessPolicyEntry{{}, {}},
+			PrivateEndpointConnections: []*armkeyvault.PrivateEndpointConnectionItem{{}},
+		},
+	}
+
+	assert.Equal(t, VaultView{
+		ID:                           "/subscriptions/x/resourceGroups/rg-kv-test/providers/Microsoft.KeyVault/vaults/kv-test",
+		Name:                         "kv-test",
+		Location:                     "westeurope",
+		TenantID:                     "00000000-0000-0000-0000-000000000001",
+		VaultURI:                     "https://kv-test.vault.azure.net/",
+		SKU:                          "premium",
+		ProvisioningState:            "Succeeded",
+		PurgeProtectionEnabled:       true,
+		SoftDeleteEnabled:            false,
+		SoftDeleteRetentionDays:      30,
+		RBACAuthorizationEnabled:     true,
+		EnabledForDiskEncryption:     true,
+		PublicNetworkAccessEnabled:   false,
+		NetworkDefaultAction:         "Deny",
+		NetworkBypass:                "None",
+		IPRules:                      []string{"198.51.100.0/24", "203.0.113.7/32"},
+		SubnetIDs:                    []string{"/subscriptions/x/resourceGroups/rg-net/providers/Microsoft.Network/virtualNetworks/vnet/subnets/apps"},
+		AccessPolicyCount:            2,
+		PrivateEndpointCount:         1,
+		Tags:                         map[string]string{"Environment": "test", "Empty": ""},
+		EnabledForDeployment:         false,
+		EnabledForTemplateDeployment: false,
+	}, vaultView(kv))
+}
+
+func TestAssertVaultLocation(t *testing.T) {
+	t.Parallel()
+
+	kv := &armkeyvault.Vault{Name: to.Ptr("kv-test"), Location: to.Ptr("eastus")}
+	for _, expected := range []string{"East US", "eastus", "EASTUS", "east us"} {
+		assert.True(t, AssertVaultLocation(t, kv, expected), "%q should match eastus", expected)
+	}
+	assert.NotEqual(t, normalizeLocation("West Europe"), normalizeLocation(*kv.Location))
+}
+
+func TestVaultSkuProblem(t *testing.T) {
+	t.Parallel()
+
+	vault := func(family armkeyvault.SKUFamily, name armkeyvault.SKUName) *armkeyvault.Vault {
+		return &armkeyvault.Vault{
+			Name:       to.Ptr("kv-test"),
+			Properties: &armkeyvault.VaultProperties{SKU: &armkeyvault.SKU{Family: to.Ptr(family), Name: to.Ptr(name)}},
+		}
+	}
+	standard := vault(armkeyvault.SKUFamilyA, armkeyvault.SKUNameStandard)
+	premium := vault(armkeyvault.SKUFamilyA, armkeyvault.SKUNamePremium)
+
+	assert.Empty(t, vaultSkuProblem(standard, "standard"))
+	assert.Empty(t, vaultSkuProblem(standard, "Standard"), "SKU names should compare case-insensitively")
+	assert.Empty(t, vaultSkuProblem(premium, "premium"))
+	assert.Equal(t, `Key Vault kv-test has SKU "standard", expected "premium"`, vaultSkuProblem(standard, "premium"))
+	assert.Equal(t, `Key Vault kv-test has SKU "premium", expected "standard"`, vaultSkuProblem(premium, "standard"))
+	assert.Contains(t, vaultSkuProblem(vault("B", armkeyvault.SKUNameStandard), "standard"), `SKU family "B", expected "A"`)
+	assert.Contains(t, vaultSkuProblem(&armkeyvault.Vault{Name: to.Ptr("kv-test")}, "standard"), `has SKU "", expected "standard"`)
+
+	assert.True(t, AssertVaultSku(t, standard, "standard"), "a standard vault needs no HSM key")
+}
+
+func TestAssertVaultSubscription(t *testing.T) {
+	t.Parallel()
+
+	const vaultID = "/subscriptions/00000000-0000-0000-0000-0000000000aa/resourceGroups/rg-kv-test/providers/Microsoft.KeyVault/vaults/kv-test"
+	config := TestConfig{Name: "primary"}
+
+	rec := &recordingT{}
+	assert.True(t, AssertVaultSubscription(rec, config, vaultID, "00000000-0000-0000-0000-0000000000AA"), "subscription IDs should compare case-insensitively")
+	assert.Empty(t, rec.errors)
+
+	rec = &recordingT{}
+	assert.False(t, AssertVaultSubscription(rec, config, vaultID, "00000000-0000-0000-0000-0000000000bb"))
+	require.Len(t, rec.errors, 1)
+	assert.Contains(t, rec.errors[0], "tenant primary: Key Vault kv-test is in subscription 00000000-0000-0000-0000-0000000000aa, expected 00000000-0000-0000-0000-0000000000bb")
+
+	assert.Contains(t, vaultSubscriptionProblem("kv-test", "00000000-0000-0000-0000-0000000000aa"), `invalid Key Vault ID "kv-test"`)
+}
+
+func TestVaultViewDefaults(t *testing.T) {
+	t.Parallel()
+
+	for name, kv := range map[string]*armkeyvault.Vault{
+		"no properties":    {},
+		"empty properties": {Properties: &armkeyvault.VaultProperties{}},
+	} {
+		kv := kv
+		t.Run(name, func(t *testing.T) {
+			view := vaultView(kv)
+			assert.Empty(t, view.Name)
+			assert.Empty(t, view.SKU)
+			assert.False(t, view.PurgeProtectionEnabled)
+			assert.True(t, view.SoftDeleteEnabled, "Azure enables soft delete when it is unset")
+			assert.Zero(t, view.SoftDeleteRetentionDays)
+			assert.True(t, view.PublicNetworkAccessEnabled, "Azure allows public access when it is unset")
+			assert.Equal(t, "Allow", view.NetworkDefaultAction)
+			assert.Equal(t, "AzureServices", view.NetworkBypass)
+			assert.NotNil(t, view.IPRules)
+			assert.NotNil(t, view.Tags)
+		})
+	}
+}
+
+func TestPrivateEndpointApprovalProblem(t *testing.T) {
+	t.Parallel()
+
+	connection := func(endpoint string, status armkeyvault.PrivateEndpointServiceConnectionStatus, description string) *armkeyvault.PrivateEndpointConnectionItem {
+		return &armkeyvault.PrivateEndpointConnectionItem{
+			ID: to.Ptr("/subscriptions/x/resourceGroups/rg-kv-test/providers/Microsoft.KeyVault/vaults/kv-test/privateEndpointConnections/" + endpoint + "-conn"),
+			Properties: &armkeyvault.PrivateEndpointConnectionProperties{
+				PrivateEndpoint: &armkeyvault.PrivateEndpoint{ID: to.Ptr("/subscriptions/x/resourceGroups/rg-net/providers/Microsoft.Network/privateEndpoints/" + endpoint)},
+				PrivateLinkServiceConnectionState: &armkeyvault.PrivateLinkServiceConnectionState{
+					Status:      to.Ptr(status),
+					Description: to.Ptr(description),
+				},
+			},
+		}
+	}
+	vault := func(connections ...*armkeyvault.PrivateEndpointConnectionItem) *armkeyvault.Vault {
+		return &armkeyvault.Vault{
+			Name:       to.Ptr("kv-test"),
+			Properties: &armkeyvault.VaultProperties{PrivateEndpointConnections: connections},
+		}
+	}
+
+	assert.Empty(t, privateEndpointApprovalProblem(vault(connection("pe-kv", armkeyvault.PrivateEndpointServiceConnectionStatusApproved, "Auto-Approved"))))
+	assert.Empty(t, privateEndpointApprovalProblem(vault(
+		connection("pe-old", armkeyvault.PrivateEndpointServiceConnectionStatusRejected, ""),
+		connection("pe-kv", "approved", ""),
+	)), "one approved connection is enough, and states compare case-insensitively")
+
+	assert.Equal(t, "Key Vault kv-test has no private endpoint connections", privateEndpointApprovalProblem(vault()))
+	assert.Equal(t, "Key Vault kv-test has no private endpoint connections", privateEndpointApprovalProblem(&armkeyvault.Vault{Name: to.Ptr("kv-test")}))
+	assert.Equal(t,
+		"Key Vault kv-test has no Approved private endpoint connection: pe-kv is Pending (Awaiting approval); pe-old is Rejected",
+		privateEndpointApprovalProblem(vault(
+			connection("pe-kv", armkeyvault.PrivateEndpointServiceConnectionStatusPending, "Awaiting approval"),
+			connection("pe-old", armkeyvault.PrivateEndpointServiceConnectionStatusRejected, ""),
+		)))
+	assert.Contains(t, privateEndpointApprovalProblem(vault(&armkeyvault.PrivateEndpointConnectionItem{ID: to.Ptr("conn-1")})), "conn-1 is unknown")
+}
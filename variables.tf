@@ -1,5 +1,11 @@
 # Key Vault Module Variables
 
+variable "enabled" {
+  description = "Whether to create the Key Vault and all of its resources. When false the module creates nothing and its outputs are null or empty"
+  type        = bool
+  default     = true
+}
+
 # Naming and Resource Configuration
 variable "name_prefix" {
   description = "Prefix for resource naming. If empty, defaults to 'kv-{environment}-{location_short}'"
@@ -8,13 +14,49 @@ variable "name_prefix" {
 }
 
 variable "name_suffix" {
-  description = "Suffix for resource naming"
+  description = "Suffix for resource naming. With workload set, it is appended as its own segment"
   type        = string
   default     = ""
 }
 
+variable "workload" {
+  description = "Workload name. When set and key_vault_name and custom_name are not, the vault is named '<name_prefix>-kv-<workload>-<environment>[-<name_suffix>]', with the workload segment truncated to fit the 24 character limit"
+  type        = string
+  default     = null
+  validation {
+    condition     = var.workload == null || can(regex("^[a-zA-Z0-9]([a-zA-Z0-9-]*[a-zA-Z0-9])?$", var.workload))
+    error_message = "workload must be letters, digits and hyphens, starting and ending with a letter or digit."
+  }
+}
+
+variable "use_random_suffix" {
+  description = "Append a random 6 character suffix to the Key Vault name, truncating the base name to stay within 24 characters. Avoids collisions with soft-deleted vaults in ephemeral environments. The suffix changes only when the base name does"
+  type        = bool
+  default     = false
+}
+
+variable "lifecycle_create_before_destroy" {
+  description = "Create a replacement Key Vault before destroying the old one, for zero-downtime renames. Only takes effect with use_random_suffix, which gives the replacement a name of its own; vault names are globally unique, so a replacement under the same name would collide. Toggling it on an existing vault replaces the vault"
+  type        = bool
+  default     = false
+}
+
+variable "key_vault_name" {
+  description = "Name of the Key Vault. Takes precedence over custom_name and the generated name"
+  type        = string
+  default     = null
+  validation {
+    condition     = var.key_vault_name == null || can(regex("^[a-zA-Z][a-zA-Z0-9-]{1,22}[a-zA-Z0-9]$", var.key_vault_name))
+    error_message = "Key Vault name must be 3-24 characters of letters, digits and hyphens, start with a letter and end with a letter or digit (e.g. 'kv-prod-weu-app')."
+  }
+  validation {
+    condition     = !can(regex("--", var.key_vault_name))
+    error_message = "Key Vault name must not contain consecutive hyphens."
+  }
+}
+
 variable "custom_name" {
-  description = "Custom name for the Key Vault. If provided, name_prefix and name_suffix are ignored"
+  description = "Custom name for the Key Vault. If provided, name_prefix and name_suffix are ignored. Prefer key_vault_name"
   type        = string
   default     = ""
 }
@@ -29,11 +71,46 @@ variable "location_short" {
   type        = string
 }
 
+variable "allowed_regions" {
+  description = "Regions the module may deploy to, for data-residency policy. The plan fails when location, or the disaster recovery vault's region, is not listed. Names are compared ignoring case and spaces, so 'West Europe' matches 'westeurope'. Empty allows any region"
+  type        = list(string)
+  default     = []
+  nullable    = false
+}
+
+variable "tenant_id" {
+  description = "Entra ID tenant the Key Vault authenticates requests against. Leave unset, or set use_current_tenant, to use the tenant the azurerm provider authenticates to"
+  type        = string
+  default     = null
+  validation {
+    condition     = var.tenant_id == null || can(regex("^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$", var.tenant_id))
+    error_message = "tenant_id must be a tenant ID (GUID)."
+  }
+}
+
+variable "use_current_tenant" {
+  description = "Take the vault's tenant from the azurerm provider's client config (true) or from tenant_id (false). Null picks tenant_id when it is set and the current tenant otherwise"
+  type        = bool
+  default     = null
+}
+
 variable "resource_group_name" {
   description = "Name of the resource group"
   type        = string
 }
 
+variable "create_resource_group" {
+  description = "Create the resource group instead of reading an existing one"
+  type        = bool
+  default     = false
+}
+
+variable "resource_group_location" {
+  description = "Region of the resource group when create_resource_group is set, for topologies keeping the group's metadata in another region than the vault. Defaults to location"
+  type        = string
+  default     = null
+}
+
 variable "environment" {
   description = "Environment name (dev, test, prod, etc.)"
   type        = string
@@ -51,20 +128,86 @@ variable "created_by" {
   default     = "terraform"
 }
 
+variable "enable_provenance_tags" {
+  description = "Tag resources with the object ID of the principal running Terraform (CreatedByObjectId) and, when set, pipeline_run_id (PipelineRunId). These tags cannot be overridden by tags"
+  type        = bool
+  default     = true
+}
+
+variable "pipeline_run_id" {
+  description = "ID of the CI/CD run deploying the module, recorded in the PipelineRunId tag with enable_provenance_tags"
+  type        = string
+  default     = null
+}
+
+variable "purpose" {
+  description = "What the vault is for, recorded in the Purpose tag and checked against org policy: certificates needs certificate contacts, encryption the premium SKU with only HSM-backed (RSA-HSM or EC-HSM) keys, secrets has no extra requirements. Unmet requirements are reported as warnings at plan"
+  type        = string
+  default     = null
+  validation {
+    condition     = var.purpose == null || contains(["certificates", "encryption", "secrets"], coalesce(var.purpose, "secrets"))
+    error_message = "purpose must be 'certificates', 'encryption' or 'secrets'."
+  }
+}
+
+variable "tags" {
+  description = "Tags to apply to the Key Vault and all child resources. They override module-managed tags except ManagedBy"
+  type        = map(string)
+  default     = {}
+  nullable    = false
+}
+
 variable "additional_tags" {
-  description = "Additional tags to add to resources"
+  description = "Additional tags to add to resources. Merged before tags, which wins on collisions"
   type        = map(string)
   default     = {}
 }
 
+variable "data_lifecycle" {
+  description = "Value of the lifecycle tag on every key, secret and certificate the module creates: permanent, or ephemeral for objects janitor jobs may purge. A lifecycle tag on an individual object wins"
+  type        = string
+  default     = "permanent"
+  validation {
+    condition     = contains(["permanent", "ephemeral"], var.data_lifecycle)
+    error_message = "data_lifecycle must be 'permanent' or 'ephemeral'."
+  }
+}
+
 # Key Vault Configuration
+variable "backend_type" {
+  description = "Backend to provision: a standard Key Vault (vault) or a FIPS 140-2 Level 3 Managed HSM (managed_hsm)"
+  type        = string
+  default     = "vault"
+  validation {
+    condition     = contains(["vault", "managed_hsm"], var.backend_type)
+    error_message = "backend_type must be either 'vault' or 'managed_hsm'."
+  }
+}
+
+variable "managed_hsm_sku_name" {
+  description = "SKU name for the Managed HSM"
+  type        = string
+  default     = "Standard_B1"
+  validation {
+    condition     = contains(["Standard_B1", "Custom_B6", "Custom_B32"], var.managed_hsm_sku_name)
+    error_message = "Managed HSM SKU name must be one of 'Standard_B1', 'Custom_B6' or 'Custom_B32'."
+  }
+}
+
+variable "managed_hsm_admin_object_ids" {
+  description = "Object IDs of the Managed HSM administrators. Defaults to the deploying identity"
+  type        = list(string)
+  default     = []
+  nullable    = false
+}
+
 variable "sku_name" {
   description = "SKU name for the Key Vault (standard or premium)"
   type        = string
   default     = "standard"
   validation {
     condition     = contains(["standard", "premium"], var.sku_name)
-    error_message = "SKU name must be either 'standard' or 'premium'."
+    error_message = "SKU name must be either 'standard' or 'premium' (lowercase). Use 'premium' for HSM-backed keys."
   }
 }
 
@@ -81,7 +224,7 @@ variable "enabled_for_disk_encryption" {
 }
 
 variable "enabled_for_template_deployment" {
-  description = "Enable Key Vault for ARM template deployment"
+  description = "Enable Key Vault for ARM template deployment. Combined with public network access this raises a plan warning, as security scanners commonly flag it"
   type        = bool
   default     = false
 }
@@ -93,27 +236,82 @@ variable "enable_rbac_authorization" {
 }
 
 variable "purge_protection_enabled" {
-  description = "Enable purge protection for the Key Vault"
+  description = "Enable purge protection for the Key Vault. Always true for prod/production environments, where false is ignored with a warning; elsewhere it defaults to false, so ephemeral vaults can be purged. Once enabled it cannot be disabled"
   type        = bool
-  default     = true
+  default     = null
+}
+
+variable "purge_on_destroy" {
+  description = "Purge the soft-deleted vault after terraform destroy, for ephemeral environments. Runs the Azure CLI (az) from the machine running Terraform. No-op when purge protection is enabled. The provider setting features.key_vault.purge_soft_delete_on_destroy does the same for every vault of a provider; use this when it is turned off"
+  type        = bool
+  default     = false
 }
 
 variable "soft_delete_retention_days" {
-  description = "Number of days to retain deleted items"
+  description = "Number of days to retain deleted items, between 7 and 90 unless clamp_soft_delete_retention is set. Defaults to 90 in production environments (prod, production) and 7 elsewhere"
   type        = number
-  default     = 90
+  default     = null
   validation {
-    condition     = var.soft_delete_retention_days >= 7 && var.soft_delete_retention_days <= 90
-    error_message = "Soft delete retention days must be between 7 and 90."
+    condition     = var.soft_delete_retention_days == null || try(floor(var.soft_delete_retention_days) == var.soft_delete_retention_days, false)
+    error_message = "Soft delete retention days must be a whole number between 7 and 90; Azure rejects values outside this range."
   }
 }
 
+variable "clamp_soft_delete_retention" {
+  description = "Clamp soft_delete_retention_days into the 7-90 days Azure accepts, with a warning, instead of failing. Meant for quick development loops"
+  type        = bool
+  default     = false
+}
+
 variable "public_network_access_enabled" {
-  description = "Enable public network access"
+  description = "Enable public network access. With network_acls_ip_rules, public access is limited to the listed addresses; disabling it while IP rules are set is an error"
+  type        = bool
+  default     = false
+}
+
+variable "bootstrap_public_access" {
+  description = "Open the vault to public traffic, overriding public_network_access_enabled and, without IP rules, allowing all addresses, so a runner outside the private network can seed it. Apply again with it false to lock the vault down"
   type        = bool
   default     = false
 }
 
+# Cloud
+variable "cloud_environment" {
+  description = "Azure cloud the azurerm provider deploys to, named as its environment setting: public, usgovernment or china. The vault URI must end in this cloud's Key Vault DNS suffix, which catches a provider pointed at the wrong cloud"
+  type        = string
+  default     = "public"
+  validation {
+    condition     = contains(["public", "usgovernment", "china"], var.cloud_environment)
+    error_message = "cloud_environment must be one of: public, usgovernment, china."
+  }
+}
+
+variable "vault_dns_suffix" {
+  description = "Key Vault DNS suffix to use instead of the one of cloud_environment, such as vault.local.azurestack.external on Azure Stack Hub. Builds the vault URI outputs and is checked against the deployed vault's URI"
+  type        = string
+  default     = null
+  validation {
+    condition     = var.vault_dns_suffix == null || can(regex("^([a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?\\.)+[a-z][a-z0-9-]{0,61}[a-z0-9]$", var.vault_dns_suffix))
+    error_message = "vault_dns_suffix must be a lowercase domain name without scheme, leading dot or trailing slash, such as vault.local.azurestack.external."
+  }
+}
+
+# Timeouts
+variable "timeouts" {
+  description = "Timeouts for Key Vault operations, for regions and sovereign clouds where the provider defaults are too short. Once set, create and delete default to 30m. Null uses the provider defaults"
+  type = object({
+    create = optional(string, "30m")
+    read   = optional(string)
+    update = optional(string)
+    delete = optional(string, "30m")
+  })
+  default = null
+  validation {
+    condition     = alltrue([for d in try(values(var.timeouts), []) : d == null || can(regex("^([0-9]+h)?([0-9]+m)?([0-9]+s)?$", d)) && d != ""])
+    error_message = "Each timeout must be a duration such as 45m, 1h or 1h30m."
+  }
+}
+
 # Network ACLs
 variable "enable_network_acls" {
   description = "Enable network ACLs for the Key Vault"
@@ -122,7 +320,7 @@ variable "enable_network_acls" {
 }
 
 variable "network_acls_bypass" {
-  description = "Bypass options for network ACLs"
+  description = "Bypass options for network ACLs: AzureServices lets trusted Azure services through the firewall, None makes them match an IP or subnet rule like any other caller, for the strictest lockdown"
   type        = string
   default     = "AzureServices"
   validation {
@@ -142,31 +340,54 @@ variable "network_acls_default_action" {
 }
 
 variable "network_acls_ip_rules" {
-  description = "List of IP rules for network ACLs"
+  description = "List of IPv4 or IPv6 addresses or CIDR ranges allowed through the network ACLs. Bare addresses are sent as /32 (IPv4) or /128 (IPv6) ranges. IPv6 rules are only available in some regions"
   type        = list(string)
   default     = []
+  validation {
+    # A range must be its own network address: 203.0.113.5/24 has host bits set
+    # and would otherwise be sent as-is, so it is rejected rather than widened.
+    # Both addresses go through cidrhost, so IPv6 case and zero compression
+    # don't matter.
+    condition = alltrue([
+      for cidr in [
+        for rule in var.network_acls_ip_rules : strcontains(rule, "/") ? rule : "${rule}/${strcontains(rule, ":") ? 128 : 32}"
+      ] : try(cidrhost(cidr, 0) == cidrhost("${split("/", cidr)[0]}/${strcontains(cidr, ":") ? 128 : 32}", 0), false)
+    ])
+    error_message = "Each network ACL IP rule must be an IPv4 or IPv6 address or CIDR range without host bits set, such as 203.0.113.7, 203.0.113.0/24 or 2001:db8::/48 (not 203.0.113.5/24)."
+  }
 }
 
 variable "network_acls_subnet_ids" {
-  description = "List of subnet IDs for network ACLs"
+  description = "List of virtual network subnet IDs allowed through the network ACLs"
   type        = list(string)
   default     = []
 }
 
 # Access Policies (when RBAC is disabled)
 variable "access_policies" {
-  description = "List of access policies for the Key Vault"
+  description = "Map of access policies for the Key Vault. At least one is required when enable_rbac_authorization is false, and none may be set when it is true. tenant_id defaults to the vault's tenant; any other tenant must be listed in additional_tenant_ids"
   type = map(object({
-    tenant_id               = string
+    tenant_id               = optional(string)
     object_id               = string
-    key_permissions         = list(string)
-    secret_permissions      = list(string)
-    certificate_permissions = list(string)
-    storage_permissions     = list(string)
+    key_permissions         = optional(list(string), [])
+    secret_permissions      = optional(list(string), [])
+    certificate_permissions = optional(list(string), [])
+    storage_permissions     = optional(list(string), [])
   }))
   default = {}
 }
 
+variable "additional_tenant_ids" {
+  description = "Tenants other than the vault's that access_policies may grant access to, for principals of B2B guest tenants. Guards against a mistyped tenant_id, which Azure accepts but no principal can use"
+  type        = list(string)
+  default     = []
+  nullable    = false
+  validation {
+    condition     = alltrue([for id in var.additional_tenant_ids : can(regex("^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$", id))])
+    error_message = "additional_tenant_ids must be tenant IDs (GUIDs)."
+  }
+}
+
 # RBAC Configuration (when RBAC is enabled)
 variable "rbac_administrators" {
   description = "List of principal IDs for Key Vault Administrator role"
@@ -204,9 +425,77 @@ variable "rbac_certificates_officers" {
   default     = []
 }
 
+variable "deployment_principal_ids" {
+  description = "Object IDs of VM or VMSS managed identities granted Key Vault Secrets User, so they can read secrets and certificates at boot. Only applies with enabled_for_deployment and RBAC authorization"
+  type        = list(string)
+  default     = []
+  nullable    = false
+  validation {
+    condition     = alltrue([for id in var.deployment_principal_ids : can(regex("^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$", id))])
+    error_message = "Each deployment_principal_ids entry must be an object ID (GUID)."
+  }
+}
+
+variable "break_glass_object_id" {
+  description = "Object ID of an emergency break-glass identity, granted full data-plane access only while break_glass_enabled is true: Key Vault Administrator with RBAC authorization, or an access policy with every key, secret, certificate and storage permission otherwise"
+  type        = string
+  default     = null
+  validation {
+    condition     = var.break_glass_object_id == null || can(regex("^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$", coalesce(var.break_glass_object_id, "-")))
+    error_message = "break_glass_object_id must be an object ID (GUID)."
+  }
+}
+
+variable "break_glass_enabled" {
+  description = "Grant break_glass_object_id its access, for the duration of an incident. Revoked again by setting it back to false"
+  type        = bool
+  default     = false
+  nullable    = false
+}
+
+variable "role_assignments" {
+  description = "Map of additional role assignments scoped to the Key Vault, e.g. 'Key Vault Secrets User' or 'Key Vault Crypto Officer'"
+  type = map(object({
+    principal_id         = string
+    role_definition_name = string
+    principal_type       = optional(string)
+  }))
+  default = {}
+  validation {
+    condition = alltrue([
+      for ra in values(var.role_assignments) : ra.principal_type == null || contains(["User", "Group", "ServicePrincipal"], coalesce(ra.principal_type, "User"))
+    ])
+    error_message = "Role assignment principal_type must be 'User', 'Group' or 'ServicePrincipal'."
+  }
+}
+
+# Data Plane
+variable "manage_data_plane" {
+  description = "Create the keys, secrets, certificates, certificate issuers and certificate contacts. Set to false for a first apply that only builds the vault and its access, then to true once role assignments have propagated"
+  type        = bool
+  default     = true
+}
+
+# Expiration
+variable "require_expiration" {
+  description = "Fail the plan when an entry in keys or secrets has no expiration_date and default_expiration_days is unset. Imported keys take their expiry from the imported certificate and are not checked"
+  type        = bool
+  default     = false
+}
+
+variable "default_expiration_days" {
+  description = "Number of days after creation that keys and secrets without an expiration_date expire. The creation time is recorded once per entry, so the expiry does not move on later applies"
+  type        = number
+  default     = null
+  validation {
+    condition     = var.default_expiration_days == null || try(var.default_expiration_days > 0 && floor(var.default_expiration_days) == var.default_expiration_days, false)
+    error_message = "default_expiration_days must be a positive whole number of days."
+  }
+}
+
 # Keys Configuration
 variable "keys" {
-  description = "Map of keys to create in the Key Vault"
+  description = "Map of keys to create in the Key Vault. key_type RSA-HSM or EC-HSM creates an HSM-backed key, which needs the premium SKU. Set key_material to import an existing key instead of generating one: contents is a base64-encoded PFX or a PEM holding the certificate and private key. Imported keys are stored as certificates and take their size, curve, operations and validity from the material. Set enabled = false to disable a generated key without deleting it"
   type = map(object({
     name            = string
     key_type        = string
@@ -216,65 +505,276 @@ variable "keys" {
     not_before_date = optional(string)
     expiration_date = optional(string)
     rotation_policy = optional(object({
+      time_after_creation  = optional(string)
       time_before_expiry   = optional(string)
       expire_after         = optional(string)
       notify_before_expiry = optional(string)
     }))
-    tags = optional(map(string), {})
+    key_material = optional(object({
+      contents = string
+      password = optional(string)
+    }))
+    enabled = optional(bool, true)
+    tags    = optional(map(string), {})
   }))
-  default = {}
+  default   = {}
+  sensitive = true
+  validation {
+    condition = alltrue([
+      for k in values(var.keys) : contains(["RSA", "RSA-HSM", "EC", "EC-HSM"], k.key_type)
+    ])
+    error_message = "Keys key_type must be RSA, RSA-HSM, EC or EC-HSM. The -HSM types are HSM-backed and require sku_name = \"premium\"."
+  }
+  validation {
+    condition = alltrue([
+      for k in values(var.keys) : k.key_material != null || !startswith(k.key_type, "RSA") || contains([2048, 3072, 4096], coalesce(k.key_size, 0))
+    ])
+    error_message = "RSA keys must set key_size to 2048, 3072 or 4096."
+  }
+  validation {
+    condition = alltrue([
+      for k in values(var.keys) : !startswith(k.key_type, "EC") || contains(["P-256", "P-256K", "P-384", "P-521"], coalesce(k.curve, "P-256"))
+    ])
+    error_message = "EC keys must use a curve supported by Azure Key Vault: P-256, P-256K, P-384 or P-521."
+  }
+  validation {
+    condition = alltrue([
+      for k in values(var.keys) : try(k.rotation_policy.time_after_creation == null || k.rotation_policy.time_before_expiry == null, true)
+    ])
+    error_message = "A key rotation policy can rotate after creation or before expiry, but not both."
+  }
+  validation {
+    condition = alltrue([
+      for k in values(var.keys) : k.key_material == null || (contains(["RSA", "EC"], k.key_type) && k.key_size == null && k.curve == null)
+    ])
+    error_message = "Keys imported from key_material take their size and curve from the material: set key_type to RSA or EC and leave key_size and curve unset."
+  }
+  validation {
+    condition = alltrue([
+      for k in values(var.keys) : k.key_material == null || (k.rotation_policy == null && k.not_before_date == null && k.expiration_date == null)
+    ])
+    error_message = "Keys imported from key_material cannot set rotation_policy, not_before_date or expiration_date, which come from the imported certificate."
+  }
+  validation {
+    condition = alltrue([
+      for k in values(var.keys) : (k.not_before_date == null || can(timeadd(k.not_before_date, "0s"))) && (k.expiration_date == null || can(timeadd(k.expiration_date, "0s")))
+    ])
+    error_message = "Keys not_before_date and expiration_date must be RFC 3339 timestamps such as 2025-06-30T00:00:00Z."
+  }
+  validation {
+    condition = alltrue([
+      for k in values(var.keys) : k.not_before_date == null || k.expiration_date == null || try(timecmp(k.not_before_date, k.expiration_date) < 0, true)
+    ])
+    error_message = "Keys not_before_date must be earlier than expiration_date, or the key would never be usable."
+  }
+  validation {
+    condition     = alltrue([for k in values(var.keys) : k.key_material == null || k.enabled])
+    error_message = "Keys imported from key_material cannot set enabled = false: Key Vault manages the key backing a certificate."
+  }
+}
+
+variable "disk_encryption_key" {
+  description = "RSA 2048 key reserved for a disk encryption set. Its versionless ID is output as disk_encryption_key_id so the disk encryption set follows rotations"
+  type = object({
+    name            = optional(string, "disk-encryption")
+    key_type        = optional(string, "RSA")
+    key_opts        = optional(list(string), ["wrapKey", "unwrapKey"])
+    expiration_date = optional(string)
+    rotation_policy = optional(object({
+      time_after_creation  = optional(string)
+      time_before_expiry   = optional(string)
+      expire_after         = optional(string)
+      notify_before_expiry = optional(string)
+    }))
+    tags = optional(map(string), {})
+  })
+  default = null
+  validation {
+    condition     = var.disk_encryption_key == null || contains(["RSA", "RSA-HSM"], try(var.disk_encryption_key.key_type, ""))
+    error_message = "The disk encryption key must be of type RSA or RSA-HSM."
+  }
+  validation {
+    condition     = var.disk_encryption_key == null || (contains(try(var.disk_encryption_key.key_opts, []), "wrapKey") && contains(try(var.disk_encryption_key.key_opts, []), "unwrapKey"))
+    error_message = "The disk encryption key must allow the wrapKey and unwrapKey operations, which disk encryption sets use."
+  }
+  validation {
+    condition     = try(var.disk_encryption_key.rotation_policy.time_after_creation == null || var.disk_encryption_key.rotation_policy.time_before_expiry == null, true)
+    error_message = "A key rotation policy can rotate after creation or before expiry, but not both."
+  }
 }
 
 # Secrets Configuration
 variable "secrets" {
-  description = "Map of secrets to create in the Key Vault"
+  description = "Map of secrets to create in the Key Vault. The secret name defaults to the map key. Set either value or value_from_file, the path of a file read as is at plan time, so values can stay out of tfvars; relative paths resolve against the working directory. not_before_date, an RFC 3339 time before expiration_date, stages a rotated credential until it should be used. Set enabled = false to disable a secret without deleting it"
   type = map(object({
-    name            = string
-    value           = string
+    name            = optional(string)
+    value           = optional(string)
+    value_from_file = optional(string)
     content_type    = optional(string)
     not_before_date = optional(string)
     expiration_date = optional(string)
+    enabled         = optional(bool, true)
     tags            = optional(map(string), {})
   }))
-  default = {}
+  default   = {}
+  sensitive = true
+  validation {
+    condition     = alltrue([for s in values(var.secrets) : (s.value == null) != (s.value_from_file == null)])
+    error_message = "Each secret must set exactly one of value or value_from_file."
+  }
+  validation {
+    condition     = alltrue([for s in values(var.secrets) : s.value_from_file == null || fileexists(coalesce(s.value_from_file, "-"))])
+    error_message = "Each secret value_from_file must name an existing file."
+  }
+  validation {
+    condition = alltrue([
+      for s in values(var.secrets) : (s.not_before_date == null || can(timeadd(s.not_before_date, "0s"))) && (s.expiration_date == null || can(timeadd(s.expiration_date, "0s")))
+    ])
+    error_message = "Secrets not_before_date and expiration_date must be RFC 3339 timestamps such as 2025-06-30T00:00:00Z."
+  }
+  validation {
+    condition = alltrue([
+      for s in values(var.secrets) : s.not_before_date == null || s.expiration_date == null || try(timecmp(s.not_before_date, s.expiration_date) < 0, true)
+    ])
+    error_message = "Secrets not_before_date must be earlier than expiration_date, or the secret would never be valid."
+  }
+}
+
+# Kubernetes Secrets Store CSI
+variable "emit_csi_provider_class" {
+  description = "Write a SecretProviderClass manifest for the Secrets Store CSI driver to csi_provider_class.path, mounting every secret in secrets from the vault. Only a local file is written; nothing is deployed to Azure or Kubernetes"
+  type        = bool
+  default     = false
+}
+
+variable "csi_provider_class" {
+  description = "SecretProviderClass written by emit_csi_provider_class: the file path, the manifest name (defaults to the vault name) and namespace, and the client ID of the workload identity reading the vault, omitted from the manifest when unset"
+  type = object({
+    path      = optional(string)
+    name      = optional(string)
+    namespace = optional(string, "default")
+    client_id = optional(string)
+  })
+  default  = {}
+  nullable = false
+}
+
+# Vault Summary
+variable "emit_summary" {
+  description = "Write a markdown summary of the vault to summary_path for self-service catalogs: its name, URI, SKU, security posture and the names of its keys and secrets. Only a local file is written; secret values are never included"
+  type        = bool
+  default     = false
+}
+
+variable "summary_path" {
+  description = "Path of the markdown summary written by emit_summary"
+  type        = string
+  default     = null
 }
 
 # Certificates Configuration
 variable "certificates" {
-  description = "Map of certificates to create in the Key Vault"
+  description = "Map of certificates to create in the Key Vault. Defaults produce a self-signed, auto-renewing RSA 2048 certificate. An RSA-HSM or EC-HSM key_type needs the premium SKU"
   type = map(object({
     name = string
-    issuer_parameters = object({
+    issuer_parameters = optional(object({
       name = string
-    })
-    key_properties = object({
-      exportable = bool
-      key_size   = number
-      key_type   = string
-      reuse_key  = bool
-    })
-    secret_properties = object({
-      content_type = string
-    })
+    }), { name = "Self" })
+    key_properties = optional(object({
+      exportable = optional(bool, true)
+      key_size   = optional(number, 2048)
+      key_type   = optional(string, "RSA")
+      reuse_key  = optional(bool, true)
+    }), {})
+    lifetime_actions = optional(list(object({
+      action_type         = string
+      days_before_expiry  = optional(number)
+      lifetime_percentage = optional(number)
+    })), [{ action_type = "AutoRenew", days_before_expiry = 30 }])
+    secret_properties = optional(object({
+      content_type = optional(string, "application/x-pkcs12")
+    }), {})
     x509_certificate_properties = object({
-      extended_key_usage = list(string)
-      key_usage          = list(string)
+      extended_key_usage = optional(list(string), ["1.3.6.1.5.5.7.3.1"])
+      key_usage          = optional(list(string), ["digitalSignature", "keyEncipherment"])
       subject            = string
-      validity_in_months = number
-      subject_alternative_names = object({
-        dns_names = list(string)
-        emails    = list(string)
-        upns      = list(string)
-      })
+      validity_in_months = optional(number, 12)
+      subject_alternative_names = optional(object({
+        dns_names = optional(list(string), [])
+        emails    = optional(list(string), [])
+        upns      = optional(list(string), [])
+      }), {})
     })
     tags = optional(map(string), {})
   }))
   default = {}
+  validation {
+    condition = alltrue([
+      for c in values(var.certificates) : length(c.lifetime_actions) > 0
+    ])
+    error_message = "Each certificate must have at least one lifetime_action, or it will expire without renewal or notice."
+  }
+  validation {
+    condition = alltrue(flatten([
+      for c in values(var.certificates) : [
+        for a in c.lifetime_actions : contains(["AutoRenew", "EmailContacts"], a.action_type)
+      ]
+    ]))
+    error_message = "Certificate lifetime_action action_type must be 'AutoRenew' or 'EmailContacts'."
+  }
+  validation {
+    condition = alltrue(flatten([
+      for c in values(var.certificates) : [
+        for a in c.lifetime_actions : (a.days_before_expiry == null) != (a.lifetime_percentage == null)
+      ]
+    ]))
+    error_message = "Each certificate lifetime_action must set exactly one of days_before_expiry or lifetime_percentage."
+  }
+  validation {
+    condition = alltrue(flatten([
+      for c in values(var.certificates) : [
+        for a in c.lifetime_actions : a.lifetime_percentage == null ? true : a.lifetime_percentage >= 1 && a.lifetime_percentage <= 99
+      ]
+    ]))
+    error_message = "Certificate lifetime_action lifetime_percentage must be between 1 and 99."
+  }
+  validation {
+    condition = alltrue(flatten([
+      for c in values(var.certificates) : [
+        for a in c.lifetime_actions : a.days_before_expiry == null ? true : a.days_before_expiry >= 1 && a.days_before_expiry <= c.x509_certificate_properties.validity_in_months * 27
+      ]
+    ]))
+    error_message = "Certificate lifetime_action days_before_expiry must be between 1 and 27 times validity_in_months, the range Key Vault accepts."
+  }
+}
+
+variable "certificate_issuers" {
+  description = "Map of external certificate issuers (e.g. DigiCert, GlobalSign) to register in the Key Vault, keyed by issuer name"
+  type = map(object({
+    provider_name = string
+    account_id    = optional(string)
+    org_id        = optional(string)
+    password      = optional(string)
+    admins = optional(list(object({
+      email_address = string
+      first_name    = optional(string)
+      last_name     = optional(string)
+      phone         = optional(string)
+    })), [])
+  }))
+  default   = {}
+  sensitive = true
+  validation {
+    condition = alltrue([
+      for i in values(var.certificate_issuers) : contains(["DigiCert", "GlobalSign", "OneCertV2-PrivateCA", "OneCertV2-PublicCA", "SslAdminV2"], i.provider_name)
+    ])
+    error_message = "Certificate issuer provider_name must be one of 'DigiCert', 'GlobalSign', 'OneCertV2-PrivateCA', 'OneCertV2-PublicCA' or 'SslAdminV2'."
+  }
 }
 
 # Contacts
 variable "contacts" {
-  description = "List of contacts for certificate management"
+  description = "List of contacts for certificate management, set through the vault's contact block. Prefer certificate_contacts"
   type = list(object({
     email = string
     name  = optional(string)
@@ -283,6 +783,45 @@ variable "contacts" {
   default = []
 }
 
+variable "certificate_contacts" {
+  description = "Contacts notified about certificate lifecycle events such as upcoming expiry, managed as an azurerm_key_vault_certificate_contacts resource"
+  type = list(object({
+    email = string
+    name  = optional(string)
+    phone = optional(string)
+  }))
+  default  = []
+  nullable = false
+}
+
+# Managed Storage Accounts
+variable "managed_storage_accounts" {
+  description = "Map of storage accounts whose access keys the Key Vault manages and regenerates, keyed by logical name. storage_account_key is the active key, key1 or key2, and regeneration_period an ISO 8601 duration such as P90D. Needs access policies (enable_rbac_authorization = false) granting the deployer storage permissions, and key_vault_service_principal_object_id"
+  type = map(object({
+    name                         = string
+    storage_account_id           = string
+    storage_account_key          = optional(string, "key1")
+    regenerate_key_automatically = optional(bool, true)
+    regeneration_period          = optional(string, "P90D")
+    tags                         = optional(map(string), {})
+  }))
+  default = {}
+  validation {
+    condition     = alltrue([for a in values(var.managed_storage_accounts) : contains(["key1", "key2"], a.storage_account_key)])
+    error_message = "Managed storage account storage_account_key must be 'key1' or 'key2'."
+  }
+  validation {
+    condition     = alltrue([for a in values(var.managed_storage_accounts) : can(regex("^P([0-9]+Y)?([0-9]+M)?([0-9]+W)?([0-9]+D)?$", a.regeneration_period)) && a.regeneration_period != "P"])
+    error_message = "Managed storage account regeneration_period must be an ISO 8601 duration of days or longer, such as P1D, P90D or P1Y."
+  }
+}
+
+variable "key_vault_service_principal_object_id" {
+  description = "Object ID of the Azure Key Vault service principal (application ID cfa8b339-82a2-471a-a3c9-0fc0be7a4093) in the tenant, granted the Storage Account Key Operator Service Role on each managed storage account. Find it with: az ad sp show --id cfa8b339-82a2-471a-a3c9-0fc0be7a4093 --query id"
+  type        = string
+  default     = null
+}
+
 # Private Endpoint Configuration
 variable "enable_private_endpoint" {
   description = "Enable private endpoint for the Key Vault"
@@ -296,15 +835,49 @@ variable "private_endpoint_subnet_id" {
   default     = null
 }
 
+variable "private_endpoint_name" {
+  description = "Name of the private endpoint. Defaults to '{key_vault_name}-pe'"
+  type        = string
+  default     = null
+}
+
 variable "private_dns_zone_ids" {
-  description = "List of private DNS zone IDs for the private endpoint"
+  description = "List of private DNS zone IDs for the private endpoint, such as the privatelink zones of several hubs. They share one DNS zone group with a zone config per zone, named after the zone, so zone names must be unique. When empty, no DNS zone group is created"
   type        = list(string)
+  default     = []
+  nullable    = false
+
+  validation {
+    condition     = alltrue([for id in var.private_dns_zone_ids : can(regex("(?i)^/subscriptions/[^/]+/resourceGroups/[^/]+/providers/Microsoft.Network/privateDnsZones/[^/]+$", id))])
+    error_message = "Each private_dns_zone_ids entry must be the resource ID of a private DNS zone."
+  }
+
+  validation {
+    condition     = length(var.private_dns_zone_ids) <= 5
+    error_message = "A private endpoint DNS zone group holds at most 5 private DNS zones."
+  }
+
+  validation {
+    condition     = length(distinct([for id in var.private_dns_zone_ids : lower(element(split("/", id), length(split("/", id)) - 1))])) == length(var.private_dns_zone_ids)
+    error_message = "private_dns_zone_ids must not list two zones with the same name: each zone config of the DNS zone group is named after its zone."
+  }
+}
+
+variable "private_dns_zone_name" {
+  description = "Name of an existing private DNS zone, such as privatelink.vaultcore.azure.net, to look up and add to the private endpoint's DNS zone group, as an alternative to private_dns_zone_ids. Needs private_dns_zone_resource_group. The zone is read with the module's azurerm provider, so it must be in the same subscription"
+  type        = string
+  default     = null
+}
+
+variable "private_dns_zone_resource_group" {
+  description = "Resource group of the private DNS zone named by private_dns_zone_name"
+  type        = string
   default     = null
 }
 
 # Diagnostic Settings
 variable "enable_diagnostic_settings" {
-  description = "Enable diagnostic settings for the Key Vault"
+  description = "Enable diagnostic settings for the Key Vault. The setting is only created when a Log Analytics workspace, Event Hub or diagnostic storage destination is configured"
   type        = bool
   default     = true
 }
@@ -315,6 +888,56 @@ variable "log_analytics_workspace_id" {
   default     = null
 }
 
+variable "diagnostic_settings" {
+  description = "Diagnostic settings destinations and log categories. Values set here take precedence over log_analytics_workspace_id and diagnostic_logs. diagnostic_retention_days sets, per log category, how many days the diagnostic storage account keeps its logs, 0 keeping them forever; other categories and metrics follow diagnostic_storage.retention_days"
+  type = object({
+    log_analytics_workspace_id     = optional(string)
+    eventhub_authorization_rule_id = optional(string)
+    eventhub_name                  = optional(string)
+    enabled_log_categories         = optional(list(string))
+    diagnostic_retention_days      = optional(map(number), { AuditEvent = 365 })
+  })
+  default  = {}
+  nullable = false
+
+  validation {
+    condition     = alltrue([for days in values(var.diagnostic_settings.diagnostic_retention_days) : days >= 0 && days <= 365 && floor(days) == days])
+    error_message = "Each diagnostic_retention_days value must be a whole number of days between 0 (keep forever) and 365."
+  }
+}
+
+variable "create_diagnostic_storage" {
+  description = "Create a storage account for long-term retention of the Key Vault's logs and metrics and add it as a destination of the diagnostic setting. The account only accepts HTTPS with TLS 1.2 or later and allows no public blob access"
+  type        = bool
+  default     = false
+}
+
+variable "diagnostic_storage" {
+  description = "Storage account created by create_diagnostic_storage. name defaults to the vault name without hyphens followed by 'diag'; archived metrics, and logs of categories missing from diagnostic_settings.diagnostic_retention_days, are deleted retention_days after they were last written"
+  type = object({
+    name                     = optional(string)
+    account_replication_type = optional(string, "LRS")
+    retention_days           = optional(number, 365)
+  })
+  default  = {}
+  nullable = false
+
+  validation {
+    condition     = var.diagnostic_storage.name == null || can(regex("^[a-z0-9]{3,24}$", var.diagnostic_storage.name))
+    error_message = "Diagnostic storage account name must be 3-24 lowercase letters and numbers."
+  }
+
+  validation {
+    condition     = contains(["LRS", "ZRS", "GRS", "RAGRS", "GZRS", "RAGZRS"], var.diagnostic_storage.account_replication_type)
+    error_message = "Diagnostic storage account_replication_type must be one of LRS, ZRS, GRS, RAGRS, GZRS or RAGZRS."
+  }
+
+  validation {
+    condition     = var.diagnostic_storage.retention_days >= 1 && floor(var.diagnostic_storage.retention_days) == var.diagnostic_storage.retention_days
+    error_message = "Diagnostic storage retention_days must be a whole number of at least 1."
+  }
+}
+
 variable "diagnostic_logs" {
   description = "List of diagnostic logs to enable"
   type        = list(string)
@@ -332,6 +955,177 @@ variable "diagnostic_metrics" {
   ]
 }
 
+# Multiple Vaults
+variable "vaults" {
+  description = "Vaults to deploy in place of the single vault, keyed by logical name (e.g. secrets, certificates). Unset settings are inherited from the module-level variables. Keys, secrets, certificates, access, private endpoint, diagnostic settings and the resource lock are only managed for the single vault"
+  type = map(object({
+    name                          = string
+    sku_name                      = optional(string)
+    enable_rbac_authorization     = optional(bool)
+    purge_protection_enabled      = optional(bool)
+    soft_delete_retention_days    = optional(number)
+    public_network_access_enabled = optional(bool)
+    network_acls = optional(object({
+      bypass                     = optional(string, "AzureServices")
+      default_action             = optional(string, "Deny")
+      ip_rules                   = optional(list(string), [])
+      virtual_network_subnet_ids = optional(list(string), [])
+    }))
+    tags = optional(map(string), {})
+  }))
+  default  = {}
+  nullable = false
+  validation {
+    condition     = alltrue([for v in var.vaults : can(regex("^[a-zA-Z][a-zA-Z0-9-]{1,22}[a-zA-Z0-9]$", v.name)) && !strcontains(v.name, "--")])
+    error_message = "Each vaults name must be 3-24 characters of letters, digits and single hyphens, start with a letter and end with a letter or digit."
+  }
+  validation {
+    condition     = length(distinct([for v in var.vaults : lower(v.name)])) == length(var.vaults)
+    error_message = "Each entry in vaults must have a different name."
+  }
+  validation {
+    condition     = alltrue([for v in var.vaults : v.sku_name == null || contains(["standard", "premium"], v.sku_name)])
+    error_message = "vaults sku_name must be either 'standard' or 'premium'."
+  }
+  validation {
+    condition     = alltrue([for v in var.vaults : v.network_acls == null || try(contains(["None", "AzureServices"], v.network_acls.bypass) && contains(["Allow", "Deny"], v.network_acls.default_action), false)])
+    error_message = "vaults network_acls bypass must be 'None' or 'AzureServices', and default_action 'Allow' or 'Deny'."
+  }
+}
+
+# Managed Identity
+variable "identity" {
+  description = "Managed identity for the child resources that take one; Key Vault itself has none. The Event Grid system topic gets this identity, and the event subscription delivers to event_grid_storage_queue as the first user-assigned identity, or the system-assigned one without it, so the queue can authorize it with RBAC"
+  type = object({
+    type         = string
+    identity_ids = optional(list(string), [])
+  })
+  default = null
+  validation {
+    condition     = var.identity == null || contains(["SystemAssigned", "UserAssigned", "SystemAssigned, UserAssigned"], try(var.identity.type, ""))
+    error_message = "Identity type must be 'SystemAssigned', 'UserAssigned' or 'SystemAssigned, UserAssigned'."
+  }
+  validation {
+    condition     = var.identity == null || (strcontains(try(var.identity.type, ""), "UserAssigned") == (length(try(var.identity.identity_ids, [])) > 0))
+    error_message = "identity_ids must list the user-assigned identities when the identity type includes UserAssigned, and be empty otherwise."
+  }
+}
+
+# Event Grid Notifications
+variable "event_grid_enabled" {
+  description = "Create an Event Grid system topic on the Key Vault with a subscription delivering near-expiry events to event_grid_webhook_url or event_grid_storage_queue"
+  type        = bool
+  default     = false
+}
+
+variable "event_grid_webhook_url" {
+  description = "Webhook endpoint receiving the Event Grid events. Mutually exclusive with event_grid_storage_queue"
+  type        = string
+  default     = null
+  sensitive   = true
+}
+
+variable "event_grid_storage_queue" {
+  description = "Storage queue receiving the Event Grid events. Mutually exclusive with event_grid_webhook_url"
+  type = object({
+    storage_account_id = string
+    queue_name         = string
+  })
+  default = null
+}
+
+variable "event_grid_event_types" {
+  description = "Key Vault event types delivered by the Event Grid subscription"
+  type        = list(string)
+  default = [
+    "Microsoft.KeyVault.KeyNearExpiry",
+    "Microsoft.KeyVault.SecretNearExpiry"
+  ]
+}
+
+# Availability Alert
+variable "create_availability_alert" {
+  description = "Create a metric alert paging availability_alert_action_group_id when the Key Vault is saturated or throttling requests"
+  type        = bool
+  default     = false
+}
+
+variable "availability_alert_action_group_id" {
+  description = "ID of the action group notified by the availability alert. Required with create_availability_alert unless action_group is set"
+  type        = string
+  default     = null
+}
+
+variable "action_group" {
+  description = "Create an action group for the availability alert to notify, for callers without one of their own. Receivers are keyed by name. name defaults to '<vault name>-alerts' and short_name, at most 12 characters, to the start of the vault name. Cannot be combined with availability_alert_action_group_id"
+  type = object({
+    name       = optional(string)
+    short_name = optional(string)
+    email_receivers = optional(map(object({
+      email_address           = string
+      use_common_alert_schema = optional(bool, true)
+    })), {})
+    webhook_receivers = optional(map(object({
+      service_uri             = string
+      use_common_alert_schema = optional(bool, true)
+    })), {})
+  })
+  default = null
+  validation {
+    condition     = var.action_group == null || length(coalesce(try(var.action_group.short_name, null), "-")) <= 12
+    error_message = "action_group.short_name must be at most 12 characters."
+  }
+  validation {
+    condition     = var.action_group == null || length(try(var.action_group.email_receivers, {})) + length(try(var.action_group.webhook_receivers, {})) > 0
+    error_message = "action_group needs at least one email or webhook receiver."
+  }
+  validation {
+    condition     = alltrue([for r in values(try(var.action_group.webhook_receivers, {})) : startswith(r.service_uri, "https://")])
+    error_message = "action_group webhook receivers must use an https:// service_uri."
+  }
+}
+
+variable "availability_alert_metric" {
+  description = "Metric of the availability alert: SaturationShoebox, the vault's overall saturation in percent, or ServiceApiResult, the number of requests throttled with status 429"
+  type        = string
+  default     = "SaturationShoebox"
+  nullable    = false
+  validation {
+    condition     = contains(["SaturationShoebox", "ServiceApiResult"], var.availability_alert_metric)
+    error_message = "availability_alert_metric must be 'SaturationShoebox' or 'ServiceApiResult'."
+  }
+}
+
+variable "availability_alert_threshold" {
+  description = "Value of availability_alert_metric above which the alert fires: a saturation percentage, or a number of throttled requests per 5 minutes"
+  type        = number
+  default     = 75
+  nullable    = false
+  validation {
+    condition     = var.availability_alert_threshold >= 0
+    error_message = "availability_alert_threshold must not be negative."
+  }
+}
+
+# Disaster Recovery
+variable "create_dr_vault" {
+  description = "Create a second, empty Key Vault with the same settings in the region paired with location, for disaster recovery. Keys, secrets, certificates and access are not replicated to it"
+  type        = bool
+  default     = false
+}
+
+variable "dr_location" {
+  description = "Region of the disaster recovery vault. Defaults to the Azure region pair of location"
+  type        = string
+  default     = null
+}
+
+variable "dr_vault_name" {
+  description = "Name of the disaster recovery vault. Defaults to the Key Vault name, cut to 21 characters, with a -dr suffix"
+  type        = string
+  default     = null
+}
+
 # Resource Lock
 variable "enable_resource_lock" {
   description = "Enable resource lock for the Key Vault"
@@ -339,6 +1133,12 @@ variable "enable_resource_lock" {
   default     = true
 }
 
+variable "resource_lock_name" {
+  description = "Name of the resource lock. Defaults to '{key_vault_name}-lock'"
+  type        = string
+  default     = null
+}
+
 variable "resource_lock_level" {
   description = "Level of the resource lock"
   type        = string
@@ -372,4 +1172,54 @@ variable "enable_policy_initiative" {
   description = "Enable Azure Policy initiative for Key Vault security"
   type        = bool
   default     = true
-}
\ No newline at end of file
+}
+
+variable "assign_rotation_policy" {
+  description = "Assign the built-in \"Keys should have a rotation policy\" definition to the vault's resource group, auditing keys across the group that do not rotate within rotation_policy_max_days"
+  type        = bool
+  default     = false
+}
+
+variable "rotation_policy_max_days" {
+  description = "Maximum number of days after creation within which keys must be scheduled to rotate, for the assign_rotation_policy assignment"
+  type        = number
+  default     = 730
+  validation {
+    condition     = var.rotation_policy_max_days >= 1 && floor(var.rotation_policy_max_days) == var.rotation_policy_max_days
+    error_message = "rotation_policy_max_days must be a positive whole number of days."
+  }
+}
+
+variable "policy_exemptions" {
+  description = "Exemptions of the vault's resource group from policy assignments, such as legacy vaults being migrated. A Waiver must set expires_on, an RFC 3339 timestamp after which the exemption stops applying; a Mitigated exemption may leave it unset. policy_definition_reference_ids narrows an exemption from an initiative to some of its policies"
+  type = list(object({
+    name                            = string
+    policy_assignment_id            = string
+    exemption_category              = string
+    expires_on                      = optional(string)
+    description                     = optional(string)
+    policy_definition_reference_ids = optional(list(string))
+  }))
+  default  = []
+  nullable = false
+
+  validation {
+    condition     = alltrue([for e in var.policy_exemptions : contains(["Waiver", "Mitigated"], e.exemption_category)])
+    error_message = "Policy exemption exemption_category must be 'Waiver' or 'Mitigated'."
+  }
+
+  validation {
+    condition     = alltrue([for e in var.policy_exemptions : e.exemption_category != "Waiver" || e.expires_on != null])
+    error_message = "Policy exemptions in the Waiver category must set expires_on, so the waiver cannot outlive the migration."
+  }
+
+  validation {
+    condition     = alltrue([for e in var.policy_exemptions : e.expires_on == null || can(timeadd(e.expires_on, "0s"))])
+    error_message = "Policy exemption expires_on must be an RFC 3339 timestamp such as 2025-06-30T00:00:00Z."
+  }
+
+  validation {
+    condition     = length(distinct([for e in var.policy_exemptions : e.name])) == length(var.policy_exemptions)
+    error_message = "Policy exemption names must be unique."
+  }
+}